@@ -0,0 +1,47 @@
+package firlog
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// adminTokenHeader is the header requests to admin endpoints carry
+// App.AdminToken in, next to the basic auth every API request needs.
+const adminTokenHeader = "X-Admin-Token"
+
+// adminMiddleware only lets the requests that can change or delete things
+// (anything but GET and HEAD) through when they carry the admin token,
+// answering 403 otherwise and whenever no admin token is configured, so the
+// dashboard's credentials alone can't.
+func adminMiddleware(adminToken string) func(http.Handler) http.Handler {
+	return adminTokenMiddleware(adminToken, false)
+}
+
+// adminOnlyMiddleware is adminMiddleware for endpoints whose reads too need
+// the admin token, like snapshots downloading whole indexes.
+func adminOnlyMiddleware(adminToken string) func(http.Handler) http.Handler {
+	return adminTokenMiddleware(adminToken, true)
+}
+
+func adminTokenMiddleware(adminToken string, reads bool) func(http.Handler) http.Handler {
+	required := sha256.Sum256([]byte(adminToken))
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !reads && (r.Method == "GET" || r.Method == "HEAD") {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if adminToken == "" {
+				writeError(w, http.StatusForbidden, errorCodeForbidden, "admin endpoints are disabled, configure an admin token to use them")
+				return
+			}
+			given := sha256.Sum256([]byte(r.Header.Get(adminTokenHeader)))
+			if subtle.ConstantTimeCompare(given[:], required[:]) != 1 {
+				writeError(w, http.StatusForbidden, errorCodeForbidden, "admin token required")
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package firlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminRoutesRejectNonAdmins(t *testing.T) {
+	app := NewApp(t.TempDir(), []string{"app1"})
+	app.AdminToken = "admin-secret"
+	engine := app.engineForToken("app1")
+	logs := testLogs(engine, "stored", 1)
+	if err := engine.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	handler := app.handler("user", "pass")
+
+	routes := []struct {
+		method, path, body string
+	}{
+		{"POST", "/replay?token=app1", ""},
+		{"POST", "/flush", ""},
+		{"POST", "/reload", ""},
+		{"POST", "/indexes/app1/20261014/repair", ""},
+		{"POST", "/indexes/app1/20261014/quarantine", ""},
+		{"POST", "/indexes/app1/20261014/optimize", ""},
+		{"POST", "/import?token=app1", "{}\n"},
+		{"GET", "/snapshot?token=app1", ""},
+		{"PATCH", "/log/app1/" + logs[0].Id, `{"msg": "changed"}`},
+	}
+	for _, route := range routes {
+		for _, adminToken := range []string{"", "wrong"} {
+			r := httptest.NewRequest(route.method, route.path, strings.NewReader(route.body))
+			r.SetBasicAuth("user", "pass")
+			if adminToken != "" {
+				r.Header.Set(adminTokenHeader, adminToken)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s %s with admin token %q: got %d, want 403", route.method, route.path, adminToken, w.Code)
+			}
+		}
+	}
+
+	stored, err := engine.Get(logs[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Data["msg"] != logs[0].Data["msg"] {
+		t.Errorf("got msg %q, want the rejected PATCH not to change it", stored.Data["msg"])
+	}
+
+	// Reading stays open to basic auth alone, and the admin token lets
+	// writes through.
+	for _, request := range []struct {
+		method, path, body, adminToken string
+	}{
+		{"GET", "/log/app1/" + logs[0].Id, "", ""},
+		{"GET", "/snapshot?token=app1", "", "admin-secret"},
+		{"PATCH", "/log/app1/" + logs[0].Id, `{"msg": "changed"}`, "admin-secret"},
+	} {
+		r := httptest.NewRequest(request.method, request.path, strings.NewReader(request.body))
+		r.SetBasicAuth("user", "pass")
+		if request.adminToken != "" {
+			r.Header.Set(adminTokenHeader, request.adminToken)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s: got %d, want 200: %s", request.method, request.path, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package firlog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+const alertSamplesCount = 5
+
+// AlertRule fires when more than Threshold newly indexed logs match Query
+// within Window. Once fired it stays quiet for another Window. Alerts go to
+// the engine's Notifier unless the rule has a Webhook.
+type AlertRule struct {
+	Name      string `json:"name"`
+	Query     string `json:"query"`
+	Threshold int    `json:"threshold"`
+	Window    string `json:"window"`
+	Webhook   string `json:"webhook"`
+	// Template optionally replaces the webhook's default JSON payload.
+	Template string `json:"template"`
+
+	window   time.Duration
+	parsed   query.Query
+	notifier Notifier
+}
+
+// Alert is the event notifiers receive when a rule fires.
+type Alert struct {
+	Rule  string
+	Token string
+	Count int
+	// Received is how many lines were received within the window, for
+	// malformed lines alerts.
+	Received int
+	From     time.Time
+	To       time.Time
+	Samples  []*Log
+}
+
+func compileAlertRules(rules []*AlertRule) error {
+	for _, rule := range rules {
+		window, err := time.ParseDuration(rule.Window)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("alert rule '%s': invalid window '%s'", rule.Name, rule.Window)
+		}
+		rule.window = window
+		parsed, err := bleve.NewQueryStringQuery(rule.Query).Parse()
+		if err != nil {
+			return fmt.Errorf("alert rule '%s': %v", rule.Name, err)
+		}
+		rule.parsed = matchBooleans(parsed)
+		if rule.Webhook != "" {
+			notifier, err := NewWebhookNotifier(rule.Webhook, rule.Template)
+			if err != nil {
+				return fmt.Errorf("alert rule '%s': %v", rule.Name, err)
+			}
+			rule.notifier = notifier
+		}
+	}
+	return nil
+}
+
+type alertState struct {
+	matches   []time.Time
+	lastFired time.Time
+}
+
+type alerter struct {
+	mu     sync.Mutex
+	states map[string]*alertState
+}
+
+// evaluateAlerts runs every alert rule against logs just indexed into index.
+func (e *Engine) evaluateAlerts(index bleve.Index, logs []*Log) {
+	rules := e.config().AlertRules
+	if len(rules) == 0 {
+		return
+	}
+
+	ids := []string{}
+	logsByID := map[string]*Log{}
+	for _, log := range logs {
+		ids = append(ids, log.Id)
+		logsByID[log.Id] = log
+	}
+
+	for _, rule := range rules {
+		query := bleve.NewConjunctionQuery(rule.parsed, bleve.NewDocIDQuery(ids))
+		searchResult, err := index.Search(bleve.NewSearchRequestOptions(query, len(ids), 0, false))
+		if err != nil {
+			logger.Printf("error evaluating alert '%s': %v\n", rule.Name, err)
+			continue
+		}
+		if len(searchResult.Hits) == 0 {
+			continue
+		}
+
+		samples := []*Log{}
+		for _, hit := range searchResult.Hits {
+			if len(samples) == alertSamplesCount {
+				break
+			}
+			samples = append(samples, logsByID[hit.ID])
+		}
+		if alert := e.alerter.record(rule, len(searchResult.Hits), time.Now()); alert != nil {
+			alert.Token = e.token()
+			alert.Samples = samples
+			notifier := e.Notifier
+			if rule.notifier != nil {
+				notifier = rule.notifier
+			}
+			if err := notifier.Notify(context.Background(), alert); err != nil {
+				logger.Printf("error firing alert '%s': %v\n", rule.Name, err)
+			}
+		}
+	}
+}
+
+// record counts matches for a rule at now, returning an alert if the rule
+// crossed its threshold and didn't already fire within its window.
+func (a *alerter) record(rule *AlertRule, count int, now time.Time) *Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	state, ok := a.states[rule.Name]
+	if !ok {
+		state = &alertState{}
+		a.states[rule.Name] = state
+	}
+
+	from := now.Add(-rule.window)
+	kept := state.matches[:0]
+	for _, match := range state.matches {
+		if match.After(from) {
+			kept = append(kept, match)
+		}
+	}
+	for i := 0; i < count; i++ {
+		kept = append(kept, now)
+	}
+	state.matches = kept
+
+	if len(state.matches) <= rule.Threshold || state.lastFired.After(from) {
+		return nil
+	}
+	state.lastFired = now
+	return &Alert{Rule: rule.Name, Count: len(state.matches), From: from, To: now}
+}
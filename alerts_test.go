@@ -0,0 +1,88 @@
+package firlog
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const alertsConfig = `{"alertRules": [{"name": "errors", "query": "level:error", "threshold": 1, "window": "1m"}]}`
+
+// recordingNotifier keeps the alerts it's given.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []*Alert
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert *Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func (n *recordingNotifier) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.alerts)
+}
+
+// errorLogs returns n logs of e at the error level.
+func errorLogs(e *Engine, n int) []*Log {
+	logs := testLogs(e, "failed", n)
+	for _, log := range logs {
+		log.Data["level"] = "error"
+	}
+	return logs
+}
+
+func TestAlertFiresOncePerWindow(t *testing.T) {
+	app := newTestApp(t, alertsConfig)
+	notifier := &recordingNotifier{}
+	app.Notifier = notifier
+	engine := app.engineForToken("app1")
+
+	if err := engine.Index(errorLogs(engine, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Index(errorLogs(engine, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if count := notifier.count(); count != 1 {
+		t.Fatalf("expected 1 alert, got %d", count)
+	}
+	if alert := notifier.alerts[0]; alert.Rule != "errors" || alert.Token != "app1" || alert.Count != 3 || len(alert.Samples) != 3 {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+}
+
+func TestAlertsDefaultToStderr(t *testing.T) {
+	if notifier := newTestEngine(t).Notifier; notifier != (StderrNotifier{}) {
+		t.Errorf("expected alerts to be logged by default, got %T", notifier)
+	}
+}
+
+func TestReplayedLogsDontFireAlerts(t *testing.T) {
+	for _, dir := range []string{deadLetterDirName, walDirName} {
+		t.Run(dir, func(t *testing.T) {
+			app := newTestApp(t, alertsConfig)
+			app.DeadLetter = true
+			notifier := &recordingNotifier{}
+			app.Notifier = notifier
+			// Left behind by the previous run.
+			logs := errorLogs(NewEngine(filepath.Join(t.TempDir(), "app1")), 3)
+			if _, err := writeLogsFile(filepath.Join(app.DataDir, "app1", dir), logs); err != nil {
+				t.Fatal(err)
+			}
+
+			engine := app.engineForToken("app1")
+			if count := docCount(t, engine); count != 3 {
+				t.Fatalf("expected the logs to be replayed, got %d docs", count)
+			}
+			if count := notifier.count(); count != 0 {
+				t.Errorf("expected no alert, got %d", count)
+			}
+		})
+	}
+}
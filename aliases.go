@@ -0,0 +1,325 @@
+package firlog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// AllTokens is the token searching every token at once.
+const AllTokens = "*"
+
+// indexGroup is an alias over a set of indexes, possibly of several engines,
+// along with what's needed to route hits back to the index and engine they
+// come from.
+type indexGroup struct {
+	alias   bleve.IndexAlias
+	engines []*Engine
+	// indexes, keys and owners are by index name, which is the path of the
+	// index and so unique across engines.
+	indexes map[string]bleve.Index
+	keys    map[string]string
+	owners  map[string]*Engine
+	// generations are the engines' index generations the group was built at.
+	generations []uint64
+}
+
+func newIndexGroup(engines []*Engine) *indexGroup {
+	group := &indexGroup{
+		alias:   bleve.NewIndexAlias(),
+		engines: engines,
+		indexes: map[string]bleve.Index{},
+		keys:    map[string]string{},
+		owners:  map[string]*Engine{},
+	}
+	for _, engine := range engines {
+		// Read before the indexes so changes made in between make the group
+		// look stale rather than current.
+		group.generations = append(group.generations, atomic.LoadUint64(&engine.generation))
+		for key, index := range engine.searchedIndexes() {
+			group.alias.Add(index)
+			group.indexes[index.Name()] = index
+			group.keys[index.Name()] = key
+			group.owners[index.Name()] = engine
+		}
+	}
+	return group
+}
+
+// zoneLeeway is how far a log's time can be from UTC, RFC 3339 allowing
+// offsets of up to 23:59 either way.
+const zoneLeeway = 24 * time.Hour
+
+// within returns the part of the group holding logs between from and to.
+// Indexes are named after the date of their logs' time in its own zone, which
+// can be a day off the UTC date, so that much leeway is kept.
+func (g *indexGroup) within(from, to time.Time) *indexGroup {
+	first := from.UTC().Add(-zoneLeeway).Format("20060102")
+	last := to.UTC().Add(zoneLeeway).Format("20060102")
+	group := &indexGroup{
+		alias:       bleve.NewIndexAlias(),
+		engines:     g.engines,
+		indexes:     map[string]bleve.Index{},
+		keys:        g.keys,
+		owners:      g.owners,
+		generations: g.generations,
+	}
+	for name, index := range g.indexes {
+		if indexInRange(g.keys[name], first, last) {
+			group.alias.Add(index)
+			group.indexes[name] = index
+		}
+	}
+	return group
+}
+
+// stale tells if any of the group's engines created or removed indexes since
+// the group was built.
+func (g *indexGroup) stale() bool {
+	for i, engine := range g.engines {
+		if atomic.LoadUint64(&engine.generation) != g.generations[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// indexesChanged is called, with e.mu held, whenever indexes are created or
+// removed so cached groups get rebuilt.
+func (e *Engine) indexesChanged() {
+	atomic.AddUint64(&e.generation, 1)
+}
+
+// group returns the cached group of the engine's indexes.
+func (e *Engine) group() *indexGroup {
+	e.groupMu.Lock()
+	defer e.groupMu.Unlock()
+
+	if e.cachedGroup == nil || e.cachedGroup.stale() {
+		e.cachedGroup = newIndexGroup([]*Engine{e})
+	}
+	return e.cachedGroup
+}
+
+// aliasManager caches the groups searches of several tokens at once go
+// through, single tokens using their engine's own group.
+type aliasManager struct {
+	mu     sync.Mutex
+	unions map[string]*indexGroup
+}
+
+func newAliasManager() *aliasManager {
+	return &aliasManager{unions: map[string]*indexGroup{}}
+}
+
+func (m *aliasManager) group(tokens []string, engines map[string]*Engine) *indexGroup {
+	if len(tokens) == 1 {
+		return engines[tokens[0]].group()
+	}
+
+	tokens = append([]string{}, tokens...)
+	sort.Strings(tokens)
+	key := strings.Join(tokens, ",")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if union, ok := m.unions[key]; ok && !union.stale() {
+		return union
+	}
+	unionEngines := []*Engine{}
+	for _, token := range tokens {
+		unionEngines = append(unionEngines, engines[token])
+	}
+	m.unions[key] = newIndexGroup(unionEngines)
+	return m.unions[key]
+}
+
+// indexGroup returns the group of the indexes of tokens.
+func (app *App) indexGroup(tokens []string) *indexGroup {
+	engines := map[string]*Engine{}
+	for _, token := range tokens {
+		engines[token] = app.engineForToken(token)
+	}
+	return app.aliases.group(tokens, engines)
+}
+
+// search runs search over all of the group's indexes. Indexes that fail are
+// skipped and recorded as broken by their engine.
+func (g *indexGroup) search(search *bleve.SearchRequest, limit int) (*SearchResult, error) {
+	logs := []*Log{}
+	if len(g.indexes) == 0 {
+		return &SearchResult{Logs: logs, Warnings: g.brokenWarnings()}, nil
+	}
+
+	if limit > 0 {
+		search.Size = limit
+	}
+	searchResult, err := g.alias.Search(search)
+	if err != nil && len(g.indexes) == 1 {
+		// The alias searches a single index directly, returning its error
+		// instead of recording it like it does for several.
+		searchResult = &bleve.SearchResult{Status: &bleve.SearchStatus{Total: 1, Failed: 1, Errors: map[string]error{}}}
+		for name := range g.indexes {
+			searchResult.Status.Errors[name] = err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return g.collect(search, searchResult)
+}
+
+// searchIncrementally is search going through the group's indexes one at a
+// time, newest first unless newestFirst is false, until timeout. Timing out
+// fails the search with errSearchTimeout unless partial is set, in which
+// case the result holds what the indexes searched in time matched.
+func (g *indexGroup) searchIncrementally(search *bleve.SearchRequest, limit int, timeout time.Duration, newestFirst, partial bool) (*SearchResult, error) {
+	if len(g.indexes) == 0 {
+		return &SearchResult{Logs: []*Log{}, Warnings: g.brokenWarnings()}, nil
+	}
+	if limit > 0 {
+		search.Size = limit
+	}
+
+	names := []string{}
+	for name := range g.indexes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if g.keys[names[i]] != g.keys[names[j]] {
+			return (g.keys[names[i]] > g.keys[names[j]]) == newestFirst
+		}
+		return names[i] < names[j]
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	merged := &bleve.SearchResult{Status: &bleve.SearchStatus{Errors: map[string]error{}}}
+	searched, timedOut := 0, false
+	for _, name := range names {
+		// Like the alias does, every index returns enough hits for the page
+		// to be cut from the merged ones.
+		child := *search
+		child.From = 0
+		child.Size = search.From + search.Size
+		child.Sort = search.Sort.Copy()
+		searchResult, err := g.indexes[name].SearchInContext(ctx, &child)
+		if err != nil && ctx.Err() != nil {
+			timedOut = true
+			break
+		} else if err != nil {
+			merged.Status.Errors[name] = err
+			continue
+		}
+		merged.Merge(searchResult)
+		searched++
+	}
+	if timedOut && !partial {
+		return nil, errSearchTimeout
+	}
+
+	cachedScoring, cachedDesc := search.Sort.CacheIsScore(), search.Sort.CacheDescending()
+	hits := merged.Hits
+	sort.SliceStable(hits, func(i, j int) bool {
+		return search.Sort.Compare(cachedScoring, cachedDesc, hits[i], hits[j]) < 0
+	})
+	if len(hits) > search.From {
+		hits = hits[search.From:]
+	} else {
+		hits = nil
+	}
+	if len(hits) > search.Size {
+		hits = hits[:search.Size]
+	}
+	merged.Hits = hits
+
+	result, err := g.collect(search, merged)
+	if err != nil {
+		return nil, err
+	}
+	result.Indexes = searched
+	if timedOut {
+		result.Partial = true
+		result.Warnings = append(result.Warnings, fmt.Sprintf("The search timed out after %s, only %d of %d indexes were searched", timeout, searched, len(names)))
+	}
+	return result, nil
+}
+
+// collect turns the hits of searchResult into logs.
+func (g *indexGroup) collect(search *bleve.SearchRequest, searchResult *bleve.SearchResult) (*SearchResult, error) {
+	logs := []*Log{}
+	result := &SearchResult{
+		Total:   searchResult.Total,
+		Indexes: len(g.indexes),
+	}
+	failed := map[string]bool{}
+	for name, err := range searchResult.Status.Errors {
+		failed[name] = true
+		// Indexes closed since the group was built, being compacted or
+		// optimized, aren't broken.
+		if err == bleve.ErrorIndexClosed {
+			result.closed = true
+			continue
+		}
+		g.owners[name].skipBroken(g.keys[name], err)
+	}
+
+	for _, hit := range searchResult.Hits {
+		index, ok := g.indexes[hit.Index]
+		if !ok {
+			return nil, fmt.Errorf("hit from unknown index '%s'", hit.Index)
+		}
+		if failed[hit.Index] {
+			continue
+		}
+
+		data, err := loadLogData(index, hit.ID)
+		if err == bleve.ErrorIndexClosed {
+			failed[hit.Index] = true
+			result.closed = true
+			continue
+		} else if err != nil {
+			failed[hit.Index] = true
+			g.owners[hit.Index].skipBroken(g.keys[hit.Index], fmt.Errorf("bleve get internal: %v", err))
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		log := &Log{Id: hit.ID, Data: data}
+		g.owners[hit.Index].config().display(log)
+
+		logs = append(logs, log)
+		if search.Explain {
+			result.Explanations = append(result.Explanations, hit.Expl)
+		}
+	}
+
+	result.Logs = logs
+	result.Warnings = g.brokenWarnings()
+	return result, nil
+}
+
+// terms returns the (at most size) most frequent values of field among the
+// logs q matches.
+func (g *indexGroup) terms(q query.Query, field string, size int) ([]TermCount, error) {
+	values, err := g.distinct(q, field, size)
+	if err != nil {
+		return nil, err
+	}
+	return values.Values, nil
+}
+
+func (g *indexGroup) brokenWarnings() []string {
+	warnings := []string{}
+	for _, engine := range g.engines {
+		warnings = append(warnings, engine.brokenWarnings()...)
+	}
+	return warnings
+}
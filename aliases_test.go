@@ -0,0 +1,233 @@
+package firlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// indexDays indexes perDay logs for each of days days up to testTime, spread
+// over the hours of the day, along with logs whose zone puts them in another
+// date than their UTC one.
+func indexDays(tb testing.TB, e *Engine, days, perDay int) {
+	tb.Helper()
+	logs := []*Log{}
+	add := func(at time.Time) {
+		log := &Log{Time: at, Data: map[string]interface{}{
+			"time": at,
+			"msg":  fmt.Sprintf("log %d", len(logs)),
+		}}
+		log.Id = e.IDs.NewID(log)
+		logs = append(logs, log)
+	}
+	first := testTime.Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	for day := 0; day < days; day++ {
+		for i := 0; i < perDay; i++ {
+			add(first.AddDate(0, 0, day).Add(time.Duration(i) * 24 * time.Hour / time.Duration(perDay)))
+		}
+	}
+	// 2026-10-13T23:30:00-13:00 is 2026-10-14T12:30:00Z and
+	// 2026-10-15T00:30:00+13:00 is 2026-10-14T11:30:00Z.
+	add(time.Date(2026, 10, 13, 23, 30, 0, 0, time.FixedZone("", -13*60*60)))
+	add(time.Date(2026, 10, 15, 0, 30, 0, 0, time.FixedZone("", 13*60*60)))
+	if err := e.Index(logs); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// searchIDs returns the sorted IDs of the logs of group between from and to.
+func searchIDs(tb testing.TB, group *indexGroup, from, to time.Time) []string {
+	tb.Helper()
+	inclusive := true
+	timeQuery := bleve.NewDateRangeInclusiveQuery(from, to, &inclusive, &inclusive)
+	timeQuery.SetField("time")
+	result, err := group.search(bleve.NewSearchRequestOptions(timeQuery, 10000, 0, false), 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ids := []string{}
+	for _, log := range result.Logs {
+		ids = append(ids, log.Id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestWithinMatchesAllIndexes(t *testing.T) {
+	e := newTestEngine(t)
+	indexDays(t, e, 5, 48)
+	all := e.group()
+
+	day := testTime.Truncate(24 * time.Hour)
+	tests := []struct {
+		name       string
+		from, to   time.Time
+		maxIndexes int
+	}{
+		{"last hour", testTime.Add(-time.Hour), testTime, 3},
+		{"zones a day off", testTime.Add(-time.Hour), testTime.Add(time.Hour), 3},
+		{"zone behind UTC-12:00", testTime, testTime.Add(time.Hour), 3},
+		{"whole day", day, day.Add(24*time.Hour - time.Nanosecond), 3},
+		{"across days", day.Add(-30 * time.Hour), day.Add(2 * time.Hour), 5},
+		{"first day", day.AddDate(0, 0, -4), day.AddDate(0, 0, -4).Add(time.Hour), 2},
+		{"before any log", day.AddDate(0, 0, -30), day.AddDate(0, 0, -20), 0},
+		{"all days", day.AddDate(0, 0, -30), day.AddDate(0, 0, 30), 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group := all.within(test.from, test.to)
+			if len(group.indexes) > test.maxIndexes {
+				t.Errorf("expected at most %d indexes to be searched, got %d", test.maxIndexes, len(group.indexes))
+			}
+			want := searchIDs(t, all, test.from, test.to)
+			got := searchIDs(t, group, test.from, test.to)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("expected the %d logs of all indexes, got %d: %v instead of %v", len(want), len(got), got, want)
+			}
+			for _, id := range got {
+				if log, err := e.Get(id); err != nil || log == nil {
+					t.Errorf("expected log %s to be found, got %v (%v)", id, log, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSearchLastHour(b *testing.B) {
+	e := NewEngine(filepath.Join(b.TempDir(), "app1"))
+	indexDays(b, e, 60, 100)
+	from, to := testTime.Add(-time.Hour), testTime
+
+	b.Run("all indexes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			searchIDs(b, e.group(), from, to)
+		}
+	})
+	b.Run("within", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			searchIDs(b, e.group().within(from, to), from, to)
+		}
+	})
+}
+
+// wrappedIndex is embedded by indexes overriding some methods, bleve.Index
+// having an Index method its field would clash with.
+type wrappedIndex = bleve.Index
+
+// corruptIndex fails every search, like an index whose files are damaged.
+type corruptIndex struct {
+	wrappedIndex
+}
+
+func (corruptIndex) SearchInContext(ctx context.Context, req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	return nil, errors.New("unexpected EOF")
+}
+
+func TestSearchSkipsFailingIndex(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		fail    func(bleve.Index) bleve.Index
+		warning bool
+	}{
+		{"corrupt", func(index bleve.Index) bleve.Index { return corruptIndex{index} }, true},
+		{"closed", func(index bleve.Index) bleve.Index { index.Close(); return index }, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			app := newTestApp(t, "")
+			engine := app.engineForToken("app1")
+			postBulk(t, app, "text/plain", syslogLine("request served")+"\n")
+			path := "/search?token=app1&query=request&from=2026-10-14T00:00:00Z&to=2026-10-15T00:00:00Z"
+			var response struct {
+				Total    uint64   `json:"total"`
+				Warnings []string `json:"warnings"`
+			}
+			if w := getJSON(t, app, path, &response); response.Total != 1 {
+				t.Fatalf("expected the log to be found, got %s", w.Body)
+			}
+
+			engine.mu.Lock()
+			if len(engine.indexes) != 1 {
+				t.Fatalf("expected a single index, got %d", len(engine.indexes))
+			}
+			for key, index := range engine.indexes {
+				engine.indexes[key] = test.fail(index)
+			}
+			engine.indexesChanged()
+			engine.mu.Unlock()
+
+			response.Total = 0
+			w := getJSON(t, app, path, &response)
+			if w.Code != 200 {
+				t.Fatalf("expected the index to be skipped, got %d: %s", w.Code, w.Body)
+			}
+			if response.Total != 0 || (len(response.Warnings) == 1) != test.warning {
+				t.Errorf("expected no logs and a warning only for broken indexes, got %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestGroupsAreCached(t *testing.T) {
+	app := newTestApp(t, "")
+	app.Tokens = []string{"app1", "app2"}
+	app1, app2 := app.engineForToken("app1"), app.engineForToken("app2")
+	for _, engine := range []*Engine{app1, app2} {
+		if err := engine.Index(testLogs(engine, "started", 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	group, union := app1.group(), app.indexGroup([]string{"app2", "app1"})
+	if app1.group() != group || app.indexGroup([]string{"app1", "app2"}) != union || app.indexGroup([]string{"app1"}) != group {
+		t.Error("expected groups to be reused")
+	}
+	if len(union.indexes) != 2 {
+		t.Errorf("expected the indexes of both tokens, got %d", len(union.indexes))
+	}
+
+	// A new date is a new index.
+	logs := testLogs(app2, "yesterday", 1)
+	logs[0].Time = testTime.Add(-24 * time.Hour)
+	if err := app2.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	if app1.group() != group {
+		t.Error("expected the group of app1 to be kept")
+	}
+	if rebuilt := app.indexGroup([]string{"app1", "app2"}); rebuilt == union || len(rebuilt.indexes) != 3 {
+		t.Errorf("expected the union to be rebuilt with the new index, got %d indexes", len(rebuilt.indexes))
+	}
+}
+
+func TestSearchAllTokens(t *testing.T) {
+	app := newTestApp(t, "")
+	app.Tokens = []string{"app1", "app2"}
+	for _, token := range app.Tokens {
+		engine := app.engineForToken(token)
+		if err := engine.Index(testLogs(engine, token, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for token, want := range map[string]string{"*": "app1 0,app2 0", "app2": "app2 0"} {
+		var response struct {
+			Logs []map[string]interface{} `json:"logs"`
+		}
+		getJSON(t, app, "/search?token="+token+dashboardRange, &response)
+		messages := []string{}
+		for _, log := range response.Logs {
+			messages = append(messages, log["msg"].(string))
+		}
+		sort.Strings(messages)
+		if strings.Join(messages, ",") != want {
+			t.Errorf("token %s: expected %s, got %v", token, want, messages)
+		}
+	}
+}
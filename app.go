@@ -4,210 +4,782 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
 	"io/ioutil"
-	"log"
-	"math/rand"
+	"net"
 	"net/http"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/blevesearch/bleve"
-	"github.com/oklog/ulid"
 )
 
-var entropyPool = sync.Pool{
-	New: func() interface{} {
-		return rand.New(rand.NewSource(time.Now().UnixNano()))
-	},
-}
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout       = time.Minute
+	DefaultWriteTimeout      = 5 * time.Minute
+	DefaultIdleTimeout       = 2 * time.Minute
+
+	DefaultIPRateBurst = 20
+)
 
 type App struct {
 	DataDir string
-	Tokens  []string
-	Engines map[string]*Engine
+	// DataDirMode is the permissions of the data directories created for
+	// DataDir and tokens if missing, DefaultDataDirMode by default.
+	DataDirMode  os.FileMode
+	Tokens       []string
+	Engines      map[string]*Engine
+	QueueSize    int
+	QueueWorkers int
+	MaxBatchSize int
+	// FlushInterval is how long queue workers wait for batches to reach
+	// MaxBatchSize, 0 indexing whatever is waiting right away.
+	FlushInterval time.Duration
+	// IndexChunkSize is the most logs applied in a single index batch.
+	IndexChunkSize int
+	// IndexWorkers is how many dates of a same request are indexed at once.
+	IndexWorkers int
+	// RecentSize is how many of the newest logs of each token `/recent`
+	// serves from memory, 0 disabling it.
+	RecentSize int
+	// Shards is how many indexes new dates are split into, see
+	// Engine.Shards.
+	Shards int
+	// IndexPrefix starts the directory names of new indexes, `{token}`
+	// standing for the token, see Engine.IndexPrefix.
+	IndexPrefix string
+	// MaxClockSkew and ClockSkewPolicy handle bulk logs timestamped too far
+	// in the future, see Engine.MaxClockSkew.
+	MaxClockSkew    time.Duration
+	ClockSkewPolicy string
+	// IDs generates the IDs of ingested logs, ULIDs by default.
+	IDs          IDGenerator
+	MaxRetries   int
+	RetryBackoff time.Duration
+	DeadLetter   bool
+	// WAL records bulk requests to disk until they're indexed, see
+	// Engine.WAL.
+	WAL bool
+	// StoreRaw stores the lines logs are parsed from for replays, see
+	// Engine.StoreRaw.
+	StoreRaw bool
+	// Notifier, when set, delivers the alerts of every token instead of the
+	// engines' StderrNotifier.
+	Notifier Notifier
+	GeoIP    *GeoIP
+	// CompactAfter is how old a month must be before its daily indexes get
+	// merged into a monthly one, 0 disables compaction.
+	CompactAfter time.Duration
+	// Retention is how long indexes are kept once their last date is past,
+	// 0 keeping them forever.
+	Retention time.Duration
+	// RetentionMaxSize is how many bytes indexes can take on disk before
+	// the oldest get deleted, down to RetentionLowWatermark (a fraction of
+	// it). It applies to all tokens together unless RetentionScope is
+	// RetentionScopeToken. 0 doesn't limit their size.
+	RetentionMaxSize      int64
+	RetentionLowWatermark float64
+	RetentionScope        string
+	// WarmUpDays is how many of the latest days of logs Start warms up in
+	// the background, today included, 0 disabling warm-up.
+	WarmUpDays int
+	// DisplayTZ is the time zone dashboard times are shown in unless the
+	// request asks for another one with `tz`.
+	DisplayTZ string
+	// Server timeouts, see http.Server. They keep slow or idle clients from
+	// holding connections open forever, 0 disables them.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// IPRateLimit is how many bulk requests per second a remote IP can make,
+	// up to IPRateBurst at once, before getting 429s. 0 disables it.
+	IPRateLimit float64
+	IPRateBurst int
+	// AdminToken is what requests to endpoints repairing, quarantining,
+	// optimizing or snapshotting indexes and replaying logs must carry in `X-Admin-Token`,
+	// those endpoints being disabled without one.
+	AdminToken string
+	// FieldOrder are the fields logs of JSON responses start with, in that
+	// order, the others following alphabetically as they all do without it.
+	FieldOrder []string
+	// Users are the dashboard logins, next to Start's user, that can only
+	// see some tokens.
+	Users map[string]*User
+	// DefaultToken is the token `POST /bulk` requests without one in their
+	// path go to, those being rejected when it's empty.
+	DefaultToken string
+	// Addr is the `host:port` Start listens on, like `127.0.0.1:3000` to
+	// only be reachable locally, instead of the given port on every
+	// interface.
+	Addr string
+	// TrustedProxies are the peers whose `X-Forwarded-For`, `-Host` and
+	// `-Proto` headers are trusted.
+	TrustedProxies []*net.IPNet
+	// InvertedRanges is what's done with searches whose `from` is after `to`,
+	// InvertedRangeError (the default) or InvertedRangeSwap.
+	InvertedRanges string
+	// MaxSearchAge is how far back searches can go, older parts of ranges
+	// being dropped. 0 doesn't limit searches.
+	MaxSearchAge time.Duration
+	// MaxSearchIndexes is how many indexes a single search can go through
+	// before being rejected, 0 doesn't limit searches.
+	MaxSearchIndexes int
+	// SearchTimeout is how long searches can take, their indexes being
+	// searched one at a time, newest first, so searches asking for partial
+	// results get those of the newest ones. 0 doesn't limit searches.
+	SearchTimeout time.Duration
+	// CrossFieldSearch makes terms of queries that don't name a field match
+	// every field of the searched indexes with its own analyzer, on top of
+	// `_all`, which costs a query per field and term.
+	CrossFieldSearch bool
+	// DefaultOperator is how terms of queries without a `+` or `-` combine,
+	// OperatorOr (the default, any of them matching) or OperatorAnd.
+	DefaultOperator string
+	// MaxQueryLength and MaxQueryClauses are how long queries can be and
+	// how many terms, phrases and such they can have, longer or bigger ones
+	// being rejected, 0 doesn't limit them. RejectLeadingWildcards rejects
+	// terms starting with a wildcard, like `*son`, which go through every
+	// term of the indexes.
+	MaxQueryLength         int
+	MaxQueryClauses        int
+	RejectLeadingWildcards bool
+	// HistorySize is how many recent queries are kept for each basic auth
+	// user, 0 disabling history. Queries matching any of HistoryExclude,
+	// like ones looking for secrets, are never kept.
+	HistorySize    int
+	HistoryExclude []*regexp.Regexp
+
+	// readOnly apps open their engines read-only, see NewReadOnlyApp.
+	readOnly  bool
+	mu        sync.Mutex
+	startedAt time.Time
+	ipLimiter *ipLimiter
+	aliases   *aliasManager
+	history   *queryHistory
+	// retentionDeleted and retentionReclaimed count the indexes retention
+	// deleted and the bytes they took.
+	retentionDeleted   int64
+	retentionReclaimed int64
 }
 
 func NewApp(dataDir string, tokens []string) *App {
-	app := &App{
-		DataDir: dataDir,
-		Tokens:  tokens,
-		Engines: map[string]*Engine{},
+	return &App{
+		DataDir:        dataDir,
+		DataDirMode:    DefaultDataDirMode,
+		Tokens:         tokens,
+		Engines:        map[string]*Engine{},
+		QueueSize:      DefaultQueueSize,
+		QueueWorkers:   DefaultQueueWorkers,
+		MaxBatchSize:   DefaultMaxBatchSize,
+		IndexChunkSize: DefaultIndexChunkSize,
+		IndexWorkers:   DefaultIndexWorkers,
+		RecentSize:     DefaultRecentSize,
+		Shards:         DefaultShards,
+		MaxRetries:     DefaultMaxRetries,
+		InvertedRanges: InvertedRangeError,
+		RetryBackoff:   DefaultRetryBackoff,
+
+		ClockSkewPolicy: ClockSkewTag,
+
+		RetentionLowWatermark: DefaultRetentionLowWatermark,
+		RetentionScope:        RetentionScopeGlobal,
+
+		DefaultOperator: OperatorOr,
+
+		ReadHeaderTimeout: DefaultReadHeaderTimeout,
+		ReadTimeout:       DefaultReadTimeout,
+		WriteTimeout:      DefaultWriteTimeout,
+		IdleTimeout:       DefaultIdleTimeout,
+
+		IPRateBurst: DefaultIPRateBurst,
+
+		HistorySize: DefaultHistorySize,
+
+		aliases: newAliasManager(),
+		history: newQueryHistory(),
 	}
+}
+
+// CreateDataDir creates DataDir if it's missing, which Start otherwise does
+// on its own, so a first run works out of the box.
+func (app *App) CreateDataDir() error {
+	return createDataDir(app.DataDir, app.DataDirMode)
+}
 
-	for _, token := range tokens {
+func (app *App) Start(port, user, pass string) {
+	app.startedAt = time.Now()
+	if app.readOnly {
+		if _, err := os.Stat(app.DataDir); err != nil {
+			logger.Fatalln(err)
+		}
+	} else if err := app.CreateDataDir(); err != nil {
+		logger.Fatalln(err)
+	}
+	for _, token := range app.Tokens {
 		app.engineForToken(token)
 	}
 
-	return app
+	if app.CompactAfter > 0 && !app.readOnly {
+		go app.compactLoop()
+	}
+	if (app.Retention > 0 || app.RetentionMaxSize > 0) && !app.readOnly {
+		go app.retentionLoop()
+	}
+	if app.WarmUpDays > 0 {
+		go app.warmUp()
+	}
+	if app.IPRateLimit > 0 {
+		app.ipLimiter = newIPLimiter(app.IPRateLimit, app.IPRateBurst)
+	}
+
+	addr := app.listenAddr(port)
+	if app.Addr != "" {
+		logger.Printf("started listening on %s\n", addr)
+	} else {
+		logger.Printf("started listening on port %s\n", port)
+	}
+	logger.Fatalln(app.server(addr, user, pass).ListenAndServe())
 }
 
-func (app *App) Start(port, user, pass string) {
+// listenAddr is the address Start listens on, Addr or port on every
+// interface.
+func (app *App) listenAddr(port string) string {
+	if app.Addr != "" {
+		return app.Addr
+	}
+	return ":" + port
+}
+
+// server is the HTTP server Start listens with, bounded by the app's
+// timeouts.
+func (app *App) server(addr, user, pass string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           app.handler(user, pass),
+		ReadHeaderTimeout: app.ReadHeaderTimeout,
+		ReadTimeout:       app.ReadTimeout,
+		WriteTimeout:      app.WriteTimeout,
+		IdleTimeout:       app.IdleTimeout,
+	}
+}
+
+// handler routes the requests of the server, user and pass being the
+// `-basic-auth` credentials.
+func (app *App) handler(user, pass string) http.Handler {
 	mux := http.NewServeMux()
 
 	staticFilesHandler := http.StripPrefix("/static/", http.FileServer(http.Dir("static")))
 	mux.Handle("/static/", staticFilesHandler)
+	auth := basicAuthMiddleware(user, pass, app.Users)
+	mux.HandleFunc("/bulk", app.handleBulk)
 	mux.HandleFunc("/bulk/", app.handleBulk)
-	mux.Handle("/stats", basicAuthMiddleware(user, pass)(http.HandlerFunc(app.handleStats)))
-	mux.Handle("/", basicAuthMiddleware(user, pass)(http.HandlerFunc(app.handleDashboard)))
-
-	log.Printf("started listening on port %s\n", port)
-	log.Fatalln(http.ListenAndServe(":"+port, mux))
+	mux.HandleFunc("/offset", app.handleOffset)
+	mux.HandleFunc("/info", app.handleInfo)
+	mux.Handle("/stats", gzipMiddleware(auth(unrestrictedMiddleware(http.HandlerFunc(app.handleStats)))))
+	mux.Handle("/log/", gzipMiddleware(auth(adminMiddleware(app.AdminToken)(http.HandlerFunc(app.handleLog)))))
+	mux.Handle("/search", gzipMiddleware(auth(http.HandlerFunc(app.handleSearch))))
+	mux.Handle("/export", gzipMiddleware(auth(http.HandlerFunc(app.handleExport))))
+	mux.Handle("/import", auth(unrestrictedMiddleware(adminMiddleware(app.AdminToken)(http.HandlerFunc(app.handleImport)))))
+	mux.Handle("/replay", auth(unrestrictedMiddleware(adminMiddleware(app.AdminToken)(http.HandlerFunc(app.handleReplay)))))
+	mux.Handle("/flush", auth(unrestrictedMiddleware(adminMiddleware(app.AdminToken)(http.HandlerFunc(app.handleFlush)))))
+	mux.Handle("/reload", auth(unrestrictedMiddleware(adminMiddleware(app.AdminToken)(http.HandlerFunc(app.handleReload)))))
+	mux.Handle("/snapshot", auth(unrestrictedMiddleware(adminOnlyMiddleware(app.AdminToken)(http.HandlerFunc(app.handleSnapshot)))))
+	mux.Handle("/indexes/", gzipMiddleware(auth(unrestrictedMiddleware(adminMiddleware(app.AdminToken)(http.HandlerFunc(app.handleIndexes))))))
+	mux.Handle("/recent", gzipMiddleware(auth(http.HandlerFunc(app.handleRecent))))
+	mux.Handle("/tail", gzipMiddleware(auth(http.HandlerFunc(app.handleTail))))
+	mux.Handle("/history", gzipMiddleware(auth(http.HandlerFunc(app.handleHistory))))
+	mux.Handle("/tokens", gzipMiddleware(auth(http.HandlerFunc(app.handleTokens))))
+	mux.Handle("/metrics", gzipMiddleware(auth(unrestrictedMiddleware(http.HandlerFunc(app.handleMetrics)))))
+	mux.Handle("/", rootOnlyMiddleware(auth(http.HandlerFunc(app.handleDashboard))))
+	return app.forwardedMiddleware(app.readOnlyMiddleware(mux))
 }
 
 func (app *App) handleStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	response := map[string]interface{}{}
-	for token, engine := range app.Engines {
-		response[token] = engine.Stats()
+	engines := app.engines()
+	tokens := sortedTokens(engines)
+	if token := r.URL.Query().Get("token"); token != "" {
+		if !contains(app.Tokens, token) {
+			writeError(w, 400, errorCodeInvalidToken, errUnknownToken.Error())
+			return
+		}
+		engines = map[string]*Engine{token: app.engineForToken(token)}
+		tokens = []string{token}
+	}
+
+	// encoding/json sorts map keys, which keeps the output stable for tools
+	// diffing snapshots of it.
+	var response interface{}
+	if r.URL.Query().Get("summary") == "1" {
+		indexesCount, docCount := 0, uint64(0)
+		lastIngest := time.Time{}
+		for _, token := range tokens {
+			if engineLastIngest := engines[token].LastIngest(); engineLastIngest.After(lastIngest) {
+				lastIngest = engineLastIngest
+			}
+			indexes, err := engines[token].Indexes()
+			if err != nil {
+				logger.Printf("error listing indexes: %v\n", err)
+				writeError(w, 500, errorCodeInternal, "Error listing indexes")
+				return
+			}
+			indexesCount += len(indexes)
+			for _, index := range indexes {
+				docCount += index.DocCount
+			}
+		}
+		summary := ingestionStats(lastIngest)
+		summary["tokensCount"] = len(tokens)
+		summary["indexesCount"] = indexesCount
+		summary["docCount"] = docCount
+		response = summary
+	} else {
+		stats := map[string]interface{}{}
+		for _, token := range tokens {
+			if r.URL.Query().Get("typed") == "1" {
+				indexStats, err := engines[token].IndexStats()
+				if err != nil {
+					logger.Printf("error reading index stats: %v\n", err)
+					writeError(w, 500, errorCodeInternal, "Error reading index stats")
+					return
+				}
+				stats[token] = map[string]interface{}{
+					"indexes":       indexStats,
+					"ingestion":     ingestionStats(engines[token].LastIngest()),
+					"searchLatency": engines[token].SearchLatency(),
+					"fieldCount":    engines[token].FieldCount(),
+				}
+				continue
+			}
+			tokenStats := map[string]interface{}{}
+			for key, indexStats := range engines[token].Stats() {
+				tokenStats[key] = indexStats
+			}
+			tokenStats["ingestion"] = ingestionStats(engines[token].LastIngest())
+			tokenStats["searchLatency"] = engines[token].SearchLatency()
+			tokenStats["fieldCount"] = engines[token].FieldCount()
+			stats[token] = tokenStats
+		}
+		response = stats
 	}
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, "Error serializing response", 500)
+		writeError(w, 500, errorCodeInternal, "Error serializing response")
 		return
 	}
 	w.Write(responseJSON)
 }
 
-func (app *App) handleDashboard(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.Error(w, "Not found", 404)
+func (app *App) handleTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, 405, errorCodeMethodNotAllowed, "only GET supported")
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 
-	token := r.URL.Query().Get("token")
-	if token == "" {
-		token = app.Tokens[0]
+	tokens := []map[string]interface{}{}
+	for _, token := range app.allowedTokens(r) {
+		engine := app.engineForToken(token)
+		indexes, err := engine.Indexes()
+		if err != nil {
+			logger.Printf("error listing indexes: %v\n", err)
+			writeError(w, 500, errorCodeInternal, "Error listing indexes")
+			return
+		}
+		tokens = append(tokens, map[string]interface{}{
+			"token":         token,
+			"indexes":       indexes,
+			"brokenIndexes": engine.BrokenIndexes(),
+		})
 	}
-	engine := app.engineForToken(token)
+	responseJSON, err := json.Marshal(map[string]interface{}{"tokens": tokens})
+	if err != nil {
+		writeError(w, 500, errorCodeInternal, "Error serializing response")
+		return
+	}
+	w.Write(responseJSON)
+}
+
+func (app *App) handleLog(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(r.URL.Path[len("/log/"):], "/", 2)
+	if len(parts) != 2 || !contains(app.allowedTokens(r), parts[0]) {
+		writeError(w, 404, errorCodeNotFound, "Not found")
+		return
+	}
+	engine := app.engineForToken(parts[0])
 
-	from := r.URL.Query().Get("from")
-	if from == "" {
-		from = time.Now().UTC().Add(-1 * 24 * time.Hour).Format(time.RFC3339)
+	var log *Log
+	var err error
+	switch r.Method {
+	case "GET":
+		log, err = engine.Get(parts[1])
+	case "PATCH":
+		fields := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+			writeError(w, 400, errorCodeBadRequest, "Invalid JSON object")
+			return
+		}
+		log, err = engine.Update(parts[1], fields)
+		if err == errImmutableField {
+			writeError(w, 400, errorCodeBadRequest, err.Error())
+			return
+		}
+	default:
+		writeError(w, 405, errorCodeMethodNotAllowed, "only GET and PATCH supported")
+		return
+	}
+	if err != nil {
+		logger.Printf("error fetching log: %v\n", err)
+		writeError(w, 500, errorCodeInternal, "Error fetching log")
+		return
+	} else if log == nil {
+		writeError(w, 404, errorCodeNotFound, "Not found")
+		return
 	}
-	to := r.URL.Query().Get("to")
-	if to == "" {
-		to = time.Now().UTC().Format(time.RFC3339)
+	responseJSON, err := json.Marshal(app.orderedFields(log.Data))
+	if err != nil {
+		writeError(w, 500, errorCodeInternal, "Error serializing response")
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
 
-	query := r.URL.Query().Get("query")
+// rootOnlyMiddleware answers a 404 for the paths no route matches, which
+// the `/` pattern otherwise catches, before asking typos and probes for
+// credentials or rendering the dashboard for them.
+func rootOnlyMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			writeError(w, 404, errorCodeNotFound, fmt.Sprintf("no route for %s, the dashboard is at /", r.URL.Path))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
 
-	queryWithTime := fmt.Sprintf(`%s time:>="%s" time:<="%s"`, query, from, to)
-	fmt.Println("query", queryWithTime)
-	search := bleve.NewSearchRequest(bleve.NewQueryStringQuery(queryWithTime))
-	search.SortBy([]string{"-time", "-_id"})
-	search.Fields = append(search.Fields, "time")
-	start := time.Now().UnixNano()
-	logs, err := engine.Search(search, 1000)
-	searchDuration := float64(time.Now().UnixNano()-start) / 1000000
-	if err != nil {
-		log.Println("error searching: ", err)
-		http.Error(w, "Error executing search", 500)
+func (app *App) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		app.handleSearch(w, r)
 		return
 	}
 
+	// Invalid parameters, like inverted ranges, are shown above the results
+	// with nothing searched, the page keeping what was asked for.
+	params, paramsError := app.parseSearchParams(r)
+	if paramsError != nil {
+		values := r.URL.Query()
+		params = &searchParams{
+			Token: values.Get("token"),
+			Query: values.Get("query"),
+			Level: values.Get("level"),
+			Sort:  values.Get("sort"),
+			From:  values.Get("from"),
+			To:    values.Get("to"),
+		}
+	}
+
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		tz = app.DisplayTZ
+	}
+	location, tzError := loadDisplayLocation(tz)
+	columns := parseColumns(r.URL.Query().Get("cols"))
+
+	// Syntax errors and searches spanning too many indexes are shown next to
+	// the query box rather than failing the whole page, so the query can be
+	// fixed in place.
+	levels := []TermCount{}
+	results := &searchResults{Logs: []*Log{}}
+	queryError := ""
+	from := params.from.In(location).Format("2006/01/02 15:04:05")
+	to := params.to.In(location).Format("2006/01/02 15:04:05")
+	if paramsError != nil {
+		from, to = params.From, params.To
+	} else if err := app.validateQuery(params); err != nil {
+		queryError = fmt.Sprintf("Invalid query: %v", err)
+	} else if group, err := app.searchGroup(params); err != nil {
+		queryError = fmt.Sprintf("Search too wide: %v", err)
+	} else {
+		levels, err = group.terms(params.timeQuery(), params.levelField, 10)
+		if err != nil {
+			logger.Println("error faceting levels: ", err)
+			http.Error(w, "Error executing search", 500)
+			return
+		}
+		results, err = app.search(params)
+		if err == errSearchTimeout {
+			queryError = "Search timed out, narrow it down or ask for partial results with partial=1"
+			results = &searchResults{Logs: []*Log{}}
+		} else if err != nil {
+			logger.Println("error searching: ", err)
+			http.Error(w, "Error executing search", 500)
+			return
+		} else {
+			app.recordQuery(r, params)
+		}
+	}
+
 	t := template.Must(template.New("").Parse(htmlDashboard))
-	err = t.Execute(w, map[string]interface{}{
-		"query":          query,
-		"tokens":         app.Tokens,
-		"selectedToken":  token,
-		"searchDuration": searchDuration,
-		"logsCount":      len(logs),
-		"logs":           logs,
+	if paramsError != nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(400)
+	}
+	err := t.Execute(w, map[string]interface{}{
+		"query":          params.Query,
+		"queryError":     queryError,
+		"paramsError":    paramsError,
+		"tz":             tz,
+		"location":       location,
+		"tzError":        tzError,
+		"level":          params.Level,
+		"sort":           params.Sort,
+		"cols":           strings.Join(columns, ","),
+		"columns":        columns,
+		"levels":         levels,
+		"tokens":         app.allowedTokens(r),
+		"selectedToken":  params.Token,
+		"searchDuration": results.Duration,
+		"logsCount":      len(results.Logs),
+		"total":          results.Total,
+		"indexes":        results.Indexes,
+		"warnings":       results.Warnings,
+		"logs":           results.Logs,
+		"history":        app.history.list(historyUser(r)),
+		"permalink":      dashboardPermalink(params, r.URL.Query()),
+		"pinned":         pinnedDashboardParams(r.URL.Query()),
+		"unpinned":       unpinnedDashboardURL(r.URL.Query()),
+		"from":           from,
+		"to":             to,
 	})
 	if err != nil {
-		log.Println(err)
+		logger.Println(err)
 		w.Write([]byte(err.Error()))
 	}
 }
 
+// wantsJSON tells if a dashboard request asks for the results as JSON, with
+// `format=json` or by accepting JSON but not HTML like API clients do.
+func wantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// parseColumns reads the comma separated fields the dashboard shows in
+// columns of their own.
+func parseColumns(cols string) []string {
+	columns := []string{}
+	for _, column := range strings.Split(cols, ",") {
+		column = strings.TrimSpace(column)
+		if column != "" && !contains(columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
 func (app *App) handleBulk(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		w.Write([]byte("only POST supported"))
+		writeError(w, 405, errorCodeMethodNotAllowed, "only POST supported")
 		return
 	}
 
-	token := r.URL.Path[len("/bulk/"):]
-	if !contains(app.Tokens, token) {
-		w.WriteHeader(401)
-		w.Write([]byte("invalid token"))
+	// Checked before the token so junk traffic is turned away as cheaply as
+	// possible.
+	if app.ipLimiter != nil && !app.ipLimiter.allow(remoteIP(r)) {
+		writeError(w, 429, errorCodeRateLimited, "too many requests")
 		return
 	}
 
-	body, err := ioutil.ReadAll(r.Body)
-	defer r.Body.Close()
+	token := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/bulk"), "/")
+	if token == "" {
+		token = app.DefaultToken
+	}
+	if !contains(app.Tokens, token) {
+		writeError(w, 401, errorCodeInvalidToken, "invalid token")
+		return
+	}
+	source, offset, hasOffset, err := bulkOffset(r)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("error reading body"))
+		writeError(w, 400, errorCodeBadRequest, err.Error())
 		return
 	}
 
-	parsedLogLines := []*Log{}
-	logLines := strings.Split(strings.TrimRight(string(body), "\n"), "\n")
-	for _, logLine := range logLines {
-		// Format:
-		// 1 <1>1 2011-11-13T01:11:11+00:00 host app web.1 - message
-		logLineParts := strings.SplitN(logLine, " ", 8)
-		if len(logLineParts) != 8 {
-			log.Printf("malformed line '%s'", logLine)
-			continue
+	defer r.Body.Close()
+	engine := app.engineForToken(token)
+	parser := newLogParser(engine.config(), engine.IDs)
+	parser.keepRaw = engine.StoreRaw
+	var parsedLogLines []*Log
+	if isMsgpack(r.Header.Get("Content-Type")) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, 500, errorCodeInternal, "error reading body")
+			return
 		}
-
-		parsedTime, err := time.Parse(time.RFC3339, logLineParts[2])
+		parsedLogLines, err = parser.parseMsgpack(body)
 		if err != nil {
-			log.Printf("malformed time '%s'", logLine)
-			continue
+			writeError(w, 400, errorCodeBadRequest, "invalid msgpack body")
+			return
 		}
-
-		message := logLineParts[7]
-		data := map[string]interface{}{}
-		data["host"] = logLineParts[3]
-		data["app"] = logLineParts[4]
-		data["process"] = logLineParts[5]
-		if message[0] == '{' && message[len(message)-1] == '}' {
-			if err := json.Unmarshal([]byte(message), &data); err != nil {
-				log.Printf("malformed json '%s'", logLine)
-				continue
-			}
-		} else {
-			data["msg"] = message
+	} else if isNDJSON(r.Header.Get("Content-Type")) {
+		var err error
+		parsedLogLines, err = parser.parseNDJSON(r.Body)
+		if err != nil {
+			writeError(w, 500, errorCodeInternal, "error reading body")
+			return
+		}
+	} else {
+		var err error
+		parsedLogLines, err = parser.parseLines(r.Body)
+		if err != nil {
+			writeError(w, 500, errorCodeInternal, "error reading body")
+			return
 		}
-		id := newUlid()
-		data["id"] = id
-		data["time"] = parsedTime
-
-		parsedLogLines = append(parsedLogLines, &Log{
-			Id:   id,
-			Time: parsedTime,
-			Data: data,
-		})
 	}
+	engine.recordMalformed(parser.received, parser.malformed, parser.malformedSamples)
 
-	if len(parsedLogLines) == 0 {
+	if hasOffset {
+		committed, err := engine.EnqueueAt(app.prepare(engine, parsedLogLines), source, offset)
+		w.Header().Set("X-Firlog-Offset", strconv.FormatUint(committed, 10))
+		if err != nil && err != errStaleOffset {
+			logger.Printf("error indexing: %v\n", err)
+			writeError(w, 500, errorCodeInternal, "error indexing logs")
+			return
+		}
 		w.WriteHeader(200)
 		return
 	}
-
-	engine := app.engineForToken(token)
-	if err := engine.Index(parsedLogLines); err != nil {
-		log.Printf("error indexing: %v\n", err)
-		w.WriteHeader(500)
-		w.Write([]byte("error indexing logs"))
+	if err := app.ingest(engine, parsedLogLines); err == ErrQueueFull {
+		writeError(w, 429, errorCodeQueueFull, "indexing queue full")
+		return
+	} else if err != nil {
+		logger.Printf("error indexing: %v\n", err)
+		writeError(w, 500, errorCodeInternal, "error indexing logs")
 		return
 	}
 	w.WriteHeader(200)
 }
 
+func (app *App) compactLoop() {
+	for range time.Tick(DefaultCompactInterval) {
+		for token, engine := range app.engines() {
+			if err := engine.Compact(time.Now().Add(-app.CompactAfter)); err != nil {
+				logger.Printf("error compacting %s: %v\n", token, err)
+			}
+		}
+	}
+}
+
+// enrich adds the fields derived from App-wide settings to freshly parsed logs.
+func (app *App) enrich(logs []*Log) {
+	if app.GeoIP == nil {
+		return
+	}
+	for _, log := range logs {
+		app.GeoIP.enrich(log.Data)
+	}
+}
+
 func (app *App) engineForToken(token string) *Engine {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
 	engine, ok := app.Engines[token]
 	if ok {
 		return engine
 	}
-	app.Engines[token] = NewEngine(filepath.Join(app.DataDir, token))
-	return app.Engines[token]
+	dataDir := filepath.Join(app.DataDir, token)
+	if app.readOnly {
+		engine, err := OpenEngineReadOnly(dataDir)
+		if err != nil {
+			panic(err)
+		}
+		app.Engines[token] = engine
+		return engine
+	}
+	engine, err := newEngine(dataDir, app.DataDirMode, false)
+	if err != nil {
+		panic(err)
+	}
+	engine.MaxRetries = app.MaxRetries
+	engine.RetryBackoff = app.RetryBackoff
+	engine.DeadLetter = app.DeadLetter
+	engine.WAL = app.WAL
+	engine.StoreRaw = app.StoreRaw
+	if app.Notifier != nil {
+		engine.Notifier = app.Notifier
+	}
+	engine.IndexChunkSize = app.IndexChunkSize
+	engine.IndexWorkers = app.IndexWorkers
+	engine.RecentSize = app.RecentSize
+	engine.Shards = app.Shards
+	engine.IndexPrefix = strings.Replace(app.IndexPrefix, "{token}", token, -1)
+	engine.MaxClockSkew = app.MaxClockSkew
+	engine.ClockSkewPolicy = app.ClockSkewPolicy
+	if app.IDs != nil {
+		engine.IDs = app.IDs
+	}
+	if app.DeadLetter {
+		replayed, err := engine.ReplayDeadLetters()
+		if err != nil {
+			logger.Printf("error replaying dead letters: %v\n", err)
+		} else if replayed > 0 {
+			logger.Printf("replayed %d dead-lettered logs\n", replayed)
+		}
+	}
+	// Left over segments are there whether WAL is still enabled or not.
+	replayed, err := engine.ReplayWAL()
+	if err != nil {
+		logger.Printf("error replaying wal: %v\n", err)
+	} else if replayed > 0 {
+		logger.Printf("replayed %d logs from the wal\n", replayed)
+	}
+	if app.QueueSize > 0 {
+		engine.StartQueue(app.QueueSize, app.QueueWorkers, app.MaxBatchSize, app.FlushInterval)
+	}
+	app.Engines[token] = engine
+	return engine
+}
+
+// engines returns a snapshot of the opened engines safe to range over while
+// requests keep opening new ones.
+func (app *App) engines() map[string]*Engine {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	engines := map[string]*Engine{}
+	for token, engine := range app.Engines {
+		engines[token] = engine
+	}
+	return engines
+}
+
+func sortedTokens(engines map[string]*Engine) []string {
+	tokens := []string{}
+	for token := range engines {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// loadDisplayLocation loads the named time zone, falling back to UTC with an
+// explanation when it doesn't exist.
+func loadDisplayLocation(name string) (*time.Location, string) {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC, fmt.Sprintf("Unknown time zone '%s', showing UTC times", name)
+	}
+	return location, ""
 }
 
 func contains(values []string, search string) bool {
@@ -219,12 +791,6 @@ func contains(values []string, search string) bool {
 	return false
 }
 
-func newUlid() string {
-	entropy := entropyPool.Get().(io.Reader)
-	defer entropyPool.Put(entropy)
-	return ulid.MustNew(ulid.Timestamp(time.Now().UTC()), entropy).String()
-}
-
 const htmlDashboard = `<!DOCTYPE html>
 <html>
 <head>
@@ -251,19 +817,26 @@ const htmlDashboard = `<!DOCTYPE html>
 	}
 	.log__time { color: hsl(217, 71%, 53%); }
 	.log__data { font-weight: bold; }
+	.log__col { display: inline-block; min-width: 8em; margin-right: 0.5em; }
+	.log__level { font-weight: bold; }
+	.log__level--error { color: hsl(348, 100%, 61%); }
+	.log__level--warn { color: hsl(44, 100%, 40%); }
+	.log__level--info { color: hsl(141, 71%, 38%); }
+	.log__level--debug { color: hsl(0, 0%, 48%); }
   </style>
 </head>
 <body>
   <div class="container">
 	<form class="hero is-light is-small">
 	  <div class="hero-body columns">
-		<div class="column is-3">
+		<div class="column is-2">
 		  <div class="field">
 			<label class="label">Token</label>
 			<div class="control">
 			  <div class="select is-fullwidth">
 				<select name="token">
 				  {{$selectedToken := .selectedToken}}
+				  <option value="*" {{if eq "*" $selectedToken}}selected{{end}}>All tokens</option>
 				  {{range $i, $token := .tokens}}
 					<option value="{{$token}}" {{if eq $token $selectedToken}}selected{{else}}{{end}}>{{$token}}</option>
 				  {{end}}
@@ -272,25 +845,105 @@ const htmlDashboard = `<!DOCTYPE html>
 			</div>
 		  </div>
 		</div>
+		<div class="column is-2">
+		  <div class="field">
+			<label class="label">Level</label>
+			<div class="control">
+			  <div class="select is-fullwidth">
+				<select name="level">
+				  <option value="">All</option>
+				  {{$level := .level}}
+				  {{range $i, $l := .levels}}
+					<option value="{{$l.Term}}" {{if eq $l.Term $level}}selected{{else}}{{end}}>{{$l.Term}} ({{$l.Count}})</option>
+				  {{end}}
+				</select>
+			  </div>
+			</div>
+		  </div>
+		</div>
+		<div class="column is-1">
+		  <div class="field">
+			<label class="label">Sort</label>
+			<div class="control">
+			  <div class="select is-fullwidth">
+				<select name="sort">
+				  <option value="time" {{if eq .sort "time"}}selected{{end}}>Newest</option>
+				  <option value="relevance" {{if eq .sort "relevance"}}selected{{end}}>Relevance</option>
+				</select>
+			  </div>
+			</div>
+		  </div>
+		</div>
+		<div class="column is-2">
+		  <div class="field">
+			<label class="label">Time zone</label>
+			<div class="control">
+			  <input class="input" type="text" name="tz" placeholder="UTC" value="{{.tz}}">
+			</div>
+		  </div>
+		</div>
+		<div class="column is-2">
+		  <div class="field">
+			<label class="label">Columns</label>
+			<div class="control">
+			  <input class="input" type="text" name="cols" placeholder="host,process" value="{{.cols}}">
+			</div>
+		  </div>
+		</div>
 		<div class="column">
 		  <div class="field">
 			<label class="label">Query</label>
 			<div class="control">
-			  <input class="input" type="text" name="query" placeholder="Query e.g.: 'started -worker port:8001'" value="{{.query}}">
+			  <input class="input{{if .queryError}} is-danger{{end}}" type="text" name="query" placeholder="Query e.g.: 'started -worker port:8001'" value="{{.query}}">
 			</div>
+			{{if .queryError}}
+			  <p class="help is-danger">{{.queryError}}</p>
+			{{end}}
+			{{if .history}}
+			  <div class="select is-small">
+				<select onchange="var o = this.options[this.selectedIndex]; this.form.query.value = o.value; this.form.token.value = o.dataset.token; this.form.submit()">
+				  <option value="" disabled selected>Recent queries</option>
+				  {{range .history}}
+					<option value="{{.Query}}" data-token="{{.Token}}">{{.Query}}{{if ne .Token "*"}} ({{.Token}}){{end}}</option>
+				  {{end}}
+				</select>
+			  </div>
+			{{end}}
 		  </div>
 		</div>
+		{{range .pinned}}
+		  <input type="hidden" name="{{.Name}}" value="{{.Value}}">
+		{{end}}
 	  </div>
 	</form>
+	{{if .paramsError}}
+	  <div class="notification is-danger">{{.paramsError}}</div>
+	{{end}}
+	{{if .tzError}}
+	  <div class="notification is-warning">{{.tzError}}</div>
+	{{end}}
+	{{range .warnings}}
+	  <div class="notification is-warning">{{.}}</div>
+	{{end}}
 	<div class="logs">
 	  <div class="logs__header">
-		<strong>{{.logsCount}} results</strong> Took {{.searchDuration | printf "%.2f"}}ms
+		<strong>{{if lt .logsCount .total}}{{.logsCount}} of {{end}}{{.total}} results</strong> Took {{.searchDuration | printf "%.2f"}}ms across {{.indexes}} indexes
+		from {{.from}} to {{.to}}
+		<a href="{{.permalink}}" title="Link to these results, which keeps showing them later" onclick="if (!navigator.clipboard) return true; navigator.clipboard.writeText(this.href); this.textContent = 'Link copied'; return false">Copy link</a>
+		{{if .pinned}}<a href="{{.unpinned}}">Back to the last day</a>{{end}}
+		{{if .columns}}
+		  <div class="log">
+			{{range .columns}}<strong class="log__col">{{.}}</strong>{{end}}
+		  </div>
+		{{end}}
 	  </div>
 	  {{range $i, $log := .logs}}
 		<div class="log">
-		  <span class="log__time">{{$log.FormattedTime}}</span>
-		  <span class="log__msg">{{$log.FormattedMessage}}</span>
-		  <span class="log__data">{{$log.FormattedData}}</span>
+		  {{range $.columns}}<span class="log__col">{{$log.Field .}}</span>{{end}}
+		  <span class="log__time">{{$log.FormattedTimeIn $.location}}</span>
+		  {{if $log.Level}}<span class="log__level {{$log.LevelClass}}">{{$log.Level}}</span>{{end}}
+		  {{if $log.HasMessage}}<span class="log__msg">{{$log.Message}}</span>{{end}}
+		  <span class="log__data">{{$log.FormattedDataExcept $.columns}}</span>
 		</div>
 	  {{end}}
 	</div>
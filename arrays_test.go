@@ -0,0 +1,56 @@
+package firlog
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestArrays(t *testing.T) {
+	app := newTestApp(t, `{"booleanFields": ["flags"], "keywordFields": ["ids"]}`)
+	postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+		`{"time": "2026-10-14T12:00:00Z", "msg": "first", "tags": ["alpha", "beta"], "nums": [1, 25], "flags": ["true", "FALSE"], "ids": ["abc-1", "abc-2"]}`,
+		`{"time": "2026-10-14T12:00:01Z", "msg": "second", "objs": [{"k": "v1", "n": 1}, {"k": "v2", "n": 2}]}`,
+	}, "\n"))
+
+	tests := map[string]int{
+		"tags:alpha":              1,
+		"tags:beta":               1,
+		"nums:25":                 1,
+		"nums:>=20":               1,
+		"nums:>30":                0,
+		"flags:true":              1,
+		"flags:false":             1,
+		`ids:"abc-2"`:             1,
+		"ids:abc":                 0,
+		"objs.k:v2":               1,
+		"+objs.k:v1 +objs.n:2":    1,
+		"+objs.k:v1 +objs.k:nope": 0,
+	}
+	for q, want := range tests {
+		var response struct {
+			Total int `json:"total"`
+		}
+		getJSON(t, app, "/search?token=app1&query="+url.QueryEscape(q)+dashboardRange, &response)
+		if response.Total != want {
+			t.Errorf("expected %s to match %d logs, got %d", q, want, response.Total)
+		}
+	}
+	if body := getJSON(t, app, "/?token=app1&cols=tags,nums,flags"+dashboardRange, nil).Body.String(); !strings.Contains(body, "alpha, beta") || !strings.Contains(body, "1, 25") || !strings.Contains(body, "true, false") {
+		t.Error("expected the dashboard to list array values in columns")
+	}
+}
+
+func TestLogField(t *testing.T) {
+	log := &Log{Data: map[string]interface{}{
+		"tags":  []interface{}{"alpha", "beta"},
+		"mixed": []interface{}{"a", 1.5, true},
+		"objs":  []interface{}{map[string]interface{}{"k": "v"}},
+	}}
+	tests := map[string]string{"tags": "alpha, beta", "mixed": "a, 1.5, true", "objs": `[{"k":"v"}]`, "missing": ""}
+	for field, want := range tests {
+		if got := log.Field(field); got != want {
+			t.Errorf("expected %s to display as %q, got %q", field, want, got)
+		}
+	}
+}
@@ -51,15 +51,22 @@ func authenticate(user, pass string, r *http.Request) bool {
 	return false
 }
 
-func basicAuthMiddleware(user string, pass string) func(http.Handler) http.Handler {
+// basicAuthMiddleware lets through the requests authenticated as user, which
+// can see every token, or as one of users, which can only see theirs. An
+// empty user only accepts users.
+func basicAuthMiddleware(user string, pass string, users map[string]*User) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !authenticate(user, pass, r) {
-				w.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
-				http.Error(w, "401 Bad authorization", http.StatusUnauthorized)
+			if user != "" && authenticate(user, pass, r) {
+				h.ServeHTTP(w, r)
 				return
 			}
-			h.ServeHTTP(w, r)
+			if restricted := authenticateUser(users, r); restricted != nil {
+				h.ServeHTTP(w, withUser(r, restricted))
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
+			writeError(w, http.StatusUnauthorized, errorCodeUnauthorized, "Bad authorization")
 		})
 	}
 }
@@ -0,0 +1,75 @@
+package firlog
+
+import (
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// detectBooleans turns "true" and "false" strings, such as extracted ones,
+// of the configured BooleanFields into booleans so they're indexed as such.
+// Arrays get their strings turned into booleans too, as boolean fields don't
+// index strings at all.
+func (c *TokenConfig) detectBooleans(data map[string]interface{}) {
+	for _, field := range c.BooleanFields {
+		switch value := data[field].(type) {
+		case string:
+			data[field] = parseBoolean(value)
+		case []interface{}:
+			for i, element := range value {
+				if s, ok := element.(string); ok {
+					value[i] = parseBoolean(s)
+				}
+			}
+		}
+	}
+}
+
+// parseBoolean turns "true" and "false", whatever their case, into booleans,
+// leaving other strings as they are.
+func parseBoolean(value string) interface{} {
+	switch strings.ToLower(value) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return value
+}
+
+// matchBooleans rewrites the `field:true` and `field:false` matches of a
+// parsed query string to also match boolean fields, which are indexed as
+// terms the query string's analyzed text never matches. Text fields holding
+// "true" or "false" keep matching.
+func matchBooleans(q query.Query) query.Query {
+	switch q := q.(type) {
+	case *query.BooleanQuery:
+		if q.Must != nil {
+			q.Must = matchBooleans(q.Must)
+		}
+		if q.Should != nil {
+			q.Should = matchBooleans(q.Should)
+		}
+		if q.MustNot != nil {
+			q.MustNot = matchBooleans(q.MustNot)
+		}
+	case *query.ConjunctionQuery:
+		for i, conjunct := range q.Conjuncts {
+			q.Conjuncts[i] = matchBooleans(conjunct)
+		}
+	case *query.DisjunctionQuery:
+		for i, disjunct := range q.Disjuncts {
+			q.Disjuncts[i] = matchBooleans(disjunct)
+		}
+	case *query.MatchQuery:
+		value := strings.ToLower(q.Match)
+		if q.FieldVal == "" || (value != "true" && value != "false") {
+			return q
+		}
+		boolQuery := bleve.NewBoolFieldQuery(value == "true")
+		boolQuery.SetField(q.FieldVal)
+		return bleve.NewDisjunctionQuery(q, boolQuery)
+	}
+	return q
+}
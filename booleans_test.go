@@ -0,0 +1,42 @@
+package firlog
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBooleanFields(t *testing.T) {
+	app := newTestApp(t, `{"booleanFields": ["cached"], "alertRules": [{"name": "uncached", "query": "cached:false", "threshold": 1, "window": "1m"}]}`)
+	notifier := &recordingNotifier{}
+	app.Notifier = notifier
+	postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+		`{"time": "2026-10-14T12:00:00Z", "msg": "hit", "cached": "TRUE", "ok": true}`,
+		`{"time": "2026-10-14T12:00:01Z", "msg": "miss", "cached": "false", "ok": false}`,
+		`{"time": "2026-10-14T12:00:02Z", "msg": "miss again", "cached": false, "note": "true"}`,
+	}, "\n"))
+
+	tests := map[string]int{
+		"cached:true":             1,
+		"cached:false":            2,
+		"ok:true":                 1,
+		"ok:FALSE":                1,
+		"note:true":               1,
+		"+cached:false -ok:false": 1,
+	}
+	for q, want := range tests {
+		var response struct {
+			Total int `json:"total"`
+		}
+		getJSON(t, app, "/search?token=app1&query="+url.QueryEscape(q)+dashboardRange, &response)
+		if response.Total != want {
+			t.Errorf("expected %s to match %d logs, got %d", q, want, response.Total)
+		}
+	}
+	if log := app.engineForToken("app1").Recent(3)[2]; log.Data["cached"] != true {
+		t.Errorf("expected \"TRUE\" to be turned into a boolean, got %#v", log.Data["cached"])
+	}
+	if count := notifier.count(); count != 1 {
+		t.Errorf("expected alert rules to match boolean fields, got %d alerts", count)
+	}
+}
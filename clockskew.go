@@ -0,0 +1,60 @@
+package firlog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ClockSkewTag keeps logs timestamped too far in the future as they
+	// are, tagged with `clock_skew`, ClockSkewClamp moves them to the time
+	// they're received at and ClockSkewReject drops them.
+	ClockSkewTag    = "tag"
+	ClockSkewClamp  = "clamp"
+	ClockSkewReject = "reject"
+)
+
+// CheckClockSkew applies ClockSkewPolicy to the logs timestamped more than
+// MaxClockSkew after now, which would otherwise land in future indexes
+// default search ranges and retention don't look at. Clamped logs keep the
+// time they had as `original_time`.
+func (e *Engine) CheckClockSkew(logs []*Log, now time.Time) []*Log {
+	if e.MaxClockSkew <= 0 {
+		return logs
+	}
+
+	limit := now.Add(e.MaxClockSkew)
+	kept := []*Log{}
+	skewed := 0
+	for _, log := range logs {
+		if !log.Time.After(limit) {
+			kept = append(kept, log)
+			continue
+		}
+		skewed++
+		switch e.ClockSkewPolicy {
+		case ClockSkewReject:
+			continue
+		case ClockSkewClamp:
+			log.Data["original_time"] = log.Time.Format(time.RFC3339Nano)
+			log.Time = now.UTC()
+			log.Data["time"] = log.Time
+		default:
+			log.Data["clock_skew"] = true
+		}
+		kept = append(kept, log)
+	}
+	if skewed > 0 {
+		atomic.AddInt64(&e.clockSkewed, int64(skewed))
+		if e.ClockSkewPolicy == ClockSkewReject {
+			logger.Printf("dropped %d logs of %s timestamped more than %s ahead\n", skewed, e.token(), e.MaxClockSkew)
+		}
+	}
+	return kept
+}
+
+// ClockSkewed returns how many logs were timestamped too far in the future
+// since startup.
+func (e *Engine) ClockSkewed() int64 {
+	return atomic.LoadInt64(&e.clockSkewed)
+}
@@ -0,0 +1,64 @@
+package firlog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	tests := []struct {
+		policy  string
+		maxSkew time.Duration
+		kept    int
+		check   func(*Log) bool
+	}{
+		{ClockSkewTag, 0, 2, func(log *Log) bool { return log.Data["clock_skew"] == nil && log.Time.Equal(testTime.Add(2*time.Hour)) }},
+		{ClockSkewTag, time.Hour, 2, func(log *Log) bool {
+			return log.Data["clock_skew"] == true && log.Time.Equal(testTime.Add(2*time.Hour))
+		}},
+		{ClockSkewClamp, time.Hour, 2, func(log *Log) bool {
+			return log.Time.Equal(testTime) && log.Data["time"] == log.Time && log.Data["original_time"] == "2026-10-14T14:00:00Z"
+		}},
+		{ClockSkewReject, time.Hour, 1, nil},
+	}
+	for _, test := range tests {
+		e := newTestEngine(t)
+		e.MaxClockSkew = test.maxSkew
+		e.ClockSkewPolicy = test.policy
+		logs := testLogs(e, "started", 2)
+		logs[1].Time = testTime.Add(2 * time.Hour)
+		kept := e.CheckClockSkew(logs, testTime)
+		if len(kept) != test.kept || kept[0] != logs[0] || logs[0].Data["clock_skew"] != nil || !logs[0].Time.Equal(testTime) {
+			t.Errorf("%s %s: expected %d logs with the first untouched, got %v", test.policy, test.maxSkew, test.kept, kept)
+			continue
+		}
+		if test.check != nil && !test.check(kept[1]) {
+			t.Errorf("%s %s: unexpected skewed log at %s: %v", test.policy, test.maxSkew, kept[1].Time, kept[1].Data)
+		}
+		skewed := int64(1)
+		if test.maxSkew == 0 {
+			skewed = 0
+		}
+		if e.ClockSkewed() != skewed {
+			t.Errorf("%s %s: expected %d skewed logs, got %d", test.policy, test.maxSkew, skewed, e.ClockSkewed())
+		}
+	}
+}
+
+func TestBulkClockSkew(t *testing.T) {
+	app := newTestApp(t, "")
+	app.MaxClockSkew = time.Hour
+	app.ClockSkewPolicy = ClockSkewReject
+	postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+		`{"time": "` + time.Now().UTC().Format(time.RFC3339) + `", "msg": "now"}`,
+		`{"time": "2030-01-01T00:00:00Z", "msg": "future"}`,
+	}, "\n"))
+	engine := app.engineForToken("app1")
+	if count := docCount(t, engine); count != 1 {
+		t.Errorf("expected the future log to be dropped, got %d logs", count)
+	}
+	if body := getJSON(t, app, "/metrics", nil).Body.String(); !strings.Contains(body, `firlog_clock_skewed_total{token="app1"} 1`) {
+		t.Errorf("expected the dropped log to be counted, got %s", body)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kiasaki/firlog"
+)
+
+// flushOnSignal flushes every index of app each time the process gets
+// SIGUSR1, without stopping it.
+func flushOnSignal(app *firlog.App) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			app.FlushAll()
+		}
+	}()
+}
@@ -0,0 +1,7 @@
+package main
+
+import "github.com/kiasaki/firlog"
+
+// flushOnSignal does nothing as there is no SIGUSR1 on Windows, `POST /flush`
+// being the way to flush there.
+func flushOnSignal(app *firlog.App) {}
@@ -3,34 +3,411 @@ package main
 import (
 	"flag"
 	"log"
+	"net"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kiasaki/firlog"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		runQuery(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		runRestore(os.Args[2:])
+		return
+	}
+
 	var port string
 	flag.StringVar(&port, "port", getEnv("PORT", "3000"), "Port for the HTTP server to listen on")
 
+	var addr string
+	flag.StringVar(&addr, "addr", getEnv("ADDR", ""), "Address (`host:port`) for the HTTP server to listen on, taking precedence over `port`")
+
 	var dataDir string
 	flag.StringVar(&dataDir, "data-dir", getEnv("DATA_DIR", "data"), "Specifies the directory to store data in")
 
+	var dataDirMode string
+	flag.StringVar(&dataDirMode, "data-dir-mode", getEnv("DATA_DIR_MODE", strconv.FormatUint(uint64(firlog.DefaultDataDirMode), 8)), "Octal permissions missing data directories are created with")
+
 	var tokensString string
 	flag.StringVar(&tokensString, "tokens", getEnv("TOKENS", ""), "Valid auth tokens")
-	if len(tokensString) == 0 {
-		log.Fatalln("Missing `tokens` config")
-	}
-	tokens := strings.Split(tokensString, ",")
+
+	var defaultToken string
+	flag.StringVar(&defaultToken, "default-token", getEnv("DEFAULT_TOKEN", ""), "Token `POST /bulk` requests without one in their path go to, one of `tokens`")
 
 	var basicAuthString string
 	flag.StringVar(&basicAuthString, "basic-auth", getEnv("BASIC_AUTH", ""), "'user:pass' pair for basic auth")
+
+	var usersFile string
+	flag.StringVar(&usersFile, "users-file", getEnv("USERS_FILE", ""), "JSON file of the dashboard users, next to `basic-auth`, that can only see some tokens")
+
+	var adminToken string
+	flag.StringVar(&adminToken, "admin-token", getEnv("ADMIN_TOKEN", ""), "Token admin endpoints (repair, quarantine, optimize, replay) require in X-Admin-Token, disabled without one")
+
+	var queueSize int
+	flag.IntVar(&queueSize, "queue-size", getEnvInt("QUEUE_SIZE", firlog.DefaultQueueSize), "Bulk requests buffered per token before rejecting with 429 (0 indexes synchronously)")
+
+	var queueWorkers int
+	flag.IntVar(&queueWorkers, "queue-workers", getEnvInt("QUEUE_WORKERS", firlog.DefaultQueueWorkers), "Indexing goroutines per token")
+
+	var maxBatchSize int
+	flag.IntVar(&maxBatchSize, "max-batch-size", getEnvInt("MAX_BATCH_SIZE", firlog.DefaultMaxBatchSize), "Maximum number of logs coalesced into one index batch")
+
+	var flushInterval time.Duration
+	flag.DurationVar(&flushInterval, "flush-interval", getEnvDuration("FLUSH_INTERVAL", 0), "How long queued logs can wait for a batch to reach max-batch-size (0 indexes them right away)")
+
+	var indexChunkSize int
+	flag.IntVar(&indexChunkSize, "index-chunk-size", getEnvInt("INDEX_CHUNK_SIZE", firlog.DefaultIndexChunkSize), "Maximum number of logs applied in a single index batch, bigger requests are split")
+
+	var indexWorkers int
+	flag.IntVar(&indexWorkers, "index-workers", getEnvInt("INDEX_WORKERS", firlog.DefaultIndexWorkers), "Dates of a same request indexed concurrently, speeding up backfills")
+	var recentSize int
+	flag.IntVar(&recentSize, "recent-size", getEnvInt("RECENT_SIZE", firlog.DefaultRecentSize), "Newest logs of each token kept in memory for /recent, 0 disabling it")
+
+	var shards int
+	flag.IntVar(&shards, "shards", getEnvInt("SHARDS", firlog.DefaultShards), "Indexes the logs of new dates are split into, for more write concurrency at high rates")
+	var indexPrefix string
+	flag.StringVar(&indexPrefix, "index-prefix", getEnv("INDEX_PREFIX", ""), "Prefix of the directory names of new indexes, '{token}' standing for the token, e.g. 'firlog-{token}'")
+
+	var maxRetries int
+	flag.IntVar(&maxRetries, "max-retries", getEnvInt("MAX_RETRIES", firlog.DefaultMaxRetries), "Times a failed index batch is retried")
+
+	var retryBackoff time.Duration
+	flag.DurationVar(&retryBackoff, "retry-backoff", getEnvDuration("RETRY_BACKOFF", firlog.DefaultRetryBackoff), "Delay before the first retry, doubled on every attempt")
+
+	var notifierKind string
+	flag.StringVar(&notifierKind, "notifier", getEnv("NOTIFIER", firlog.NotifierStderr), "Where alerts go unless their rule has a webhook: 'stderr', 'webhook' or 'none'")
+
+	var notifierURL string
+	flag.StringVar(&notifierURL, "notifier-url", getEnv("NOTIFIER_URL", ""), "URL alerts are POSTed to with the 'webhook' notifier")
+
+	var storeRaw bool
+	flag.BoolVar(&storeRaw, "store-raw", getEnvBool("STORE_RAW", false), "Store the raw lines logs are parsed from so POST /replay can reparse them")
+
+	var wal bool
+	flag.BoolVar(&wal, "wal", getEnvBool("WAL", false), "Record bulk requests to a write-ahead log until indexed and replay it on startup")
+
+	var deadLetter bool
+	flag.BoolVar(&deadLetter, "dead-letter", getEnvBool("DEAD_LETTER", false), "Write batches that keep failing to disk and replay them on startup")
+
+	var geoIPDB string
+	flag.StringVar(&geoIPDB, "geoip-db", getEnv("GEOIP_DB", ""), "Path to a MaxMind City or Country database used to geolocate IPs")
+
+	var geoIPField string
+	flag.StringVar(&geoIPField, "geoip-field", getEnv("GEOIP_FIELD", firlog.DefaultGeoIPField), "Log field holding the IP to geolocate")
+
+	var compactAfter time.Duration
+	flag.DurationVar(&compactAfter, "compact-after", getEnvDuration("COMPACT_AFTER", 0), "Merge the daily indexes of months that ended this long ago into monthly ones, e.g. '720h' (0 disables)")
+
+	var warmUpDays int
+	flag.IntVar(&warmUpDays, "warm-up-days", getEnvInt("WARM_UP_DAYS", 0), "Number of latest days of logs to warm up in the background on startup (0 disables)")
+
+	var retention time.Duration
+	flag.DurationVar(&retention, "retention", getEnvDuration("RETENTION", 0), "Delete indexes once their last date is this old, e.g. '720h' (0 keeps them forever)")
+
+	var retentionMaxSize string
+	flag.StringVar(&retentionMaxSize, "retention-max-size", getEnv("RETENTION_MAX_SIZE", "0"), "Delete the oldest indexes once they take more than this on disk, e.g. '50GB' (0 disables)")
+
+	var retentionLowWatermark float64
+	flag.Float64Var(&retentionLowWatermark, "retention-low-watermark", getEnvFloat("RETENTION_LOW_WATERMARK", firlog.DefaultRetentionLowWatermark), "Fraction of `retention-max-size` indexes are brought back under")
+
+	var retentionScope string
+	flag.StringVar(&retentionScope, "retention-scope", getEnv("RETENTION_SCOPE", firlog.RetentionScopeGlobal), "Whether `retention-max-size` applies to all tokens together ('global') or to each one ('token')")
+
+	var maxClockSkew time.Duration
+	flag.DurationVar(&maxClockSkew, "max-clock-skew", getEnvDuration("MAX_CLOCK_SKEW", 0), "How far in the future bulk logs can be timestamped before `clock-skew-policy` applies, e.g. '1h' (0 disables)")
+
+	var clockSkewPolicy string
+	flag.StringVar(&clockSkewPolicy, "clock-skew-policy", getEnv("CLOCK_SKEW_POLICY", firlog.ClockSkewTag), "What's done with logs timestamped too far in the future: 'tag', 'clamp' or 'reject'")
+
+	var displayTZ string
+	flag.StringVar(&displayTZ, "display-tz", getEnv("DISPLAY_TZ", "UTC"), "Time zone the dashboard shows times in, e.g. 'America/Montreal'")
+
+	var readHeaderTimeout time.Duration
+	flag.DurationVar(&readHeaderTimeout, "read-header-timeout", getEnvDuration("READ_HEADER_TIMEOUT", firlog.DefaultReadHeaderTimeout), "Time allowed to read request headers (0 disables)")
+
+	var readTimeout time.Duration
+	flag.DurationVar(&readTimeout, "read-timeout", getEnvDuration("READ_TIMEOUT", firlog.DefaultReadTimeout), "Time allowed to read a whole request, body included (0 disables)")
+
+	var writeTimeout time.Duration
+	flag.DurationVar(&writeTimeout, "write-timeout", getEnvDuration("WRITE_TIMEOUT", firlog.DefaultWriteTimeout), "Time allowed to write a response, snapshots included (0 disables)")
+
+	var idleTimeout time.Duration
+	flag.DurationVar(&idleTimeout, "idle-timeout", getEnvDuration("IDLE_TIMEOUT", firlog.DefaultIdleTimeout), "Time keep-alive connections are kept open between requests (0 disables)")
+
+	var ipRateLimit float64
+	flag.Float64Var(&ipRateLimit, "ip-rate-limit", getEnvFloat("IP_RATE_LIMIT", 0), "Bulk requests per second allowed per remote IP before answering 429 (0 disables)")
+
+	var ipRateBurst int
+	flag.IntVar(&ipRateBurst, "ip-rate-burst", getEnvInt("IP_RATE_BURST", firlog.DefaultIPRateBurst), "Bulk requests a remote IP can make at once on top of `ip-rate-limit`")
+
+	var trustedProxiesString string
+	flag.StringVar(&trustedProxiesString, "trusted-proxies", getEnv("TRUSTED_PROXIES", ""), "Comma separated CIDRs of the proxies whose X-Forwarded-For, -Host and -Proto headers are trusted")
+
+	var invertedRanges string
+	flag.StringVar(&invertedRanges, "inverted-ranges", getEnv("INVERTED_RANGES", firlog.InvertedRangeError), "What to do with searches whose 'from' is after 'to': 'error' or 'swap'")
+
+	var maxSearchAge time.Duration
+	flag.DurationVar(&maxSearchAge, "max-search-age", getEnvDuration("MAX_SEARCH_AGE", 0), "How far back searches can go, e.g. '720h' to match data kept for 30 days (0 disables)")
+
+	var maxSearchIndexes int
+	flag.IntVar(&maxSearchIndexes, "max-search-indexes", getEnvInt("MAX_SEARCH_INDEXES", 0), "Most daily or monthly indexes a single search can go through (0 disables)")
+
+	var searchTimeout time.Duration
+	flag.DurationVar(&searchTimeout, "search-timeout", getEnvDuration("SEARCH_TIMEOUT", 0), "How long searches can take before failing with a 504, or returning what was found with 'partial=1' (0 disables)")
+
+	var defaultOperator string
+	flag.StringVar(&defaultOperator, "default-operator", getEnv("DEFAULT_OPERATOR", firlog.OperatorOr), "How query terms without '+' or '-' combine: 'or' (any of them matching) or 'and' (all of them)")
+
+	var maxQueryLength int
+	flag.IntVar(&maxQueryLength, "max-query-length", getEnvInt("MAX_QUERY_LENGTH", 0), "Longest query, in bytes, searches can have (0 disables)")
+
+	var maxQueryClauses int
+	flag.IntVar(&maxQueryClauses, "max-query-clauses", getEnvInt("MAX_QUERY_CLAUSES", 0), "Most terms, phrases and ranges a query can have (0 disables)")
+
+	var rejectLeadingWildcards bool
+	flag.BoolVar(&rejectLeadingWildcards, "reject-leading-wildcards", getEnvBool("REJECT_LEADING_WILDCARDS", false), "Reject query terms starting with a wildcard, like '*son', which go through every term of the indexes")
+
+	var crossFieldSearch bool
+	flag.BoolVar(&crossFieldSearch, "cross-field-search", getEnvBool("CROSS_FIELD_SEARCH", false), "Match query terms without a field against every field with its own analyzer, finding keyword values too, at the cost of slower searches")
+
+	var historySize int
+	flag.IntVar(&historySize, "history-size", getEnvInt("HISTORY_SIZE", firlog.DefaultHistorySize), "Recent queries kept per basic auth user for the dashboard and GET /history (0 disables)")
+
+	var historyExclude string
+	flag.StringVar(&historyExclude, "history-exclude", getEnv("HISTORY_EXCLUDE", ""), "Comma separated regexps of the queries never kept in history, e.g. 'password,email:'")
+
+	var fieldOrder string
+	flag.StringVar(&fieldOrder, "field-order", getEnv("FIELD_ORDER", ""), "Comma separated fields logs of JSON responses start with, e.g. 'time,level,msg', the others following alphabetically")
+
+	var idStrategy string
+	flag.StringVar(&idStrategy, "id-strategy", getEnv("ID_STRATEGY", firlog.IDStrategyULID), "How log IDs are generated: 'ulid', 'sequential' or 'hash' of their content")
+	var ulidEntropy string
+	flag.StringVar(&ulidEntropy, "ulid-entropy", getEnv("ULID_ENTROPY", firlog.ULIDEntropyCrypto), "Where the random part of ULIDs comes from: 'crypto' (crypto/rand) or 'math' (a math/rand source seeded once)")
+
+	var logFormat string
+	flag.StringVar(&logFormat, "log-format", getEnv("LOG_FORMAT", firlog.LogFormatText), "Log output format, 'text' or 'json'")
+
+	var debug bool
+	flag.BoolVar(&debug, "debug", getEnvBool("DEBUG", false), "Log debug messages, including search queries")
+
+	var importFile string
+	flag.StringVar(&importFile, "import-file", "", "Syslog or NDJSON file (optionally gzipped) to index on startup")
+
+	var importToken string
+	flag.StringVar(&importToken, "import-token", "", "Token to import `import-file` into (defaults to the first token)")
+
+	var importExit bool
+	flag.BoolVar(&importExit, "import-exit", false, "Exit once `import-file` is imported instead of starting the server")
+
+	flag.Parse()
+
+	if logFormat != firlog.LogFormatText && logFormat != firlog.LogFormatJSON {
+		log.Fatalf("Unknown `log-format` '%s'\n", logFormat)
+	}
+	logger := firlog.NewLogger(os.Stderr, logFormat, debug)
+	firlog.SetLogger(logger)
+
+	if len(tokensString) == 0 {
+		logger.Fatalln("Missing `tokens` config")
+	}
+	tokens, warnings, err := firlog.ParseTokens(tokensString)
+	if err != nil {
+		logger.Fatalf("Invalid `tokens`: %v\n", err)
+	}
+	for _, warning := range warnings {
+		logger.Printf("Warning: %s\n", warning)
+	}
+	if defaultToken != "" && !contains(tokens, defaultToken) {
+		logger.Fatalln("Invalid `default-token`, must be one of `tokens`")
+	}
+
+	users := map[string]*firlog.User{}
+	if usersFile != "" {
+		users, err = firlog.LoadUsers(usersFile)
+		if err != nil {
+			logger.Fatalf("Invalid `users-file`: %v\n", err)
+		}
+		for name, user := range users {
+			for _, token := range user.Tokens {
+				if !contains(tokens, token) {
+					logger.Fatalf("Invalid `users-file`, user '%s' has unknown token '%s'\n", name, token)
+				}
+			}
+		}
+	}
+
 	basicAuthCredentials := strings.SplitN(basicAuthString, ":", 2)
+	if usersFile != "" && basicAuthString == "" {
+		basicAuthCredentials = []string{"", ""}
+	}
 	if len(basicAuthCredentials) != 2 {
-		log.Fatalln("Missing `basic-auth` config")
+		logger.Fatalln("Missing `basic-auth` config")
+	}
+
+	if addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			logger.Fatalf("Invalid `addr` '%s': %v\n", addr, err)
+		}
+	}
+
+	if _, err := time.LoadLocation(displayTZ); err != nil {
+		logger.Printf("Unknown `display-tz` '%s', using UTC\n", displayTZ)
+		displayTZ = "UTC"
+	}
+
+	logger.Printf("firlog %s\n", firlog.Version)
+	trustedProxies, err := firlog.ParseCIDRs(trustedProxiesString)
+	if err != nil {
+		logger.Fatalf("Invalid `trusted-proxies`: %v\n", err)
+	}
+	if invertedRanges != firlog.InvertedRangeError && invertedRanges != firlog.InvertedRangeSwap {
+		logger.Fatalf("Unknown `inverted-ranges` '%s'\n", invertedRanges)
+	}
+
+	if warmUpDays < 0 {
+		logger.Fatalf("Invalid `warm-up-days` %d, must be at least 0\n", warmUpDays)
+	}
+
+	if defaultOperator != firlog.OperatorOr && defaultOperator != firlog.OperatorAnd {
+		logger.Fatalf("Unknown `default-operator` '%s'\n", defaultOperator)
+	}
+
+	if recentSize < 0 {
+		logger.Fatalf("Invalid `recent-size` %d, must be at least 0\n", recentSize)
+	}
+	if historySize < 0 {
+		logger.Fatalf("Invalid `history-size` %d, must be at least 0\n", historySize)
+	}
+	historyExcludePatterns := []*regexp.Regexp{}
+	for _, pattern := range splitList(historyExclude) {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Fatalf("Invalid `history-exclude` '%s': %v\n", pattern, err)
+		}
+		historyExcludePatterns = append(historyExcludePatterns, compiled)
+	}
+
+	parsedDataDirMode, err := strconv.ParseUint(dataDirMode, 8, 32)
+	if err != nil || parsedDataDirMode > 0777 {
+		logger.Fatalf("Invalid `data-dir-mode` '%s'\n", dataDirMode)
+	}
+
+	if shards < 1 {
+		logger.Fatalf("Invalid `shards` %d, must be at least 1\n", shards)
+	}
+	if strings.ContainsAny(indexPrefix, "/\\") || strings.HasPrefix(indexPrefix, ".") {
+		logger.Fatalf("Invalid `index-prefix` '%s', must not hold slashes or start with a dot\n", indexPrefix)
+	}
+
+	parsedRetentionMaxSize, err := firlog.ParseSize(retentionMaxSize)
+	if err != nil {
+		logger.Fatalf("Invalid `retention-max-size`: %v\n", err)
+	}
+	if retentionLowWatermark <= 0 || retentionLowWatermark > 1 {
+		logger.Fatalf("Invalid `retention-low-watermark` %v, must be between 0 and 1\n", retentionLowWatermark)
+	}
+	if retentionScope != firlog.RetentionScopeGlobal && retentionScope != firlog.RetentionScopeToken {
+		logger.Fatalf("Unknown `retention-scope` '%s'\n", retentionScope)
+	}
+	if clockSkewPolicy != firlog.ClockSkewTag && clockSkewPolicy != firlog.ClockSkewClamp && clockSkewPolicy != firlog.ClockSkewReject {
+		logger.Fatalf("Unknown `clock-skew-policy` '%s'\n", clockSkewPolicy)
+	}
+
+	notifier, err := firlog.NewNotifier(notifierKind, notifierURL)
+	if err != nil {
+		logger.Fatalf("Invalid `notifier`: %v\n", err)
+	}
+
+	ids, err := firlog.NewIDGenerator(idStrategy)
+	if err != nil {
+		logger.Fatalln(err)
+	}
+	if err := firlog.SetULIDEntropy(ulidEntropy); err != nil {
+		logger.Fatalln(err)
 	}
 
 	app := firlog.NewApp(dataDir, tokens)
+	app.DataDirMode = os.FileMode(parsedDataDirMode)
+	if err := app.CreateDataDir(); err != nil {
+		logger.Fatalln(err)
+	}
+	app.IDs = ids
+	app.DefaultToken = defaultToken
+	app.Addr = addr
+	app.Users = users
+	app.QueueSize = queueSize
+	app.QueueWorkers = queueWorkers
+	app.MaxBatchSize = maxBatchSize
+	app.FlushInterval = flushInterval
+	app.IndexChunkSize = indexChunkSize
+	app.IndexWorkers = indexWorkers
+	app.RecentSize = recentSize
+	app.Shards = shards
+	app.IndexPrefix = indexPrefix
+	app.MaxRetries = maxRetries
+	app.RetryBackoff = retryBackoff
+	app.DeadLetter = deadLetter
+	app.WAL = wal
+	app.StoreRaw = storeRaw
+	app.Notifier = notifier
+	app.CompactAfter = compactAfter
+	app.WarmUpDays = warmUpDays
+	app.Retention = retention
+	app.RetentionMaxSize = parsedRetentionMaxSize
+	app.RetentionLowWatermark = retentionLowWatermark
+	app.RetentionScope = retentionScope
+	app.MaxClockSkew = maxClockSkew
+	app.ClockSkewPolicy = clockSkewPolicy
+	app.DisplayTZ = displayTZ
+	app.ReadHeaderTimeout = readHeaderTimeout
+	app.ReadTimeout = readTimeout
+	app.WriteTimeout = writeTimeout
+	app.IdleTimeout = idleTimeout
+	app.IPRateLimit = ipRateLimit
+	app.IPRateBurst = ipRateBurst
+	app.TrustedProxies = trustedProxies
+	app.AdminToken = adminToken
+	if adminToken != "" && len(adminToken) < firlog.MinTokenLength {
+		logger.Printf("Warning: the admin token is shorter than %d characters, it could be guessed\n", firlog.MinTokenLength)
+	}
+	app.InvertedRanges = invertedRanges
+	app.MaxSearchAge = maxSearchAge
+	app.MaxSearchIndexes = maxSearchIndexes
+	app.SearchTimeout = searchTimeout
+	app.CrossFieldSearch = crossFieldSearch
+	app.DefaultOperator = defaultOperator
+	app.MaxQueryLength = maxQueryLength
+	app.MaxQueryClauses = maxQueryClauses
+	app.RejectLeadingWildcards = rejectLeadingWildcards
+	app.HistorySize = historySize
+	app.FieldOrder = splitList(fieldOrder)
+	app.HistoryExclude = historyExcludePatterns
+	if geoIPDB != "" {
+		app.GeoIP = firlog.NewGeoIP(geoIPDB, geoIPField)
+		go app.GeoIP.Watch(firlog.DefaultGeoIPCheckInterval)
+	}
+	if importFile != "" {
+		if importToken == "" {
+			importToken = tokens[0]
+		}
+		imported, err := app.ImportFile(importToken, importFile)
+		if err != nil {
+			logger.Fatalf("Error importing `%s` after %d logs: %v\n", importFile, imported, err)
+		}
+		logger.Printf("imported %d logs from %s\n", imported, importFile)
+		if importExit {
+			return
+		}
+	}
+
+	flushOnSignal(app)
 	app.Start(port, basicAuthCredentials[0], basicAuthCredentials[1])
 }
 
@@ -41,3 +418,55 @@ func getEnv(name, alt string) string {
 	}
 	return value
 }
+
+func getEnvInt(name string, alt int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return alt
+	}
+	return value
+}
+
+func getEnvFloat(name string, alt float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return alt
+	}
+	return value
+}
+
+func getEnvDuration(name string, alt time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return alt
+	}
+	return value
+}
+
+func getEnvBool(name string, alt bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return alt
+	}
+	return value
+}
+
+func contains(values []string, search string) bool {
+	for _, value := range values {
+		if value == search {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated flag into its non-empty values.
+func splitList(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kiasaki/firlog"
+)
+
+// runQuery implements `firlog query`, searching a running firlog through its
+// JSON search API and printing the matching logs.
+func runQuery(args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+
+	var serverURL string
+	flags.StringVar(&serverURL, "url", getEnv("FIRLOG_URL", "http://localhost:3000"), "Base URL of the firlog server")
+
+	var basicAuthString string
+	flags.StringVar(&basicAuthString, "basic-auth", getEnv("BASIC_AUTH", ""), "'user:pass' pair for basic auth")
+
+	var token string
+	flags.StringVar(&token, "token", "", "Token to search (defaults to the server's first token)")
+
+	var from string
+	flags.StringVar(&from, "from", "", "RFC3339 start of the searched range (defaults to a day ago)")
+
+	var to string
+	flags.StringVar(&to, "to", "", "RFC3339 end of the searched range (defaults to now)")
+
+	var limit int
+	flags.IntVar(&limit, "limit", 0, "Maximum number of logs returned")
+
+	var sortOrder string
+	flags.StringVar(&sortOrder, "sort", "", "Order of the logs, time (newest first, the default) or relevance")
+
+	var asJSON bool
+	flags.BoolVar(&asJSON, "json", false, "Print logs as NDJSON instead of columns")
+
+	flags.Parse(args)
+
+	values := url.Values{}
+	values.Set("query", strings.Join(flags.Args(), " "))
+	for name, value := range map[string]string{"token": token, "from": from, "to": to, "sort": sortOrder} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	if limit > 0 {
+		values.Set("limit", fmt.Sprint(limit))
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimRight(serverURL, "/")+"/search?"+values.Encode(), nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if credentials := strings.SplitN(basicAuthString, ":", 2); len(credentials) == 2 {
+		req.SetBasicAuth(credentials[0], credentials[1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if res.StatusCode != 200 {
+		errorResponse := struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}{}
+		message := strings.TrimSpace(string(body))
+		if err := json.Unmarshal(body, &errorResponse); err == nil && errorResponse.Error.Message != "" {
+			message = errorResponse.Error.Message
+		}
+		log.Fatalf("search failed (%d): %s\n", res.StatusCode, message)
+	}
+
+	response := struct {
+		Logs         []map[string]interface{} `json:"logs"`
+		MessageField string                   `json:"messageField"`
+		LevelField   string                   `json:"levelField"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		log.Fatalln(err)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, data := range response.Logs {
+			encoder.Encode(data)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	levelField := response.LevelField
+	if levelField == "" {
+		levelField = firlog.DefaultLevelField
+	}
+	for _, data := range response.Logs {
+		messageField := displayedMessageField(data, response.MessageField)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%s\n", data["time"], valueOr(data[levelField], "-"), valueOr(data[messageField], ""), formatFields(data, messageField, levelField))
+	}
+	w.Flush()
+}
+
+func valueOr(value interface{}, alt string) interface{} {
+	if value == nil {
+		return alt
+	}
+	return value
+}
+
+// displayedMessageField is the field shown as the message of data, the
+// token's configured one or the first of firlog.DefaultMessageFields it has,
+// like the dashboard does.
+func displayedMessageField(data map[string]interface{}, configured string) string {
+	if _, ok := data[configured]; ok && configured != "" {
+		return configured
+	}
+	for _, field := range firlog.DefaultMessageFields {
+		if _, ok := data[field]; ok {
+			return field
+		}
+	}
+	return ""
+}
+
+// formatFields renders the fields not already shown in their own column as
+// sorted key=value pairs.
+func formatFields(data map[string]interface{}, messageField, levelField string) string {
+	keys := []string{}
+	for key := range data {
+		if key == "id" || key == "time" || key == "_raw" || key == levelField || key == messageField {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, data[key]))
+	}
+	return strings.Join(pairs, " ")
+}
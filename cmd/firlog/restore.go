@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/kiasaki/firlog"
+)
+
+// runRestore implements `firlog restore`, extracting a `/snapshot` archive
+// into the data directory of a token that has no data yet.
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+
+	var dataDir string
+	flags.StringVar(&dataDir, "data-dir", getEnv("DATA_DIR", "data"), "Specifies the directory to store data in")
+
+	var token string
+	flags.StringVar(&token, "token", "", "Token to restore the snapshot as")
+
+	flags.Parse(args)
+
+	if token == "" || flags.NArg() != 1 {
+		log.Fatalln("Usage: firlog restore -data-dir <dir> -token <token> <snapshot.tar.gz|->")
+	}
+	if tokens, _, err := firlog.ParseTokens(token); err != nil || len(tokens) != 1 {
+		log.Fatalf("Invalid `token` '%s'\n", token)
+	}
+
+	var r io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := firlog.RestoreSnapshot(filepath.Join(dataDir, token), r); err != nil {
+		log.Fatalf("Error restoring snapshot: %v\n", err)
+	}
+	log.Printf("restored snapshot into %s\n", filepath.Join(dataDir, token))
+}
@@ -0,0 +1,250 @@
+package firlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+const (
+	DefaultCompactInterval = time.Hour
+
+	compactBatchSize = 1000
+	// compactionFileName records the daily index being compacted.
+	compactionFileName = "compaction.json"
+)
+
+// Compact merges the daily indexes of every month that ended before `before`
+// into one monthly index, deleting the dailies once their documents are
+// copied. Copying is idempotent (documents keep their IDs) so an interrupted
+// compaction is simply resumed by the next one, the daily index copied when
+// it was interrupted being finished on startup.
+func (e *Engine) Compact(before time.Time) error {
+	if e.readOnly {
+		return ErrReadOnly
+	}
+	months := map[string][]string{}
+	for _, key := range e.sortedIndexNames() {
+		if len(keyDate(key)) != len("20060102") {
+			continue
+		}
+		monthStart, err := time.Parse("200601", key[:len("200601")])
+		if err != nil {
+			continue
+		}
+		if monthStart.AddDate(0, 1, 0).After(before) {
+			continue
+		}
+		months[key[:len("200601")]] = append(months[key[:len("200601")]], key)
+	}
+
+	sortedMonths := []string{}
+	for month := range months {
+		sortedMonths = append(sortedMonths, month)
+	}
+	sort.Strings(sortedMonths)
+	for _, month := range sortedMonths {
+		if err := e.compactMonth(month, months[month]); err != nil {
+			return fmt.Errorf("compacting %s: %v", month, err)
+		}
+		logger.Printf("compacted %d daily indexes into %s\n", len(months[month]), month)
+	}
+	return nil
+}
+
+func (e *Engine) compactMonth(month string, dailies []string) error {
+	e.compactMu.Lock()
+	defer e.compactMu.Unlock()
+	// Wait for in-flight batches so nothing lands in the dailies once writes
+	// are routed to the monthly index.
+	e.writeMu.Lock()
+	e.mu.Lock()
+	e.compacting[month] = true
+	e.mu.Unlock()
+	e.writeMu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.compacting, month)
+		e.mu.Unlock()
+	}()
+
+	monthly, err := e.indexFor(month)
+	if err != nil {
+		return err
+	}
+	for _, date := range dailies {
+		e.mu.RLock()
+		daily, ok := e.indexes[date]
+		e.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		if err := e.compactDaily(month, date, daily, monthly); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactionRecord is the daily index being copied into its monthly one,
+// recorded until it's deleted.
+type compactionRecord struct {
+	Month string `json:"month"`
+	Index string `json:"index"`
+}
+
+// compactDaily copies the daily index of key into monthly and deletes it. It's
+// left out of searches meanwhile, the monthly index holding part of its logs
+// already, and recorded so an interrupted copy gets finished on startup.
+func (e *Engine) compactDaily(month, key string, daily, monthly bleve.Index) error {
+	record, err := json.Marshal(&compactionRecord{Month: month, Index: key})
+	if err != nil {
+		return err
+	}
+	if err := writeFileSynced(filepath.Join(e.dataDir, compactionFileName), record); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.copying[key] = true
+	e.indexesChanged()
+	e.mu.Unlock()
+
+	if err := copyIndex(daily, monthly); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	delete(e.indexes, key)
+	delete(e.copying, key)
+	e.indexesChanged()
+	e.mu.Unlock()
+	if err := daily.Close(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(e.indexPath(key)); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(e.dataDir, compactionFileName))
+}
+
+// resumeCompaction finishes copying the daily index a compaction was copying
+// when interrupted, which read-only engines only leave out of searches.
+func (e *Engine) resumeCompaction() error {
+	path := filepath.Join(e.dataDir, compactionFileName)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	record := &compactionRecord{}
+	if err := json.Unmarshal(contents, record); err != nil {
+		return err
+	}
+
+	daily, ok := e.indexes[record.Index]
+	if e.readOnly {
+		if ok {
+			e.copying[record.Index] = true
+		}
+		return nil
+	} else if !ok {
+		// Deleted already.
+		return os.Remove(path)
+	}
+	e.copying[record.Index] = true
+	monthly, err := e.indexFor(record.Month)
+	if err != nil {
+		return err
+	}
+	logger.Printf("finishing the compaction of %s into %s\n", record.Index, record.Month)
+	return e.compactDaily(record.Month, record.Index, daily, monthly)
+}
+
+// copyIndex reindexes every document of src, along with its internal copy,
+// into dst.
+func copyIndex(src, dst bleve.Index) error {
+	advanced, _, err := src.Advanced()
+	if err != nil {
+		return err
+	}
+	reader, err := advanced.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	ids, err := reader.DocIDReaderAll()
+	if err != nil {
+		return err
+	}
+	defer ids.Close()
+
+	batch := dst.NewBatch()
+	for {
+		internalID, err := ids.Next()
+		if err != nil {
+			return err
+		}
+		if internalID == nil {
+			break
+		}
+		id, err := reader.ExternalID(internalID)
+		if err != nil {
+			return err
+		}
+		serialized, err := src.GetInternal([]byte(id))
+		if err != nil {
+			return err
+		}
+		// Logs indexed with SkipStoredJSON are copied without a JSON copy.
+		if serialized == nil {
+			data, err := loadLogData(src, id)
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				batch.Index(id, data)
+			}
+		} else {
+			data := map[string]interface{}{}
+			if err := json.Unmarshal(serialized, &data); err != nil {
+				return err
+			}
+			batch.Index(id, data)
+			batch.SetInternal([]byte(id), serialized)
+		}
+		raw, err := src.GetInternal(rawKey(id))
+		if err != nil {
+			return err
+		}
+		if raw != nil {
+			batch.SetInternal(rawKey(id), raw)
+		}
+
+		if batch.Size() >= compactBatchSize {
+			if err := dst.Batch(batch); err != nil {
+				return err
+			}
+			batch = dst.NewBatch()
+		}
+	}
+	return dst.Batch(batch)
+}
+
+// indexInRange tells if the daily (or shard of it) or monthly index key holds
+// dates between from and to (inclusive `20060102` dates, empty for unbounded).
+func indexInRange(key, from, to string) bool {
+	key = keyDate(key)
+	start, end := key, key
+	if len(key) == len("200601") {
+		start, end = key+"01", key+"31"
+	}
+	return (from == "" || end >= from) && (to == "" || start <= to)
+}
@@ -0,0 +1,119 @@
+package firlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// hookedIndex calls applied after every batch applied to it.
+type hookedIndex struct {
+	wrappedIndex
+	applied func()
+}
+
+func (index *hookedIndex) Batch(batch *bleve.Batch) error {
+	err := index.wrappedIndex.Batch(batch)
+	index.applied()
+	return err
+}
+
+// indexSeptember indexes perDay logs on the 10th and 11th of September 2026.
+func indexSeptember(t *testing.T, e *Engine, perDay int) {
+	t.Helper()
+	logs := []*Log{}
+	for _, day := range []int{10, 11} {
+		for i := 0; i < perDay; i++ {
+			at := time.Date(2026, 9, day, 12, 0, i, 0, time.UTC)
+			log := &Log{Time: at, Data: map[string]interface{}{"time": at, "msg": fmt.Sprintf("september %d", i)}}
+			log.Id = e.IDs.NewID(log)
+			logs = append(logs, log)
+		}
+	}
+	if err := e.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompactionDoesntDuplicateSearches(t *testing.T) {
+	e := newTestEngine(t)
+	indexSeptember(t, e, 50)
+	monthly, err := e.indexFor("202609")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := 0
+	e.mu.Lock()
+	e.indexes["202609"] = &hookedIndex{monthly, func() {
+		// The daily being copied is left out, the other one isn't.
+		copied++
+		if total := searchTotal(t, e, "september"); total != 100 {
+			t.Errorf("expected every log once while copying, got %d", total)
+		}
+	}}
+	e.mu.Unlock()
+
+	if err := e.Compact(testTime); err != nil {
+		t.Fatal(err)
+	}
+	if copied != 2 {
+		t.Fatalf("expected both dailies to be copied, got %d", copied)
+	}
+	if total := searchTotal(t, e, "september"); total != 100 {
+		t.Errorf("expected every log once, got %d", total)
+	}
+	if _, err := os.Stat(filepath.Join(e.dataDir, compactionFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected the compaction record to be removed, got %v", err)
+	}
+}
+
+func TestInterruptedCompactionIsFinished(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "app1")
+	e := NewEngine(dir)
+	indexSeptember(t, e, 50)
+	// Interrupted once the 10th was copied, before it was deleted.
+	monthly, err := e.indexFor("202609")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copyIndex(e.snapshotIndexes()["20260910"], monthly); err != nil {
+		t.Fatal(err)
+	}
+	record := []byte(`{"month": "202609", "index": "20260910"}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, compactionFileName), record, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range e.snapshotIndexes() {
+		index.Close()
+	}
+
+	readOnly, err := OpenEngineReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := searchTotal(t, readOnly, "september"); total != 100 {
+		t.Errorf("expected read-only engines to leave the copied daily out, got %d logs", total)
+	}
+	for _, index := range readOnly.snapshotIndexes() {
+		index.Close()
+	}
+
+	e = NewEngine(dir)
+	if total := searchTotal(t, e, "september"); total != 100 {
+		t.Errorf("expected every log once, got %d", total)
+	}
+	if count := docCount(t, e); count != 100 {
+		t.Errorf("expected the copied daily to be deleted, got %d docs", count)
+	}
+	if _, ok := e.snapshotIndexes()["20260910"]; ok {
+		t.Error("expected the copied daily to be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, compactionFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected the compaction record to be removed, got %v", err)
+	}
+}
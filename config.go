@@ -0,0 +1,166 @@
+package firlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Analyzers selectable with TokenConfig.Analyzer.
+	_ "github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	_ "github.com/blevesearch/bleve/analysis/analyzer/simple"
+	_ "github.com/blevesearch/bleve/analysis/analyzer/standard"
+	_ "github.com/blevesearch/bleve/analysis/lang/de"
+	_ "github.com/blevesearch/bleve/analysis/lang/en"
+	_ "github.com/blevesearch/bleve/analysis/lang/es"
+	_ "github.com/blevesearch/bleve/analysis/lang/fr"
+	_ "github.com/blevesearch/bleve/analysis/lang/it"
+	_ "github.com/blevesearch/bleve/analysis/lang/pt"
+)
+
+const (
+	tokenConfigFileName = "config.json"
+
+	DefaultMaxMessageSize = 64 * 1024
+)
+
+// TokenConfig holds the settings of a single token, read from `config.json`
+// in the token's data directory. A missing file means all defaults.
+type TokenConfig struct {
+	// Multiline appends lines that don't look like syslog messages (stack
+	// traces, wrapped output) to the previous log's message instead of
+	// dropping them.
+	Multiline bool `json:"multiline"`
+	// MaxMessageSize caps the size in bytes a message can grow to through
+	// multiline continuation.
+	MaxMessageSize int `json:"maxMessageSize"`
+	// ExtractRules are regexps applied in order to every message, their
+	// named captures becoming searchable fields.
+	ExtractRules []*ExtractRule `json:"extractRules"`
+	// MaxExtractInput is how many bytes of a message extract rules look at.
+	MaxExtractInput int `json:"maxExtractInput"`
+	// AlertRules are queries run against every newly indexed batch.
+	AlertRules []*AlertRule `json:"alertRules"`
+	// MalformedAlert fires when too many bulk lines can't be parsed.
+	MalformedAlert *MalformedAlert `json:"malformedAlert"`
+	// Sampling maps lowercased levels to N, keeping only 1 in N logs of that
+	// level. Errors and warnings are never sampled out.
+	Sampling map[string]int `json:"sampling"`
+	// Analyzer is the bleve analyzer `msg` is indexed with in new indexes:
+	// standard (the default), keyword, simple, en, fr, de, es, it or pt.
+	Analyzer string `json:"analyzer"`
+	// KeywordFields are indexed as a whole instead of being tokenized, so
+	// identifiers only match exactly. Nil means DefaultKeywordFields.
+	KeywordFields []string `json:"keywordFields"`
+	// BooleanFields are indexed as booleans in new indexes, "true" and
+	// "false" strings in them being turned into booleans.
+	BooleanFields []string `json:"booleanFields"`
+	// TimeField is a field holding the log's own timestamp, taking over the
+	// syslog (or, for NDJSON, `time`) one when it parses.
+	TimeField string `json:"timeField"`
+	// TimeFormats are Go time layouts, like "02/Jan/2006:15:04:05 -0700",
+	// TimeField is parsed with when it isn't RFC3339, and that string fields
+	// are indexed as dates with in new indexes on top of bleve's own.
+	TimeFormats []string `json:"timeFormats"`
+	// RedactRules mask or remove secrets from logs before they're stored.
+	RedactRules []*RedactRule `json:"redactRules"`
+	// SkipStoredJSON doesn't store the JSON copy of logs searches normally
+	// return, rebuilding them from the fields bleve stores instead.
+	SkipStoredJSON bool `json:"skipStoredJSON"`
+	// RedactPlaceholder replaces masked values, DefaultRedactPlaceholder
+	// when empty.
+	RedactPlaceholder string `json:"redactPlaceholder"`
+	// MessageField and LevelField are the fields logs are displayed with as
+	// their message and level, DefaultMessageFields and DefaultLevelField
+	// when empty or missing from a log.
+	MessageField string `json:"messageField"`
+	LevelField   string `json:"levelField"`
+	// HiddenFields are left out of the data the dashboard shows logs with,
+	// still being stored and searchable. Nil means DefaultHiddenFields.
+	HiddenFields []string `json:"hiddenFields"`
+	// RawField keeps the line, or lines, logs were parsed from in their
+	// `_raw` field, stored and searchable, to debug parsing.
+	RawField bool `json:"rawField"`
+	// MaxFields caps how many distinct fields the token's logs can have,
+	// those adding fields past it being handled as FieldOverflow says:
+	// FieldOverflowCollapse (the default) or FieldOverflowDrop. 0 doesn't
+	// limit fields.
+	MaxFields     int    `json:"maxFields"`
+	FieldOverflow string `json:"fieldOverflow"`
+}
+
+// DefaultKeywordFields are the identifier fields indexed as keywords unless
+// a token configures its own.
+var DefaultKeywordFields = []string{"request_id", "trace_id", "span_id", "correlation_id", "session_id", "user_id"}
+
+// DefaultHiddenFields are the fields the dashboard doesn't show in the data
+// of logs, next to their message and level, unless a token configures its
+// own.
+var DefaultHiddenFields = []string{"id", "time", rawField}
+
+// display makes log displayed with the token's message, level and hidden
+// fields.
+func (c *TokenConfig) display(log *Log) {
+	log.messageField = c.MessageField
+	log.levelField = c.LevelField
+	log.hiddenFields = c.HiddenFields
+}
+
+func (c *TokenConfig) keywordFields() []string {
+	if c.KeywordFields == nil {
+		return DefaultKeywordFields
+	}
+	return c.KeywordFields
+}
+
+func loadTokenConfig(dataDir string) (*TokenConfig, error) {
+	config := &TokenConfig{}
+
+	contents, err := ioutil.ReadFile(filepath.Join(dataDir, tokenConfigFileName))
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, config); err != nil {
+		return nil, err
+	}
+	if err := compileExtractRules(config.ExtractRules); err != nil {
+		return nil, err
+	}
+	if err := compileAlertRules(config.AlertRules); err != nil {
+		return nil, err
+	}
+	if err := compileMalformedAlert(config.MalformedAlert); err != nil {
+		return nil, err
+	}
+	if err := compileRedactRules(config.RedactRules); err != nil {
+		return nil, err
+	}
+	for _, format := range config.TimeFormats {
+		if strings.TrimSpace(format) == "" {
+			return nil, fmt.Errorf("invalid timeFormats, formats can't be blank")
+		}
+	}
+	if config.MaxFields < 0 {
+		return nil, fmt.Errorf("invalid maxFields %d, must be at least 0", config.MaxFields)
+	}
+	switch config.FieldOverflow {
+	case "", FieldOverflowCollapse, FieldOverflowDrop:
+	default:
+		return nil, fmt.Errorf("unknown fieldOverflow '%s'", config.FieldOverflow)
+	}
+	if err := buildIndexMapping(config).Validate(); err != nil {
+		return nil, fmt.Errorf("invalid index mapping: %v", err)
+	}
+	return config, nil
+}
+
+func (c *TokenConfig) maxMessageSize() int {
+	if c.MaxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return c.MaxMessageSize
+}
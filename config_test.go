@@ -0,0 +1,98 @@
+package firlog
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzer(t *testing.T) {
+	tests := []struct {
+		analyzer string
+		matches  map[string]uint64
+	}{
+		{"", map[string]uint64{"msg:connection": 0, "msg:connections": 1, "msg:the": 0}},
+		{"en", map[string]uint64{"msg:connection": 1, "msg:connections": 1, "msg:the": 0}},
+		{"simple", map[string]uint64{"msg:connection": 0, "msg:the": 1}},
+		{"keyword", map[string]uint64{"msg:connections": 0, `msg:"the Connections failed"`: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.analyzer, func(t *testing.T) {
+			app := newTestApp(t, `{"analyzer": "`+test.analyzer+`"}`)
+			engine := app.engineForToken("app1")
+			logs := testLogs(engine, "started", 1)
+			logs[0].Data["msg"] = "the Connections failed"
+			if err := engine.Index(logs); err != nil {
+				t.Fatal(err)
+			}
+			for q, want := range test.matches {
+				if total := searchTotal(t, engine, q); total != want {
+					t.Errorf("expected %s to match %d logs, got %d", q, want, total)
+				}
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, tokenConfigFileName), []byte(`{"analyzer": "klingon"}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTokenConfig(dir); err == nil || !strings.Contains(err.Error(), "klingon") {
+		t.Errorf("expected unknown analyzers to be rejected, got %v", err)
+	}
+}
+
+func TestKeywordFields(t *testing.T) {
+	tests := []struct {
+		config  string
+		matches map[string]uint64
+	}{
+		{"", map[string]uint64{`request_id:"abc-123"`: 1, "request_id:abc": 0, "order:ord": 1}},
+		{`{"keywordFields": ["order"]}`, map[string]uint64{"request_id:abc": 1, `order:"ord-42"`: 1, "order:ord": 0}},
+	}
+	for _, test := range tests {
+		app := newTestApp(t, test.config)
+		engine := app.engineForToken("app1")
+		logs := testLogs(engine, "started", 1)
+		logs[0].Data["request_id"] = "abc-123"
+		logs[0].Data["order"] = "ord-42"
+		if err := engine.Index(logs); err != nil {
+			t.Fatal(err)
+		}
+		for q, want := range test.matches {
+			if total := searchTotal(t, engine, q); total != want {
+				t.Errorf("%q: expected %s to match %d logs, got %d", test.config, q, want, total)
+			}
+		}
+	}
+}
+
+func TestTimeFormats(t *testing.T) {
+	for _, config := range []string{"", `{"timeFormats": ["02/Jan/2006:15:04:05 -0700"]}`} {
+		app := newTestApp(t, config)
+		postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+			`{"time": "2026-10-14T12:00:00Z", "msg": "apache", "seen": "14/Oct/2026:10:00:00 +0000"}`,
+			`{"time": "2026-10-14T12:00:01Z", "msg": "default", "seen": "2026-10-14 11:00:00"}`,
+		}, "\n"))
+		engine := app.engineForToken("app1")
+		// bleve's own layouts are still parsed next to the configured ones.
+		want := map[string]uint64{`seen:>="2026-10-14T09:00:00Z"`: 1, `seen:>="2026-10-14T10:30:00Z"`: 1}
+		if config != "" {
+			want[`seen:>="2026-10-14T09:00:00Z"`] = 2
+		}
+		for q, total := range want {
+			if got := searchTotal(t, engine, q); got != total {
+				t.Errorf("%q: expected %s to match %d logs, got %d", config, q, total, got)
+			}
+		}
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, tokenConfigFileName), []byte(`{"timeFormats": [" "]}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTokenConfig(dir); err == nil {
+		t.Error("expected blank time formats to be rejected")
+	}
+}
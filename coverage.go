@@ -0,0 +1,120 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Coverage is what an engine's indexes cover: every index on disk sorted by
+// date, from the first to the last date they hold logs of (`20060102`,
+// empty without indexes) and the days in between without any.
+type Coverage struct {
+	Indexes      []IndexInfo `json:"indexes"`
+	From         string      `json:"from"`
+	To           string      `json:"to"`
+	Days         int         `json:"days"`
+	DocCount     uint64      `json:"docCount"`
+	MissingDates []string    `json:"missingDates"`
+}
+
+// Coverage lists the opened indexes along with the ones on disk that aren't,
+// broken ones, which count as covered but have no doc count.
+func (e *Engine) Coverage() (*Coverage, error) {
+	keys := map[string]bool{}
+	names, err := listIndexes(e.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		keys[indexKey(name)] = true
+	}
+	indexes := e.snapshotIndexes()
+	for key := range indexes {
+		keys[key] = true
+	}
+	broken := e.BrokenIndexes()
+
+	sorted := []string{}
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	coverage := &Coverage{Indexes: []IndexInfo{}, MissingDates: []string{}}
+	covered := map[string]bool{}
+	for _, key := range sorted {
+		info := IndexInfo{Date: key}
+		if index, ok := indexes[key]; ok {
+			count, err := index.DocCount()
+			if err != nil {
+				return nil, err
+			}
+			info.DocCount = count
+			coverage.DocCount += count
+		} else if err, ok := broken[key]; ok {
+			info.Broken = err
+		} else {
+			info.Broken = "not opened"
+		}
+		coverage.Indexes = append(coverage.Indexes, info)
+
+		for _, date := range indexDates(key) {
+			covered[date] = true
+		}
+	}
+	if len(covered) == 0 {
+		return coverage, nil
+	}
+
+	dates := []string{}
+	for date := range covered {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	coverage.From, coverage.To = dates[0], dates[len(dates)-1]
+	from, _ := time.Parse("20060102", coverage.From)
+	to, _ := time.Parse("20060102", coverage.To)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		coverage.Days++
+		if date := day.Format("20060102"); !covered[date] {
+			coverage.MissingDates = append(coverage.MissingDates, date)
+		}
+	}
+	return coverage, nil
+}
+
+// indexDates are the dates the index key holds logs of, every day of the
+// month for monthly indexes.
+func indexDates(key string) []string {
+	key = keyDate(key)
+	if len(key) == len("20060102") {
+		return []string{key}
+	}
+	month, err := time.Parse("200601", key)
+	if err != nil {
+		return nil
+	}
+	dates := []string{}
+	for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+		dates = append(dates, day.Format("20060102"))
+	}
+	return dates
+}
+
+// handleCoverage answers `GET /indexes/<token>` with the token's Coverage.
+func (app *App) handleCoverage(w http.ResponseWriter, r *http.Request, engine *Engine) {
+	if r.Method != "GET" {
+		writeError(w, 405, errorCodeMethodNotAllowed, "only GET supported")
+		return
+	}
+	coverage, err := engine.Coverage()
+	if err != nil {
+		logger.Printf("error listing indexes: %v\n", err)
+		writeError(w, 500, errorCodeInternal, "Error listing indexes")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(coverage)
+}
@@ -0,0 +1,38 @@
+package firlog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	app := newTestApp(t, "")
+	var coverage Coverage
+	getJSON(t, app, "/indexes/app1", &coverage)
+	if len(coverage.Indexes) != 0 || coverage.From != "" || coverage.Days != 0 || len(coverage.MissingDates) != 0 {
+		t.Errorf("expected nothing to be covered without indexes, got %+v", coverage)
+	}
+
+	postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+		`{"time": "2026-10-11T12:00:00Z", "msg": "first"}`,
+		`{"time": "2026-10-12T12:00:00Z", "msg": "second"}`,
+		`{"time": "2026-10-14T12:00:00Z", "msg": "third"}`,
+		`{"time": "2026-10-14T12:00:01Z", "msg": "fourth"}`,
+	}, "\n"))
+	getJSON(t, app, "/indexes/app1", &coverage)
+	want := []IndexInfo{{Date: "20261011", DocCount: 1}, {Date: "20261012", DocCount: 1}, {Date: "20261014", DocCount: 2}}
+	if !reflect.DeepEqual(coverage.Indexes, want) {
+		t.Errorf("expected the indexes sorted by date, got %+v", coverage.Indexes)
+	}
+	if coverage.From != "20261011" || coverage.To != "20261014" || coverage.Days != 4 || coverage.DocCount != 4 || !reflect.DeepEqual(coverage.MissingDates, []string{"20261013"}) {
+		t.Errorf("unexpected coverage %+v", coverage)
+	}
+
+	if dates := indexDates("202609"); len(dates) != 30 || dates[0] != "20260901" || dates[29] != "20260930" {
+		t.Errorf("expected monthly indexes to cover their month, got %v", dates)
+	}
+	if w := getJSON(t, app, "/indexes/app2", nil); w.Code != 404 {
+		t.Errorf("expected unknown tokens to answer 404, got %d", w.Code)
+	}
+}
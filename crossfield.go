@@ -0,0 +1,97 @@
+package firlog
+
+import (
+	"sort"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// textFields lists the fields of the group's indexes bare terms can be
+// matched against with App.CrossFieldSearch, leaving out `_all` which they
+// already match and `time` which they never do.
+func (g *indexGroup) textFields() []string {
+	seen := map[string]bool{}
+	fields := []string{}
+	for _, index := range g.indexes {
+		indexFields, err := index.Fields()
+		if err != nil {
+			continue
+		}
+		for _, field := range indexFields {
+			if field == "_all" || field == "time" || seen[field] {
+				continue
+			}
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// matchAcrossFields rewrites the matches of a parsed query string that don't
+// name a field to also match each of fields, with the analyzer of each, so
+// terms whose values `_all` indexes differently (keyword fields, language
+// analyzers) or not at all still match. Field qualified matches are kept as
+// they are.
+func matchAcrossFields(q query.Query, fields []string) query.Query {
+	if len(fields) == 0 {
+		return q
+	}
+	switch q := q.(type) {
+	case *query.BooleanQuery:
+		if q.Must != nil {
+			q.Must = matchAcrossFields(q.Must, fields)
+		}
+		if q.Should != nil {
+			q.Should = matchAcrossFields(q.Should, fields)
+		}
+		if q.MustNot != nil {
+			q.MustNot = matchAcrossFields(q.MustNot, fields)
+		}
+	case *query.ConjunctionQuery:
+		for i, conjunct := range q.Conjuncts {
+			q.Conjuncts[i] = matchAcrossFields(conjunct, fields)
+		}
+	case *query.DisjunctionQuery:
+		for i, disjunct := range q.Disjuncts {
+			q.Disjuncts[i] = matchAcrossFields(disjunct, fields)
+		}
+	case *query.MatchQuery:
+		if q.FieldVal != "" {
+			return q
+		}
+		disjuncts := []query.Query{q}
+		for _, field := range fields {
+			fieldQuery := *q
+			fieldQuery.SetField(field)
+			disjuncts = append(disjuncts, &fieldQuery)
+		}
+		return bleve.NewDisjunctionQuery(disjuncts...)
+	case *query.MatchPhraseQuery:
+		if q.FieldVal != "" {
+			return q
+		}
+		disjuncts := []query.Query{q}
+		for _, field := range fields {
+			fieldQuery := *q
+			fieldQuery.SetField(field)
+			disjuncts = append(disjuncts, &fieldQuery)
+		}
+		return bleve.NewDisjunctionQuery(disjuncts...)
+	case *query.WildcardQuery:
+		// `*` matches everything through `_all` already.
+		if q.FieldVal != "" || q.Wildcard == "*" {
+			return q
+		}
+		disjuncts := []query.Query{q}
+		for _, field := range fields {
+			fieldQuery := bleve.NewWildcardQuery(q.Wildcard)
+			fieldQuery.SetField(field)
+			disjuncts = append(disjuncts, fieldQuery)
+		}
+		return bleve.NewDisjunctionQuery(disjuncts...)
+	}
+	return q
+}
@@ -0,0 +1,226 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// dashboardRange is a dashboard range holding the logs of postSeverities.
+const dashboardRange = "&from=2026-10-14T00:00:00Z&to=2026-10-14T23:59:59Z"
+
+// postSeverities indexes logs whose level is in `severity`, next to a `level`
+// field that isn't it.
+func postSeverities(t *testing.T, app *App) {
+	t.Helper()
+	postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+		`{"time": "2026-10-14T12:00:00Z", "msg": "disk full", "severity": "error", "level": "3"}`,
+		`{"time": "2026-10-14T12:00:01Z", "msg": "disk full again", "severity": "error", "level": "3"}`,
+		`{"time": "2026-10-14T12:00:02Z", "msg": "started", "severity": "info", "level": "6"}`,
+		"",
+	}, "\n"))
+}
+
+func TestDashboardFacetsLevelField(t *testing.T) {
+	app := newTestApp(t, `{"levelField": "severity"}`)
+	postSeverities(t, app)
+
+	w := getJSON(t, app, "/?token=app1"+dashboardRange, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	for _, option := range []string{`<option value="error" >error (2)</option>`, `<option value="info" >info (1)</option>`} {
+		if !strings.Contains(body, option) {
+			t.Errorf("expected the levels of severity to be offered, missing %s", option)
+		}
+	}
+	if strings.Contains(body, `<option value="3"`) {
+		t.Error("expected the level field not to be faceted")
+	}
+
+	var response struct {
+		Total uint64 `json:"total"`
+	}
+	getJSON(t, app, "/search?token=app1&level=error"+dashboardRange, &response)
+	if response.Total != 2 {
+		t.Errorf("expected the level filter to match severity, got %d logs", response.Total)
+	}
+}
+
+func TestDashboardShowsInvalidParams(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+
+	for path, message := range map[string]string{
+		"/?token=app1&query=disk&from=2026-10-14T23:00:00Z&to=2026-10-14T00:00:00Z": errInvertedRange.Error(),
+		"/?token=app1&query=disk&from=2099-01-01T00:00:00Z&to=2099-01-02T00:00:00Z": errFutureRange.Error(),
+		"/?token=app1&query=disk&from=yesterday":                                    errInvalidFrom.Error(),
+	} {
+		w := getJSON(t, app, path, nil)
+		body := w.Body.String()
+		if w.Code != 400 || !strings.Contains(w.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s: expected a 400 page, got %d (%s)", path, w.Code, w.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(body, `<div class="notification is-danger">`+message+`</div>`) {
+			t.Errorf("%s: expected %q to be shown, got %s", path, message, body)
+		}
+		if !strings.Contains(body, `value="disk"`) || !strings.Contains(body, "0 results") {
+			t.Errorf("%s: expected the query to be kept with nothing searched", path)
+		}
+	}
+}
+
+func TestDashboardTimeZone(t *testing.T) {
+	app := newTestApp(t, "")
+	app.DisplayTZ = "Europe/Paris"
+	postSeverities(t, app)
+
+	for tz, shown := range map[string]string{
+		"":                 "2026/10/14 14:00:00 CEST",
+		"America/New_York": "2026/10/14 08:00:00 EDT",
+		"Mars/Olympus":     "2026/10/14 12:00:00 UTC",
+	} {
+		w := getJSON(t, app, "/?token=app1&tz="+tz+dashboardRange, nil)
+		body := w.Body.String()
+		if !strings.Contains(body, `<span class="log__time">`+shown+`</span>`) {
+			t.Errorf("tz %q: expected logs to be shown at %s, got %s", tz, shown, body)
+		}
+		if warned := strings.Contains(body, "Unknown time zone &#39;Mars/Olympus&#39;"); warned != (tz == "Mars/Olympus") {
+			t.Errorf("tz %q: unexpected warning", tz)
+		}
+	}
+}
+
+func TestDashboardShowsQueryErrors(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+
+	w := getJSON(t, app, "/?token=app1&query=%22disk+full"+dashboardRange, nil)
+	body := w.Body.String()
+	if w.Code != 200 || !strings.Contains(body, `<p class="help is-danger">Invalid query:`) || !strings.Contains(body, `class="input is-danger"`) {
+		t.Errorf("expected the syntax error next to the query box, got %d: %s", w.Code, body)
+	}
+	if !strings.Contains(body, `value="&#34;disk full"`) || !strings.Contains(body, "0 results") {
+		t.Error("expected the query to be kept with nothing searched")
+	}
+
+	if w := getJSON(t, app, "/search?token=app1&query=%22disk+full"+dashboardRange, nil); w.Code != 400 || !strings.Contains(w.Body.String(), "invalid_query") {
+		t.Errorf("expected searches to fail with invalid_query, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestDashboardShowsTotal(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+
+	tests := []struct {
+		query  string
+		total  uint64
+		header string
+	}{
+		{"", 3, "2 of 3 results"},
+		{"disk", 2, "2 results"},
+	}
+	for _, test := range tests {
+		var response struct {
+			Count int    `json:"count"`
+			Total uint64 `json:"total"`
+		}
+		getJSON(t, app, "/search?token=app1&limit=2&query="+test.query+dashboardRange, &response)
+		if response.Count != 2 || response.Total != test.total {
+			t.Errorf("%q: expected 2 of %d logs, got %+v", test.query, test.total, response)
+		}
+		if body := getJSON(t, app, "/?token=app1&limit=2&query="+test.query+dashboardRange, nil).Body.String(); !strings.Contains(body, "<strong>"+test.header+"</strong>") {
+			t.Errorf("%q: expected %s to be shown, got %s", test.query, test.header, body)
+		}
+	}
+}
+
+func TestDashboardColumns(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+
+	body := getJSON(t, app, "/?token=app1&query=started&cols=severity,+host,severity,"+dashboardRange, nil).Body.String()
+	if !strings.Contains(body, `value="severity,host"`) {
+		t.Error("expected the columns to be deduplicated in the form")
+	}
+	if !strings.Contains(body, `<strong class="log__col">severity</strong><strong class="log__col">host</strong>`) {
+		t.Errorf("expected a header with the columns, got %s", body)
+	}
+	// Blank for logs without the field, and left out of the data.
+	if !strings.Contains(body, `<span class="log__col">info</span><span class="log__col"></span>`) || !strings.Contains(body, `<span class="log__data">{}</span>`) {
+		t.Errorf("expected the fields in columns of their own, got %s", body)
+	}
+}
+
+func TestDashboardAnswersJSON(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+
+	tests := []struct {
+		format string
+		accept string
+		json   bool
+	}{
+		{"", "", false},
+		{"", "text/html,application/xhtml+xml,application/json;q=0.9", false},
+		{"", "application/json", true},
+		{"json", "", true},
+		{"html", "application/json", false},
+	}
+	for _, test := range tests {
+		path := "/?token=app1&query=disk" + dashboardRange
+		if test.format != "" {
+			path += "&format=" + test.format
+		}
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth("user", "pass")
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		app.handler("user", "pass").ServeHTTP(w, r)
+		var response struct {
+			Total int `json:"total"`
+		}
+		isJSON := json.Unmarshal(w.Body.Bytes(), &response) == nil
+		if isJSON != test.json || (isJSON && response.Total != 2) {
+			t.Errorf("format %q, accept %q: expected JSON %v, got %d: %.100s", test.format, test.accept, test.json, w.Code, w.Body)
+		}
+	}
+}
+
+func TestDashboardHiddenFields(t *testing.T) {
+	tests := []struct {
+		config string
+		data   string
+	}{
+		{"", `{&#34;host&#34;:&#34;web-1&#34;,&#34;user&#34;:&#34;bob&#34;}`},
+		{`{"hiddenFields": ["host"]}`, `&#34;user&#34;:&#34;bob&#34;}`},
+		{`{"hiddenFields": []}`, `&#34;host&#34;:&#34;web-1&#34;`},
+	}
+	for _, test := range tests {
+		app := newTestApp(t, test.config)
+		postBulk(t, app, "application/x-ndjson", `{"time": "2026-10-14T12:00:00Z", "msg": "started", "host": "web-1", "user": "bob"}`)
+		body := getJSON(t, app, "/?token=app1"+dashboardRange, nil).Body.String()
+		if !strings.Contains(body, test.data) {
+			t.Errorf("%q: expected the data to show %s, got %s", test.config, test.data, body)
+		}
+		if hidesHost := !strings.Contains(body, `&#34;host&#34;`); hidesHost != (test.config == `{"hiddenFields": ["host"]}`) {
+			t.Errorf("%q: unexpected host in %s", test.config, body)
+		}
+		// Hidden fields are still searchable.
+		if total := searchTotal(t, app.engineForToken("app1"), "host:web-1"); total != 1 {
+			t.Errorf("%q: expected hidden fields to be searchable, got %d logs", test.config, total)
+		}
+	}
+
+	log := &Log{Data: map[string]interface{}{"id": "01ABC", "time": "now", "msg": "started", "host": "web-1"}}
+	if data := log.FormattedData(); data != `{"host":"web-1"}` {
+		t.Errorf("expected the id and time to be hidden by default, got %s", data)
+	}
+	log.hiddenFields = []string{}
+	if data := log.FormattedData(); !strings.Contains(data, `"id":"01ABC"`) || strings.Contains(data, "started") {
+		t.Errorf("expected only the message to be hidden, got %s", data)
+	}
+}
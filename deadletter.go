@@ -0,0 +1,161 @@
+package firlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+
+	deadLetterDirName = ".deadletter"
+)
+
+// applyBatch applies a batch, retrying retryable failures with exponential
+// backoff. Closed indexes are replaced by what reopen returns before
+// retrying.
+func (e *Engine) applyBatch(index bleve.Index, batch *bleve.Batch, reopen func() (bleve.Index, error)) error {
+	backoff := e.RetryBackoff
+	err := index.Batch(batch)
+	for attempt := 0; err != nil && isRetryable(err) && attempt < e.MaxRetries; attempt++ {
+		logger.Printf("retrying batch in %s: %v\n", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if err == bleve.ErrorIndexClosed {
+			// Swapped by a compaction or optimization in the meantime.
+			if index, err = reopen(); err != nil {
+				return err
+			}
+		}
+		err = index.Batch(batch)
+	}
+	return err
+}
+
+// noReopen is the reopen of applyBatch for batches of a given index, which
+// fail once it's closed.
+func noReopen() (bleve.Index, error) {
+	return nil, bleve.ErrorIndexClosed
+}
+
+// isRetryable tells whether err may go away by itself: indexes closed while
+// swapped, and I/O errors. Others, like documents that can't be indexed,
+// would fail again.
+func isRetryable(err error) bool {
+	if err == bleve.ErrorIndexClosed {
+		return true
+	}
+	var pathErr *os.PathError
+	var syscallErr *os.SyscallError
+	var errno syscall.Errno
+	return errors.As(err, &pathErr) || errors.As(err, &syscallErr) || errors.As(err, &errno) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (e *Engine) writeDeadLetter(logs []*Log) error {
+	_, err := writeLogsFile(filepath.Join(e.dataDir, deadLetterDirName), logs)
+	return err
+}
+
+// writeLogsFile durably writes logs to a new NDJSON file of dir, returning
+// its path. Names are ULIDs so files sort in the order they were written.
+func writeLogsFile(dir string, logs []*Log) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, newUlid()+".ndjson")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	for _, log := range logs {
+		if err := encoder.Encode(log); err != nil {
+			return "", err
+		}
+	}
+	return path, f.Sync()
+}
+
+// ReplayDeadLetters indexes the batches previously written by a failed Index
+// call, removing every file that was successfully replayed. It returns the
+// number of logs indexed.
+func (e *Engine) ReplayDeadLetters() (int, error) {
+	return e.replayLogsFiles(filepath.Join(e.dataDir, deadLetterDirName))
+}
+
+// replayLogsFiles indexes the files of dir written by writeLogsFile in order,
+// removing every file that was successfully replayed.
+func (e *Engine) replayLogsFiles(dir string) (int, error) {
+	if e.readOnly {
+		return 0, ErrReadOnly
+	}
+	d, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	names, err := d.Readdirnames(0)
+	d.Close()
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(names)
+
+	replayed := 0
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".ndjson") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		logs, err := readLogsFile(path)
+		if err != nil {
+			return replayed, fmt.Errorf("reading %s: %v", name, err)
+		}
+		// Replayed batches must not be dead-lettered again into a new file
+		// while the original one is still around, nor fire alerts again.
+		if err := e.index(logs, false, false); err != nil {
+			return replayed, fmt.Errorf("replaying %s: %v", name, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return replayed, err
+		}
+		replayed += len(logs)
+	}
+	return replayed, nil
+}
+
+func readLogsFile(path string) ([]*Log, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	logs := []*Log{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		log := &Log{}
+		if err := json.Unmarshal(scanner.Bytes(), log); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, scanner.Err()
+}
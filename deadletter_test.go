@@ -0,0 +1,89 @@
+package firlog
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// failingIndex fails the first batches applied to it with errs, calling
+// failed after each.
+type failingIndex struct {
+	wrappedIndex
+	errs    []error
+	failed  func()
+	batches int
+}
+
+func (index *failingIndex) Batch(batch *bleve.Batch) error {
+	index.batches++
+	if len(index.errs) > 0 {
+		err := index.errs[0]
+		index.errs = index.errs[1:]
+		if index.failed != nil {
+			index.failed()
+		}
+		return err
+	}
+	return index.wrappedIndex.Batch(batch)
+}
+
+func TestOnlyTransientErrorsAreRetried(t *testing.T) {
+	ioErr := &os.PathError{Op: "write", Path: "store", Err: syscall.EIO}
+	tests := []struct {
+		name         string
+		errs         []error
+		batches      int
+		deadLettered bool
+	}{
+		{"invalid", []error{errors.New("invalid document")}, 1, true},
+		{"I/O error", []error{ioErr, ioErr}, 3, false},
+		{"closed", []error{bleve.ErrorIndexClosed}, 1, false},
+		{"persistent I/O error", []error{ioErr, ioErr, ioErr, ioErr}, 4, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newTestEngine(t)
+			e.RetryBackoff = time.Millisecond
+			if err := e.Index(testLogs(e, "first", 1)); err != nil {
+				t.Fatal(err)
+			}
+			e.mu.Lock()
+			for key, index := range e.indexes {
+				failing := &failingIndex{wrappedIndex: index, errs: test.errs}
+				if test.name == "closed" {
+					// Compaction swapped it, its replacement takes the batch.
+					failing.failed = func() {
+						e.mu.Lock()
+						e.indexes[key] = index
+						e.mu.Unlock()
+					}
+				}
+				e.indexes[key] = failing
+				defer func() {
+					if failing.batches != test.batches {
+						t.Errorf("expected %d attempts, got %d", test.batches, failing.batches)
+					}
+				}()
+			}
+			e.mu.Unlock()
+
+			if err := e.index(testLogs(e, "second", 1), true, true); err != nil {
+				t.Fatal(err)
+			}
+			if total := searchTotal(t, e, "second"); (total == 1) == test.deadLettered {
+				t.Errorf("expected the log to be indexed unless dead-lettered, got %d", total)
+			}
+			files, _ := ioutil.ReadDir(filepath.Join(e.dataDir, deadLetterDirName))
+			if (len(files) == 1) != test.deadLettered {
+				t.Errorf("expected dead-lettering to be %t, got %d files", test.deadLettered, len(files))
+			}
+		})
+	}
+}
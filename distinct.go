@@ -0,0 +1,95 @@
+package firlog
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+const (
+	DistinctSortCount = "count"
+	DistinctSortAlpha = "alpha"
+)
+
+var errInvalidDistinctSort = errors.New("invalid distinctSort, expected count or alpha")
+
+// DistinctValues are the values a field takes among the logs a search
+// matches, the most frequent ones when there are more than asked for.
+type DistinctValues struct {
+	Field  string      `json:"field"`
+	Values []TermCount `json:"values"`
+	// Truncated tells other values were left out.
+	Truncated bool `json:"truncated"`
+	// Missing is how many matching logs don't have the field.
+	Missing int `json:"missing"`
+}
+
+// distinct returns the (at most size) most frequent values of field among the
+// logs q matches, like terms, telling whether others were left out.
+func (g *indexGroup) distinct(q query.Query, field string, size int) (*DistinctValues, error) {
+	values := &DistinctValues{Field: field, Values: []TermCount{}}
+	if len(g.indexes) == 0 {
+		return values, nil
+	}
+
+	search := bleve.NewSearchRequestOptions(q, 0, 0, false)
+	search.AddFacet(field, bleve.NewFacetRequest(field, size))
+	searchResult, err := g.alias.Search(search)
+	if err != nil {
+		return nil, err
+	}
+	if facet, ok := searchResult.Facets[field]; ok {
+		for _, term := range facet.Terms {
+			values.Values = append(values.Values, TermCount{Term: term.Term, Count: term.Count})
+		}
+		values.Truncated = facet.Other > 0
+		values.Missing = facet.Missing
+	}
+	return values, nil
+}
+
+// handleDistinct answers searches with `distinct=<field>` with the values of
+// the field among the matching logs instead of the logs themselves.
+func (app *App) handleDistinct(w http.ResponseWriter, params *searchParams) {
+	start := time.Now().UnixNano()
+	group, err := app.searchGroup(params)
+	if err != nil {
+		writeError(w, 400, errorCodeBadRequest, err.Error())
+		return
+	}
+	values, err := group.distinct(params.searchQuery(), params.Distinct, params.Limit)
+	if err != nil {
+		logger.Println("error searching distinct values: ", err)
+		writeError(w, 500, errorCodeInternal, "Error executing search")
+		return
+	}
+	if params.DistinctSort == DistinctSortAlpha {
+		sort.Slice(values.Values, func(i, j int) bool {
+			return values.Values[i].Term < values.Values[j].Term
+		})
+	}
+
+	responseJSON, err := json.Marshal(map[string]interface{}{
+		"field":           values.Field,
+		"count":           len(values.Values),
+		"values":          values.Values,
+		"truncated":       values.Truncated,
+		"missing":         values.Missing,
+		"indexes":         len(group.indexes),
+		"searchDuration":  float64(time.Now().UnixNano()-start) / 1000000,
+		"warnings":        group.brokenWarnings(),
+		"from":            params.from.UTC().Format(time.RFC3339Nano),
+		"to":              params.to.UTC().Format(time.RFC3339Nano),
+		"effective_query": params.searchQuery(),
+	})
+	if err != nil {
+		writeError(w, 500, errorCodeInternal, "Error serializing response")
+		return
+	}
+	w.Write(responseJSON)
+}
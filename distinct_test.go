@@ -0,0 +1,50 @@
+package firlog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDistinct(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+	postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+		`{"time": "2026-10-14T12:00:03Z", "msg": "no severity"}`,
+		`{"time": "2026-10-14T12:00:04Z", "msg": "slow", "severity": "warning"}`,
+		`{"time": "2026-10-14T12:00:05Z", "msg": "slow", "severity": "warning"}`,
+		`{"time": "2026-10-14T12:00:06Z", "msg": "slow", "severity": "warning"}`,
+	}, "\n"))
+
+	tests := []struct {
+		params    string
+		values    []TermCount
+		truncated bool
+		missing   int
+	}{
+		{"", []TermCount{{"warning", 3}, {"error", 2}, {"info", 1}}, false, 1},
+		{"&distinctSort=alpha", []TermCount{{"error", 2}, {"info", 1}, {"warning", 3}}, false, 1},
+		{"&limit=2", []TermCount{{"warning", 3}, {"error", 2}}, true, 1},
+		{"&query=disk", []TermCount{{"error", 2}}, false, 0},
+	}
+	for _, test := range tests {
+		var response struct {
+			Field     string      `json:"field"`
+			Count     int         `json:"count"`
+			Values    []TermCount `json:"values"`
+			Truncated bool        `json:"truncated"`
+			Missing   int         `json:"missing"`
+		}
+		getJSON(t, app, "/search?token=app1&distinct=severity"+dashboardRange+test.params, &response)
+		if response.Field != "severity" || response.Count != len(test.values) || !reflect.DeepEqual(response.Values, test.values) {
+			t.Errorf("%s: expected %v, got %+v", test.params, test.values, response)
+		}
+		if response.Truncated != test.truncated || response.Missing != test.missing {
+			t.Errorf("%s: expected truncated %v and %d missing, got %v and %d", test.params, test.truncated, test.missing, response.Truncated, response.Missing)
+		}
+	}
+
+	if w := getJSON(t, app, "/search?token=app1&distinct=level&distinctSort=size"+dashboardRange, nil); w.Code != 400 {
+		t.Errorf("expected unknown sorts to be rejected, got %d", w.Code)
+	}
+}
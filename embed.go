@@ -0,0 +1,80 @@
+package firlog
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrInvalidToken is returned by Ingest and IngestLines for tokens that
+// aren't one of Tokens.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Ingest indexes logs in token as if they had been POSTed to `/bulk/<token>`,
+// for programs embedding firlog. Logs need their Data, their Time defaulting
+// to now when zero, and go through the token's configuration (extract rules,
+// `timeField`, redaction, sampling...) before getting IDs of their own. With
+// a started queue they're indexed asynchronously, ErrQueueFull being returned
+// when it's full.
+func (app *App) Ingest(token string, logs []*Log) error {
+	if !contains(app.Tokens, token) {
+		return ErrInvalidToken
+	}
+	engine := app.engineForToken(token)
+	parser := newLogParser(engine.config(), engine.IDs)
+	parser.keepRaw = engine.StoreRaw
+
+	now := time.Now().UTC()
+	for _, log := range logs {
+		if log.Data == nil {
+			log.Data = map[string]interface{}{}
+		}
+		if log.Time.IsZero() {
+			log.Time = now
+		}
+		log.Data["time"] = log.Time
+		// Replayed like the JSON line they'd have been imported from.
+		if parser.keepsLines() && log.Raw == "" {
+			raw, _ := json.Marshal(log.Data)
+			log.Raw = string(raw)
+		}
+	}
+	return app.ingest(engine, parser.done(logs))
+}
+
+// IngestLines indexes the syslog lines of body in token like `/bulk/<token>`
+// does, skipping (and logging) the lines it can't parse.
+func (app *App) IngestLines(token string, body io.Reader) error {
+	if !contains(app.Tokens, token) {
+		return ErrInvalidToken
+	}
+	engine := app.engineForToken(token)
+	parser := newLogParser(engine.config(), engine.IDs)
+	parser.keepRaw = engine.StoreRaw
+	logs, err := parser.parseLines(body)
+	if err != nil {
+		return err
+	}
+	engine.recordMalformed(parser.received, parser.malformed, parser.malformedSamples)
+	return app.ingest(engine, logs)
+}
+
+// ingest indexes logs parsed for engine, dropping or moving those too far
+// ahead, sampling and enriching them first.
+func (app *App) ingest(engine *Engine, logs []*Log) error {
+	logs = app.prepare(engine, logs)
+	if len(logs) == 0 {
+		return nil
+	}
+	return engine.Enqueue(logs)
+}
+
+// prepare drops or moves the logs parsed for engine that are too far ahead,
+// samples and enriches them.
+func (app *App) prepare(engine *Engine, logs []*Log) []*Log {
+	logs = engine.CheckClockSkew(logs, time.Now())
+	logs = engine.Sample(logs)
+	app.enrich(logs)
+	return logs
+}
@@ -0,0 +1,45 @@
+package firlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIngest(t *testing.T) {
+	app := newTestApp(t, `{"rawField": true}`)
+	if err := app.Ingest("app2", []*Log{{Data: map[string]interface{}{"msg": "lost"}}}); err != ErrInvalidToken {
+		t.Errorf("expected unknown tokens to be rejected, got %v", err)
+	}
+	if err := app.IngestLines("app2", strings.NewReader(syslogLine("lost"))); err != ErrInvalidToken {
+		t.Errorf("expected unknown tokens to be rejected, got %v", err)
+	}
+
+	logs := []*Log{
+		{Time: testTime, Data: map[string]interface{}{"msg": "from go", "user": "bob"}},
+		{},
+	}
+	if err := app.Ingest("app1", logs); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.IngestLines("app1", strings.NewReader(syslogLine("from syslog")+"\nnot a log line\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, log := range logs {
+		if log.Id == "" || log.Time.IsZero() || log.Data["time"] != log.Time {
+			t.Errorf("expected the log to get an id and a time, got %+v", log)
+		}
+	}
+	if raw, _ := logs[0].Data[rawField].(string); !strings.Contains(raw, `"user":"bob"`) {
+		t.Errorf("expected the log to be kept raw as JSON, got %q", raw)
+	}
+
+	engine := app.engineForToken("app1")
+	for q, want := range map[string]uint64{"user:bob": 1, "syslog": 1, "log": 0} {
+		if total := searchTotal(t, engine, q); total != want {
+			t.Errorf("expected %s to match %d logs, got %d", q, want, total)
+		}
+	}
+	if count := docCount(t, engine); count != 3 {
+		t.Errorf("expected 3 logs to be indexed, got %d", count)
+	}
+}
@@ -2,23 +2,58 @@ package firlog
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/analysis/datetime/flexible"
 	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/oklog/ulid"
 )
 
+var errImmutableField = errors.New("id and time can't be changed")
+
 type Log struct {
 	Id   string
 	Time time.Time
 	Data map[string]interface{}
+	// Raw is the line, or lines, the log was parsed from when the engine
+	// stores them for Replay.
+	Raw string `json:",omitempty"`
+	// redacted are the values redaction took out of the log, masked in Raw
+	// when it's set after parsing.
+	redacted []string
+
+	// messageField and levelField are the configured fields the log is
+	// displayed with, see TokenConfig.MessageField.
+	messageField string
+	levelField   string
+	// hiddenFields are left out of FormattedData, see
+	// TokenConfig.HiddenFields.
+	hiddenFields []string
 }
 
+// DefaultMessageFields are the fields looked for, in order, as the message of
+// logs whose token doesn't configure one or that don't have it.
+var DefaultMessageFields = []string{"msg", "message"}
+
+// DefaultLevelField is the field holding the level of logs whose token
+// doesn't configure one.
+const DefaultLevelField = "level"
+
+// rawField holds the lines logs were parsed from with TokenConfig.RawField.
+const rawField = "_raw"
+
 func (l *Log) FormattedTime() string {
 	dt, err := time.Parse(time.RFC3339, l.Data["time"].(string))
 	if err != nil {
@@ -26,18 +61,147 @@ func (l *Log) FormattedTime() string {
 	}
 	return dt.Format("2006/01/02 15:04:05")
 }
+
+// FormattedTimeIn formats the log's time in the given display location.
+func (l *Log) FormattedTimeIn(loc *time.Location) string {
+	dt, err := time.Parse(time.RFC3339, l.Data["time"].(string))
+	if err != nil {
+		panic(err)
+	}
+	return dt.In(loc).Format("2006/01/02 15:04:05 MST")
+}
+
+// FormattedMessage is the log's message prefixed with its level.
 func (l *Log) FormattedMessage() string {
-	message := l.Data["msg"].(string)
-	if level, ok := l.Data["level"]; ok {
-		message = level.(string) + " " + message
+	message := l.Message()
+	if level := l.Level(); level != "" {
+		message = level + " " + message
 	}
 	return message
 }
 
+// Message is the value of the log's message field, or its serialized data
+// when it has none.
+func (l *Log) Message() string {
+	if field := l.MessageField(); field != "" {
+		return l.Field(field)
+	}
+	return l.FormattedData()
+}
+
+// HasMessage tells if the log has a message field, logs without one being
+// displayed by their data alone.
+func (l *Log) HasMessage() bool {
+	return l.MessageField() != ""
+}
+
+// MessageField is the field holding the log's message: the configured one,
+// else the first of DefaultMessageFields it has, blank when it has none.
+func (l *Log) MessageField() string {
+	if _, ok := l.Data[l.messageField]; ok && l.messageField != "" {
+		return l.messageField
+	}
+	for _, field := range DefaultMessageFields {
+		if _, ok := l.Data[field]; ok {
+			return field
+		}
+	}
+	return ""
+}
+
+func (l *Log) Level() string {
+	return l.Field(l.LevelField())
+}
+
+// LevelField is the field holding the log's level, the configured one or
+// DefaultLevelField.
+func (l *Log) LevelField() string {
+	if l.levelField != "" {
+		return l.levelField
+	}
+	return DefaultLevelField
+}
+
+// Field formats the value of a field for display, blank when the log doesn't
+// have it. Arrays of strings and numbers, indexed as multiple values, are
+// listed comma separated.
+func (l *Log) Field(name string) string {
+	if array, ok := l.Data[name].([]interface{}); ok {
+		if values, ok := scalarValues(array); ok {
+			return strings.Join(values, ", ")
+		}
+	}
+	switch value := l.Data[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		serialized, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return string(serialized)
+	}
+}
+
+// scalarValues formats the elements of an array for display, as long as
+// they're all strings, numbers or booleans.
+func scalarValues(array []interface{}) ([]string, bool) {
+	values := []string{}
+	for _, element := range array {
+		switch element := element.(type) {
+		case string:
+			values = append(values, element)
+		case float64, bool:
+			serialized, _ := json.Marshal(element)
+			values = append(values, string(serialized))
+		default:
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// LevelClass is the CSS class the dashboard colors the log's level with.
+func (l *Log) LevelClass() string {
+	if severity := levelSeverity(l.Level()); severity != "" {
+		return "log__level--" + severity
+	}
+	return ""
+}
+
+// levelSeverity groups the many spellings of log levels into error, warn,
+// info and debug.
+func levelSeverity(level string) string {
+	switch strings.ToLower(level) {
+	case "fatal", "panic", "crit", "critical", "err", "error":
+		return "error"
+	case "warn", "warning":
+		return "warn"
+	case "info", "notice":
+		return "info"
+	case "debug", "trace":
+		return "debug"
+	}
+	return ""
+}
+
 func (l *Log) FormattedData() string {
+	return l.FormattedDataExcept(nil)
+}
+
+// FormattedDataExcept is FormattedData without the fields shown in columns of
+// their own.
+func (l *Log) FormattedDataExcept(columns []string) string {
+	hidden := l.hiddenFields
+	if hidden == nil {
+		hidden = DefaultHiddenFields
+	}
 	data := map[string]interface{}{}
+	messageField, levelField := l.MessageField(), l.LevelField()
 	for k, v := range l.Data {
-		if k == "id" || k == "time" || k == "level" || k == "msg" {
+		if k == levelField || k == messageField || contains(hidden, k) || contains(columns, k) {
 			continue
 		}
 		data[k] = v
@@ -50,161 +214,640 @@ func (l *Log) FormattedData() string {
 }
 
 type Engine struct {
+	// MaxRetries is how many more times a batch failing with a retryable
+	// error is applied before giving up, waiting RetryBackoff (doubled on
+	// every attempt) in between.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// DeadLetter makes batches that still fail after retries get written to
+	// disk for ReplayDeadLetters instead of being dropped.
+	DeadLetter bool
+	// WAL makes Enqueue record logs to a write-ahead log on disk until
+	// they're indexed, for ReplayWAL to recover them after a crash.
+	WAL bool
+	// StoreRaw stores the lines logs are parsed from next to them, for
+	// Replay to reparse them once the token's config changes.
+	StoreRaw bool
+	// IndexChunkSize is the most logs applied in a single batch.
+	IndexChunkSize int
+	// IndexWorkers is how many dates (and shards of a same date) of a same
+	// Index call are applied at once.
+	IndexWorkers int
+	// RecentSize is how many of the newest logs given to Enqueue are kept
+	// in memory for Recent, 0 keeping none.
+	RecentSize int
+	// Shards is how many indexes the logs of new dates are split into,
+	// by a hash of their ID, so that many batches of a same date can be
+	// applied at once.
+	Shards int
+	// IndexPrefix starts the directory names of new indexes, followed by a
+	// dash, so they can be told apart once copied out of the data
+	// directory. Existing indexes keep their names.
+	IndexPrefix string
+	// MaxClockSkew is how far in the future received logs can be
+	// timestamped before ClockSkewPolicy applies to them, 0 accepting any
+	// time.
+	MaxClockSkew    time.Duration
+	ClockSkewPolicy string
+	// IDs generates the IDs parsed logs are stored under.
+	IDs IDGenerator
+	// Config is the token's configuration, read from its `config.json` on
+	// startup and replaced by ReloadConfig.
+	Config *TokenConfig
+	// Notifier delivers the alerts of rules without a webhook of their own,
+	// StderrNotifier by default.
+	Notifier Notifier
+
 	dataDir string
+	mu      sync.RWMutex
+	// writeMu is held for reading while batches are applied so snapshots can
+	// exclude writes by holding it for writing.
+	writeMu sync.RWMutex
 	indexes map[string]bleve.Index
+	queue   *indexQueue
+	// compacting holds the months currently being merged, whose writes go
+	// to the monthly index already.
+	compacting map[string]bool
+	// copying holds the daily indexes being compacted, left out of
+	// searches, see compactDaily.
+	copying map[string]bool
+	// compactMu is held while a month is compacted so snapshots don't
+	// copy its indexes halfway through.
+	compactMu sync.Mutex
+	// broken holds the errors of indexes that failed to open or search.
+	broken map[string]string
+	// optimizations are the statuses of the OptimizeIndex calls by index.
+	optimizations map[string]*OptimizeStatus
+	alerter       *alerter
+	sampler       *sampler
+	// malformed tracks malformed bulk lines for Config.MalformedAlert.
+	malformed *malformedTracker
+	// lastIngest is the UnixNano time logs were last indexed at.
+	lastIngest int64
+	// clockSkewed counts the logs CheckClockSkew found too far ahead.
+	clockSkewed int64
+	// searchLatency records how long the engine's searches took.
+	searchLatency latencyWindow
+	fieldLimiter  fieldLimiter
+	// configMu guards Config against ReloadConfig.
+	configMu sync.RWMutex
+	recent   recentLogs
+	offsets  offsets
+	// generation counts index creations and removals, invalidating
+	// cachedGroup.
+	generation  uint64
+	groupMu     sync.Mutex
+	cachedGroup *indexGroup
+	// readOnly engines only open existing indexes, see OpenEngineReadOnly.
+	readOnly bool
 }
 
+// NewEngine opens the indexes of dataDir, creating it with DefaultDataDirMode
+// if missing.
 func NewEngine(dataDir string) *Engine {
+	engine, err := newEngine(dataDir, DefaultDataDirMode, false)
+	if err != nil {
+		panic(err)
+	}
+	return engine
+}
+
+// newEngine opens the indexes of dataDir, creating it with mode if missing
+// unless readOnly.
+func newEngine(dataDir string, mode os.FileMode, readOnly bool) (*Engine, error) {
 	engine := &Engine{
-		dataDir: dataDir,
-		indexes: map[string]bleve.Index{},
+		MaxRetries:     DefaultMaxRetries,
+		RetryBackoff:   DefaultRetryBackoff,
+		IndexChunkSize: DefaultIndexChunkSize,
+		IndexWorkers:   DefaultIndexWorkers,
+		Shards:         DefaultShards,
+		IDs:            ULIDGenerator{},
+		Notifier:       StderrNotifier{},
+		dataDir:        dataDir,
+		indexes:        map[string]bleve.Index{},
+		compacting:     map[string]bool{},
+		copying:        map[string]bool{},
+		broken:         map[string]string{},
+		optimizations:  map[string]*OptimizeStatus{},
+		alerter:        &alerter{states: map[string]*alertState{}},
+		sampler:        &sampler{seen: map[string]int{}},
+		malformed:      &malformedTracker{},
+		readOnly:       readOnly,
+
+		ClockSkewPolicy: ClockSkewTag,
 	}
 
+	if !readOnly {
+		if err := createDataDir(dataDir, mode); err != nil {
+			return nil, err
+		}
+	}
 	indexesNames, err := listIndexes(dataDir)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	engine.Config, err = loadTokenConfig(dataDir)
+	if err != nil {
+		return nil, err
 	}
+	// An index that can't be opened must not keep the others from being
+	// searched, it's left out until it's repaired or quarantined.
 	for _, indexName := range indexesNames {
-		var err error
-		engine.indexes[strings.Split(indexName, "_")[0]], err = bleve.Open(filepath.Join(dataDir, indexName))
+		key := indexKey(indexName)
+		index, err := engine.openIndex(filepath.Join(dataDir, indexName))
 		if err != nil {
-			panic(err)
+			logger.Printf("error opening index %s, skipping it: %v\n", indexName, err)
+			engine.broken[key] = err.Error()
+			continue
 		}
+		engine.indexes[key] = index
+	}
+	if err := engine.resumeCompaction(); err != nil {
+		logger.Printf("error finishing the interrupted compaction: %v\n", err)
+	}
+	if err := engine.recomputeLastIngest(); err != nil {
+		logger.Printf("error finding the last ingested log: %v\n", err)
 	}
 
-	return engine
+	return engine, nil
 }
 
 func (e *Engine) Stats() map[string]map[string]interface{} {
+	indexes := e.snapshotIndexes()
 	indexesStats := map[string]map[string]interface{}{}
-	for date, index := range e.indexes {
-		indexesStats[date] = index.StatsMap()
+	for _, date := range e.sortedIndexNames() {
+		if index, ok := indexes[date]; ok {
+			indexesStats[date] = index.StatsMap()
+		}
 	}
 	return indexesStats
 }
 
-func (e *Engine) Search(search *bleve.SearchRequest, limit int) ([]*Log, error) {
-	logs := []*Log{}
+// IndexInfo summarizes one of an engine's daily or monthly indexes.
+type IndexInfo struct {
+	Date     string `json:"date"`
+	DocCount uint64 `json:"docCount"`
+	// Broken is why the index isn't opened, for Coverage.
+	Broken string `json:"broken,omitempty"`
+}
 
-	if len(e.indexes) == 0 {
-		return []*Log{}, nil
+// Indexes lists the engine's indexes sorted by date.
+func (e *Engine) Indexes() ([]IndexInfo, error) {
+	infos := []IndexInfo{}
+	indexes := e.snapshotIndexes()
+	for _, date := range e.sortedIndexNames() {
+		index, ok := indexes[date]
+		if !ok {
+			continue
+		}
+		count, err := index.DocCount()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, IndexInfo{Date: date, DocCount: count})
 	}
+	return infos, nil
+}
+
+// SearchResult is a page of logs matching a search.
+type SearchResult struct {
+	Logs []*Log
+	// Total is how many logs matched, including the ones past the page.
+	Total uint64
+	// Indexes is how many indexes were searched.
+	Indexes int
+	// Warnings tell about the broken indexes missing from the results.
+	Warnings []string
+	// Explanations are how each of Logs matched, for requests with Explain.
+	Explanations []*search.Explanation
+	// Partial tells the search timed out, Logs only coming from the indexes
+	// searched in time.
+	Partial bool
+
+	// closed tells that some indexes were closed while being searched, their
+	// logs missing from the results.
+	closed bool
+}
+
+// Search runs search over all of the engine's indexes. Indexes that fail are
+// skipped and recorded as broken.
+func (e *Engine) Search(search *bleve.SearchRequest, limit int) (*SearchResult, error) {
+	return e.group().search(search, limit)
+}
 
-	// TODO extract and cache
-	group := bleve.NewIndexAlias()
-	for _, index := range e.indexes {
-		group.Add(index)
+func (e *Engine) skipBroken(key string, err error) {
+	logger.Printf("error searching index %s of %s, skipping it: %v\n", key, e.token(), err)
+	e.markBroken(key, err)
+}
+
+func (e *Engine) brokenWarnings() []string {
+	broken := e.BrokenIndexes()
+	keys := []string{}
+	for key := range broken {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	searchResult, err := group.Search(search)
-	if err != nil {
-		return nil, err
+	warnings := []string{}
+	for _, key := range keys {
+		warnings = append(warnings, fmt.Sprintf("Index %s of %s is broken (%s), its logs are missing from the results", key, e.token(), broken[key]))
 	}
+	return warnings
+}
 
-	for _, hit := range searchResult.Hits {
-		dtString := hit.Fields["time"].(string)
-		dt, err := time.Parse(time.RFC3339, dtString)
-		if err != nil {
-			return nil, err
+// Get returns the log stored under id, or nil if there's none. IDs are ULIDs
+// so the index for the time they were generated at is tried first, which is
+// where live ingested logs end up; other indexes are searched next.
+func (e *Engine) Get(id string) (*Log, error) {
+	log, _, err := e.find(id)
+	return log, err
+}
+
+// find returns the log stored under id along with the index holding it.
+func (e *Engine) find(id string) (*Log, bleve.Index, error) {
+	indexes := e.snapshotIndexes()
+	candidates := []bleve.Index{}
+	if parsed, err := ulid.Parse(id); err == nil {
+		date := time.Unix(0, int64(parsed.Time())*int64(time.Millisecond)).UTC().Format("20060102")
+		if index, ok := e.lookupIndex(routeKey(date, id, e.shardCounts(nil)[date])); ok {
+			candidates = append(candidates, index)
 		}
+	}
+	for _, key := range e.sortedIndexNames() {
+		if index, ok := indexes[key]; ok {
+			candidates = append(candidates, index)
+		}
+	}
 
-		index, err := e.indexFor(dt.Format("20060102"))
+	for _, index := range candidates {
+		data, err := loadLogData(index, id)
 		if err != nil {
-			return nil, err
+			return nil, nil, fmt.Errorf("bleve get internal: %v", err)
+		}
+		if data == nil {
+			continue
 		}
+		log := &Log{Id: id, Data: data}
+		e.config().display(log)
+		return log, index, nil
+	}
+	return nil, nil, nil
+}
 
-		logValue, err := index.GetInternal([]byte(hit.ID))
-		if err != nil {
-			return nil, fmt.Errorf("bleve get internal: %v", err)
+// Update merges fields into the log stored under id and re-indexes it in
+// place, returning the updated log or nil if there's none. Fields deciding
+// where a log is stored, `id` and `time`, can't be changed.
+func (e *Engine) Update(id string, fields map[string]interface{}) (*Log, error) {
+	if e.readOnly {
+		return nil, ErrReadOnly
+	}
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+
+	log, index, err := e.find(id)
+	if err != nil || log == nil {
+		return nil, err
+	}
+	for _, key := range []string{"id", "time"} {
+		if value, ok := fields[key]; ok && value != log.Data[key] {
+			return nil, errImmutableField
 		}
-		log := &Log{Id: hit.ID}
-		err = json.Unmarshal(logValue, &log.Data)
-		if err != nil {
-			return nil, err
+	}
+	for key, value := range fields {
+		log.Data[key] = value
+	}
+	e.config().redact(log.Data)
+
+	batch := index.NewBatch()
+	if err := e.addToBatch(batch, log); err != nil {
+		return nil, err
+	}
+	reopen := func() (bleve.Index, error) {
+		_, index, err := e.find(id)
+		if err == nil && index == nil {
+			err = bleve.ErrorIndexClosed
 		}
+		return index, err
+	}
+	if err := e.applyBatch(index, batch, reopen); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// lookupIndex returns the existing daily (or shard of it) or monthly index
+// holding the logs of key.
+func (e *Engine) lookupIndex(key string) (bleve.Index, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if index, ok := e.indexes[key]; ok {
+		return index, true
+	}
+	index, ok := e.indexes[key[:len("200601")]]
+	return index, ok
+}
+
+// TermCount is how many logs have a given value for a field.
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// Terms returns the (at most size) most frequent values of field among the
+// logs q matches.
+func (e *Engine) Terms(q query.Query, field string, size int) ([]TermCount, error) {
+	return e.group().terms(q, field, size)
+}
 
-		logs = append(logs, log)
+// searchedIndexes is snapshotIndexes without the indexes being compacted.
+func (e *Engine) searchedIndexes() map[string]bleve.Index {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	indexes := map[string]bleve.Index{}
+	for key, index := range e.indexes {
+		if !e.copying[key] {
+			indexes[key] = index
+		}
 	}
+	return indexes
+}
 
-	return logs, nil
+func (e *Engine) snapshotIndexes() map[string]bleve.Index {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	indexes := map[string]bleve.Index{}
+	for key, index := range e.indexes {
+		indexes[key] = index
+	}
+	return indexes
 }
 
 func (e *Engine) Index(logs []*Log) error {
-	batches := map[string]*bleve.Batch{}
+	return e.index(logs, e.DeadLetter, true)
+}
 
+// index applies logs in batches of at most IndexChunkSize logs, each of them
+// all-or-nothing, so huge requests don't build huge batches. Dates, and shards
+// of a same date, go to independent indexes so up to IndexWorkers of them are
+// applied at once, chunks of a same index still being applied in order.
+// Alert rules are evaluated against the indexed logs only with alert, logs
+// replayed from disk having been received, and counted, before.
+func (e *Engine) index(logs []*Log, deadLetter, alert bool) error {
+	if e.readOnly {
+		return ErrReadOnly
+	}
+	e.limitFields(logs)
+	dates := []string{}
 	for _, log := range logs {
-		date := log.Time.Format("20060102")
-		index, err := e.indexFor(date)
-		if err != nil {
-			return err
-		}
-		batch, ok := batches[date]
-		if !ok {
-			batches[date] = index.NewBatch()
-			batch = batches[date]
+		dates = append(dates, log.Time.Format("20060102"))
+	}
+	shards := e.shardCounts(dates)
+	keys := []string{}
+	logsByKey := map[string][]*Log{}
+	for i, log := range logs {
+		key := routeKey(dates[i], log.Id, shards[dates[i]])
+		if _, ok := logsByKey[key]; !ok {
+			keys = append(keys, key)
 		}
+		logsByKey[key] = append(logsByKey[key], log)
+	}
 
-		serialized, err := json.Marshal(log.Data)
-		if err != nil {
-			return err
-		}
-		batch.Index(log.Id, log.Data)
-		batch.SetInternal([]byte(log.Id), serialized)
+	chunkSize := e.IndexChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultIndexChunkSize
+	}
+	workers := e.IndexWorkers
+	if workers <= 0 {
+		workers = DefaultIndexWorkers
 	}
 
-	for date, batch := range batches {
-		index, err := e.indexFor(date)
-		if err != nil {
-			return err
-		}
-		err = index.Batch(batch)
-		if err != nil {
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+
+	var (
+		wg      sync.WaitGroup
+		errMu   sync.Mutex
+		errs    []string
+		indexed int64
+	)
+	work := make(chan string)
+	for i := 0; i < workers && i < len(keys); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				keyLogs := logsByKey[key]
+				for start := 0; start < len(keyLogs); start += chunkSize {
+					end := start + chunkSize
+					if end > len(keyLogs) {
+						end = len(keyLogs)
+					}
+					if err := e.indexChunk(key, keyLogs[start:end], deadLetter, alert); err != nil {
+						errMu.Lock()
+						errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+						errMu.Unlock()
+						break
+					}
+					atomic.AddInt64(&indexed, int64(end-start))
+				}
+			}
+		}()
+	}
+	for _, key := range keys {
+		work <- key
+	}
+	close(work)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("indexed %d of %d logs: %s", indexed, len(logs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// addToBatch indexes log in batch along with its raw line if it has one and,
+// unless the token is configured with SkipStoredJSON, the JSON copy of it
+// searches return.
+func (e *Engine) addToBatch(batch *bleve.Batch, log *Log) error {
+	if err := batch.Index(log.Id, log.Data); err != nil {
+		return err
+	}
+	if log.Raw != "" {
+		batch.SetInternal(rawKey(log.Id), []byte(log.Raw))
+	}
+	if e.config().SkipStoredJSON {
+		return nil
+	}
+	serialized, err := json.Marshal(log.Data)
+	if err != nil {
+		return err
+	}
+	batch.SetInternal([]byte(log.Id), serialized)
+	return nil
+}
+
+func (e *Engine) indexChunk(key string, logs []*Log, deadLetter, alert bool) error {
+	index, err := e.indexFor(key)
+	if err != nil {
+		return err
+	}
+	batch := index.NewBatch()
+	for _, log := range logs {
+		if err := e.addToBatch(batch, log); err != nil {
 			return err
 		}
 	}
 
+	err = e.applyBatch(index, batch, func() (bleve.Index, error) { return e.indexFor(key) })
+	if err != nil && !deadLetter {
+		return err
+	} else if err != nil {
+		if dlErr := e.writeDeadLetter(logs); dlErr != nil {
+			return fmt.Errorf("%v (dead-letter: %v)", err, dlErr)
+		}
+		logger.Printf("dead-lettered %d logs for %s: %v\n", len(logs), key, err)
+		return nil
+	}
+	e.touchLastIngest(time.Now())
+	if alert {
+		e.evaluateAlerts(index, logs)
+	}
 	return nil
 }
 
-func buildIndexMapping() *mapping.IndexMappingImpl {
+// timeFormatsParser is the datetime parser of the mapping of tokens with
+// TimeFormats, used for string fields in place of bleve's default one.
+const timeFormatsParser = "firlogTimeFormats"
+
+// defaultTimeFormats are the layouts bleve's default datetime parser indexes
+// strings as dates with, which timeFormatsParser keeps.
+var defaultTimeFormats = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// buildIndexMapping is the mapping new indexes get created with. Indexes
+// keep the mapping they were created with, config changes only apply to the
+// ones created afterwards.
+func buildIndexMapping(config *TokenConfig) *mapping.IndexMappingImpl {
 	indexMapping := bleve.NewIndexMapping()
 
+	msgMapping := bleve.NewTextFieldMapping()
+	msgMapping.Analyzer = config.Analyzer
+
+	fieldMappings := map[string]*mapping.FieldMapping{
+		"level": bleve.NewTextFieldMapping(),
+		"msg":   msgMapping,
+	}
+	for _, field := range config.keywordFields() {
+		keywordMapping := bleve.NewTextFieldMapping()
+		keywordMapping.Analyzer = keyword.Name
+		fieldMappings[field] = keywordMapping
+	}
+	for _, field := range config.BooleanFields {
+		fieldMappings[field] = bleve.NewBooleanFieldMapping()
+	}
+
 	logMapping := bleve.NewDocumentMapping()
 	logMapping.AddFieldMappingsAt("time", bleve.NewDateTimeFieldMapping())
-	logMapping.AddFieldMappingsAt("level", bleve.NewTextFieldMapping())
-	logMapping.AddFieldMappingsAt("msg", bleve.NewTextFieldMapping())
+	for field, fieldMapping := range fieldMappings {
+		logMapping.AddFieldMappingsAt(field, fieldMapping)
+	}
 
 	indexMapping.DefaultMapping = logMapping
+
+	if len(config.TimeFormats) > 0 {
+		layouts := []interface{}{}
+		for _, layout := range defaultTimeFormats {
+			layouts = append(layouts, layout)
+		}
+		for _, layout := range config.TimeFormats {
+			layouts = append(layouts, layout)
+		}
+		// Validated along with the mapping.
+		indexMapping.AddCustomDateTimeParser(timeFormatsParser, map[string]interface{}{
+			"type":    flexible.Name,
+			"layouts": layouts,
+		})
+		indexMapping.DefaultDateTimeParser = timeFormatsParser
+	}
 	return indexMapping
 }
 
-func (e *Engine) indexFor(date string) (bleve.Index, error) {
-	if index, ok := e.indexes[date]; ok {
+// indexFor returns the index logs of key go in, a date (`20060102`) or shard
+// of one (`20060102_2`), creating it if needed. Dates of compacted months map
+// to their monthly index (`200601`).
+func (e *Engine) indexFor(key string) (bleve.Index, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if date := keyDate(key); len(date) == len("20060102") {
+		month := date[:len("200601")]
+		if e.compacting[month] {
+			key = month
+		} else if _, ok := e.indexes[key]; !ok {
+			if index, ok := e.indexes[month]; ok {
+				return index, nil
+			}
+		}
+	}
+	if index, ok := e.indexes[key]; ok {
 		return index, nil
 	}
 
 	var index bleve.Index
-	indexPath := filepath.Join(e.dataDir, date+"_1.bleve")
+	indexPath := e.indexPath(key)
 	_, err := os.Stat(indexPath)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, fmt.Errorf("failed to check existence of index")
 	} else if os.IsNotExist(err) {
-		index, err = bleve.New(indexPath, buildIndexMapping())
+		if e.readOnly {
+			return nil, ErrReadOnly
+		}
+		index, err = bleve.New(indexPath, buildIndexMapping(e.config()))
 		if err != nil {
 			return nil, fmt.Errorf("bleve new: %s", err.Error())
 		}
-		e.indexes[date] = index
+		e.indexes[key] = index
+		e.indexesChanged()
 	} else {
-		index, err = bleve.Open(indexPath)
+		index, err = e.openIndex(indexPath)
 		if err != nil {
 			return nil, fmt.Errorf("bleve open: %s", err.Error())
 		}
-		e.indexes[date] = index
+		e.indexes[key] = index
+		e.indexesChanged()
 	}
-	return e.indexes[date], nil
+	return e.indexes[key], nil
+}
+
+func (e *Engine) indexPath(key string) string {
+	path := filepath.Join(e.dataDir, indexDirName(e.IndexPrefix, key))
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// Created before IndexPrefix changed, it keeps its name.
+		if existing, err := e.indexDir(key); err == nil {
+			return existing
+		}
+	}
+	return path
+}
+
+// token is the name of the token the engine stores logs for.
+func (e *Engine) token() string {
+	return filepath.Base(e.dataDir)
 }
 
 func (e *Engine) sortedIndexNames() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	names := []string{}
 	for name := range e.indexes {
 		names = append(names, name)
@@ -213,12 +856,20 @@ func (e *Engine) sortedIndexNames() []string {
 	return names
 }
 
-func listIndexes(dataDir string) ([]string, error) {
-	err := os.MkdirAll(dataDir, os.ModePerm)
-	if err != nil {
-		return nil, err
+// DefaultDataDirMode is the permissions missing data directories are
+// created with, logs being for the user firlog runs as (and its group).
+const DefaultDataDirMode os.FileMode = 0750
+
+// createDataDir creates dir, and its parents, with mode unless it exists.
+func createDataDir(dir string, mode os.FileMode) error {
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return fmt.Errorf("can't create data directory: %v", err)
 	}
+	return nil
+}
 
+// listIndexes returns the names of the index directories of dataDir, sorted.
+func listIndexes(dataDir string) ([]string, error) {
 	d, err := os.Open(dataDir)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,178 @@
+package firlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// testTime is the time test logs are received at, all of them going to the
+// same daily index.
+var testTime = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+func newTestEngine(t *testing.T) *Engine {
+	t.Helper()
+	return NewEngine(filepath.Join(t.TempDir(), "app1"))
+}
+
+// newTestApp returns an app of the token app1, indexing synchronously, with
+// config as its `config.json` unless empty. The engine of app1 is only
+// created once asked for so fields of the app can be set first.
+func newTestApp(t *testing.T, config string) *App {
+	t.Helper()
+	app := NewApp(t.TempDir(), []string{"app1"})
+	app.QueueSize = 0
+	if config != "" {
+		dir := filepath.Join(app.DataDir, "app1")
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, tokenConfigFileName), []byte(config), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return app
+}
+
+// postBulk sends body to `/bulk/app1` of app as contentType.
+func postBulk(t *testing.T, app *App, contentType, body string) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/bulk/app1", strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	app.handler("user", "pass").ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("expected bulk request to answer 200, got %d: %s", w.Code, w.Body)
+	}
+}
+
+// getJSON requests path of app with its dashboard credentials, decoding the
+// response into response unless nil.
+func getJSON(t *testing.T, app *App, path string, response interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", path, nil)
+	r.SetBasicAuth("user", "pass")
+	w := httptest.NewRecorder()
+	app.handler("user", "pass").ServeHTTP(w, r)
+	if response != nil && w.Code == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+			t.Fatalf("expected %s to answer JSON, got %v: %s", path, err, w.Body)
+		}
+	}
+	return w
+}
+
+// searchTotal is how many logs of e the query string q matches.
+func searchTotal(t *testing.T, e *Engine, q string) uint64 {
+	t.Helper()
+	result, err := e.group().search(bleve.NewSearchRequest(bleve.NewQueryStringQuery(q)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Total
+}
+
+// testLogs returns n logs with IDs of e, their messages prefixed with name.
+func testLogs(e *Engine, name string, n int) []*Log {
+	logs := []*Log{}
+	for i := 0; i < n; i++ {
+		at := testTime.Add(time.Duration(len(logs)) * time.Millisecond)
+		log := &Log{Time: at, Data: map[string]interface{}{
+			"time": at,
+			"msg":  fmt.Sprintf("%s %d", name, i),
+		}}
+		log.Id = e.IDs.NewID(log)
+		logs = append(logs, log)
+	}
+	return logs
+}
+
+// docCount is how many logs the indexes of e hold.
+func docCount(t *testing.T, e *Engine) uint64 {
+	t.Helper()
+	stats, err := e.IndexStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count uint64
+	for _, indexStats := range stats {
+		count += indexStats.DocCount
+	}
+	return count
+}
+
+func TestFirstRunCreatesDataDir(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "data")
+	app := NewApp(dataDir, []string{"app1"})
+	app.DataDirMode = 0700
+	engine := app.engineForToken("app1")
+	for _, dir := range []string{dataDir, engine.dataDir} {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.IsDir() || fi.Mode().Perm() != 0700 {
+			t.Errorf("expected %s to be created with mode 0700, got %s", dir, fi.Mode())
+		}
+	}
+
+	logs := testLogs(engine, "first", 2)
+	if err := engine.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	if count := docCount(t, engine); count != 2 {
+		t.Errorf("expected 2 docs, got %d", count)
+	}
+
+	// Listing indexes or opening engines read-only leaves missing
+	// directories missing.
+	missing := filepath.Join(t.TempDir(), "missing")
+	if _, err := listIndexes(missing); !os.IsNotExist(err) {
+		t.Errorf("expected listing a missing directory to fail, got %v", err)
+	}
+	if _, err := OpenEngineReadOnly(missing); !os.IsNotExist(err) {
+		t.Errorf("expected opening a missing directory read-only to fail, got %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to be created, got %v", missing, err)
+	}
+}
+
+func TestIndexAppliesChunks(t *testing.T) {
+	for _, failing := range []bool{false, true} {
+		e := newTestEngine(t)
+		e.IndexChunkSize = 2
+		if err := e.Index(testLogs(e, "first", 1)); err != nil {
+			t.Fatal(err)
+		}
+		index := &failingIndex{}
+		if failing {
+			index.errs = []error{errors.New("invalid document")}
+		}
+		e.mu.Lock()
+		for key, opened := range e.indexes {
+			index.wrappedIndex = opened
+			e.indexes[key] = index
+		}
+		e.mu.Unlock()
+
+		err := e.Index(testLogs(e, "second", 5))
+		if failing {
+			// Chunks after a failed one aren't applied.
+			if err == nil || !strings.Contains(err.Error(), "indexed 0 of 5 logs") || index.batches != 1 || docCount(t, e) != 1 {
+				t.Errorf("expected the first chunk to fail the request, got %v after %d batches", err, index.batches)
+			}
+		} else if err != nil || index.batches != 3 || docCount(t, e) != 6 {
+			t.Errorf("expected 3 batches of at most 2 logs, got %v after %d batches", err, index.batches)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Codes of the JSON error responses, stable for clients to switch on.
+const (
+	errorCodeBadRequest       = "bad_request"
+	errorCodeUnauthorized     = "unauthorized"
+	errorCodeForbidden        = "forbidden"
+	errorCodeInvalidToken     = "invalid_token"
+	errorCodeNotFound         = "not_found"
+	errorCodeMethodNotAllowed = "method_not_allowed"
+	errorCodeInvalidQuery     = "invalid_query"
+	errorCodeRateLimited      = "rate_limited"
+	errorCodeQueueFull        = "queue_full"
+	errorCodeConflict         = "conflict"
+	errorCodeTimeout          = "timeout"
+	errorCodeInternal         = "internal"
+)
+
+// writeError answers an API request with a JSON error envelope:
+// `{"error": {"code": "...", "message": "..."}}`. The dashboard, being for
+// browsers, sticks to http.Error.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
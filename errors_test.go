@@ -0,0 +1,52 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   bool
+		status int
+		code   string
+	}{
+		{"bad authorization", "GET", "/search?token=app1", false, 401, errorCodeUnauthorized},
+		{"invalid token", "POST", "/bulk?token=app2", true, 401, errorCodeInvalidToken},
+		{"invalid query", "GET", "/search?token=app1&query=%22disk+full" + dashboardRange, true, 400, errorCodeInvalidQuery},
+		{"method not allowed", "GET", "/bulk?token=app1", true, 405, errorCodeMethodNotAllowed},
+		// Unknown paths aren't asked for credentials.
+		{"not found", "GET", "/favicon.ico", false, 404, errorCodeNotFound},
+		{"not found with auth", "GET", "/wp-login.php?token=app1", true, 404, errorCodeNotFound},
+		{"dashboard", "GET", "/?token=app1", false, 401, errorCodeUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(""))
+			if test.auth {
+				r.SetBasicAuth("user", "pass")
+			}
+			w := httptest.NewRecorder()
+			app.handler("user", "pass").ServeHTTP(w, r)
+			response := struct {
+				Error struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}{}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("expected a JSON error, got %q: %s", w.Header().Get("Content-Type"), w.Body)
+			}
+			if w.Code != test.status || response.Error.Code != test.code || response.Error.Message == "" {
+				t.Errorf("expected %d %s, got %d: %s", test.status, test.code, w.Code, w.Body)
+			}
+		})
+	}
+}
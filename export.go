@@ -0,0 +1,157 @@
+package firlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Export writes the logs of dates between from and to (inclusive `20060102`
+// dates, empty for unbounded) to w as NDJSON, one index at a time so exports
+// of any size are streamed, flushing w after each if it's an http.Flusher.
+// Logs keep their IDs so importing them back with POST /import is
+// idempotent.
+func (e *Engine) Export(w io.Writer, from, to string) (int, error) {
+	out := bufio.NewWriter(w)
+	exported := 0
+	indexes := e.snapshotIndexes()
+	for _, key := range e.sortedIndexNames() {
+		index, ok := indexes[key]
+		if !ok || !indexInRange(key, from, to) {
+			continue
+		}
+
+		advanced, _, err := index.Advanced()
+		if err != nil {
+			return exported, err
+		}
+		reader, err := advanced.Reader()
+		if err != nil {
+			return exported, err
+		}
+		ids, err := reader.DocIDReaderAll()
+		if err != nil {
+			reader.Close()
+			return exported, err
+		}
+		for {
+			internalID, err := ids.Next()
+			if err != nil || internalID == nil {
+				ids.Close()
+				reader.Close()
+				if err != nil {
+					return exported, err
+				}
+				break
+			}
+			id, err := reader.ExternalID(internalID)
+			if err != nil {
+				continue
+			}
+			data, err := loadLogData(index, id)
+			if err != nil || data == nil {
+				continue
+			}
+			serialized, err := json.Marshal(data)
+			if err != nil {
+				continue
+			}
+			// Monthly indexes can hold dates outside of the range.
+			if len(keyDate(key)) != len("20060102") && !logInRange(serialized, from, to) {
+				continue
+			}
+			out.Write(serialized)
+			out.WriteByte('\n')
+			exported++
+		}
+		// Each index is sent once done, so clients see the export progress.
+		if err := out.Flush(); err != nil {
+			return exported, err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	return exported, out.Flush()
+}
+
+func logInRange(serialized []byte, from, to string) bool {
+	data := struct {
+		Time time.Time `json:"time"`
+	}{}
+	if err := json.Unmarshal(serialized, &data); err != nil {
+		return true
+	}
+	date := data.Time.UTC().Format("20060102")
+	return (from == "" || date >= from) && (to == "" || date <= to)
+}
+
+// gzipFlusher flushes what was compressed so far to w.
+type gzipFlusher struct {
+	*gzip.Writer
+	w http.ResponseWriter
+}
+
+func (f gzipFlusher) Flush() {
+	f.Writer.Flush()
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// handleExport streams `GET /export?token=<token>&from=<date>&to=<date>` as
+// gzipped NDJSON, or plain with `gzip=0`.
+func (app *App) handleExport(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if !contains(app.allowedTokens(r), token) {
+		writeError(w, 404, errorCodeInvalidToken, "unknown token")
+		return
+	}
+	compress := r.URL.Query().Get("gzip") != "0"
+
+	filename := token + ".ndjson"
+	var out io.Writer = w
+	if compress {
+		filename += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gzipFlusher{gz, w}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	exported, err := app.engineForToken(token).Export(out, r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		// Headers are sent by now, all that's left is to cut the export short.
+		logger.Printf("error exporting %s after %d logs: %v\n", token, exported, err)
+	}
+}
+
+// handleImport indexes the NDJSON (optionally gzipped) body of
+// `POST /import?token=<token>`, such as an export, keeping the logs' IDs.
+func (app *App) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, 405, errorCodeMethodNotAllowed, "only POST supported")
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if !contains(app.Tokens, token) {
+		writeError(w, 404, errorCodeInvalidToken, "unknown token")
+		return
+	}
+
+	imported, err := app.importLogs(token, r.Body, true)
+	if err != nil {
+		logger.Printf("error importing into %s after %d logs: %v\n", token, imported, err)
+		writeError(w, 500, errorCodeInternal, fmt.Sprintf("Error importing after %d logs", imported))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"imported": imported})
+}
@@ -0,0 +1,62 @@
+package firlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	app := newTestApp(t, "")
+	postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+		`{"time": "2026-10-14T12:00:00Z", "msg": "today"}`,
+		`{"time": "2026-10-13T12:00:00Z", "msg": "yesterday"}`,
+		`{"time": "2026-10-12T12:00:00Z", "msg": "two days ago"}`,
+	}, "\n"))
+	logs := app.engineForToken("app1").Recent(3)
+
+	w := getJSON(t, app, "/export?token=app1", nil)
+	gz, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("expected a gzipped export, got %v", err)
+	}
+	export, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(export)), "\n"); len(lines) != 3 || !strings.Contains(lines[0], `"two days ago"`) || !strings.Contains(lines[0], `"id":"`) {
+		t.Errorf("expected the 3 logs oldest date first, got %s", export)
+	}
+	if ranged := getJSON(t, app, "/export?token=app1&gzip=0&from=20261013&to=20261013", nil).Body.String(); strings.Count(ranged, "\n") != 1 || !strings.Contains(ranged, `"yesterday"`) {
+		t.Errorf("expected the logs of 20261013 as plain NDJSON, got %s", ranged)
+	}
+	if w := getJSON(t, app, "/export?token=app2", nil); w.Code != 404 {
+		t.Errorf("expected unknown tokens to answer 404, got %d", w.Code)
+	}
+
+	// Importing twice keeps the IDs, so nothing is duplicated.
+	other := newTestApp(t, "")
+	other.AdminToken = "secret"
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/import?token=app1", bytes.NewReader(w.Body.Bytes()))
+		r.SetBasicAuth("user", "pass")
+		r.Header.Set("X-Admin-Token", "secret")
+		response := httptest.NewRecorder()
+		other.handler("user", "pass").ServeHTTP(response, r)
+		if response.Code != 200 || strings.TrimSpace(response.Body.String()) != `{"imported":3}` {
+			t.Fatalf("expected 3 logs to be imported, got %d: %s", response.Code, response.Body)
+		}
+	}
+	imported := other.engineForToken("app1")
+	if count := docCount(t, imported); count != 3 {
+		t.Errorf("expected 3 docs, got %d", count)
+	}
+	for _, log := range logs {
+		if found, err := imported.Get(log.Id); err != nil || found == nil || found.Data["msg"] != log.Data["msg"] {
+			t.Errorf("expected %s to keep its ID, got %v", log.Data["msg"], err)
+		}
+	}
+}
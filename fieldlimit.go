@@ -0,0 +1,194 @@
+package firlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// FieldOverflowCollapse moves the fields of logs past their token's
+	// MaxFields into their `overflow` field and FieldOverflowDrop drops them.
+	FieldOverflowCollapse = "collapse"
+	FieldOverflowDrop     = "drop"
+)
+
+// overflowField holds the fields collapsed by FieldOverflowCollapse, as
+// `key=value` strings, value being JSON, so they stay searchable without
+// adding fields.
+const overflowField = "overflow"
+
+// fieldLimiter keeps track of the distinct fields of a token's indexes, those
+// of the indexes opened first and those indexed since.
+type fieldLimiter struct {
+	mu     sync.Mutex
+	fields map[string]bool
+	// overflowed counts the logs that had fields collapsed or dropped.
+	overflowed int64
+}
+
+// limitFields keeps the fields of logs within the token's MaxFields, fields
+// adding new ones past it being collapsed or dropped as FieldOverflow says,
+// so a client sending a new field name with every log can't bloat the
+// mapping of its indexes. The fields of nested objects count as theirs in
+// bleve, `user.name` for one, and collapse or are dropped with their top
+// level field. Ids, times, messages, levels and raw lines are always kept.
+func (e *Engine) limitFields(logs []*Log) {
+	config := e.config()
+	if config.MaxFields <= 0 {
+		return
+	}
+
+	e.fieldLimiter.mu.Lock()
+	defer e.fieldLimiter.mu.Unlock()
+
+	if e.fieldLimiter.fields == nil {
+		e.fieldLimiter.fields = e.indexedFields()
+	}
+	protected := config.protectedFields()
+	overflowed := 0
+	for _, log := range logs {
+		limited := false
+		extra := []string{}
+		for _, key := range sortedDataKeys(log.Data) {
+			paths := fieldPaths(key, log.Data[key], nil)
+			if protected[key] || e.fieldLimiter.fits(paths, config.MaxFields) {
+				for _, path := range paths {
+					e.fieldLimiter.fields[path] = true
+				}
+				continue
+			}
+			if config.FieldOverflow != FieldOverflowDrop {
+				value, err := json.Marshal(log.Data[key])
+				if err != nil {
+					value = []byte(fmt.Sprint(log.Data[key]))
+				}
+				extra = append(extra, key+"="+string(value))
+			}
+			delete(log.Data, key)
+			limited = true
+		}
+		if len(extra) > 0 {
+			// A log's own `overflow` field is collapsed along with the others.
+			if own, ok := log.Data[overflowField]; ok {
+				value, _ := json.Marshal(own)
+				extra = append([]string{overflowField + "=" + string(value)}, extra...)
+			}
+			log.Data[overflowField] = extra
+			e.fieldLimiter.fields[overflowField] = true
+		}
+		if limited {
+			overflowed++
+		}
+	}
+	if overflowed > 0 {
+		e.fieldLimiter.overflowed += int64(overflowed)
+		logger.Printf("warning: %d logs of %s have fields past its %d fields limit, they were %s\n", overflowed, e.token(), config.MaxFields, config.fieldOverflowVerb())
+	}
+}
+
+// fits tells if paths can be indexed without going past max fields.
+func (l *fieldLimiter) fits(paths []string, max int) bool {
+	added := 0
+	for _, path := range paths {
+		if !l.fields[path] {
+			added++
+		}
+	}
+	return added == 0 || len(l.fields)+added <= max
+}
+
+// indexedFields are the fields of the engine's opened indexes.
+func (e *Engine) indexedFields() map[string]bool {
+	fields := map[string]bool{}
+	for _, index := range e.snapshotIndexes() {
+		indexFields, err := index.Fields()
+		if err != nil {
+			continue
+		}
+		for _, field := range indexFields {
+			if field != "_all" {
+				fields[field] = true
+			}
+		}
+	}
+	return fields
+}
+
+// FieldCount returns how many distinct fields the engine's logs have, as
+// counted by MaxFields.
+func (e *Engine) FieldCount() int {
+	e.fieldLimiter.mu.Lock()
+	defer e.fieldLimiter.mu.Unlock()
+	if e.fieldLimiter.fields == nil {
+		return len(e.indexedFields())
+	}
+	return len(e.fieldLimiter.fields)
+}
+
+// FieldsOverflowed returns how many logs had fields collapsed or dropped by
+// MaxFields since startup.
+func (e *Engine) FieldsOverflowed() int64 {
+	e.fieldLimiter.mu.Lock()
+	defer e.fieldLimiter.mu.Unlock()
+	return e.fieldLimiter.overflowed
+}
+
+// fieldPaths appends the fields bleve indexes value of field under to paths,
+// nested objects' fields being joined with dots, arrays' elements indexed
+// under the array's field.
+func fieldPaths(field string, value interface{}, paths []string) []string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range value {
+			paths = fieldPaths(field+"."+key, nested, paths)
+		}
+		return paths
+	case []interface{}:
+		scalars := len(value) == 0
+		for _, element := range value {
+			if nested, ok := element.(map[string]interface{}); ok {
+				paths = fieldPaths(field, nested, paths)
+			} else {
+				scalars = true
+			}
+		}
+		if scalars {
+			paths = append(paths, field)
+		}
+		return paths
+	}
+	return append(paths, field)
+}
+
+func sortedDataKeys(data map[string]interface{}) []string {
+	keys := []string{}
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// protectedFields are the fields MaxFields never collapses or drops.
+func (c *TokenConfig) protectedFields() map[string]bool {
+	protected := map[string]bool{"id": true, "time": true, rawField: true, DefaultLevelField: true}
+	for _, field := range DefaultMessageFields {
+		protected[field] = true
+	}
+	if c.MessageField != "" {
+		protected[c.MessageField] = true
+	}
+	if c.LevelField != "" {
+		protected[c.LevelField] = true
+	}
+	return protected
+}
+
+func (c *TokenConfig) fieldOverflowVerb() string {
+	if c.FieldOverflow == FieldOverflowDrop {
+		return "dropped"
+	}
+	return "collapsed into `" + overflowField + "`"
+}
@@ -0,0 +1,98 @@
+package firlog
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLimitFields(t *testing.T) {
+	tests := []struct {
+		overflow string
+		data     map[string]interface{}
+	}{
+		{"", map[string]interface{}{"msg": "second", "a": 2.0, overflowField: []string{`c={"d":1}`, `e="x"`}}},
+		{FieldOverflowDrop, map[string]interface{}{"msg": "second", "a": 2.0}},
+	}
+	for _, test := range tests {
+		e := newTestEngine(t)
+		e.Config.MaxFields = 3
+		e.Config.FieldOverflow = test.overflow
+		first := &Log{Data: map[string]interface{}{"msg": "first", "a": 1.0, "b": 1.0}}
+		second := &Log{Data: map[string]interface{}{"msg": "second", "a": 2.0, "c": map[string]interface{}{"d": 1.0}, "e": "x"}}
+		e.limitFields([]*Log{first, second})
+
+		if len(first.Data) != 3 {
+			t.Errorf("%q: expected the first fields to be kept, got %v", test.overflow, first.Data)
+		}
+		if !reflect.DeepEqual(second.Data, test.data) {
+			t.Errorf("%q: expected %v, got %v", test.overflow, test.data, second.Data)
+		}
+		if e.FieldsOverflowed() != 1 {
+			t.Errorf("%q: expected 1 log to be counted, got %d", test.overflow, e.FieldsOverflowed())
+		}
+	}
+}
+
+func TestMaxFields(t *testing.T) {
+	app := newTestApp(t, `{"maxFields": 5}`)
+	postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+		`{"time": "2026-10-14T12:00:00Z", "msg": "first", "host": "web-1"}`,
+		`{"time": "2026-10-14T12:00:01Z", "msg": "second", "host": "web-2", "session_8f2": 1, "level": "error"}`,
+	}, "\n"))
+	engine := app.engineForToken("app1")
+	for q, want := range map[string]uint64{"host:web": 2, `overflow:"session_8f2=1"`: 1, "session_8f2:1": 0, "level:error": 1} {
+		if total := searchTotal(t, engine, q); total != want {
+			t.Errorf("expected %s to match %d logs, got %d", q, want, total)
+		}
+	}
+
+	// Fields of existing indexes count on startup.
+	for _, index := range engine.snapshotIndexes() {
+		index.Close()
+	}
+	reopened := NewEngine(engine.dataDir)
+	reopened.Config.MaxFields = 5
+	if count := reopened.FieldCount(); count != engine.FieldCount() {
+		t.Errorf("expected %d fields once reopened, got %d", engine.FieldCount(), count)
+	}
+
+	var stats map[string]struct {
+		FieldCount int `json:"fieldCount"`
+	}
+	getJSON(t, app, "/stats?token=app1", &stats)
+	if stats["app1"].FieldCount != engine.FieldCount() {
+		t.Errorf("expected the field count in the stats, got %+v", stats)
+	}
+	if metrics := getJSON(t, app, "/metrics", nil).Body.String(); !strings.Contains(metrics, `firlog_fields_overflowed_total{token="app1"} 1`) {
+		t.Errorf("expected the overflowed log to be counted, got %s", metrics)
+	}
+}
+
+func TestFieldPaths(t *testing.T) {
+	value := map[string]interface{}{
+		"name": "bob",
+		"tags": []interface{}{"a", "b"},
+		"objs": []interface{}{map[string]interface{}{"k": "v"}},
+	}
+	paths := fieldPaths("user", value, nil)
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, []string{"user.name", "user.objs.k", "user.tags"}) {
+		t.Errorf("unexpected paths %v", paths)
+	}
+}
+
+func TestFieldLimitConfig(t *testing.T) {
+	for _, config := range []string{`{"maxFields": -1}`, `{"fieldOverflow": "truncate"}`} {
+		dir := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(dir, tokenConfigFileName), []byte(config), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadTokenConfig(dir); err == nil {
+			t.Errorf("expected %s to be rejected", config)
+		}
+	}
+}
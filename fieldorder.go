@@ -0,0 +1,60 @@
+package firlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// orderedData serializes the data of a log with the fields of order first,
+// in that order, then the others alphabetically like maps are.
+type orderedData struct {
+	data  map[string]interface{}
+	order []string
+}
+
+func (d orderedData) MarshalJSON() ([]byte, error) {
+	keys := []string{}
+	for _, key := range d.order {
+		if _, ok := d.data[key]; ok && !contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	rest := []string{}
+	for key := range d.data {
+		if !contains(keys, key) {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i, key := range append(keys, rest...) {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		serializedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(d.data[key])
+		if err != nil {
+			return nil, err
+		}
+		out.Write(serializedKey)
+		out.WriteByte(':')
+		out.Write(value)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// orderedFields returns data for serializing in JSON responses, with its
+// FieldOrder fields first.
+func (app *App) orderedFields(data map[string]interface{}) interface{} {
+	if len(app.FieldOrder) == 0 {
+		return data
+	}
+	return orderedData{data: data, order: app.FieldOrder}
+}
@@ -0,0 +1,46 @@
+package firlog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFieldOrder(t *testing.T) {
+	data := map[string]interface{}{
+		"time":  "2026-10-14T12:00:00Z",
+		"msg":   "started",
+		"level": "info",
+		"app":   "web",
+		"http":  map[string]interface{}{"status": 200, "method": "GET"},
+	}
+	tests := []struct {
+		order []string
+		json  string
+	}{
+		{nil, `{"app":"web","http":{"method":"GET","status":200},"level":"info","msg":"started","time":"2026-10-14T12:00:00Z"}`},
+		// Missing and repeated fields are skipped.
+		{[]string{"time", "level", "missing", "msg", "time"}, `{"time":"2026-10-14T12:00:00Z","level":"info","msg":"started","app":"web","http":{"method":"GET","status":200}}`},
+	}
+	for _, test := range tests {
+		app := &App{FieldOrder: test.order}
+		serialized, err := json.Marshal(app.orderedFields(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(serialized) != test.json {
+			t.Errorf("%v: expected %s, got %s", test.order, test.json, serialized)
+		}
+	}
+
+	app := newTestApp(t, "")
+	app.FieldOrder = []string{"msg", "id"}
+	postSeverities(t, app)
+	id := app.engineForToken("app1").Recent(1)[0].Id
+	if body := getJSON(t, app, "/search?token=app1&query=started"+dashboardRange, nil).Body.String(); !strings.Contains(body, `"logs":[{"msg":"started","id":"`+id+`","level":"6"`) {
+		t.Errorf("expected searched logs to start with msg and id, got %s", body)
+	}
+	if body := getJSON(t, app, "/log/app1/"+id, nil).Body.String(); !strings.HasPrefix(body, `{"msg":"started","id":"`+id+`","level":"6"`) {
+		t.Errorf("expected the log to start with msg and id, got %s", body)
+	}
+}
@@ -0,0 +1,101 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// queueFlush is a barrier sent to every worker of a queue: each one that
+// reaches it is done with the batches enqueued before it and waits for
+// release, so it can't take the barrier of another worker.
+type queueFlush struct {
+	reached sync.WaitGroup
+	release chan struct{}
+}
+
+func (f *queueFlush) wait() {
+	f.reached.Done()
+	<-f.release
+}
+
+// drain returns once the logs enqueued before it was called are indexed.
+// Enqueue keeps accepting logs meanwhile.
+func (q *indexQueue) drain() {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	flush := &queueFlush{release: make(chan struct{})}
+	flush.reached.Add(q.workers)
+	for i := 0; i < q.workers; i++ {
+		q.batches <- queuedLogs{flush: flush}
+	}
+	flush.reached.Wait()
+	close(flush.release)
+}
+
+// Flush waits for the logs enqueued so far to be indexed and syncs every open
+// index of the engine to disk, returning how many were. Batches are committed
+// when applied, so this mostly guarantees nothing is left in the queue, like
+// before taking a snapshot of the data directory by other means.
+func (e *Engine) Flush() (int, error) {
+	if e.queue != nil {
+		e.queue.drain()
+	}
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	flushed := 0
+	for _, key := range e.sortedIndexNames() {
+		if err := syncFile(filepath.Join(e.indexPath(key), "store")); os.IsNotExist(err) {
+			// Deleted since, by retention for one.
+			continue
+		} else if err != nil {
+			return flushed, err
+		}
+		flushed++
+	}
+	return flushed, nil
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// FlushAll flushes the engines of every token, see Engine.Flush, returning
+// how many indexes were flushed by token. Tokens failing to flush are logged
+// and left out.
+func (app *App) FlushAll() map[string]int {
+	engines := app.engines()
+	flushed := map[string]int{}
+	total := 0
+	for _, token := range sortedTokens(engines) {
+		count, err := engines[token].Flush()
+		if err != nil {
+			logger.Printf("error flushing %s: %v\n", token, err)
+			continue
+		}
+		flushed[token] = count
+		total += count
+	}
+	logger.Printf("flushed %d indexes of %d tokens\n", total, len(flushed))
+	return flushed
+}
+
+// handleFlush flushes the indexes of every token, answering how many were
+// flushed by token.
+func (app *App) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, 405, errorCodeMethodNotAllowed, "only POST supported")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tokens": app.FlushAll()})
+}
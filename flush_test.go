@@ -0,0 +1,67 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFlush(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		e := newTestEngine(t)
+		// Without the flush, the batches would wait for the interval.
+		e.StartQueue(100, workers, 1000, time.Minute)
+		for i := 0; i < 10; i++ {
+			logs := testLogs(e, "queued", 5)
+			if i%2 == 0 {
+				for _, log := range logs {
+					log.Time = testTime.Add(-24 * time.Hour)
+				}
+			}
+			if err := e.Enqueue(logs); err != nil {
+				t.Fatal(err)
+			}
+		}
+		flushed, err := e.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flushed != 2 {
+			t.Errorf("%d workers: expected both indexes to be flushed, got %d", workers, flushed)
+		}
+		if count := docCount(t, e); count != 50 {
+			t.Errorf("%d workers: expected every enqueued log to be indexed, got %d", workers, count)
+		}
+		// The queue keeps working afterwards.
+		if err := e.Enqueue(testLogs(e, "after", 1)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Flush(); err != nil || docCount(t, e) != 51 {
+			t.Errorf("%d workers: expected the queue to keep indexing, got %v", workers, err)
+		}
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	app := newTestApp(t, "")
+	app.Tokens = append(app.Tokens, "app2")
+	app.AdminToken = "admin-secret"
+	postSeverities(t, app)
+	app.engineForToken("app2")
+
+	r := httptest.NewRequest("POST", "/flush", nil)
+	r.SetBasicAuth("user", "pass")
+	r.Header.Set(adminTokenHeader, app.AdminToken)
+	w := httptest.NewRecorder()
+	app.handler("user", "pass").ServeHTTP(w, r)
+	var response struct {
+		Tokens map[string]int `json:"tokens"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if len(response.Tokens) != 2 || response.Tokens["app1"] != 1 || response.Tokens["app2"] != 0 {
+		t.Errorf("expected the indexes flushed by token, got %v", response.Tokens)
+	}
+}
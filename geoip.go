@@ -0,0 +1,383 @@
+package firlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultGeoIPField         = "client_ip"
+	DefaultGeoIPCheckInterval = time.Minute
+
+	// maxGeoIPDepth is how deep values can be nested, by pointers included,
+	// so pointers looping back don't recurse forever.
+	maxGeoIPDepth = 64
+)
+
+var (
+	errGeoIPNotFound = errors.New("geoip: address not found")
+	errGeoIPCorrupt  = errors.New("geoip: corrupt database")
+
+	geoIPMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+)
+
+// GeoIP enriches logs with the country and city of an IP address field using
+// a MaxMind (GeoLite2/GeoIP2 City or Country) database. The database file is
+// re-read whenever it changes on disk so it can be updated in place.
+type GeoIP struct {
+	Path  string
+	Field string
+
+	mu      sync.RWMutex
+	db      *geoIPDB
+	modTime time.Time
+}
+
+func NewGeoIP(path, field string) *GeoIP {
+	g := &GeoIP{Path: path, Field: field}
+	if err := g.Reload(); err != nil {
+		logger.Printf("geoip: %v\n", err)
+	}
+	return g
+}
+
+// Reload re-reads the database if it changed since it was last loaded.
+func (g *GeoIP) Reload() error {
+	fi, err := os.Stat(g.Path)
+	if err != nil {
+		return err
+	}
+
+	g.mu.RLock()
+	unchanged := g.db != nil && fi.ModTime().Equal(g.modTime)
+	g.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(g.Path)
+	if err != nil {
+		return err
+	}
+	db, err := openGeoIPDB(contents)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.db = db
+	g.modTime = fi.ModTime()
+	g.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the database every interval, forever.
+func (g *GeoIP) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := g.Reload(); err != nil {
+			logger.Printf("geoip: %v\n", err)
+		}
+	}
+}
+
+// enrich adds `geo_country` and `geo_city` to data when its IP field can be
+// located. Missing databases, private addresses and failed lookups leave the
+// log untouched.
+func (g *GeoIP) enrich(data map[string]interface{}) {
+	value, ok := data[g.Field].(string)
+	if !ok {
+		return
+	}
+	ip := net.ParseIP(value)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return
+	}
+
+	g.mu.RLock()
+	db := g.db
+	g.mu.RUnlock()
+	if db == nil {
+		return
+	}
+
+	record, err := db.lookup(ip)
+	if err != nil {
+		if err != errGeoIPNotFound {
+			logger.Printf("geoip: looking up '%s': %v\n", value, err)
+		}
+		return
+	}
+	if country, ok := lookupPath(record, "country", "iso_code").(string); ok {
+		data["geo_country"] = country
+	}
+	if city, ok := lookupPath(record, "city", "names", "en").(string); ok {
+		data["geo_city"] = city
+	}
+}
+
+func lookupPath(value interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// geoIPDB is a minimal reader for the MaxMind DB format, see
+// https://maxmind.github.io/MaxMind-DB/
+type geoIPDB struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+func openGeoIPDB(buf []byte) (*geoIPDB, error) {
+	markerAt := bytes.LastIndex(buf, geoIPMetadataMarker)
+	if markerAt == -1 {
+		return nil, fmt.Errorf("geoip: missing metadata")
+	}
+	metadataStart := markerAt + len(geoIPMetadataMarker)
+	meta, _, err := decodeGeoIPValue(buf[metadataStart:], 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errGeoIPCorrupt
+	}
+
+	db := &geoIPDB{buf: buf}
+	db.nodeCount = metadataUint(metadata, "node_count")
+	db.recordSize = metadataUint(metadata, "record_size")
+	db.ipVersion = metadataUint(metadata, "ip_version")
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("geoip: unsupported record size %d", db.recordSize)
+	}
+
+	treeSize := int(db.nodeCount * db.recordSize / 4)
+	if treeSize+16 > markerAt {
+		return nil, errGeoIPCorrupt
+	}
+	db.data = buf[treeSize+16 : markerAt]
+
+	// IPv4 addresses live under ::/96 in IPv6 databases.
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node, _ = db.readNode(node)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+func metadataUint(metadata map[string]interface{}, key string) uint {
+	switch v := metadata[key].(type) {
+	case uint64:
+		return uint(v)
+	}
+	return 0
+}
+
+func (db *geoIPDB) readNode(node uint) (uint, uint) {
+	switch db.recordSize {
+	case 24:
+		b := db.buf[node*6:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]),
+			uint(b[3])<<16 | uint(b[4])<<8 | uint(b[5])
+	case 28:
+		b := db.buf[node*7:]
+		return (uint(b[3])&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]),
+			(uint(b[3])&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		b := db.buf[node*8:]
+		return uint(binary.BigEndian.Uint32(b)), uint(binary.BigEndian.Uint32(b[4:]))
+	}
+}
+
+func (db *geoIPDB) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bits := net.IP(ip.To16())
+	bitCount := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		bitCount = 32
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, errGeoIPNotFound
+	}
+
+	for i := 0; i < bitCount && node < db.nodeCount; i++ {
+		left, right := db.readNode(node)
+		if bits[i>>3]&(0x80>>uint(i&7)) == 0 {
+			node = left
+		} else {
+			node = right
+		}
+	}
+	if node <= db.nodeCount {
+		return nil, errGeoIPNotFound
+	}
+	// Records past the node count point 16 bytes of separator further into
+	// the data section.
+	if node < db.nodeCount+16 {
+		return nil, errGeoIPCorrupt
+	}
+
+	offset := int(node - db.nodeCount - 16)
+	if offset >= len(db.data) {
+		return nil, errGeoIPCorrupt
+	}
+	value, _, err := decodeGeoIPValue(db.data, offset, 0)
+	return value, err
+}
+
+// decodeGeoIPValue decodes the value at offset in a data section, depth
+// values deep, returning it along with the offset of the following value.
+func decodeGeoIPValue(data []byte, offset, depth int) (interface{}, int, error) {
+	if offset >= len(data) || depth > maxGeoIPDepth {
+		return nil, 0, errGeoIPCorrupt
+	}
+	ctrl := data[offset]
+	offset++
+	kind := int(ctrl >> 5)
+
+	if kind == 1 {
+		pointer, next, err := decodeGeoIPPointer(data, ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := decodeGeoIPValue(data, pointer, depth+1)
+		return value, next, err
+	}
+
+	if kind == 0 {
+		if offset >= len(data) {
+			return nil, 0, errGeoIPCorrupt
+		}
+		kind = 7 + int(data[offset])
+		offset++
+	}
+
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > len(data) {
+			return nil, 0, errGeoIPCorrupt
+		}
+		n := 0
+		for _, b := range data[offset : offset+extra] {
+			n = n<<8 | int(b)
+		}
+		offset += extra
+		size = []int{29, 285, 65821}[extra-1] + n
+	}
+
+	switch kind {
+	case 7: // map
+		m := map[string]interface{}{}
+		for i := 0; i < size; i++ {
+			key, next, err := decodeGeoIPValue(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, errGeoIPCorrupt
+			}
+			value, next, err := decodeGeoIPValue(data, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[keyString] = value
+			offset = next
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			value, next, err := decodeGeoIPValue(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case 14: // boolean
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(data) {
+		return nil, 0, errGeoIPCorrupt
+	}
+	payload := data[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case 2: // string
+		return string(payload), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errGeoIPCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+	case 4: // bytes
+		return payload, offset, nil
+	case 5, 6, 9: // uint16, uint32, uint64
+		n := uint64(0)
+		for _, b := range payload {
+			n = n<<8 | uint64(b)
+		}
+		return n, offset, nil
+	case 8: // int32
+		n := uint32(0)
+		for _, b := range payload {
+			n = n<<8 | uint32(b)
+		}
+		return int64(int32(n)), offset, nil
+	case 10: // uint128, not needed for enrichment
+		return payload, offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errGeoIPCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), offset, nil
+	}
+	return nil, 0, fmt.Errorf("geoip: unknown data type %d", kind)
+}
+
+func decodeGeoIPPointer(data []byte, ctrl byte, offset int) (int, int, error) {
+	size := int((ctrl >> 3) & 0x3)
+	if offset+size+1 > len(data) {
+		return 0, 0, errGeoIPCorrupt
+	}
+	b := data[offset : offset+size+1]
+	vvv := int(ctrl & 0x7)
+	switch size {
+	case 0:
+		return vvv<<8 | int(b[0]), offset + 1, nil
+	case 1:
+		return 2048 + (vvv<<16 | int(b[0])<<8 | int(b[1])), offset + 2, nil
+	case 2:
+		return 526336 + (vvv<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])), offset + 3, nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), offset + 4, nil
+	}
+}
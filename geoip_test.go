@@ -0,0 +1,106 @@
+package firlog
+
+import (
+	"net"
+	"testing"
+)
+
+// geoIPString, geoIPUint32 and geoIPMap encode MaxMind DB values, keeping to
+// sizes under 29.
+func geoIPString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func geoIPUint32(n uint32) []byte {
+	return []byte{6<<5 | 4, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+}
+
+func geoIPMap(pairs ...[]byte) []byte {
+	m := []byte{7<<5 | byte(len(pairs)/2)}
+	for _, pair := range pairs {
+		m = append(m, pair...)
+	}
+	return m
+}
+
+// testGeoIPDB returns an IPv4 database of a single node with 24 bit records,
+// addresses starting with a 0 bit going to left and the others to right,
+// followed by data.
+func testGeoIPDB(left, right uint32, data []byte) []byte {
+	buf := []byte{
+		byte(left >> 16), byte(left >> 8), byte(left),
+		byte(right >> 16), byte(right >> 8), byte(right),
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, geoIPMetadataMarker...)
+	return append(buf, geoIPMap(
+		geoIPString("node_count"), geoIPUint32(1),
+		geoIPString("record_size"), geoIPUint32(24),
+		geoIPString("ip_version"), geoIPUint32(4),
+	)...)
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	paris := geoIPMap(
+		geoIPString("country"), geoIPMap(geoIPString("iso_code"), geoIPString("FR")),
+		geoIPString("city"), geoIPMap(geoIPString("names"), geoIPMap(geoIPString("en"), geoIPString("Paris"))),
+	)
+	tests := []struct {
+		name    string
+		right   uint32
+		data    []byte
+		err     error
+		country string
+	}{
+		{"found", 1 + 16, paris, nil, "FR"},
+		{"no data", 1, paris, errGeoIPNotFound, ""},
+		{"record in the separator", 1 + 5, paris, errGeoIPCorrupt, ""},
+		{"record past the data", 1 + 16 + 1000, paris, errGeoIPCorrupt, ""},
+		{"pointer to itself", 1 + 16, []byte{1 << 5, 0}, errGeoIPCorrupt, ""},
+		{"map holding itself", 1 + 16, geoIPMap(geoIPString("a"), []byte{1 << 5, 0}), errGeoIPCorrupt, ""},
+		{"truncated map", 1 + 16, paris[:10], errGeoIPCorrupt, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := openGeoIPDB(testGeoIPDB(1, test.right, test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.lookup(net.ParseIP("1.2.3.4")); err != errGeoIPNotFound {
+				t.Errorf("expected left addresses not to be found, got %v", err)
+			}
+			record, err := db.lookup(net.ParseIP("200.1.2.3"))
+			if err != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if country := lookupPath(record, "country", "iso_code"); test.err == nil && country != test.country {
+				t.Errorf("expected country %s, got %v", test.country, country)
+			}
+		})
+	}
+}
+
+func TestGeoIPEnrich(t *testing.T) {
+	db, err := openGeoIPDB(testGeoIPDB(1, 1+16, geoIPMap(
+		geoIPString("country"), geoIPMap(geoIPString("iso_code"), geoIPString("FR")),
+		geoIPString("city"), geoIPMap(geoIPString("names"), geoIPMap(geoIPString("en"), geoIPString("Paris"))),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &GeoIP{Field: DefaultGeoIPField, db: db}
+
+	data := map[string]interface{}{"client_ip": "200.1.2.3"}
+	g.enrich(data)
+	if data["geo_country"] != "FR" || data["geo_city"] != "Paris" {
+		t.Errorf("expected the log to be located in Paris, FR, got %v", data)
+	}
+	for _, ip := range []string{"1.2.3.4", "10.0.0.1", "127.0.0.1", "not an ip"} {
+		data := map[string]interface{}{"client_ip": ip}
+		g.enrich(data)
+		if len(data) != 1 {
+			t.Errorf("expected %s to be left alone, got %v", ip, data)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package firlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var searchDurations = regexp.MustCompile(`"searchDuration":[0-9.e-]+`)
+
+func TestGzipResponses(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+	id := app.engineForToken("app1").Recent(1)[0].Id
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth("user", "pass")
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		app.handler("user", "pass").ServeHTTP(w, r)
+		return w
+	}
+	for _, path := range []string{"/log/app1/" + id, "/indexes/app1", "/tokens", "/export?token=app1&gzip=0", "/search?token=app1&query=nope" + dashboardRange} {
+		plain := get(path, "")
+		if plain.Code != 200 || plain.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s: expected a plain 200, got %d %q", path, plain.Code, plain.Header().Get("Content-Encoding"))
+		}
+		compressed := get(path, "deflate, gzip;q=0.5")
+		if compressed.Header().Get("Content-Encoding") != "gzip" || compressed.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("%s: expected a gzipped response, got %v", path, compressed.Header())
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(compressed.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		body, err := ioutil.ReadAll(gz)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		// Searches report how long they took, the rest must be the same.
+		if strings.HasPrefix(path, "/search") {
+			body = searchDurations.ReplaceAll(body, nil)
+			plain.Body = bytes.NewBuffer(searchDurations.ReplaceAll(plain.Body.Bytes(), nil))
+		}
+		if !bytes.Equal(body, plain.Body.Bytes()) {
+			t.Errorf("%s: expected the same body compressed, got %s and %s", path, body, plain.Body)
+		}
+		if compressed.Header().Get("Content-Type") != plain.Header().Get("Content-Type") {
+			t.Errorf("%s: expected the same content type, got %q and %q", path, compressed.Header().Get("Content-Type"), plain.Header().Get("Content-Type"))
+		}
+	}
+
+	if w := get("/search?token=app1"+dashboardRange, "gzip;q=0"); w.Header().Get("Content-Encoding") != "" {
+		t.Error("expected gzip;q=0 to refuse compression")
+	}
+	// Gzipped exports are already compressed.
+	if w := get("/export?token=app1", "gzip"); w.Header().Get("Content-Encoding") != "" || w.Header().Get("Content-Type") != "application/gzip" {
+		t.Errorf("expected exports not to be compressed twice, got %v", w.Header())
+	}
+	if w := get("/?token=app1"+dashboardRange, "gzip"); w.Header().Get("Content-Encoding") != "" {
+		t.Error("expected the dashboard not to be compressed")
+	}
+}
@@ -0,0 +1,116 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultHistorySize is how many recent queries are kept per user.
+const DefaultHistorySize = 20
+
+// HistoryEntry is a query recently searched.
+type HistoryEntry struct {
+	Query string    `json:"query"`
+	Token string    `json:"token"`
+	Time  time.Time `json:"time"`
+}
+
+// queryHistory keeps the recent successful queries of each basic auth user
+// in memory, newest first.
+type queryHistory struct {
+	mu      sync.Mutex
+	entries map[string][]HistoryEntry
+}
+
+func newQueryHistory() *queryHistory {
+	return &queryHistory{entries: map[string][]HistoryEntry{}}
+}
+
+// record adds entry to the history of user, moving it first if it was there
+// already and evicting the oldest entries past size.
+func (h *queryHistory) record(user string, entry HistoryEntry, size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := []HistoryEntry{entry}
+	for _, existing := range h.entries[user] {
+		if len(entries) >= size {
+			break
+		}
+		if existing.Query != entry.Query || existing.Token != entry.Token {
+			entries = append(entries, existing)
+		}
+	}
+	h.entries[user] = entries
+}
+
+// list returns the history of user, newest first.
+func (h *queryHistory) list(user string) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]HistoryEntry{}, h.entries[user]...)
+}
+
+func (h *queryHistory) clear(user string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.entries, user)
+}
+
+// historyUser is who the history of r is kept for, its basic auth user.
+func historyUser(r *http.Request) string {
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+// recordQuery adds the query of a successful search to the history of the
+// user making it, unless it's empty or matches HistoryExclude.
+func (app *App) recordQuery(r *http.Request, params *searchParams) {
+	if app.HistorySize <= 0 || params.Query == "" || isSensitiveQuery(params.Query, app.HistoryExclude) {
+		return
+	}
+	app.history.record(historyUser(r), HistoryEntry{
+		Query: params.Query,
+		Token: params.Token,
+		Time:  time.Now().UTC(),
+	}, app.HistorySize)
+}
+
+func isSensitiveQuery(query string, exclude []*regexp.Regexp) bool {
+	for _, pattern := range exclude {
+		if pattern.MatchString(query) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleHistory lists the recent queries of the requesting user, or clears
+// them with DELETE.
+func (app *App) handleHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := historyUser(r)
+	switch r.Method {
+	case "GET":
+	case "DELETE":
+		app.history.clear(user)
+	default:
+		writeError(w, 405, errorCodeMethodNotAllowed, "only GET and DELETE supported")
+		return
+	}
+
+	responseJSON, err := json.Marshal(map[string]interface{}{
+		"history": app.history.list(user),
+	})
+	if err != nil {
+		writeError(w, 500, errorCodeInternal, "Error serializing response")
+		return
+	}
+	w.Write(responseJSON)
+}
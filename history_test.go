@@ -0,0 +1,66 @@
+package firlog
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestQueryHistory(t *testing.T) {
+	history := newQueryHistory()
+	for _, query := range []string{"a", "b", "c", "a", "d"} {
+		history.record("alice", HistoryEntry{Query: query, Token: "app1"}, 3)
+	}
+	history.record("bob", HistoryEntry{Query: "e", Token: "app1"}, 3)
+	queries := []string{}
+	for _, entry := range history.list("alice") {
+		queries = append(queries, entry.Query)
+	}
+	if !reflect.DeepEqual(queries, []string{"d", "a", "c"}) {
+		t.Errorf("expected the 3 most recent queries without duplicates, got %v", queries)
+	}
+	history.clear("alice")
+	if len(history.list("alice")) != 0 || len(history.list("bob")) != 1 {
+		t.Error("expected only the history of alice to be cleared")
+	}
+}
+
+func TestHistoryRecordsSearches(t *testing.T) {
+	app := newTestApp(t, "")
+	app.HistoryExclude = []*regexp.Regexp{regexp.MustCompile("password")}
+	postSeverities(t, app)
+	for _, query := range []string{"disk", "", `"disk full`, "password:hunter2", "started"} {
+		getJSON(t, app, "/search?token=app1&query="+url.QueryEscape(query)+dashboardRange, nil)
+	}
+	getJSON(t, app, "/?token=app1&query=full"+dashboardRange, nil)
+
+	var response struct {
+		History []HistoryEntry `json:"history"`
+	}
+	getJSON(t, app, "/history", &response)
+	queries := []string{}
+	for _, entry := range response.History {
+		if entry.Token != "app1" || entry.Time.IsZero() {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+		queries = append(queries, entry.Query)
+	}
+	// Empty, failed and excluded queries are left out.
+	if !reflect.DeepEqual(queries, []string{"full", "started", "disk"}) {
+		t.Errorf("expected the successful queries newest first, got %v", queries)
+	}
+	if body := getJSON(t, app, "/?token=app1"+dashboardRange, nil).Body.String(); !strings.Contains(body, `<option value="started" data-token="app1">started (app1)</option>`) {
+		t.Error("expected the dashboard to offer recent queries")
+	}
+
+	r := httptest.NewRequest("DELETE", "/history", nil)
+	r.SetBasicAuth("user", "pass")
+	w := httptest.NewRecorder()
+	app.handler("user", "pass").ServeHTTP(w, r)
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"history":[]}` {
+		t.Errorf("expected the history to be cleared, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,173 @@
+package firlog
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+const (
+	IDStrategyULID       = "ulid"
+	IDStrategySequential = "sequential"
+	IDStrategyHash       = "hash"
+)
+
+const (
+	// ULIDEntropyCrypto reads the random part of ULIDs from crypto/rand, the
+	// default.
+	ULIDEntropyCrypto = "crypto"
+	// ULIDEntropyMath reads it from a math/rand source seeded from
+	// crypto/rand once, which is cheaper but predictable.
+	ULIDEntropyMath = "math"
+)
+
+// ulids generates the ULIDs of logs, dead letter files and quarantined
+// indexes.
+var ulids = &ulidSource{entropy: cryptorand.Reader}
+
+// ulidSource generates monotonic ULIDs: ones of a same millisecond get the
+// random part of the previous one plus one rather than a new one, so they
+// sort in the order they were generated in, which searches rely on to break
+// ties between logs of a same time.
+type ulidSource struct {
+	mu      sync.Mutex
+	entropy io.Reader
+	last    ulid.ULID
+}
+
+// SetULIDEntropy sets where the random part of ULIDs is read from, one of
+// the ULIDEntropy* names.
+func SetULIDEntropy(name string) error {
+	var entropy io.Reader
+	switch name {
+	case ULIDEntropyCrypto:
+		entropy = cryptorand.Reader
+	case ULIDEntropyMath:
+		// Seeded from crypto/rand, sources created within the same
+		// nanosecond would otherwise produce the same ULIDs.
+		seed := time.Now().UnixNano()
+		var seedBytes [8]byte
+		if _, err := cryptorand.Read(seedBytes[:]); err == nil {
+			seed = int64(binary.BigEndian.Uint64(seedBytes[:]))
+		}
+		entropy = rand.New(rand.NewSource(seed))
+	default:
+		return fmt.Errorf("unknown ulid entropy '%s'", name)
+	}
+	ulids.mu.Lock()
+	defer ulids.mu.Unlock()
+	ulids.entropy = entropy
+	return nil
+}
+
+func (s *ulidSource) next(now time.Time) ulid.ULID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Also done when the clock went back, IDs staying ordered until it
+	// catches up.
+	if ulid.Timestamp(now) <= s.last.Time() {
+		if id, ok := incrementULID(s.last); ok {
+			s.last = id
+			return id
+		}
+		// Out of random values for the millisecond, the next one is used.
+		now = time.Unix(0, int64(s.last.Time()+1)*int64(time.Millisecond))
+	}
+	s.last = ulid.MustNew(ulid.Timestamp(now), s.entropy)
+	return s.last
+}
+
+// incrementULID adds one to the random part of id, false if it overflows.
+func incrementULID(id ulid.ULID) (ulid.ULID, bool) {
+	// The random part is the last 10 of the 16 bytes, big endian.
+	for i := len(id) - 1; i >= 6; i-- {
+		id[i]++
+		if id[i] != 0 {
+			return id, true
+		}
+	}
+	return id, false
+}
+
+func newUlid() string {
+	return ulids.next(time.Now().UTC()).String()
+}
+
+// IDGenerator generates the IDs logs are stored under. IDs are also the
+// secondary sort key of searches, so they should sort in the order logs were
+// received in to keep pages stable.
+type IDGenerator interface {
+	NewID(log *Log) string
+}
+
+// NewIDGenerator returns the generator for one of the IDStrategy* names.
+func NewIDGenerator(strategy string) (IDGenerator, error) {
+	switch strategy {
+	case IDStrategyULID:
+		return ULIDGenerator{}, nil
+	case IDStrategySequential:
+		return NewSequentialIDGenerator(uint64(time.Now().UnixNano())), nil
+	case IDStrategyHash:
+		return HashIDGenerator{}, nil
+	}
+	return nil, fmt.Errorf("unknown id strategy '%s'", strategy)
+}
+
+// ULIDGenerator generates ULIDs of the time logs are received at, the
+// default. Looking logs up and searching around them is faster with ULIDs as
+// the index they're in can be told from them.
+type ULIDGenerator struct{}
+
+func (ULIDGenerator) NewID(log *Log) string {
+	return newUlid()
+}
+
+// SequentialIDGenerator counts up from a starting value. Starting from the
+// current time in nanoseconds keeps IDs unique across restarts.
+type SequentialIDGenerator struct {
+	next uint64
+}
+
+func NewSequentialIDGenerator(start uint64) *SequentialIDGenerator {
+	return &SequentialIDGenerator{next: start}
+}
+
+func (g *SequentialIDGenerator) NewID(log *Log) string {
+	// Zero padded so IDs sort as strings like they do as numbers.
+	return fmt.Sprintf("%020d", atomic.AddUint64(&g.next, 1)-1)
+}
+
+// HashIDGenerator derives IDs from logs' content, time included, the same
+// log getting the same ID which makes importing it again a no-op. They don't
+// sort in the order logs were received in.
+type HashIDGenerator struct{}
+
+func (HashIDGenerator) NewID(log *Log) string {
+	serialized, _ := json.Marshal(log.Data)
+	sum := sha256.Sum256(serialized)
+	return hex.EncodeToString(sum[:16])
+}
+
+// existingIDs keeps the IDs logs already have, e.g. exported ones, falling
+// back to its generator for the others.
+type existingIDs struct {
+	IDGenerator
+}
+
+func (g existingIDs) NewID(log *Log) string {
+	if id, ok := log.Data["id"].(string); ok && id != "" {
+		return id
+	}
+	return g.IDGenerator.NewID(log)
+}
@@ -0,0 +1,137 @@
+package firlog
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+func TestULIDsUniqueAndIncreasing(t *testing.T) {
+	saved := ulids
+	defer func() { ulids = saved }()
+
+	for _, entropy := range []string{ULIDEntropyCrypto, ULIDEntropyMath} {
+		t.Run(entropy, func(t *testing.T) {
+			var mu sync.Mutex
+			seen := map[string]bool{}
+			// Every restart starts from a fresh source, like a new process.
+			for restart := 0; restart < 3; restart++ {
+				ulids = &ulidSource{}
+				if err := SetULIDEntropy(entropy); err != nil {
+					t.Fatal(err)
+				}
+				var wg sync.WaitGroup
+				for g := 0; g < 8; g++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						previous := ""
+						for i := 0; i < 1000; i++ {
+							id := newUlid()
+							if id <= previous {
+								t.Errorf("got %s after %s, want increasing IDs", id, previous)
+							}
+							previous = id
+							mu.Lock()
+							if seen[id] {
+								t.Errorf("got %s twice", id)
+							}
+							seen[id] = true
+							mu.Unlock()
+						}
+					}()
+				}
+				wg.Wait()
+			}
+		})
+	}
+}
+
+func TestULIDSourceMonotonic(t *testing.T) {
+	s := &ulidSource{entropy: ulids.entropy}
+	now := time.Now()
+	first := s.next(now)
+	sameMillisecond := s.next(now)
+	clockBack := s.next(now.Add(-time.Second))
+	for i, pair := range [][2]ulid.ULID{{first, sameMillisecond}, {sameMillisecond, clockBack}} {
+		if pair[1].Compare(pair[0]) <= 0 {
+			t.Errorf("%d: got %s after %s, want increasing IDs", i, pair[1], pair[0])
+		}
+		if pair[1].Time() != first.Time() {
+			t.Errorf("%d: got time %d, want that of the first ID %d", i, pair[1].Time(), first.Time())
+		}
+	}
+
+	// Out of random values, the next millisecond is used.
+	var last ulid.ULID
+	for i := 6; i < len(last); i++ {
+		last[i] = 0xff
+	}
+	last.SetTime(first.Time())
+	s.last = last
+	if next := s.next(now); next.Time() != first.Time()+1 {
+		t.Errorf("got time %d after overflowing, want %d", next.Time(), first.Time()+1)
+	}
+
+	if err := SetULIDEntropy("dice"); err == nil {
+		t.Error("got no error for an unknown entropy")
+	}
+}
+
+func TestIDStrategies(t *testing.T) {
+	sequential, err := NewIDGenerator(IDStrategySequential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := sequential.NewID(&Log{}), sequential.NewID(&Log{})
+	if len(first) != 20 || second <= first {
+		t.Errorf("expected zero padded increasing IDs, got %s then %s", first, second)
+	}
+	if ids := NewSequentialIDGenerator(9); ids.NewID(&Log{}) != "00000000000000000009" || ids.NewID(&Log{}) != "00000000000000000010" {
+		t.Error("expected IDs to count up from the start")
+	}
+	if _, err := NewIDGenerator("uuid"); err == nil {
+		t.Error("expected unknown strategies to be rejected")
+	}
+
+	// The same log gets the same hash, so importing it twice is a no-op.
+	app := newTestApp(t, "")
+	app.IDs, err = NewIDGenerator(IDStrategyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `{"time": "2026-10-14T12:00:00Z", "msg": "disk full"}`
+	postBulk(t, app, "application/x-ndjson", line+"\n"+line)
+	postBulk(t, app, "application/x-ndjson", line+"\n"+`{"time": "2026-10-14T12:00:00Z", "msg": "disk full again"}`)
+	engine := app.engineForToken("app1")
+	if count := docCount(t, engine); count != 2 {
+		t.Errorf("expected duplicates to share an ID, got %d docs", count)
+	}
+	for _, log := range engine.Recent(10) {
+		if len(log.Id) != 32 {
+			t.Errorf("expected a hash ID, got %s", log.Id)
+		}
+	}
+}
+
+func TestMathEntropySeededRandomly(t *testing.T) {
+	saved := ulids
+	defer func() { ulids = saved }()
+
+	// Sources created back to back, within the same nanosecond on coarse
+	// clocks, must still differ.
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		ulids = &ulidSource{}
+		if err := SetULIDEntropy(ULIDEntropyMath); err != nil {
+			t.Fatal(err)
+		}
+		random := ulids.next(testTime).Entropy()
+		if seen[string(random)] {
+			t.Fatalf("got the random part %x twice", random)
+		}
+		seen[string(random)] = true
+	}
+}
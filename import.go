@@ -0,0 +1,104 @@
+package firlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	importChunkSize     = 1000
+	importProgressEvery = 100000
+)
+
+// ImportFile indexes a file of syslog lines or NDJSON objects (optionally
+// gzipped) into token's engine, returning how many logs were imported.
+func (app *App) ImportFile(token, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return app.Import(token, f)
+}
+
+// Import streams logs from r line by line, so arbitrarily large inputs can be
+// imported, using the same parsing as bulk requests. Lines starting with `{`
+// are read as NDJSON.
+func (app *App) Import(token string, r io.Reader) (int, error) {
+	return app.importLogs(token, r, false)
+}
+
+// importLogs is Import, keeping the IDs NDJSON logs already have when keepIDs
+// is set so importing an export twice doesn't duplicate it.
+func (app *App) importLogs(token string, r io.Reader, keepIDs bool) (int, error) {
+	if !contains(app.Tokens, token) {
+		return 0, fmt.Errorf("invalid token")
+	}
+	engine := app.engineForToken(token)
+	var ids IDGenerator = engine.IDs
+	if keepIDs {
+		ids = existingIDs{engine.IDs}
+	}
+
+	r, err := maybeGunzip(r)
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	index := func(logs []*Log) error {
+		if len(logs) == 0 {
+			return nil
+		}
+		app.enrich(logs)
+		if err := engine.Index(logs); err != nil {
+			return err
+		}
+		if imported/importProgressEvery != (imported+len(logs))/importProgressEvery {
+			logger.Printf("imported %d logs\n", imported+len(logs))
+		}
+		imported += len(logs)
+		return nil
+	}
+
+	parser := newLogParser(engine.config(), ids)
+	parser.keepRaw = engine.StoreRaw
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lines := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "{") {
+			parser.add(line, parseJSONLine)
+		} else {
+			parser.add(line, parseLogLine)
+		}
+		lines++
+		if lines%importChunkSize == 0 {
+			if err := index(parser.take()); err != nil {
+				return imported, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, err
+	}
+	return imported, index(parser.finish())
+}
+
+// maybeGunzip transparently decompresses r if it starts with gzip's magic
+// bytes.
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
@@ -0,0 +1,44 @@
+package firlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportFile(t *testing.T) {
+	lines := []string{syslogLine("started"), syslogLine("started again"), "", `{"time": "2026-10-14T12:00:00Z", "msg": "disk full", "level": "error"}`, ""}
+	body := strings.Join(lines, "\n")
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(body))
+	gz.Close()
+
+	for name, contents := range map[string][]byte{"logs.txt": []byte(body), "logs.txt.gz": gzipped.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			app := newTestApp(t, "")
+			path := filepath.Join(t.TempDir(), name)
+			if err := ioutil.WriteFile(path, contents, 0640); err != nil {
+				t.Fatal(err)
+			}
+			imported, err := app.ImportFile("app1", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			engine := app.engineForToken("app1")
+			if imported != 3 || docCount(t, engine) != 3 {
+				t.Errorf("expected 3 logs to be imported, got %d", imported)
+			}
+			if total := searchTotal(t, engine, "level:error"); total != 1 {
+				t.Errorf("expected NDJSON fields to be indexed, got %d logs", total)
+			}
+		})
+	}
+
+	if _, err := newTestApp(t, "").Import("app2", strings.NewReader(body)); err == nil {
+		t.Error("expected unknown tokens to be rejected")
+	}
+}
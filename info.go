@@ -0,0 +1,49 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// Version is the firlog release being run, set at build time with
+// `-ldflags "-X github.com/kiasaki/firlog.Version=v1.2.3"`.
+var Version = "dev"
+
+// handleInfo describes the running server. It's served without
+// authentication so it must never include tokens or credentials.
+func (app *App) handleInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	response := map[string]interface{}{
+		"version":       Version,
+		"goVersion":     runtime.Version(),
+		"tokensCount":   len(app.Tokens),
+		"uptimeSeconds": int64(time.Since(app.startedAt).Seconds()),
+		"indexing": map[string]interface{}{
+			"queueSize":     app.QueueSize,
+			"queueWorkers":  app.QueueWorkers,
+			"maxBatchSize":  app.MaxBatchSize,
+			"flushInterval": app.FlushInterval.String(),
+		},
+		// Logs go to daily indexes, merged into monthly ones once compacted.
+		"granularity": map[string]interface{}{
+			"index":        "daily",
+			"shards":       app.Shards,
+			"compactAfter": app.CompactAfter.String(),
+		},
+		"retention": map[string]interface{}{
+			"maxAge":       app.Retention.String(),
+			"maxSize":      app.RetentionMaxSize,
+			"lowWatermark": app.RetentionLowWatermark,
+			"scope":        app.RetentionScope,
+		},
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		writeError(w, 500, errorCodeInternal, "Error serializing response")
+		return
+	}
+	w.Write(responseJSON)
+}
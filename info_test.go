@@ -0,0 +1,75 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfo(t *testing.T) {
+	app := NewApp(t.TempDir(), []string{"token-one", "token-two"})
+	app.AdminToken = "admin-secret"
+	app.Retention = 30 * 24 * time.Hour
+	app.RetentionMaxSize = 1 << 30
+	app.RetentionScope = RetentionScopeToken
+	app.CompactAfter = 720 * time.Hour
+	app.Shards = 2
+	app.FlushInterval = 2 * time.Second
+	handler := app.handler("dashboard-user", "dashboard-pass")
+
+	// No credentials needed.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/info", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	for _, secret := range []string{"token-one", "token-two", "admin-secret", "dashboard-user", "dashboard-pass"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("expected %s to be left out of /info, got %s", secret, body)
+		}
+	}
+
+	var info struct {
+		Version       string `json:"version"`
+		GoVersion     string `json:"goVersion"`
+		TokensCount   int    `json:"tokensCount"`
+		UptimeSeconds *int64 `json:"uptimeSeconds"`
+		Indexing      struct {
+			QueueSize     *int   `json:"queueSize"`
+			QueueWorkers  *int   `json:"queueWorkers"`
+			MaxBatchSize  *int   `json:"maxBatchSize"`
+			FlushInterval string `json:"flushInterval"`
+		} `json:"indexing"`
+		Granularity struct {
+			Index        string `json:"index"`
+			Shards       int    `json:"shards"`
+			CompactAfter string `json:"compactAfter"`
+		} `json:"granularity"`
+		Retention struct {
+			MaxAge       string  `json:"maxAge"`
+			MaxSize      int64   `json:"maxSize"`
+			LowWatermark float64 `json:"lowWatermark"`
+			Scope        string  `json:"scope"`
+		} `json:"retention"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&info); err != nil {
+		t.Fatalf("unexpected /info structure: %v: %s", err, body)
+	}
+	if info.Version != Version || info.GoVersion == "" || info.TokensCount != 2 || info.UptimeSeconds == nil {
+		t.Errorf("unexpected server info %+v", info)
+	}
+	if info.Indexing.QueueSize == nil || info.Indexing.QueueWorkers == nil || info.Indexing.MaxBatchSize == nil || info.Indexing.FlushInterval != "2s" {
+		t.Errorf("unexpected indexing info %+v", info.Indexing)
+	}
+	if info.Granularity.Index != "daily" || info.Granularity.Shards != 2 || info.Granularity.CompactAfter != "720h0m0s" {
+		t.Errorf("unexpected granularity %+v", info.Granularity)
+	}
+	if info.Retention.MaxAge != "720h0m0s" || info.Retention.MaxSize != 1<<30 || info.Retention.LowWatermark != DefaultRetentionLowWatermark || info.Retention.Scope != "token" {
+		t.Errorf("unexpected retention %+v", info.Retention)
+	}
+}
@@ -0,0 +1,57 @@
+package firlog
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/oklog/ulid"
+)
+
+// LastIngest is when logs were last indexed successfully, the zero time if
+// they never were.
+func (e *Engine) LastIngest() time.Time {
+	nanos := atomic.LoadInt64(&e.lastIngest)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
+
+func (e *Engine) touchLastIngest(at time.Time) {
+	nanos := at.UnixNano()
+	for {
+		last := atomic.LoadInt64(&e.lastIngest)
+		if last >= nanos || atomic.CompareAndSwapInt64(&e.lastIngest, last, nanos) {
+			return
+		}
+	}
+}
+
+// recomputeLastIngest recovers LastIngest after a restart from the newest
+// stored log, IDs being ULIDs of the time logs were received at.
+func (e *Engine) recomputeLastIngest() error {
+	search := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	search.SortBy([]string{"-_id"})
+	result, err := e.Search(search, 1)
+	if err != nil || len(result.Logs) == 0 {
+		return err
+	}
+	parsed, err := ulid.Parse(result.Logs[0].Id)
+	if err != nil {
+		return nil
+	}
+	e.touchLastIngest(time.Unix(0, int64(parsed.Time())*int64(time.Millisecond)))
+	return nil
+}
+
+// ingestionStats is LastIngest for /stats, along with how long ago it was.
+func ingestionStats(lastIngest time.Time) map[string]interface{} {
+	if lastIngest.IsZero() {
+		return map[string]interface{}{"lastIngest": nil, "secondsSinceLastIngest": nil}
+	}
+	return map[string]interface{}{
+		"lastIngest":             lastIngest.Format(time.RFC3339Nano),
+		"secondsSinceLastIngest": int64(time.Since(lastIngest).Seconds()),
+	}
+}
@@ -0,0 +1,75 @@
+package firlog
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLastIngest(t *testing.T) {
+	app := newTestApp(t, "")
+	engine := app.engineForToken("app1")
+	if !engine.LastIngest().IsZero() {
+		t.Fatalf("expected no ingestion yet, got %s", engine.LastIngest())
+	}
+	var stats map[string]map[string]interface{}
+	getJSON(t, app, "/stats?token=app1", &stats)
+	if ingestion := stats["app1"]["ingestion"].(map[string]interface{}); ingestion["lastIngest"] != nil {
+		t.Errorf("expected a null lastIngest, got %v", ingestion)
+	}
+
+	before := time.Now()
+	postBulk(t, app, "text/plain", syslogLine("started"))
+	lastIngest := engine.LastIngest()
+	if lastIngest.Before(before) || lastIngest.After(time.Now()) {
+		t.Errorf("expected the time logs were indexed at, got %s", lastIngest)
+	}
+	getJSON(t, app, "/stats?token=app1", &stats)
+	if ingestion := stats["app1"]["ingestion"].(map[string]interface{}); ingestion["lastIngest"] != lastIngest.Format(time.RFC3339Nano) || ingestion["secondsSinceLastIngest"] != float64(0) {
+		t.Errorf("expected the last ingestion in the stats, got %v", ingestion)
+	}
+	metrics := getJSON(t, app, "/metrics", nil).Body.String()
+	if !strings.Contains(metrics, fmt.Sprintf(`firlog_last_ingest_timestamp_seconds{token="app1"} %d`, lastIngest.Unix())) {
+		t.Errorf("expected the last ingestion in the metrics, got %s", metrics)
+	}
+
+	// After a restart it comes from the ID of the newest log, generated when
+	// it was received.
+	for _, index := range engine.snapshotIndexes() {
+		index.Close()
+	}
+	restarted := NewEngine(engine.dataDir)
+	if recovered := restarted.LastIngest(); recovered.Before(before.Truncate(time.Millisecond)) || recovered.After(lastIngest) {
+		t.Errorf("expected the time the log was received at, got %s", recovered)
+	}
+}
+
+func TestDefaultToken(t *testing.T) {
+	tests := []struct {
+		defaultToken string
+		path         string
+		status       int
+	}{
+		{"", "/bulk", 401},
+		{"", "/bulk/", 401},
+		{"", "/bulk/app1", 200},
+		{"app1", "/bulk", 200},
+		{"app1", "/bulk/", 200},
+		{"app1", "/bulk/app2", 401},
+	}
+	for _, test := range tests {
+		app := newTestApp(t, "")
+		app.DefaultToken = test.defaultToken
+		r := httptest.NewRequest("POST", test.path, strings.NewReader(syslogLine("started")))
+		w := httptest.NewRecorder()
+		app.handler("user", "pass").ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s with default token %q: expected %d, got %d: %s", test.path, test.defaultToken, test.status, w.Code, w.Body)
+		}
+		if test.status == 200 && docCount(t, app.engineForToken("app1")) != 1 {
+			t.Errorf("%s with default token %q: expected the log to be indexed for app1", test.path, test.defaultToken)
+		}
+	}
+}
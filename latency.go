@@ -0,0 +1,91 @@
+package firlog
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SearchLatencyWindow is how many of the latest searches of a token latency
+// percentiles are computed over, so they follow searches slowing down as data
+// grows rather than averaging them out since startup.
+const SearchLatencyWindow = 1000
+
+// SearchLatency summarizes how long the latest searches of a token took.
+type SearchLatency struct {
+	// Count and Sum are over every search since startup, the percentiles
+	// over the latest SearchLatencyWindow ones.
+	Count uint64        `json:"count"`
+	Sum   time.Duration `json:"sum"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+}
+
+// latencyWindow keeps the latest durations recorded, overwriting the oldest
+// ones once full.
+type latencyWindow struct {
+	mu        sync.Mutex
+	durations []time.Duration
+	next      int
+	count     uint64
+	sum       time.Duration
+}
+
+func (w *latencyWindow) record(duration time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.durations) < SearchLatencyWindow {
+		w.durations = append(w.durations, duration)
+	} else {
+		w.durations[w.next] = duration
+	}
+	w.next = (w.next + 1) % SearchLatencyWindow
+	w.count++
+	w.sum += duration
+}
+
+func (w *latencyWindow) latency() SearchLatency {
+	w.mu.Lock()
+	sorted := append([]time.Duration{}, w.durations...)
+	latency := SearchLatency{Count: w.count, Sum: w.sum}
+	w.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	latency.P50 = percentile(sorted, 0.50)
+	latency.P95 = percentile(sorted, 0.95)
+	latency.P99 = percentile(sorted, 0.99)
+	return latency
+}
+
+// percentile is the nearest-rank p percentile of sorted durations, 0 when
+// there are none.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// recordSearch records how long a search of the engine's logs took.
+func (e *Engine) recordSearch(duration time.Duration) {
+	e.searchLatency.record(duration)
+}
+
+// SearchLatency returns how long searches of the engine's logs took.
+func (e *Engine) SearchLatency() SearchLatency {
+	return e.searchLatency.latency()
+}
+
+// recordSearch records a search of tokens that took duration.
+func (app *App) recordSearch(tokens []string, duration time.Duration) {
+	for _, token := range tokens {
+		app.engineForToken(token).recordSearch(duration)
+	}
+}
@@ -0,0 +1,64 @@
+package firlog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyWindow(t *testing.T) {
+	var window latencyWindow
+	if latency := window.latency(); latency != (SearchLatency{}) {
+		t.Errorf("expected no latency before any search, got %+v", latency)
+	}
+	for i := 100; i > 0; i-- {
+		window.record(time.Duration(i) * time.Millisecond)
+	}
+	latency := window.latency()
+	if latency.Count != 100 || latency.Sum != 5050*time.Millisecond {
+		t.Errorf("unexpected count and sum %+v", latency)
+	}
+	if latency.P50 != 50*time.Millisecond || latency.P95 != 95*time.Millisecond || latency.P99 != 99*time.Millisecond {
+		t.Errorf("unexpected percentiles %+v", latency)
+	}
+
+	// Only the latest searches count towards percentiles.
+	for i := 0; i < SearchLatencyWindow; i++ {
+		window.record(time.Second)
+	}
+	latency = window.latency()
+	if latency.Count != 100+SearchLatencyWindow || latency.P50 != time.Second || latency.P99 != time.Second {
+		t.Errorf("expected the oldest searches to be dropped from percentiles, got %+v", latency)
+	}
+}
+
+func TestSearchLatencyStats(t *testing.T) {
+	app := newTestApp(t, "")
+	app.Tokens = []string{"app1", "app2"}
+	postSeverities(t, app)
+	getJSON(t, app, "/search?token=app1&query=disk"+dashboardRange, nil)
+	getJSON(t, app, "/search?token=*&query=disk"+dashboardRange, nil)
+
+	var stats map[string]struct {
+		SearchLatency SearchLatency `json:"searchLatency"`
+	}
+	getJSON(t, app, "/stats", &stats)
+	if latency := stats["app1"].SearchLatency; latency.Count != 2 || latency.Sum <= 0 || latency.P99 <= 0 {
+		t.Errorf("expected both searches to count for app1, got %+v", latency)
+	}
+	if latency := stats["app2"].SearchLatency; latency.Count != 1 {
+		t.Errorf("expected the search of both tokens to count for app2, got %+v", latency)
+	}
+
+	metrics := getJSON(t, app, "/metrics", nil).Body.String()
+	for _, line := range []string{
+		"# TYPE firlog_search_duration_seconds summary",
+		`firlog_search_duration_seconds{token="app1",quantile="0.99"} `,
+		`firlog_search_duration_seconds_count{token="app1"} 2`,
+		`firlog_search_duration_seconds_count{token="app2"} 1`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("expected %s in the metrics, got %s", line, metrics)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package firlog
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetLog(t *testing.T) {
+	app := newTestApp(t, "")
+	engine := app.engineForToken("app1")
+	logs := testLogs(engine, "started", 2)
+	// Backfilled, so it isn't in the index of the time its ID was made at.
+	logs[1].Time = testTime.Add(-48 * time.Hour)
+	if err := engine.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, log := range logs {
+		var response map[string]interface{}
+		if w := getJSON(t, app, "/log/app1/"+log.Id, &response); w.Code != 200 || response["msg"] != log.Data["msg"] {
+			t.Errorf("expected %s to be found, got %d: %s", log.Id, w.Code, w.Body)
+		}
+	}
+	for _, path := range []string{"/log/app1/01M4WMDX1Z597WXS64Q7PR6PCC", "/log/app2/" + logs[0].Id, "/log/app1"} {
+		if w := getJSON(t, app, path, nil); w.Code != 404 {
+			t.Errorf("%s: expected 404, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestPatchLog(t *testing.T) {
+	app := newTestApp(t, "")
+	app.AdminToken = "secret"
+	engine := app.engineForToken("app1")
+	logs := testLogs(engine, "started", 1)
+	if err := engine.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PATCH", "/log/app1/"+id, strings.NewReader(body))
+		r.SetBasicAuth("user", "pass")
+		r.Header.Set("X-Admin-Token", "secret")
+		w := httptest.NewRecorder()
+		app.handler("user", "pass").ServeHTTP(w, r)
+		return w
+	}
+
+	if w := patch(logs[0].Id, `{"owner": "alice"}`); w.Code != 200 || !strings.Contains(w.Body.String(), `"owner":"alice"`) {
+		t.Fatalf("expected the updated log, got %d: %s", w.Code, w.Body)
+	}
+	var stored map[string]interface{}
+	getJSON(t, app, "/log/app1/"+logs[0].Id, &stored)
+	if stored["owner"] != "alice" || stored["msg"] != "started 0" {
+		t.Errorf("expected the field to be merged, got %v", stored)
+	}
+	if total := searchTotal(t, engine, "owner:alice"); total != 1 || docCount(t, engine) != 1 {
+		t.Errorf("expected the log to be re-indexed in place, got %d matches", total)
+	}
+
+	for _, body := range []string{`{"time": "2020-01-01T00:00:00Z"}`, `{"id": "other"}`, `["owner"]`} {
+		if w := patch(logs[0].Id, body); w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	if w := patch("01M4WMDX1Z597WXS64Q7PR6PCC", `{"owner": "bob"}`); w.Code != 404 {
+		t.Errorf("expected unknown logs to answer 404, got %d", w.Code)
+	}
+}
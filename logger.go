@@ -0,0 +1,87 @@
+package firlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Logger is what the package logs through. Text output looks like the
+// standard log package's, JSON output is one object per line for log
+// aggregators. Debug messages are dropped unless Debug is set, they may
+// contain user queries so they're off by default.
+type Logger struct {
+	Format string
+	Debug  bool
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewLogger(out io.Writer, format string, debug bool) *Logger {
+	return &Logger{Format: format, Debug: debug, out: out}
+}
+
+var logger = NewLogger(os.Stderr, LogFormatText, false)
+
+// SetLogger replaces the package's logger, it should be called before the app
+// is started.
+func SetLogger(l *Logger) {
+	logger = l
+}
+
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.output("info", fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Println(v ...interface{}) {
+	l.output("info", fmt.Sprintln(v...))
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	if l.Debug {
+		l.output("debug", fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.output("fatal", fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+func (l *Logger) Fatalln(v ...interface{}) {
+	l.output("fatal", fmt.Sprintln(v...))
+	os.Exit(1)
+}
+
+func (l *Logger) output(level, message string) {
+	now := time.Now()
+	message = strings.TrimSuffix(message, "\n")
+
+	var line bytes.Buffer
+	if l.Format == LogFormatJSON {
+		encoder := json.NewEncoder(&line)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(map[string]string{
+			"time":  now.UTC().Format(time.RFC3339Nano),
+			"level": level,
+			"msg":   message,
+		})
+	} else {
+		line.WriteString(now.Format("2006/01/02 15:04:05 ") + message + "\n")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line.Bytes())
+}
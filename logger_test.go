@@ -0,0 +1,59 @@
+package firlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// captureLogs makes the package log to the returned buffer for the rest of
+// the test.
+func captureLogs(t *testing.T, format string, debug bool) *bytes.Buffer {
+	var out bytes.Buffer
+	previous := logger
+	SetLogger(NewLogger(&out, format, debug))
+	t.Cleanup(func() { SetLogger(previous) })
+	return &out
+}
+
+func TestLoggerFormats(t *testing.T) {
+	out := captureLogs(t, LogFormatText, false)
+	logger.Printf("imported %d logs\n", 3)
+	logger.Debugf("hidden")
+	if !regexp.MustCompile(`^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d imported 3 logs\n$`).MatchString(out.String()) {
+		t.Errorf("expected a standard log line, got %q", out)
+	}
+
+	out = captureLogs(t, LogFormatJSON, true)
+	logger.Println("error searching: ", "<boom>")
+	logger.Debugf("shown")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", out)
+	}
+	var line map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["level"] != "info" || line["msg"] != "error searching:  <boom>" || line["time"] == "" {
+		t.Errorf("unexpected line %v", line)
+	}
+	if !strings.Contains(lines[1], `"level":"debug"`) {
+		t.Errorf("expected a debug line, got %s", lines[1])
+	}
+}
+
+func TestQueriesOnlyLoggedWithDebug(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+
+	for _, debug := range []bool{false, true} {
+		out := captureLogs(t, LogFormatText, debug)
+		getJSON(t, app, "/search?token=app1&query=secret"+dashboardRange, nil)
+		if logged := strings.Contains(out.String(), "secret"); logged != debug {
+			t.Errorf("debug %v: expected the query to be logged %v, got %q", debug, debug, out)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package firlog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMalformedMinLines = 100
+	malformedAlertRule       = "malformed lines"
+)
+
+// MalformedAlert fires when more than Threshold (a 0 to 1 rate) of the bulk
+// lines received within Window couldn't be parsed, once at least MinLines
+// were received. Once fired it stays quiet for Cooldown (Window by default).
+// Like alert rules it goes to the engine's Notifier unless it has a Webhook.
+type MalformedAlert struct {
+	Threshold float64 `json:"threshold"`
+	MinLines  int     `json:"minLines"`
+	Window    string  `json:"window"`
+	Cooldown  string  `json:"cooldown"`
+	Webhook   string  `json:"webhook"`
+	Template  string  `json:"template"`
+
+	window   time.Duration
+	cooldown time.Duration
+	notifier Notifier
+}
+
+func compileMalformedAlert(alert *MalformedAlert) error {
+	if alert == nil {
+		return nil
+	}
+	if alert.Threshold <= 0 || alert.Threshold >= 1 {
+		return fmt.Errorf("malformed alert: threshold must be between 0 and 1")
+	}
+	window, err := time.ParseDuration(alert.Window)
+	if err != nil || window <= 0 {
+		return fmt.Errorf("malformed alert: invalid window '%s'", alert.Window)
+	}
+	alert.window = window
+	alert.cooldown = window
+	if alert.Cooldown != "" {
+		cooldown, err := time.ParseDuration(alert.Cooldown)
+		if err != nil || cooldown <= 0 {
+			return fmt.Errorf("malformed alert: invalid cooldown '%s'", alert.Cooldown)
+		}
+		alert.cooldown = cooldown
+	}
+	if alert.MinLines <= 0 {
+		alert.MinLines = DefaultMalformedMinLines
+	}
+	if alert.Webhook != "" {
+		notifier, err := NewWebhookNotifier(alert.Webhook, alert.Template)
+		if err != nil {
+			return fmt.Errorf("malformed alert: %v", err)
+		}
+		alert.notifier = notifier
+	}
+	return nil
+}
+
+type malformedCount struct {
+	at        time.Time
+	received  int
+	malformed int
+}
+
+// malformedTracker keeps the line counts of the bulk requests received
+// within the malformed alert's window.
+type malformedTracker struct {
+	mu        sync.Mutex
+	counts    []malformedCount
+	samples   []string
+	lastFired time.Time
+}
+
+// recordMalformed accounts for a parsed bulk request, firing the token's
+// malformed alert if the share of malformed lines crossed its threshold.
+func (e *Engine) recordMalformed(received, malformed int, samples []string) {
+	config := e.config().MalformedAlert
+	if config == nil || received == 0 {
+		return
+	}
+
+	alert := e.malformed.record(config, received, malformed, samples, time.Now())
+	if alert == nil {
+		return
+	}
+	alert.Token = e.token()
+	// Samples are raw lines, only redaction rules applying to `msg` or to any
+	// field can apply to them.
+	for _, log := range alert.Samples {
+		e.config().redact(log.Data)
+	}
+	notifier := e.Notifier
+	if config.notifier != nil {
+		notifier = config.notifier
+	}
+	if err := notifier.Notify(context.Background(), alert); err != nil {
+		logger.Printf("error firing malformed lines alert: %v\n", err)
+	}
+}
+
+func (t *malformedTracker) record(config *MalformedAlert, received, malformed int, samples []string, now time.Time) *Alert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	from := now.Add(-config.window)
+	kept := t.counts[:0]
+	totalReceived, totalMalformed := 0, 0
+	for _, count := range t.counts {
+		if count.at.After(from) {
+			kept = append(kept, count)
+			totalReceived += count.received
+			totalMalformed += count.malformed
+		}
+	}
+	t.counts = append(kept, malformedCount{at: now, received: received, malformed: malformed})
+	totalReceived += received
+	totalMalformed += malformed
+
+	// The most recent offending lines are the most telling.
+	t.samples = append(t.samples, samples...)
+	if len(t.samples) > alertSamplesCount {
+		t.samples = t.samples[len(t.samples)-alertSamplesCount:]
+	}
+
+	if totalReceived < config.MinLines ||
+		float64(totalMalformed) <= config.Threshold*float64(totalReceived) ||
+		t.lastFired.After(now.Add(-config.cooldown)) {
+		return nil
+	}
+	t.lastFired = now
+
+	alert := &Alert{Rule: malformedAlertRule, Count: totalMalformed, Received: totalReceived, From: from, To: now}
+	for _, line := range t.samples {
+		alert.Samples = append(alert.Samples, &Log{Data: map[string]interface{}{"msg": line}})
+	}
+	t.samples = nil
+	return alert
+}
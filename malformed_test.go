@@ -0,0 +1,39 @@
+package firlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMalformedAlert(t *testing.T) {
+	app := newTestApp(t, `{"malformedAlert": {"threshold": 0.5, "minLines": 4, "window": "1m"}}`)
+	notifier := &recordingNotifier{}
+	app.Notifier = notifier
+
+	postBulk(t, app, "text/plain", strings.Join([]string{syslogLine("first"), syslogLine("second"), "garbage 1"}, "\n"))
+	if count := notifier.count(); count != 0 {
+		t.Fatalf("expected no alert below minLines, got %d", count)
+	}
+	postBulk(t, app, "text/plain", strings.Join([]string{syslogLine("third"), "garbage 2", "garbage 3", "garbage 4"}, "\n"))
+	if count := notifier.count(); count != 1 {
+		t.Fatalf("expected an alert once over the threshold, got %d", count)
+	}
+	alert := notifier.alerts[0]
+	if alert.Rule != malformedAlertRule || alert.Token != "app1" || alert.Count != 4 || alert.Received != 7 {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+	if len(alert.Samples) != 4 || alert.Samples[0].Data["msg"] != "garbage 1" || alert.Samples[3].Data["msg"] != "garbage 4" {
+		t.Errorf("expected the malformed lines as samples, got %v", alert.Samples)
+	}
+
+	postBulk(t, app, "text/plain", "garbage 5\ngarbage 6")
+	if count := notifier.count(); count != 1 {
+		t.Errorf("expected the alert to cool down, got %d alerts", count)
+	}
+
+	for _, alert := range []*MalformedAlert{{Threshold: 1, Window: "1m"}, {Threshold: 0.1, Window: "soon"}, {Threshold: 0.1, Window: "1m", Cooldown: "-1m"}} {
+		if err := compileMalformedAlert(alert); err == nil {
+			t.Errorf("expected %+v to be rejected", alert)
+		}
+	}
+}
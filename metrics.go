@@ -0,0 +1,102 @@
+package firlog
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// handleMetrics exposes operational gauges in the Prometheus text format.
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	engines := app.engines()
+	tokens := sortedTokens(engines)
+
+	var out bytes.Buffer
+	writeMetricHeader(&out, "firlog_queue_depth", "gauge", "Bulk requests waiting to be indexed.")
+	for _, token := range tokens {
+		requests, _ := engines[token].QueueDepth()
+		fmt.Fprintf(&out, "firlog_queue_depth{token=%q} %d\n", token, requests)
+	}
+	writeMetricHeader(&out, "firlog_queue_pending_logs", "gauge", "Logs waiting to be indexed.")
+	for _, token := range tokens {
+		_, pending := engines[token].QueueDepth()
+		fmt.Fprintf(&out, "firlog_queue_pending_logs{token=%q} %d\n", token, pending)
+	}
+	writeMetricHeader(&out, "firlog_sampled_out_total", "counter", "Logs dropped by sampling.")
+	for _, token := range tokens {
+		fmt.Fprintf(&out, "firlog_sampled_out_total{token=%q} %d\n", token, engines[token].SampledOut())
+	}
+	writeMetricHeader(&out, "firlog_clock_skewed_total", "counter", "Bulk logs timestamped further in the future than the allowed clock skew.")
+	for _, token := range tokens {
+		fmt.Fprintf(&out, "firlog_clock_skewed_total{token=%q} %d\n", token, engines[token].ClockSkewed())
+	}
+	writeMetricHeader(&out, "firlog_fields", "gauge", "Distinct fields of the logs of each token.")
+	for _, token := range tokens {
+		fmt.Fprintf(&out, "firlog_fields{token=%q} %d\n", token, engines[token].FieldCount())
+	}
+	writeMetricHeader(&out, "firlog_fields_overflowed_total", "counter", "Logs with fields collapsed or dropped for being past their token's maxFields.")
+	for _, token := range tokens {
+		fmt.Fprintf(&out, "firlog_fields_overflowed_total{token=%q} %d\n", token, engines[token].FieldsOverflowed())
+	}
+	writeMetricHeader(&out, "firlog_last_ingest_timestamp_seconds", "gauge", "Unix time logs were last indexed at.")
+	for _, token := range tokens {
+		if lastIngest := engines[token].LastIngest(); !lastIngest.IsZero() {
+			fmt.Fprintf(&out, "firlog_last_ingest_timestamp_seconds{token=%q} %d\n", token, lastIngest.Unix())
+		}
+	}
+	writeMetricHeader(&out, "firlog_seconds_since_last_ingest", "gauge", "Seconds since logs were last indexed.")
+	for _, token := range tokens {
+		if lastIngest := engines[token].LastIngest(); !lastIngest.IsZero() {
+			fmt.Fprintf(&out, "firlog_seconds_since_last_ingest{token=%q} %d\n", token, int64(time.Since(lastIngest).Seconds()))
+		}
+	}
+
+	writeMetricHeader(&out, "firlog_search_duration_seconds", "summary", "Time searches took, quantiles over the latest ones.")
+	for _, token := range tokens {
+		latency := engines[token].SearchLatency()
+		for _, quantile := range []struct {
+			name     string
+			duration time.Duration
+		}{{"0.5", latency.P50}, {"0.95", latency.P95}, {"0.99", latency.P99}} {
+			fmt.Fprintf(&out, "firlog_search_duration_seconds{token=%q,quantile=%q} %g\n", token, quantile.name, quantile.duration.Seconds())
+		}
+		fmt.Fprintf(&out, "firlog_search_duration_seconds_sum{token=%q} %g\n", token, latency.Sum.Seconds())
+		fmt.Fprintf(&out, "firlog_search_duration_seconds_count{token=%q} %d\n", token, latency.Count)
+	}
+
+	indexStats := map[string]map[string]IndexStats{}
+	for _, token := range tokens {
+		stats, err := engines[token].IndexStats()
+		if err != nil {
+			logger.Printf("error reading index stats of %s: %v\n", token, err)
+			continue
+		}
+		indexStats[token] = stats
+	}
+	writeMetricHeader(&out, "firlog_index_docs", "gauge", "Documents in each opened index.")
+	for _, token := range tokens {
+		for _, key := range sortedIndexStatsKeys(indexStats[token]) {
+			fmt.Fprintf(&out, "firlog_index_docs{token=%q,index=%q} %d\n", token, key, indexStats[token][key].DocCount)
+		}
+	}
+	writeMetricHeader(&out, "firlog_index_disk_bytes", "gauge", "Bytes each opened index takes on disk.")
+	for _, token := range tokens {
+		for _, key := range sortedIndexStatsKeys(indexStats[token]) {
+			fmt.Fprintf(&out, "firlog_index_disk_bytes{token=%q,index=%q} %d\n", token, key, indexStats[token][key].DiskBytes)
+		}
+	}
+
+	writeMetricHeader(&out, "firlog_retention_deleted_indexes_total", "counter", "Indexes deleted by retention.")
+	fmt.Fprintf(&out, "firlog_retention_deleted_indexes_total %d\n", atomic.LoadInt64(&app.retentionDeleted))
+	writeMetricHeader(&out, "firlog_retention_reclaimed_bytes_total", "counter", "Bytes reclaimed by retention.")
+	fmt.Fprintf(&out, "firlog_retention_reclaimed_bytes_total %d\n", atomic.LoadInt64(&app.retentionReclaimed))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(out.Bytes())
+}
+
+func writeMetricHeader(out *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
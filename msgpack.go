@@ -0,0 +1,248 @@
+package firlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"mime"
+	"time"
+)
+
+// msgpackMaxDepth is how deeply arrays and maps can nest in msgpack bodies,
+// so malicious ones can't exhaust the stack.
+const msgpackMaxDepth = 100
+
+// msgpackTimestampType is the extension type of msgpack timestamps.
+const msgpackTimestampType = -1
+
+var errMalformedMsgpack = errors.New("malformed msgpack")
+
+// isMsgpack tells if contentType is one msgpack bodies are sent with.
+func isMsgpack(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return true
+	}
+	return false
+}
+
+// parseMsgpack parses a bulk body holding a msgpack array of records, maps
+// indexed like NDJSON lines would be, skipping (and logging) the elements
+// that aren't maps. Integers are decoded as float64 like JSON numbers and
+// timestamps as times, binary strings and other extensions as strings. A body
+// that doesn't decode as a whole is rejected with errMalformedMsgpack.
+func (parser *logParser) parseMsgpack(body []byte) ([]*Log, error) {
+	value, offset, err := decodeMsgpackValue(body, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	records, ok := value.([]interface{})
+	if !ok || offset != len(body) {
+		return nil, errMalformedMsgpack
+	}
+	receivedAt := time.Now().UTC()
+	for _, record := range records {
+		parser.addRecord(record, receivedAt)
+	}
+	return parser.finish(), nil
+}
+
+// decodeMsgpackValue decodes the msgpack value at offset of data, returning
+// it and the offset following it.
+func decodeMsgpackValue(data []byte, offset, depth int) (interface{}, int, error) {
+	if offset >= len(data) {
+		return nil, 0, errMalformedMsgpack
+	}
+	if depth > msgpackMaxDepth {
+		return nil, 0, errMalformedMsgpack
+	}
+	b := data[offset]
+	offset++
+
+	switch {
+	case b <= 0x7f: // positive fixint
+		return float64(b), offset, nil
+	case b >= 0xe0: // negative fixint
+		return float64(int8(b)), offset, nil
+	case b&0xf0 == 0x80: // fixmap
+		return decodeMsgpackMap(data, offset, int(b&0x0f), depth)
+	case b&0xf0 == 0x90: // fixarray
+		return decodeMsgpackArray(data, offset, int(b&0x0f), depth)
+	case b&0xe0 == 0xa0: // fixstr
+		return decodeMsgpackString(data, offset, int(b&0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, offset, nil
+	case 0xc2:
+		return false, offset, nil
+	case 0xc3:
+		return true, offset, nil
+	case 0xc4, 0xd9: // bin8, str8
+		size, next, err := readMsgpackUint(data, offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMsgpackString(data, next, int(size))
+	case 0xc5, 0xda: // bin16, str16
+		size, next, err := readMsgpackUint(data, offset, 2)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMsgpackString(data, next, int(size))
+	case 0xc6, 0xdb: // bin32, str32
+		size, next, err := readMsgpackUint(data, offset, 4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMsgpackString(data, next, int(size))
+	case 0xc7, 0xc8, 0xc9: // ext8, ext16, ext32
+		size, next, err := readMsgpackUint(data, offset, 1<<(b-0xc7))
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMsgpackExt(data, next, int(size))
+	case 0xca:
+		bits, next, err := readMsgpackUint(data, offset, 4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return float64(math.Float32frombits(uint32(bits))), next, nil
+	case 0xcb:
+		bits, next, err := readMsgpackUint(data, offset, 8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(bits), next, nil
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint8 to uint64
+		n, next, err := readMsgpackUint(data, offset, 1<<(b-0xcc))
+		if err != nil {
+			return nil, 0, err
+		}
+		return float64(n), next, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3: // int8 to int64
+		size := 1 << (b - 0xd0)
+		n, next, err := readMsgpackUint(data, offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Sign extended from the size's top bit.
+		shift := uint(64 - 8*size)
+		return float64(int64(n<<shift) >> shift), next, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1 to 16
+		return decodeMsgpackExt(data, offset, 1<<(b-0xd4))
+	case 0xdc, 0xdd: // array16, array32
+		size, next, err := readMsgpackUint(data, offset, 2<<(b-0xdc))
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMsgpackArray(data, next, int(size), depth)
+	case 0xde, 0xdf: // map16, map32
+		size, next, err := readMsgpackUint(data, offset, 2<<(b-0xde))
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMsgpackMap(data, next, int(size), depth)
+	}
+	// 0xc1 is never used.
+	return nil, 0, errMalformedMsgpack
+}
+
+func readMsgpackUint(data []byte, offset, size int) (uint64, int, error) {
+	if offset+size > len(data) {
+		return 0, 0, errMalformedMsgpack
+	}
+	var n uint64
+	for _, b := range data[offset : offset+size] {
+		n = n<<8 | uint64(b)
+	}
+	return n, offset + size, nil
+}
+
+func decodeMsgpackString(data []byte, offset, size int) (interface{}, int, error) {
+	if size < 0 || offset+size > len(data) {
+		return nil, 0, errMalformedMsgpack
+	}
+	return string(data[offset : offset+size]), offset + size, nil
+}
+
+func decodeMsgpackArray(data []byte, offset, size, depth int) (interface{}, int, error) {
+	// Every element takes a byte at least, which keeps bogus sizes from
+	// allocating more than the body holds.
+	if size < 0 || size > len(data)-offset {
+		return nil, 0, errMalformedMsgpack
+	}
+	a := make([]interface{}, 0, size)
+	for i := 0; i < size; i++ {
+		value, next, err := decodeMsgpackValue(data, offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		a = append(a, value)
+		offset = next
+	}
+	return a, offset, nil
+}
+
+func decodeMsgpackMap(data []byte, offset, size, depth int) (interface{}, int, error) {
+	if size < 0 || 2*size > len(data)-offset {
+		return nil, 0, errMalformedMsgpack
+	}
+	m := make(map[string]interface{}, size)
+	for i := 0; i < size; i++ {
+		key, next, err := decodeMsgpackValue(data, offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		keyString, ok := key.(string)
+		if !ok {
+			return nil, 0, errMalformedMsgpack
+		}
+		value, next, err := decodeMsgpackValue(data, next, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		m[keyString] = value
+		offset = next
+	}
+	return m, offset, nil
+}
+
+// decodeMsgpackExt decodes the extension value of size bytes at offset, its
+// type byte first. Timestamps are the only extension understood, others are
+// decoded as their bytes, as strings like binary strings are.
+func decodeMsgpackExt(data []byte, offset, size int) (interface{}, int, error) {
+	if size < 0 || offset+1+size > len(data) {
+		return nil, 0, errMalformedMsgpack
+	}
+	payload := data[offset+1 : offset+1+size]
+	next := offset + 1 + size
+	if int8(data[offset]) != msgpackTimestampType {
+		return string(payload), next, nil
+	}
+
+	var seconds int64
+	var nanoseconds uint32
+	switch size {
+	case 4:
+		seconds = int64(binary.BigEndian.Uint32(payload))
+	case 8:
+		n := binary.BigEndian.Uint64(payload)
+		nanoseconds = uint32(n >> 34)
+		seconds = int64(n & (1<<34 - 1))
+	case 12:
+		nanoseconds = binary.BigEndian.Uint32(payload)
+		seconds = int64(binary.BigEndian.Uint64(payload[4:]))
+	default:
+		return nil, 0, errMalformedMsgpack
+	}
+	if nanoseconds >= 1e9 {
+		return nil, 0, errMalformedMsgpack
+	}
+	return time.Unix(seconds, int64(nanoseconds)).UTC(), next, nil
+}
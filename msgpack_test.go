@@ -0,0 +1,204 @@
+package firlog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// msgpackExt is an extension value of an arbitrary type.
+type msgpackExt struct {
+	kind    int8
+	payload []byte
+}
+
+// encodeMsgpack encodes the values JSON decodes to, times and extensions,
+// always in their largest form but for fixed sizes extensions.
+func encodeMsgpack(value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return []byte{0xc0}
+	case bool:
+		if v {
+			return []byte{0xc3}
+		}
+		return []byte{0xc2}
+	case float64:
+		if v == math.Trunc(v) && v >= 0 {
+			return binary.BigEndian.AppendUint64([]byte{0xcf}, uint64(v))
+		}
+		return binary.BigEndian.AppendUint64([]byte{0xcb}, math.Float64bits(v))
+	case string:
+		return append(binary.BigEndian.AppendUint32([]byte{0xdb}, uint32(len(v))), v...)
+	case []interface{}:
+		b := binary.BigEndian.AppendUint32([]byte{0xdd}, uint32(len(v)))
+		for _, element := range v {
+			b = append(b, encodeMsgpack(element)...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b := binary.BigEndian.AppendUint32([]byte{0xdf}, uint32(len(v)))
+		for _, key := range keys {
+			b = append(b, encodeMsgpack(key)...)
+			b = append(b, encodeMsgpack(v[key])...)
+		}
+		return b
+	case time.Time:
+		b := []byte{0xc7, 12, 0xff}
+		b = binary.BigEndian.AppendUint32(b, uint32(v.Nanosecond()))
+		return binary.BigEndian.AppendUint64(b, uint64(v.Unix()))
+	case msgpackExt:
+		b := []byte{0xc7, byte(len(v.payload)), byte(v.kind)}
+		return append(b, v.payload...)
+	}
+	panic(fmt.Sprintf("can't encode %T", value))
+}
+
+// testRecords returns n records as NDJSON lines, along with them decoded.
+func testRecords(n int) ([]string, []interface{}) {
+	lines := []string{}
+	records := []interface{}{}
+	for i := 0; i < n; i++ {
+		line := fmt.Sprintf(`{"time": "2026-10-14T12:00:%02d.5Z", "level": "info", "msg": "request %d timed out", "status": %d, "duration": %d.25, "cached": %t, "user": null, "tags": ["web", "api"], "http": {"method": "GET", "path": "/users/%d"}}`, i%60, i, 200+i%5*100, i, i%2 == 0, i)
+		var record interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			panic(err)
+		}
+		lines = append(lines, line)
+		records = append(records, record)
+	}
+	return lines, records
+}
+
+// indexedData indexes logs in a new engine, returning what's stored for
+// each of them without their IDs and how many logs queries match.
+func indexedData(t *testing.T, logs []*Log, queries []string) ([]string, []uint64) {
+	t.Helper()
+	e := newTestEngine(t)
+	if err := e.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	stored := []string{}
+	for _, log := range logs {
+		found, err := e.Get(log.Id)
+		if err != nil || found == nil {
+			t.Fatalf("expected log %s to be stored, got %v (%v)", log.Id, found, err)
+		}
+		delete(found.Data, "id")
+		data, _ := json.Marshal(found.Data)
+		stored = append(stored, string(data))
+	}
+	totals := []uint64{}
+	for _, q := range queries {
+		result, err := e.group().search(bleve.NewSearchRequest(bleve.NewQueryStringQuery(q)), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		totals = append(totals, result.Total)
+	}
+	return stored, totals
+}
+
+func TestMsgpackIndexesLikeJSON(t *testing.T) {
+	lines, records := testRecords(20)
+	config := &TokenConfig{}
+
+	ndjson, err := newLogParser(config, ULIDGenerator{}).parseNDJSON(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgpack, err := newLogParser(config, ULIDGenerator{}).parseMsgpack(encodeMsgpack(records))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ndjson) != len(records) || len(msgpack) != len(records) {
+		t.Fatalf("expected %d logs each, got %d from NDJSON and %d from msgpack", len(records), len(ndjson), len(msgpack))
+	}
+
+	queries := []string{"timed", "level:info", "status:500", "status:>=400", "duration:<5", "http.method:GET", "tags:api", `time:>"2026-10-14T12:00:10Z"`}
+	jsonStored, jsonTotals := indexedData(t, ndjson, queries)
+	msgpackStored, msgpackTotals := indexedData(t, msgpack, queries)
+	for i := range jsonStored {
+		if msgpackStored[i] != jsonStored[i] {
+			t.Errorf("expected record %d to be stored as %s, got %s", i, jsonStored[i], msgpackStored[i])
+		}
+		if !msgpack[i].Time.Equal(ndjson[i].Time) {
+			t.Errorf("expected record %d to be at %s, got %s", i, ndjson[i].Time, msgpack[i].Time)
+		}
+	}
+	for i, q := range queries {
+		if msgpackTotals[i] != jsonTotals[i] || jsonTotals[i] == 0 {
+			t.Errorf("expected %s to match %d logs, got %d", q, jsonTotals[i], msgpackTotals[i])
+		}
+	}
+}
+
+func TestMsgpackExtensions(t *testing.T) {
+	at := time.Date(2026, 10, 14, 12, 0, 0, 500, time.UTC)
+	body := encodeMsgpack([]interface{}{map[string]interface{}{
+		"time":  at,
+		"msg":   "hello",
+		"trace": msgpackExt{kind: 42, payload: []byte("abc")},
+	}})
+	logs, err := newLogParser(&TokenConfig{}, ULIDGenerator{}).parseMsgpack(body)
+	if err != nil {
+		t.Fatalf("expected unknown extensions to be accepted, got %v", err)
+	}
+	if len(logs) != 1 || !logs[0].Time.Equal(at) || logs[0].Data["trace"] != "abc" {
+		t.Errorf("expected a log at %s with its extension as a string, got %+v", at, logs[0])
+	}
+
+	// Truncated extensions still fail the body.
+	if _, err := newLogParser(&TokenConfig{}, ULIDGenerator{}).parseMsgpack(body[:len(body)-1]); err != errMalformedMsgpack {
+		t.Errorf("expected a truncated body to be rejected, got %v", err)
+	}
+}
+
+func BenchmarkParseBulk(b *testing.B) {
+	lines, records := testRecords(1000)
+	ndjson := []byte(strings.Join(lines, "\n"))
+	msgpack := encodeMsgpack(records)
+	syslog := []string{}
+	for i := range lines {
+		syslog = append(syslog, fmt.Sprintf("<14>1 2026-10-14T12:00:%02d.5Z host app web.1 - request %d timed out", i%60, i))
+	}
+	text := []byte(strings.Join(syslog, "\n"))
+	config := &TokenConfig{}
+
+	b.Run("syslog", func(b *testing.B) {
+		b.SetBytes(int64(len(text)))
+		for i := 0; i < b.N; i++ {
+			if _, err := newLogParser(config, ULIDGenerator{}).parseLines(strings.NewReader(string(text))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ndjson", func(b *testing.B) {
+		b.SetBytes(int64(len(ndjson)))
+		for i := 0; i < b.N; i++ {
+			if _, err := newLogParser(config, ULIDGenerator{}).parseNDJSON(strings.NewReader(string(ndjson))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("msgpack", func(b *testing.B) {
+		b.SetBytes(int64(len(msgpack)))
+		for i := 0; i < b.N; i++ {
+			if _, err := newLogParser(config, ULIDGenerator{}).parseMsgpack(msgpack); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package firlog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	NotifierNone    = "none"
+	NotifierStderr  = "stderr"
+	NotifierWebhook = "webhook"
+)
+
+// Notifier delivers events, such as the alerts of alert rules and of
+// too many malformed lines. Embedding programs can set their own on
+// App.Notifier.
+type Notifier interface {
+	Notify(ctx context.Context, alert *Alert) error
+}
+
+// NewNotifier returns the built-in notifier of one of the Notifier* names,
+// url being where a webhook one POSTs to.
+func NewNotifier(kind, url string) (Notifier, error) {
+	switch kind {
+	case NotifierNone:
+		return NopNotifier{}, nil
+	case NotifierStderr:
+		return StderrNotifier{}, nil
+	case NotifierWebhook:
+		if url == "" {
+			return nil, fmt.Errorf("webhook notifier needs a url")
+		}
+		return NewWebhookNotifier(url, "")
+	}
+	return nil, fmt.Errorf("unknown notifier '%s'", kind)
+}
+
+// NopNotifier drops events.
+type NopNotifier struct{}
+
+func (NopNotifier) Notify(ctx context.Context, alert *Alert) error {
+	return nil
+}
+
+// StderrNotifier logs events to stderr.
+type StderrNotifier struct{}
+
+func (StderrNotifier) Notify(ctx context.Context, alert *Alert) error {
+	if alert.Received > 0 {
+		_, err := fmt.Fprintf(os.Stderr, "alert '%s' fired for token %s: %d of %d lines between %s and %s\n",
+			alert.Rule, alert.Token, alert.Count, alert.Received, alert.From.Format(time.RFC3339), alert.To.Format(time.RFC3339))
+		return err
+	}
+	_, err := fmt.Fprintf(os.Stderr, "alert '%s' fired for token %s: %d matches between %s and %s\n",
+		alert.Rule, alert.Token, alert.Count, alert.From.Format(time.RFC3339), alert.To.Format(time.RFC3339))
+	return err
+}
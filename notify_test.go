@@ -0,0 +1,80 @@
+package firlog
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingNotifier fails to deliver anything.
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx context.Context, alert *Alert) error {
+	return errors.New("unreachable")
+}
+
+func TestNewNotifier(t *testing.T) {
+	if notifier, err := NewNotifier(NotifierNone, ""); err != nil || notifier != (NopNotifier{}) {
+		t.Errorf("expected a NopNotifier, got %v, %v", notifier, err)
+	}
+	if notifier, err := NewNotifier(NotifierStderr, ""); err != nil || notifier != (StderrNotifier{}) {
+		t.Errorf("expected a StderrNotifier, got %v, %v", notifier, err)
+	}
+	for _, kind := range []string{NotifierWebhook, "pager"} {
+		if _, err := NewNotifier(kind, ""); err == nil {
+			t.Errorf("expected a %s notifier without a url to be rejected", kind)
+		}
+	}
+
+	server, bodies := webhookServer(t)
+	notifier, err := NewNotifier(NotifierWebhook, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), &Alert{Rule: "errors", Token: "app1", Count: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if body := receive(t, bodies); !strings.Contains(body, `"errors"`) {
+		t.Errorf("expected the alert to be posted, got %s", body)
+	}
+}
+
+func TestStderrNotifier(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	from, to := testTime, testTime.Add(time.Minute)
+	StderrNotifier{}.Notify(context.Background(), &Alert{Rule: "errors", Token: "app1", Count: 3, From: from, To: to})
+	StderrNotifier{}.Notify(context.Background(), &Alert{Rule: malformedAlertRule, Token: "app1", Count: 4, Received: 7, From: from, To: to})
+	w.Close()
+	output, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "alert 'errors' fired for token app1: 3 matches between 2026-10-14T12:00:00Z and 2026-10-14T12:01:00Z\n" +
+		"alert '" + malformedAlertRule + "' fired for token app1: 4 of 7 lines between 2026-10-14T12:00:00Z and 2026-10-14T12:01:00Z\n"
+	if string(output) != want {
+		t.Errorf("got %q, want %q", output, want)
+	}
+}
+
+func TestNotifierErrorsDontFailIndexing(t *testing.T) {
+	app := newTestApp(t, alertsConfig)
+	app.Notifier = failingNotifier{}
+	engine := app.engineForToken("app1")
+	if err := engine.Index(errorLogs(engine, 3)); err != nil {
+		t.Fatalf("expected the logs to be indexed anyway, got %v", err)
+	}
+	if count := docCount(t, engine); count != 3 {
+		t.Errorf("got %d logs indexed, want 3", count)
+	}
+}
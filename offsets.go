@@ -0,0 +1,177 @@
+package firlog
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+const offsetsFileName = "offsets.json"
+
+// errStaleOffset is returned by EnqueueAt for batches at or below the offset
+// already committed for their source, which were indexed already.
+var errStaleOffset = errors.New("offset already committed")
+
+// offsets are the highest batch offsets committed by each source of a token,
+// persisted in its data directory.
+type offsets struct {
+	// mu is held for the whole of EnqueueAt so a batch sent twice at once is
+	// only indexed once.
+	mu        sync.Mutex
+	committed map[string]uint64
+}
+
+// Offset returns the highest batch offset committed for source, 0 when it
+// never sent one.
+func (e *Engine) Offset(source string) (uint64, error) {
+	e.offsets.mu.Lock()
+	defer e.offsets.mu.Unlock()
+
+	if err := e.loadOffsets(); err != nil {
+		return 0, err
+	}
+	return e.offsets.committed[source], nil
+}
+
+// EnqueueAt indexes the batch of logs numbered offset by source and commits
+// offset once they're indexed, returning the offset committed for source
+// afterwards. Batches at or below it index nothing, failing with
+// errStaleOffset, so shippers resending what they weren't sure got through
+// don't duplicate logs. Unlike Enqueue, logs are indexed synchronously for
+// the offset to only be committed when they're on disk, and batches failing
+// to index fail whole for shippers to send them again.
+func (e *Engine) EnqueueAt(logs []*Log, source string, offset uint64) (uint64, error) {
+	if e.readOnly {
+		return 0, ErrReadOnly
+	}
+	e.offsets.mu.Lock()
+	defer e.offsets.mu.Unlock()
+
+	if err := e.loadOffsets(); err != nil {
+		return 0, err
+	}
+	committed := e.offsets.committed[source]
+	if offset <= committed {
+		return committed, errStaleOffset
+	}
+	if len(logs) > 0 {
+		// Neither dead-lettered, the offset would be committed for logs
+		// never indexed, nor written to the WAL, its replay duplicating the
+		// batch shippers send again when it fails.
+		recent := e.copyRecent(logs)
+		if err := e.index(logs, false, true); err != nil {
+			return committed, err
+		}
+		e.recordRecent(recent)
+	}
+	e.offsets.committed[source] = offset
+	if err := e.saveOffsets(); err != nil {
+		// The logs are indexed already, sending the batch again will
+		// duplicate them.
+		e.offsets.committed[source] = committed
+		return committed, err
+	}
+	return offset, nil
+}
+
+// loadOffsets reads the committed offsets on first use, with offsets.mu held.
+func (e *Engine) loadOffsets() error {
+	if e.offsets.committed != nil {
+		return nil
+	}
+	committed := map[string]uint64{}
+	contents, err := ioutil.ReadFile(filepath.Join(e.dataDir, offsetsFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err == nil {
+		if err := json.Unmarshal(contents, &committed); err != nil {
+			return err
+		}
+	}
+	e.offsets.committed = committed
+	return nil
+}
+
+// saveOffsets durably replaces the offsets file, with offsets.mu held.
+func (e *Engine) saveOffsets() error {
+	contents, err := json.Marshal(e.offsets.committed)
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(filepath.Join(e.dataDir, offsetsFileName), contents)
+}
+
+// writeFileSynced durably replaces the file at path with contents.
+func writeFileSynced(path string, contents []byte) error {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(contents); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// bulkOffset reads the `X-Firlog-Source` and `X-Firlog-Offset` headers of a
+// bulk request, ok being false without an offset.
+func bulkOffset(r *http.Request) (source string, offset uint64, ok bool, err error) {
+	value := r.Header.Get("X-Firlog-Offset")
+	if value == "" {
+		return "", 0, false, nil
+	}
+	offset, err = strconv.ParseUint(value, 10, 64)
+	if err != nil || offset == 0 {
+		return "", 0, false, errors.New("invalid `X-Firlog-Offset`, must be a positive integer")
+	}
+	return r.Header.Get("X-Firlog-Source"), offset, true, nil
+}
+
+// handleOffset answers the highest batch offset committed for a source of a
+// token, for shippers to resume from after a crash. Like bulk requests it's
+// authenticated by the token alone, `-default-token` being used without one.
+func (app *App) handleOffset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, 405, errorCodeMethodNotAllowed, "only GET supported")
+		return
+	}
+	// Guessing tokens here is as rate limited as through bulk requests.
+	if app.ipLimiter != nil && !app.ipLimiter.allow(remoteIP(r)) {
+		writeError(w, 429, errorCodeRateLimited, "too many requests")
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = app.DefaultToken
+	}
+	if !contains(app.Tokens, token) {
+		writeError(w, 401, errorCodeInvalidToken, "invalid token")
+		return
+	}
+	source := r.URL.Query().Get("source")
+	offset, err := app.engineForToken(token).Offset(source)
+	if err != nil {
+		logger.Printf("error reading offsets of %s: %v\n", token, err)
+		writeError(w, 500, errorCodeInternal, "error reading offsets")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":  token,
+		"source": source,
+		"offset": offset,
+	})
+}
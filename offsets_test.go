@@ -0,0 +1,113 @@
+package firlog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sendBatch posts lines to app1 as batch offset of shipper, returning the
+// status and committed offset of the response.
+func sendBatch(t *testing.T, app *App, offset uint64, lines ...string) (int, string) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/bulk/app1", strings.NewReader(strings.Join(lines, "\n")+"\n"))
+	r.Header.Set("X-Firlog-Source", "shipper")
+	r.Header.Set("X-Firlog-Offset", strconv.FormatUint(offset, 10))
+	w := httptest.NewRecorder()
+	app.handler("user", "pass").ServeHTTP(w, r)
+	return w.Code, w.Header().Get("X-Firlog-Offset")
+}
+
+func syslogLine(msg string) string {
+	return "<14>1 2026-10-14T12:00:00Z host app - - - " + msg
+}
+
+// committedOffset asks /offset, with no basic auth, the offset committed for
+// shipper.
+func committedOffset(t *testing.T, app *App) uint64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	app.handler("user", "pass").ServeHTTP(w, httptest.NewRequest("GET", "/offset?token=app1&source=shipper", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var response struct {
+		Offset uint64 `json:"offset"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Offset
+}
+
+func TestResumeAfterPartialDelivery(t *testing.T) {
+	app := NewApp(t.TempDir(), []string{"app1"})
+	// Failed batches must not be dead-lettered and committed anyway.
+	app.DeadLetter = true
+	engine := app.engineForToken("app1")
+
+	if status, committed := sendBatch(t, app, 1, syslogLine("first 1"), syslogLine("first 2")); status != 200 || committed != "1" {
+		t.Fatalf("expected batch 1 to be committed, got %d and offset %s", status, committed)
+	}
+
+	// The next batch fails to index, its index being closed under it.
+	indexes := engine.snapshotIndexes()
+	for _, index := range indexes {
+		index.Close()
+	}
+	if status, committed := sendBatch(t, app, 2, syslogLine("second 1"), syslogLine("second 2"), syslogLine("second 3")); status != 500 || committed != "1" {
+		t.Fatalf("expected batch 2 to fail uncommitted, got %d and offset %s", status, committed)
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(engine.dataDir, deadLetterDirName)); len(files) != 0 {
+		t.Errorf("expected nothing to be dead-lettered, got %d files", len(files))
+	}
+	if offset := committedOffset(t, app); offset != 1 {
+		t.Fatalf("expected the shipper to resume after 1, got %d", offset)
+	}
+
+	// Once the index is back the shipper resends what wasn't committed,
+	// then what it isn't sure got through.
+	engine.mu.Lock()
+	for key := range indexes {
+		delete(engine.indexes, key)
+	}
+	engine.indexesChanged()
+	engine.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		if status, committed := sendBatch(t, app, 2, syslogLine("second 1"), syslogLine("second 2"), syslogLine("second 3")); status != 200 || committed != "2" {
+			t.Fatalf("expected batch 2 to be committed, got %d and offset %s", status, committed)
+		}
+	}
+	if offset := committedOffset(t, app); offset != 2 {
+		t.Errorf("expected the shipper to resume after 2, got %d", offset)
+	}
+	if count := docCount(t, engine); count != 5 {
+		t.Errorf("expected every log to be indexed once, got %d docs", count)
+	}
+	if _, err := os.Stat(filepath.Join(engine.dataDir, offsetsFileName)); err != nil {
+		t.Errorf("expected offsets to be persisted: %v", err)
+	}
+}
+
+func TestOffsetNeedsToken(t *testing.T) {
+	app := NewApp(t.TempDir(), []string{"app1"})
+	handler := app.handler("user", "pass")
+
+	for _, path := range []string{"/offset?token=other&source=shipper", "/offset?source=shipper"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth("user", "pass")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != 401 {
+			t.Errorf("expected %s to answer 401, got %d", path, w.Code)
+		}
+	}
+	if offset := committedOffset(t, app); offset != 0 {
+		t.Errorf("expected no offset to be committed, got %d", offset)
+	}
+}
@@ -0,0 +1,32 @@
+package firlog
+
+import (
+	"github.com/blevesearch/bleve/search/query"
+)
+
+const (
+	// OperatorOr makes logs match queries when they match any of their
+	// terms without a `+` or `-`, bleve's default, and OperatorAnd when they
+	// match all of them.
+	OperatorOr  = "or"
+	OperatorAnd = "and"
+)
+
+// requireTerms makes the optional terms of a parsed query string, those
+// without a `+` or `-`, required, so `foo bar` means `+foo +bar`.
+func requireTerms(q query.Query) query.Query {
+	boolQuery, ok := q.(*query.BooleanQuery)
+	if !ok {
+		return q
+	}
+	should, ok := boolQuery.Should.(*query.DisjunctionQuery)
+	if !ok || len(should.Disjuncts) == 0 {
+		return q
+	}
+	// Emptied rather than replaced, keeping how the query string built
+	// them.
+	terms := should.Disjuncts
+	should.Disjuncts = []query.Query{}
+	boolQuery.AddMust(terms...)
+	return boolQuery
+}
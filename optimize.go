@@ -0,0 +1,371 @@
+package firlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/index/store"
+	"github.com/blevesearch/bleve/index/store/boltdb"
+	"github.com/blevesearch/bleve/index/upsidedown"
+)
+
+const (
+	optimizeDirName   = ".optimizing"
+	optimizeBatchSize = 10000
+)
+
+var (
+	errNoIndex    = errors.New("no such index")
+	errOptimizing = errors.New("index is already being optimized")
+)
+
+// OptimizeStatus is the progress of an index optimization.
+type OptimizeStatus struct {
+	// State is "running", "done" or "failed".
+	State      string     `json:"state"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	DocCount   uint64     `json:"docCount"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// OptimizeIndex rewrites the index stored for key into a fresh one in the
+// background, reclaiming the space updates and deletes left behind. Only one
+// optimization of an index runs at once, the returned status is the one
+// OptimizeStatus reports until it's done.
+func (e *Engine) OptimizeIndex(key string) (*OptimizeStatus, error) {
+	if e.readOnly {
+		return nil, ErrReadOnly
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.indexes[key]; !ok {
+		return nil, errNoIndex
+	}
+	if status, ok := e.optimizations[key]; ok && status.State == "running" {
+		return nil, errOptimizing
+	}
+	status := &OptimizeStatus{State: "running", StartedAt: time.Now().UTC()}
+	e.optimizations[key] = status
+	go e.optimize(key)
+	return status.copy(), nil
+}
+
+// OptimizeStatus returns the status of the last optimization of the index
+// stored for key, nil if there was none since startup.
+func (e *Engine) OptimizeStatus(key string) *OptimizeStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if status, ok := e.optimizations[key]; ok {
+		return status.copy()
+	}
+	return nil
+}
+
+func (s *OptimizeStatus) copy() *OptimizeStatus {
+	copied := *s
+	return &copied
+}
+
+func (e *Engine) optimize(key string) {
+	docCount, err := e.rewriteIndex(key)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := e.optimizations[key]
+	finishedAt := time.Now().UTC()
+	status.FinishedAt = &finishedAt
+	status.DocCount = docCount
+	if err != nil {
+		status.State = "failed"
+		status.Error = err.Error()
+		logger.Printf("error optimizing index %s of %s: %v\n", key, e.token(), err)
+		return
+	}
+	status.State = "done"
+	logger.Printf("optimized index %s of %s (%d docs)\n", key, e.token(), docCount)
+}
+
+// rewriteIndex copies the index stored for key into a fresh, compact index,
+// swapping it in once complete. The copy is made a batch at a time while
+// writes go on, then brought up to date with what they changed: writes for
+// the token only wait for a last catch-up and the swap, searches going on
+// against the old index meanwhile.
+func (e *Engine) rewriteIndex(key string) (uint64, error) {
+	e.mu.RLock()
+	index, ok := e.indexes[key]
+	e.mu.RUnlock()
+	if !ok {
+		return 0, errNoIndex
+	}
+	path, err := e.indexDir(key)
+	if err != nil {
+		return 0, err
+	}
+
+	// Work happens in a dot directory so it's never opened as an index if
+	// interrupted, one per index as several can be optimized at once.
+	dir := filepath.Join(e.dataDir, optimizeDirName, filepath.Base(path))
+	freshPath := filepath.Join(dir, filepath.Base(path))
+	oldPath := freshPath + ".old"
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+	// Keys are copied in order so pages can be filled completely.
+	fresh, err := bleve.NewUsing(freshPath, index.Mapping(), upsidedown.Name, boltdb.Name, map[string]interface{}{"fillPercent": 1.0})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := syncStore(index, fresh); err != nil {
+		fresh.Close()
+		return 0, err
+	}
+	// Catching up without the lock first leaves only the writes made
+	// during this pass for the one holding it.
+	if _, err := syncStore(index, fresh); err != nil {
+		fresh.Close()
+		return 0, err
+	}
+
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	e.mu.RLock()
+	current := e.indexes[key]
+	e.mu.RUnlock()
+	if current != index {
+		fresh.Close()
+		return 0, errors.New("index was replaced while being optimized")
+	}
+	if _, err := syncStore(index, fresh); err != nil {
+		fresh.Close()
+		return 0, err
+	}
+	if err := fresh.Close(); err != nil {
+		return 0, err
+	}
+	// Only the copy fills pages completely, later writes would otherwise
+	// too as the store config is kept with the index.
+	if err := removeStoreConfig(freshPath, "fillPercent"); err != nil {
+		return 0, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	index.Close()
+	delete(e.indexes, key)
+	e.indexesChanged()
+	if err := os.Rename(path, oldPath); err != nil {
+		return 0, e.reopenIndex(key, path, err)
+	}
+	if err := os.Rename(freshPath, path); err != nil {
+		os.Rename(oldPath, path)
+		return 0, e.reopenIndex(key, path, err)
+	}
+	if err := e.reopenIndex(key, path, nil); err != nil {
+		return 0, err
+	}
+	// Only counted right once reopened, document counts are loaded on open.
+	docCount, err := e.indexes[key].DocCount()
+	if err != nil {
+		return 0, err
+	}
+	return docCount, os.RemoveAll(dir)
+}
+
+// storeChange sets key to value in a store, or deletes it.
+type storeChange struct {
+	key, value []byte
+	delete     bool
+}
+
+// syncStore makes dst's store hold the same keys and values as src's,
+// documents, internal copies and index rows alike, returning how many it
+// changed. Changes are found optimizeBatchSize at a time, in key order, and
+// applied with no reader open, as a bolt store growing waits for them.
+func syncStore(src, dst bleve.Index) (int, error) {
+	_, srcStore, err := src.Advanced()
+	if err != nil {
+		return 0, err
+	}
+	_, dstStore, err := dst.Advanced()
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	var from []byte
+	for {
+		changes, next, err := diffStores(srcStore, dstStore, from)
+		if err != nil {
+			return changed, err
+		}
+		if len(changes) > 0 {
+			writer, err := dstStore.Writer()
+			if err != nil {
+				return changed, err
+			}
+			batch := writer.NewBatch()
+			for _, change := range changes {
+				if change.delete {
+					batch.Delete(change.key)
+				} else {
+					batch.Set(change.key, change.value)
+				}
+			}
+			err = writer.ExecuteBatch(batch)
+			writer.Close()
+			if err != nil {
+				return changed, err
+			}
+			changed += len(changes)
+		}
+		if next == nil {
+			return changed, nil
+		}
+		from = next
+	}
+}
+
+// diffStores returns up to optimizeBatchSize changes making dst's keys from
+// from on match src's, and the key to go on from, nil once there are no
+// more.
+func diffStores(src, dst store.KVStore, from []byte) ([]storeChange, []byte, error) {
+	srcReader, err := src.Reader()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer srcReader.Close()
+	dstReader, err := dst.Reader()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer dstReader.Close()
+
+	srcIterator := srcReader.RangeIterator(from, nil)
+	defer srcIterator.Close()
+	dstIterator := dstReader.RangeIterator(from, nil)
+	defer dstIterator.Close()
+	changes := []storeChange{}
+	for srcIterator.Valid() || dstIterator.Valid() {
+		var comparison int
+		switch {
+		case !dstIterator.Valid():
+			comparison = -1
+		case !srcIterator.Valid():
+			comparison = 1
+		default:
+			comparison = bytes.Compare(srcIterator.Key(), dstIterator.Key())
+		}
+		if len(changes) == optimizeBatchSize {
+			// Keys and values are only valid until the readers close.
+			if comparison <= 0 {
+				return changes, copyBytes(srcIterator.Key()), nil
+			}
+			return changes, copyBytes(dstIterator.Key()), nil
+		}
+
+		switch {
+		case comparison < 0:
+			changes = append(changes, storeChange{key: copyBytes(srcIterator.Key()), value: copyBytes(srcIterator.Value())})
+			srcIterator.Next()
+		case comparison > 0:
+			changes = append(changes, storeChange{key: copyBytes(dstIterator.Key()), delete: true})
+			dstIterator.Next()
+		default:
+			if !bytes.Equal(srcIterator.Value(), dstIterator.Value()) {
+				changes = append(changes, storeChange{key: copyBytes(srcIterator.Key()), value: copyBytes(srcIterator.Value())})
+			}
+			srcIterator.Next()
+			dstIterator.Next()
+		}
+	}
+	return changes, nil, nil
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// removeStoreConfig removes setting from the store config bleve keeps in the
+// `index_meta.json` of the index at path, which it's opened with.
+func removeStoreConfig(path, setting string) error {
+	metaPath := filepath.Join(path, "index_meta.json")
+	contents, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return err
+	}
+	meta := map[string]interface{}{}
+	if err := json.Unmarshal(contents, &meta); err != nil {
+		return err
+	}
+	if config, ok := meta["config"].(map[string]interface{}); ok {
+		delete(config, setting)
+		if len(config) == 0 {
+			delete(meta, "config")
+		}
+	}
+	contents, err = json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metaPath, contents, 0666)
+}
+
+// reopenIndex opens the index at path back as the one for key, with e.mu
+// held, returning cause or the error opening it.
+func (e *Engine) reopenIndex(key, path string, cause error) error {
+	index, err := bleve.Open(path)
+	if err != nil {
+		e.broken[key] = err.Error()
+		return err
+	}
+	e.indexes[key] = index
+	e.indexesChanged()
+	return cause
+}
+
+// handleOptimize starts an optimization of the index of date on POST,
+// answering 202 with its status, and reports the status of the last one on
+// GET.
+func (app *App) handleOptimize(w http.ResponseWriter, r *http.Request, engine *Engine, date string) {
+	var status *OptimizeStatus
+	switch r.Method {
+	case "GET":
+		status = engine.OptimizeStatus(date)
+		if status == nil {
+			writeError(w, 404, errorCodeNotFound, "no optimization of this index since startup")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+	case "POST":
+		var err error
+		status, err = engine.OptimizeIndex(date)
+		if err == errNoIndex {
+			writeError(w, 404, errorCodeNotFound, err.Error())
+			return
+		} else if err == errOptimizing {
+			writeError(w, 409, errorCodeConflict, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, 500, errorCodeInternal, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(202)
+	default:
+		writeError(w, 405, errorCodeMethodNotAllowed, "only GET and POST supported")
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,95 @@
+package firlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptimizeKeepsConcurrentWrites(t *testing.T) {
+	e := newTestEngine(t)
+	logs := testLogs(e, "before", 1000)
+	if err := e.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	key := testTime.Format("20060102")
+
+	if _, err := e.OptimizeIndex(key); err != nil {
+		t.Fatal(err)
+	}
+	// Logs keep coming in and getting updated until the optimization is
+	// over, the last batches landing while it catches up or once swapped.
+	done := make(chan struct{})
+	written := make(chan []*Log)
+	go func() {
+		during := []*Log{}
+		for i := 0; ; i++ {
+			time.Sleep(5 * time.Millisecond)
+			select {
+			case <-done:
+				written <- during
+				return
+			default:
+			}
+			batch := testLogs(e, fmt.Sprintf("during %d", i), 10)
+			if err := e.Index(batch); err != nil {
+				t.Error(err)
+			}
+			if _, err := e.Update(logs[i%len(logs)].Id, map[string]interface{}{"updated": "yes"}); err != nil {
+				t.Error(err)
+			}
+			during = append(during, batch...)
+		}
+	}()
+	var status *OptimizeStatus
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if status = e.OptimizeStatus(key); status.State != "running" {
+			break
+		}
+	}
+	close(done)
+	during := <-written
+	if status.State != "done" {
+		t.Fatalf("expected optimization to be done, got %+v", status)
+	}
+	if len(during) == 0 {
+		t.Fatal("expected logs to be indexed during the optimization")
+	}
+	// A last batch once swapped lands in the optimized index.
+	after := testLogs(e, "after", 10)
+	if err := e.Index(after); err != nil {
+		t.Fatal(err)
+	}
+
+	all := append(append(logs, during...), after...)
+	if count := docCount(t, e); count != uint64(len(all)) {
+		t.Errorf("expected %d docs, got %d", len(all), count)
+	}
+	for _, log := range all {
+		stored, err := e.Get(log.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored == nil {
+			t.Fatalf("log %s (%v) is missing", log.Id, log.Data["msg"])
+		}
+	}
+	stored, err := e.Get(logs[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Data["updated"] != "yes" {
+		t.Errorf("expected update made during the optimization to be kept, got %v", stored.Data)
+	}
+
+	meta, err := ioutil.ReadFile(filepath.Join(e.indexPath(key), "index_meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(meta), "fillPercent") {
+		t.Errorf("expected fillPercent to be left out of the optimized index meta, got %s", meta)
+	}
+}
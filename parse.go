@@ -0,0 +1,467 @@
+package firlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"strings"
+	"time"
+)
+
+var (
+	errMalformedLine = errors.New("malformed line")
+	errMalformedTime = errors.New("malformed time")
+	errMalformedJSON = errors.New("malformed json")
+)
+
+// parseLines parses a bulk body of newline delimited syslog messages as it's
+// read, skipping (and logging) the lines it can't make sense of. Blank lines
+// aren't logs, so they're skipped without counting as malformed, unless they
+// continue a multiline message.
+func (parser *logParser) parseLines(body io.Reader) ([]*Log, error) {
+	reader := bufio.NewReader(body)
+	// Blank lines are held back until another line follows them, so trailing
+	// ones are trimmed rather than appended to the last message.
+	blankLines := []string{}
+	for {
+		logLine, err := reader.ReadString('\n')
+		logLine = strings.TrimSuffix(logLine, "\n")
+		if strings.TrimSpace(logLine) == "" {
+			blankLines = append(blankLines, logLine)
+		} else {
+			for _, blankLine := range blankLines {
+				parser.addBlank(blankLine)
+			}
+			blankLines = blankLines[:0]
+			parser.add(logLine, parseLogLine)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return parser.finish(), nil
+}
+
+// parseNDJSON parses a bulk body of newline delimited JSON objects as it's
+// read, each of them a log of its own, skipping (and logging) the lines that
+// aren't objects. Blank lines are ignored.
+func (parser *logParser) parseNDJSON(body io.Reader) ([]*Log, error) {
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) != "" {
+			parser.add(line, parseJSONLine)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return parser.finish(), nil
+}
+
+// isNDJSON tells if contentType is one NDJSON bodies are sent with.
+func isNDJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonlines", "application/x-jsonlines":
+		return true
+	}
+	return false
+}
+
+// logParser accumulates parsed logs a line at a time.
+type logParser struct {
+	config *TokenConfig
+	ids    IDGenerator
+	logs   []*Log
+	// keepRaw keeps the lines logs were parsed from in their Raw.
+	keepRaw bool
+	// received and malformed count lines, malformedSamples holding the
+	// first few malformed ones.
+	received         int
+	malformed        int
+	malformedSamples []string
+}
+
+func newLogParser(config *TokenConfig, ids IDGenerator) *logParser {
+	return &logParser{config: config, ids: ids, logs: []*Log{}}
+}
+
+func (p *logParser) add(logLine string, parse func(string) (*Log, error)) {
+	p.received++
+	parsed, err := parse(logLine)
+	if err == errMalformedLine && p.config.Multiline && len(p.logs) > 0 {
+		previous := p.logs[len(p.logs)-1]
+		if appendContinuation(previous, logLine, p.config.maxMessageSize()) {
+			if p.keepsLines() {
+				previous.Raw += "\n" + logLine
+			}
+			return
+		}
+	}
+	if err != nil {
+		logger.Printf("%v '%s'", err, logLine)
+		p.malformed++
+		if len(p.malformedSamples) < alertSamplesCount {
+			p.malformedSamples = append(p.malformedSamples, logLine)
+		}
+		return
+	}
+	if p.keepsLines() {
+		parsed.Raw = logLine
+	}
+	p.logs = append(p.logs, parsed)
+}
+
+// addBlank appends a blank line to the message of the previous log with
+// TokenConfig.Multiline, as part of a stack trace or wrapped output, and
+// ignores it otherwise.
+func (p *logParser) addBlank(line string) {
+	if !p.config.Multiline || len(p.logs) == 0 {
+		return
+	}
+	previous := p.logs[len(p.logs)-1]
+	if appendContinuation(previous, line, p.config.maxMessageSize()) && p.keepsLines() {
+		previous.Raw += "\n" + line
+	}
+}
+
+// addRecord adds a log made of a decoded record, like a msgpack one, those
+// that aren't objects being counted as malformed. Kept raw, records are stored
+// as the JSON line they'd have been sent as, which replays the same.
+func (p *logParser) addRecord(record interface{}, receivedAt time.Time) {
+	p.received++
+	data, ok := record.(map[string]interface{})
+	if !ok {
+		sample, _ := json.Marshal(record)
+		logger.Printf("%v '%s'", errMalformedMsgpack, sample)
+		p.malformed++
+		if len(p.malformedSamples) < alertSamplesCount {
+			p.malformedSamples = append(p.malformedSamples, string(sample))
+		}
+		return
+	}
+	var raw []byte
+	if p.keepsLines() {
+		raw, _ = json.Marshal(data)
+	}
+	parsed := logFromData(data, receivedAt)
+	parsed.Raw = string(raw)
+	p.logs = append(p.logs, parsed)
+}
+
+// keepsLines tells if logs need the lines they were parsed from, for Raw or
+// for TokenConfig.RawField.
+func (p *logParser) keepsLines() bool {
+	return p.keepRaw || p.config.RawField
+}
+
+// take returns the logs parsed so far except for the last one, which
+// continuation lines could still be appended to.
+func (p *logParser) take() []*Log {
+	if len(p.logs) < 2 {
+		return []*Log{}
+	}
+	taken := p.done(p.logs[:len(p.logs)-1])
+	p.logs = []*Log{p.logs[len(p.logs)-1]}
+	return taken
+}
+
+// finish returns all the remaining parsed logs.
+func (p *logParser) finish() []*Log {
+	taken := p.done(p.logs)
+	p.logs = []*Log{}
+	return taken
+}
+
+// done finishes logs once no more lines can be appended to them, IDs being
+// generated last so content based ones cover the whole log.
+func (p *logParser) done(logs []*Log) []*Log {
+	for _, parsed := range logs {
+		p.config.display(parsed)
+		p.config.extract(parsed.Data)
+		p.config.detectBooleans(parsed.Data)
+		p.config.retime(parsed)
+		// After extraction so extracted fields get redacted too.
+		parsed.redacted = p.config.redact(parsed.Data)
+		// Raw lines are stored and, with RawField, searchable.
+		parsed.Raw = p.config.redactRaw(parsed.Raw, parsed.redacted)
+		if p.config.RawField && parsed.Raw != "" {
+			parsed.Data[rawField] = parsed.Raw
+		}
+		if !p.keepRaw {
+			parsed.Raw = ""
+		}
+		parsed.Id = p.ids.NewID(parsed)
+		parsed.Data["id"] = parsed.Id
+	}
+	return logs
+}
+
+func parseLogLine(logLine string) (*Log, error) {
+	// Format, the octet count drains prefix lines with being optional:
+	// 1 <1>1 2011-11-13T01:11:11+00:00 host app web.1 - message
+	// Lines are told apart by their `<pri>version` token, what follows the
+	// header fields being parsed as RFC5424's msgid and structured data, both
+	// of which agents often leave out, before the message.
+	field, rest := nextField(logLine)
+	if !isSyslogPriority(field) {
+		if !isDigits(field) {
+			return nil, errMalformedLine
+		}
+		if field, rest = nextField(rest); !isSyslogPriority(field) {
+			return nil, errMalformedLine
+		}
+	}
+	headerParts := strings.SplitN(rest, " ", 5)
+	if len(headerParts) < 4 {
+		return nil, errMalformedLine
+	}
+
+	parsedTime, err := time.Parse(time.RFC3339, headerParts[0])
+	if err != nil {
+		return nil, errMalformedTime
+	}
+
+	msgID, structuredData, message := "-", "-", ""
+	if len(headerParts) == 5 {
+		msgID, structuredData, message = splitMessage(headerParts[4])
+	}
+	data := map[string]interface{}{}
+	data["host"] = headerParts[1]
+	data["app"] = headerParts[2]
+	data["process"] = headerParts[3]
+	if msgID != "-" {
+		data["msgid"] = msgID
+	}
+	if structuredData != "-" {
+		data["structuredData"] = structuredData
+	}
+	if strings.HasPrefix(message, "{") && strings.HasSuffix(message, "}") {
+		if err := json.Unmarshal([]byte(message), &data); err != nil {
+			return nil, errMalformedJSON
+		}
+	} else {
+		data["msg"] = message
+	}
+	data["time"] = parsedTime
+
+	return &Log{
+		Time: parsedTime,
+		Data: data,
+	}, nil
+}
+
+// nextField splits the first space separated field off s.
+func nextField(s string) (string, string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isSyslogPriority tells if field is a `<pri>version` token like `<13>1`.
+func isSyslogPriority(field string) bool {
+	end := strings.IndexByte(field, '>')
+	if !strings.HasPrefix(field, "<") || end < 0 {
+		return false
+	}
+	priority, version := field[1:end], field[end+1:]
+	return isDigits(priority) && len(priority) <= 3 && isDigits(version) && len(version) <= 2
+}
+
+// splitMessage splits what follows the header fields of a line into its
+// msgid, structured data and message, `-` standing for the ones left out.
+// Messages starting like a structured data element that isn't one, such as
+// `[INFO] started`, are kept whole.
+func splitMessage(rest string) (string, string, string) {
+	if structuredData, message, ok := cutStructuredData(rest); ok {
+		return "-", structuredData, message
+	}
+	msgID, afterMsgID := nextField(rest)
+	if next, message := nextField(afterMsgID); next == "-" {
+		return msgID, "-", message
+	}
+	if structuredData, message, ok := cutStructuredData(afterMsgID); ok {
+		return msgID, structuredData, message
+	}
+	if msgID == "-" {
+		return "-", "-", afterMsgID
+	}
+	return "-", "-", rest
+}
+
+// cutStructuredData cuts the RFC5424 structured data elements s starts with
+// off it.
+func cutStructuredData(s string) (string, string, bool) {
+	end := 0
+	for end < len(s) && s[end] == '[' {
+		length := structuredDataElement(s[end:])
+		if length == 0 {
+			return "", "", false
+		}
+		end += length
+	}
+	if end == 0 || (end < len(s) && s[end] != ' ') {
+		return "", "", false
+	}
+	return s[:end], strings.TrimPrefix(s[end:], " "), true
+}
+
+// structuredDataElement returns the length of the `[id name="value" ...]`
+// element s starts with, 0 if it doesn't. Elements need parameters or an id
+// with an `@`, as private ones have, not to mistake bracketed words for them.
+func structuredDataElement(s string) int {
+	i := 1
+	for i < len(s) && s[i] != ' ' && s[i] != ']' {
+		if s[i] == '=' || s[i] == '"' {
+			return 0
+		}
+		i++
+	}
+	id := s[1:i]
+	if id == "" {
+		return 0
+	}
+	params := 0
+	for i < len(s) && s[i] == ' ' {
+		equal := strings.IndexByte(s[i:], '=')
+		if equal <= 1 || strings.ContainsAny(s[i+1:i+equal], " ]\"") || i+equal+1 >= len(s) || s[i+equal+1] != '"' {
+			return 0
+		}
+		i += equal + 2
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(s) {
+			return 0
+		}
+		i++
+		params++
+	}
+	if i >= len(s) || s[i] != ']' {
+		return 0
+	}
+	if params == 0 && !strings.Contains(id, "@") {
+		return 0
+	}
+	return i + 1
+}
+
+// appendContinuation appends a line that isn't a syslog message of its own
+// (e.g. a stack trace frame) to the previous log's message, as long as the
+// message stays under maxSize bytes.
+func appendContinuation(previous *Log, line string, maxSize int) bool {
+	message, _ := previous.Data["msg"].(string)
+	if len(message)+1+len(line) > maxSize {
+		return false
+	}
+	if message == "" {
+		previous.Data["msg"] = line
+	} else {
+		previous.Data["msg"] = message + "\n" + line
+	}
+	return true
+}
+
+// parseJSONLine parses a standalone JSON object, taking its time from a
+// `time` field when it has a valid one.
+func parseJSONLine(line string) (*Log, error) {
+	return parseJSONLineAt(line, time.Now().UTC())
+}
+
+// parseJSONLineAt is parseJSONLine, defaulting the time to receivedAt.
+func parseJSONLineAt(line string, receivedAt time.Time) (*Log, error) {
+	// Anything but an object, `null` included, isn't a log.
+	if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+		return nil, errMalformedJSON
+	}
+	data := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &data); err != nil {
+		return nil, errMalformedJSON
+	}
+	return logFromData(data, receivedAt), nil
+}
+
+// logFromData makes a log of the fields of a standalone record, taking its
+// time from a `time` field when it has a valid one and defaulting it to
+// receivedAt.
+func logFromData(data map[string]interface{}, receivedAt time.Time) *Log {
+	parsedTime := receivedAt
+	switch value := data["time"].(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			parsedTime = t
+		}
+	case time.Time:
+		parsedTime = value
+	}
+	data["time"] = parsedTime
+
+	return &Log{
+		Time: parsedTime,
+		Data: data,
+	}
+}
+
+// parseTime parses value as an RFC3339 time, or with TimeFormats.
+func (c *TokenConfig) parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	for _, format := range c.TimeFormats {
+		if err == nil {
+			break
+		}
+		t, err = time.Parse(format, value)
+	}
+	return t, err
+}
+
+// retime takes the log's time from the configured TimeField, normalized into
+// `time` which is what's indexed and sorted on. RFC3339 strings and Unix
+// timestamps in seconds or milliseconds are understood.
+func (c *TokenConfig) retime(log *Log) {
+	if c.TimeField == "" {
+		return
+	}
+	var parsed time.Time
+	switch value := log.Data[c.TimeField].(type) {
+	case string:
+		t, err := c.parseTime(value)
+		if err != nil {
+			return
+		}
+		parsed = t
+	case float64:
+		if value > 1e11 {
+			parsed = time.Unix(0, int64(value*float64(time.Millisecond)))
+		} else {
+			parsed = time.Unix(0, int64(value*float64(time.Second)))
+		}
+	default:
+		return
+	}
+	log.Time = parsed.UTC()
+	log.Data["time"] = log.Time
+}
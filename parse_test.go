@@ -0,0 +1,245 @@
+package firlog
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// parseBody parses body as bulk syslog lines with config, returning the
+// messages of the logs and how many lines were malformed.
+func parseBody(t *testing.T, config *TokenConfig, body string) ([]string, int) {
+	t.Helper()
+	parser := newLogParser(config, ULIDGenerator{})
+	logs, err := parser.parseLines(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []string{}
+	for _, log := range logs {
+		messages = append(messages, log.Data["msg"].(string))
+	}
+	return messages, parser.malformed
+}
+
+func TestMultiline(t *testing.T) {
+	body := strings.Join([]string{
+		syslogLine("panic: boom"),
+		"goroutine 1 [running]:",
+		"",
+		"main.main()",
+		syslogLine("recovered"),
+		"",
+		"",
+	}, "\n")
+	tests := []struct {
+		name      string
+		config    *TokenConfig
+		messages  []string
+		malformed int
+	}{
+		{"folded", &TokenConfig{Multiline: true}, []string{"panic: boom\ngoroutine 1 [running]:\n\nmain.main()", "recovered"}, 0},
+		{"dropped", &TokenConfig{}, []string{"panic: boom", "recovered"}, 2},
+		{"too big", &TokenConfig{Multiline: true, MaxMessageSize: 40}, []string{"panic: boom\ngoroutine 1 [running]:\n", "recovered"}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages, malformed := parseBody(t, test.config, body)
+			if strings.Join(messages, "|") != strings.Join(test.messages, "|") {
+				t.Errorf("expected messages %q, got %q", test.messages, messages)
+			}
+			if malformed != test.malformed {
+				t.Errorf("expected %d malformed lines, got %d", test.malformed, malformed)
+			}
+		})
+	}
+
+	// Continuation lines before any log have nothing to be appended to.
+	if messages, malformed := parseBody(t, &TokenConfig{Multiline: true}, "  at main()\n"+syslogLine("started")); len(messages) != 1 || malformed != 1 {
+		t.Errorf("expected a leading continuation line to be malformed, got %q and %d", messages, malformed)
+	}
+}
+
+func TestTimeField(t *testing.T) {
+	received := testTime.Add(time.Hour)
+	want := time.Date(2026, 10, 13, 8, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value interface{}
+		time  time.Time
+	}{
+		{"RFC3339", "2026-10-13T10:30:00+02:00", want},
+		{"seconds", float64(want.Unix()), want},
+		{"milliseconds", float64(want.UnixNano() / int64(time.Millisecond)), want},
+		{"time format", "13/Oct/2026:08:30:00 +0000", want},
+		{"invalid", "yesterday", received},
+		{"missing", nil, received},
+	}
+	config := &TokenConfig{TimeField: "@timestamp", TimeFormats: []string{"02/Jan/2006:15:04:05 -0700"}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Log{Time: received, Data: map[string]interface{}{"time": received}}
+			if test.value != nil {
+				log.Data["@timestamp"] = test.value
+			}
+			config.retime(log)
+			if !log.Time.Equal(test.time) || log.Data["time"] != log.Time {
+				t.Errorf("expected the time to be %s, got %s and %v", test.time, log.Time, log.Data["time"])
+			}
+			if test.value != nil && log.Data["@timestamp"] != test.value {
+				t.Errorf("expected the field to be kept, got %v", log.Data["@timestamp"])
+			}
+		})
+	}
+
+	app := newTestApp(t, `{"timeField": "ts"}`)
+	postBulk(t, app, "application/x-ndjson", `{"time": "2026-10-14T12:00:00Z", "msg": "late", "ts": 1791880200}`+"\n")
+	indexes, err := app.engineForToken("app1").Indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 1 || indexes[0].Date != "20261013" {
+		t.Errorf("expected the log to go to the index of its own time, got %+v", indexes)
+	}
+}
+
+func TestParseLinesStreams(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	body := syslogLine("first") + "\n\n" + syslogLine(long) + "\n" + syslogLine("last")
+	logs, err := newLogParser(&TokenConfig{}, ULIDGenerator{}).parseLines(iotest.OneByteReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 3 || logs[1].Data["msg"] != long || logs[2].Data["msg"] != "last" {
+		t.Errorf("expected the 3 logs, long lines and the unterminated last one included, got %d", len(logs))
+	}
+
+	failing := io.MultiReader(strings.NewReader(syslogLine("first")+"\n"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := newLogParser(&TokenConfig{}, ULIDGenerator{}).parseLines(failing); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected read errors to fail parsing, got %v", err)
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name           string
+		line           string
+		msgID          string
+		structuredData string
+		message        string
+	}{
+		{"heroku", `83 <40>1 2026-10-14T12:00:00+00:00 host app web.1 - State changed`, "", "", "State changed"},
+		{"without octet count", `<13>1 2026-10-14T12:00:00Z host app web.1 - - started`, "", "", "started"},
+		{"msgid", `<13>1 2026-10-14T12:00:00Z host app web.1 ID47 - started`, "ID47", "", "started"},
+		{"structured data", `<13>1 2026-10-14T12:00:00Z host app web.1 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"] started`, "ID47", `[exampleSDID@32473 iut="3" eventSource="App\"lication"]`, "started"},
+		{"structured data without msgid", `<13>1 2026-10-14T12:00:00Z host app web.1 [origin ip="10.0.0.1"][meta@1] started`, "", `[origin ip="10.0.0.1"][meta@1]`, "started"},
+		{"bracketed words", `<13>1 2026-10-14T12:00:00Z host app web.1 [INFO] started`, "", "", "[INFO] started"},
+		{"bracketed words after a dash", `<13>1 2026-10-14T12:00:00Z host app web.1 - [INFO] started`, "", "", "[INFO] started"},
+		{"no message", `<13>1 2026-10-14T12:00:00Z host app web.1`, "", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log, err := parseLogLine(test.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if log.Data["host"] != "host" || log.Data["app"] != "app" || log.Data["process"] != "web.1" || !log.Time.Equal(testTime) {
+				t.Errorf("unexpected header fields %v at %s", log.Data, log.Time)
+			}
+			if msgID, _ := log.Data["msgid"].(string); msgID != test.msgID {
+				t.Errorf("expected msgid %q, got %q", test.msgID, msgID)
+			}
+			if structuredData, _ := log.Data["structuredData"].(string); structuredData != test.structuredData {
+				t.Errorf("expected structured data %q, got %q", test.structuredData, structuredData)
+			}
+			if log.Data["msg"] != test.message {
+				t.Errorf("expected message %q, got %q", test.message, log.Data["msg"])
+			}
+		})
+	}
+
+	for line, want := range map[string]error{
+		"just some text":                          errMalformedLine,
+		"83 not syslog":                           errMalformedLine,
+		"<13>1 2026-10-14T12:00:00Z host app":     errMalformedLine,
+		"<1234>1 2026-10-14T12:00:00Z host app p": errMalformedLine,
+		"<13>1 yesterday host app web.1 - hi":     errMalformedTime,
+	} {
+		if _, err := parseLogLine(line); err != want {
+			t.Errorf("expected %q to fail with %v, got %v", line, want, err)
+		}
+	}
+}
+
+func TestParseNDJSON(t *testing.T) {
+	body := strings.Join([]string{
+		`{"msg": "first"}`,
+		"",
+		`{"msg": "second", "status": 200}` + "\r",
+		"   ",
+		`[{"msg": "in an array"}]`,
+		"null",
+		`"just a string"`,
+		syslogLine("not json"),
+		`{"msg": "last"}`,
+	}, "\n")
+	parser := newLogParser(&TokenConfig{}, ULIDGenerator{})
+	logs, err := parser.parseNDJSON(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []string{}
+	for _, log := range logs {
+		messages = append(messages, log.Data["msg"].(string))
+	}
+	if strings.Join(messages, ",") != "first,second,last" {
+		t.Errorf("expected only the objects to be logs, got %v", messages)
+	}
+	if parser.received != 7 || parser.malformed != 4 {
+		t.Errorf("expected 4 of 7 lines to be malformed, got %d of %d", parser.malformed, parser.received)
+	}
+}
+
+func TestIsNDJSON(t *testing.T) {
+	tests := map[string]bool{
+		"application/x-ndjson":                true,
+		"application/ndjson; charset=utf-8":   true,
+		"application/jsonlines":               true,
+		"application/x-jsonlines":             true,
+		"application/json":                    false,
+		"text/plain":                          false,
+		"":                                    false,
+		"application/x-ndjson; charset=\"utf": false,
+	}
+	for contentType, want := range tests {
+		if got := isNDJSON(contentType); got != want {
+			t.Errorf("expected %q to be NDJSON %v, got %v", contentType, want, got)
+		}
+	}
+}
+
+func TestBlankLines(t *testing.T) {
+	body := strings.Join([]string{"", "   ", syslogLine("first"), "\t", "\r", "", syslogLine("second"), " ", ""}, "\n")
+	parser := newLogParser(&TokenConfig{}, ULIDGenerator{})
+	logs, err := parser.parseLines(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || parser.received != 2 || parser.malformed != 0 {
+		t.Errorf("expected blank lines to be skipped, got %d logs of %d lines, %d malformed", len(logs), parser.received, parser.malformed)
+	}
+
+	// Folded into the previous message, trailing ones aside.
+	messages, malformed := parseBody(t, &TokenConfig{Multiline: true}, body)
+	if strings.Join(messages, "|") != "first\n\t\n\r\n|second" || malformed != 0 {
+		t.Errorf("expected blank lines to continue the previous message, got %q and %d malformed", messages, malformed)
+	}
+
+	app := newTestApp(t, "")
+	postBulk(t, app, "text/plain", "\n \n\t\n")
+	if count := docCount(t, app.engineForToken("app1")); count != 0 {
+		t.Errorf("expected a blank body to index nothing, got %d logs", count)
+	}
+}
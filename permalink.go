@@ -0,0 +1,76 @@
+package firlog
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// pinnedParams are the dashboard parameters the search form has no inputs
+// for, kept as hidden ones so changing the query keeps the range of a shared
+// link instead of going back to the last day.
+var pinnedParams = []string{"from", "to", "around", "window", "limit"}
+
+// pinnedParam is a hidden input of the search form.
+type pinnedParam struct {
+	Name  string
+	Value string
+}
+
+// dashboardPermalink is the dashboard URL of the search of params made with
+// values, its range resolved to absolute times so the link shows the same
+// logs when opened later rather than those of the last day then. Searches
+// around a log keep `around`, which is absolute already.
+func dashboardPermalink(params *searchParams, values url.Values) string {
+	link := url.Values{}
+	link.Set("token", params.Token)
+	if params.Query != "" {
+		link.Set("query", params.Query)
+	}
+	if params.Level != "" {
+		link.Set("level", params.Level)
+	}
+	link.Set("sort", params.Sort)
+	if around := values.Get("around"); around != "" {
+		link.Set("around", around)
+		if window := values.Get("window"); window != "" {
+			link.Set("window", window)
+		}
+	} else {
+		link.Set("from", params.from.UTC().Format(time.RFC3339Nano))
+		link.Set("to", params.to.UTC().Format(time.RFC3339Nano))
+	}
+	if params.Limit != DefaultSearchLimit {
+		link.Set("limit", strconv.Itoa(params.Limit))
+	}
+	for _, name := range []string{"tz", "cols"} {
+		if value := values.Get(name); value != "" {
+			link.Set(name, value)
+		}
+	}
+	return "/?" + link.Encode()
+}
+
+// unpinnedDashboardURL is the dashboard URL of the search made with values
+// over the default range.
+func unpinnedDashboardURL(values url.Values) string {
+	unpinned := url.Values{}
+	for name, value := range values {
+		unpinned[name] = value
+	}
+	for _, name := range pinnedParams {
+		unpinned.Del(name)
+	}
+	return "/?" + unpinned.Encode()
+}
+
+// pinnedDashboardParams are the pinnedParams values has.
+func pinnedDashboardParams(values url.Values) []pinnedParam {
+	pinned := []pinnedParam{}
+	for _, name := range pinnedParams {
+		if value := values.Get(name); value != "" {
+			pinned = append(pinned, pinnedParam{Name: name, Value: value})
+		}
+	}
+	return pinned
+}
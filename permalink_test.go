@@ -0,0 +1,59 @@
+package firlog
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// permalinkHref matches the href of the dashboard's copy link.
+var permalinkHref = regexp.MustCompile(`<a href="([^"]*)" title="Link to these results`)
+
+func TestDashboardPermalink(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+
+	body := getJSON(t, app, "/?token=app1&query=disk&cols=severity"+dashboardRange, nil).Body.String()
+	match := permalinkHref.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("expected a link to the results, got %s", body)
+	}
+	link, err := url.Parse(html.UnescapeString(match[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{
+		"token": {"app1"}, "query": {"disk"}, "sort": {"time"}, "cols": {"severity"},
+		"from": {"2026-10-14T00:00:00Z"}, "to": {"2026-10-14T23:59:59Z"},
+	}
+	if link.Query().Encode() != want.Encode() {
+		t.Errorf("expected the link to keep the search, got %s", link)
+	}
+	if again := getJSON(t, app, link.String(), nil).Body.String(); !strings.Contains(again, "<strong>2 results</strong>") {
+		t.Errorf("expected the link to show the same logs, got %s", again)
+	}
+
+	// The range of a shared link is kept when changing the query.
+	for _, input := range []string{`<input type="hidden" name="from" value="2026-10-14T00:00:00Z">`, `<input type="hidden" name="to" value="2026-10-14T23:59:59Z">`} {
+		if !strings.Contains(body, input) {
+			t.Errorf("expected %s in the search form", input)
+		}
+	}
+	if !strings.Contains(body, `<a href="/?cols=severity&amp;query=disk&amp;token=app1">Back to the last day</a>`) {
+		t.Errorf("expected a link back to the last day, got %s", body)
+	}
+	if body := getJSON(t, app, "/?token=app1&query=disk", nil).Body.String(); strings.Contains(body, `type="hidden" name="from"`) || strings.Contains(body, "Back to the last day") {
+		t.Error("expected the last day not to be pinned")
+	}
+}
+
+func TestPermalinkAround(t *testing.T) {
+	params := &searchParams{Token: "app1", Sort: "time", Limit: 20, from: testTime, to: testTime.Add(time.Hour)}
+	values := url.Values{"around": {"01ABC"}, "window": {"5m"}, "tz": {"Europe/Paris"}}
+	if link := dashboardPermalink(params, values); link != "/?around=01ABC&limit=20&sort=time&token=app1&tz=Europe%2FParis&window=5m" {
+		t.Errorf("expected the log searched around to be kept instead of the range, got %s", link)
+	}
+}
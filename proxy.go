@@ -0,0 +1,110 @@
+package firlog
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ParseCIDRs parses a comma separated list of CIDRs, plain IPs standing for
+// themselves alone.
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP '%s'", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR '%s'", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (app *App) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range app.TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedMiddleware rewrites requests coming from a trusted proxy with the
+// client IP, host and scheme it forwarded, so the rest of the app sees the
+// original request. `X-Forwarded-*` headers of other peers are ignored as
+// anyone can send them.
+func (app *App) forwardedMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.trustedProxy(remoteIP(r)) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		if client := app.forwardedFor(r); client != "" {
+			r2.RemoteAddr = net.JoinHostPort(client, "0")
+		}
+		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+			r2.Host = host
+			r2.URL.Host = host
+		}
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			r2.URL.Scheme = proto
+		}
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// forwardedFor is the client IP of a request going through trusted proxies,
+// the last `X-Forwarded-For` entry that isn't one of them. Entries before it
+// are whatever the client sent.
+func (app *App) forwardedFor(r *http.Request) string {
+	entries := []string{}
+	for _, header := range r.Header["X-Forwarded-For"] {
+		entries = append(entries, strings.Split(header, ",")...)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(entries[i])
+		if net.ParseIP(entry) == nil {
+			return ""
+		}
+		if i == 0 || !app.trustedProxy(entry) {
+			return entry
+		}
+	}
+	return ""
+}
+
+// remoteIP is the IP a request comes from.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
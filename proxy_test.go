@@ -0,0 +1,70 @@
+package firlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs(" 10.0.0.0/8, 127.0.0.1,::1 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 || networks[0].String() != "10.0.0.0/8" || networks[1].String() != "127.0.0.1/32" || networks[2].String() != "::1/128" {
+		t.Errorf("unexpected networks %v", networks)
+	}
+	for _, list := range []string{"10.0.0.0/33", "localhost"} {
+		if _, err := ParseCIDRs(list); err == nil {
+			t.Errorf("expected %s to be rejected", list)
+		}
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	app := newTestApp(t, "")
+	app.TrustedProxies, _ = ParseCIDRs("10.0.0.0/8")
+	var seen *http.Request
+	handler := app.forwardedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	}))
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		ip        string
+	}{
+		{"untrusted peer", "203.0.113.9", []string{"198.51.100.1"}, "203.0.113.9"},
+		{"trusted peer", "10.0.0.1", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "10.0.0.1", []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, "198.51.100.1"},
+		{"spoofed entries", "10.0.0.1", []string{"192.0.2.1, 198.51.100.1"}, "198.51.100.1"},
+		{"invalid entry", "10.0.0.1", []string{"198.51.100.1, nope"}, "10.0.0.1"},
+		{"no header", "10.0.0.1", nil, "10.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/info", nil)
+			r.RemoteAddr = test.peer + ":1234"
+			r.Header["X-Forwarded-For"] = test.forwarded
+			r.Header.Set("X-Forwarded-Host", "logs.example.com")
+			r.Header.Set("X-Forwarded-Proto", "https")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if ip := remoteIP(seen); ip != test.ip {
+				t.Errorf("expected the request to come from %s, got %s", test.ip, ip)
+			}
+			trusted := test.peer == "10.0.0.1"
+			if (seen.Host == "logs.example.com") != trusted || (seen.URL.Scheme == "https") != trusted {
+				t.Errorf("expected the forwarded host and scheme to be used %v, got %s and %q", trusted, seen.Host, seen.URL.Scheme)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/info", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-Proto", "javascript")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if seen.URL.Scheme != "" {
+		t.Errorf("expected unknown schemes to be ignored, got %s", seen.URL.Scheme)
+	}
+}
@@ -0,0 +1,149 @@
+package firlog
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const quarantineDirName = ".quarantine"
+
+// markBroken records an index that failed to open or to be searched, so it
+// can be listed and dealt with through RepairIndex or QuarantineIndex.
+func (e *Engine) markBroken(key string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.broken[key] = err.Error()
+}
+
+// BrokenIndexes returns the errors of the indexes that failed to open or to
+// be searched, by index date.
+func (e *Engine) BrokenIndexes() map[string]string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	broken := map[string]string{}
+	for key, err := range e.broken {
+		broken[key] = err
+	}
+	return broken
+}
+
+// RepairIndex closes and re-opens the index stored for key, forgetting its
+// failure when it opens fine.
+func (e *Engine) RepairIndex(key string) error {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	path, err := e.indexDir(key)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if index, ok := e.indexes[key]; ok {
+		index.Close()
+		delete(e.indexes, key)
+		e.indexesChanged()
+	}
+	index, err := e.openIndex(path)
+	if err != nil {
+		e.broken[key] = err.Error()
+		return err
+	}
+	e.indexes[key] = index
+	e.indexesChanged()
+	delete(e.broken, key)
+	return nil
+}
+
+// QuarantineIndex closes the index stored for key and moves it aside to
+// `.quarantine/`, new logs for its date going to a fresh index.
+func (e *Engine) QuarantineIndex(key string) error {
+	if e.readOnly {
+		return ErrReadOnly
+	}
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	path, err := e.indexDir(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(e.dataDir, quarantineDirName)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if index, ok := e.indexes[key]; ok {
+		index.Close()
+		delete(e.indexes, key)
+		e.indexesChanged()
+	}
+	if err := os.Rename(path, filepath.Join(dir, filepath.Base(path)+"."+newUlid())); err != nil {
+		return err
+	}
+	delete(e.broken, key)
+	logger.Printf("quarantined index %s of %s\n", key, e.token())
+	return nil
+}
+
+// indexDir is the directory the index for key is stored in.
+func (e *Engine) indexDir(key string) (string, error) {
+	names, err := listIndexes(e.dataDir)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if indexKey(name) == key {
+			return filepath.Join(e.dataDir, name), nil
+		}
+	}
+	return "", fmt.Errorf("no index for '%s'", key)
+}
+
+// handleIndexes serves `POST /indexes/<token>/<date>/repair`,
+// `POST /indexes/<token>/<date>/quarantine` and
+// `GET|POST /indexes/<token>/<date>/optimize`.
+func (app *App) handleIndexes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path[len("/indexes/"):], "/")
+	if len(parts) == 1 && contains(app.Tokens, parts[0]) {
+		app.handleCoverage(w, r, app.engineForToken(parts[0]))
+		return
+	}
+	if len(parts) != 3 || !contains(app.Tokens, parts[0]) {
+		writeError(w, 404, errorCodeNotFound, "Not found")
+		return
+	}
+	engine := app.engineForToken(parts[0])
+	if parts[2] == "optimize" {
+		app.handleOptimize(w, r, engine, parts[1])
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, 405, errorCodeMethodNotAllowed, "only POST supported")
+		return
+	}
+
+	var err error
+	switch parts[2] {
+	case "repair":
+		err = engine.RepairIndex(parts[1])
+	case "quarantine":
+		err = engine.QuarantineIndex(parts[1])
+	default:
+		writeError(w, 404, errorCodeNotFound, "Not found")
+		return
+	}
+	if err != nil {
+		logger.Printf("error during %s of index %s: %v\n", parts[2], parts[1], err)
+		writeError(w, 500, errorCodeInternal, fmt.Sprintf("Error during %s: %v", parts[2], err))
+		return
+	}
+	w.Write([]byte("ok"))
+}
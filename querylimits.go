@@ -0,0 +1,66 @@
+package firlog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+var errLeadingWildcard = errors.New("terms can't start with a wildcard, which goes through every term of the indexes")
+
+// checkQueryLimits rejects queries longer than MaxQueryLength, with more
+// clauses than MaxQueryClauses or, with RejectLeadingWildcards, terms
+// starting with a wildcard, before they're parsed or run.
+func (app *App) checkQueryLimits(q string) error {
+	if app.MaxQueryLength > 0 && len(q) > app.MaxQueryLength {
+		return fmt.Errorf("the query is %d characters long, more than the %d allowed", len(q), app.MaxQueryLength)
+	}
+	if app.MaxQueryClauses <= 0 && !app.RejectLeadingWildcards {
+		return nil
+	}
+	parsed, err := bleve.NewQueryStringQuery(q).Parse()
+	if err != nil {
+		// Left for validateQuery to report.
+		return nil
+	}
+	clauses, leadingWildcard := queryClauses(parsed)
+	if app.MaxQueryClauses > 0 && clauses > app.MaxQueryClauses {
+		return fmt.Errorf("the query has %d clauses, more than the %d allowed", clauses, app.MaxQueryClauses)
+	}
+	if app.RejectLeadingWildcards && leadingWildcard {
+		return errLeadingWildcard
+	}
+	return nil
+}
+
+// queryClauses counts the terms, phrases, ranges and such of a parsed query
+// string, telling if any of them is a wildcard starting with `*` or `?`. A
+// lone `*` matches everything without going through terms so it doesn't
+// count as one.
+func queryClauses(q query.Query) (int, bool) {
+	var children []query.Query
+	switch q := q.(type) {
+	case *query.BooleanQuery:
+		children = []query.Query{q.Must, q.Should, q.MustNot}
+	case *query.ConjunctionQuery:
+		children = q.Conjuncts
+	case *query.DisjunctionQuery:
+		children = q.Disjuncts
+	case *query.WildcardQuery:
+		return 1, q.Wildcard != "*" && strings.IndexAny(q.Wildcard, "*?") == 0
+	case nil:
+		return 0, false
+	default:
+		return 1, false
+	}
+	clauses, leadingWildcard := 0, false
+	for _, child := range children {
+		childClauses, childWildcard := queryClauses(child)
+		clauses += childClauses
+		leadingWildcard = leadingWildcard || childWildcard
+	}
+	return clauses, leadingWildcard
+}
@@ -0,0 +1,56 @@
+package firlog
+
+import (
+	"html"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCheckQueryLimits(t *testing.T) {
+	app := newTestApp(t, "")
+	app.MaxQueryLength = 40
+	app.MaxQueryClauses = 3
+	app.RejectLeadingWildcards = true
+
+	tests := map[string]string{
+		"":          "",
+		"*":         "",
+		"disk full": "",
+		`+level:error msg:"timed out" -host:web1`: "",
+		"user:adm*n":            "",
+		"a b c d":               "4 clauses, more than the 3",
+		"*son":                  "wildcard",
+		"user:?dmin":            "wildcard",
+		"+disk -(full *again)":  "wildcard",
+		strings.Repeat("x", 41): "41 characters long, more than the 40",
+		// Syntax errors are left for validateQuery.
+		`"disk full`: "",
+	}
+	for q, message := range tests {
+		err := app.checkQueryLimits(q)
+		if message == "" && err != nil {
+			t.Errorf("expected %q to be allowed, got %v", q, err)
+		} else if message != "" && (err == nil || !strings.Contains(err.Error(), message)) {
+			t.Errorf("expected %q to be rejected with %q, got %v", q, message, err)
+		}
+	}
+
+	if err := newTestApp(t, "").checkQueryLimits("*son " + strings.Repeat("x ", 100)); err != nil {
+		t.Errorf("expected queries not to be limited by default, got %v", err)
+	}
+}
+
+func TestQueryLimitsRejectSearches(t *testing.T) {
+	app := newTestApp(t, "")
+	app.RejectLeadingWildcards = true
+	postSeverities(t, app)
+
+	query := "&query=" + url.QueryEscape("*ull")
+	if w := getJSON(t, app, "/search?token=app1"+query+dashboardRange, nil); w.Code != 400 || !strings.Contains(w.Body.String(), "invalid_query") {
+		t.Errorf("expected a 400 invalid_query, got %d: %s", w.Code, w.Body)
+	}
+	if body := getJSON(t, app, "/?token=app1"+query+dashboardRange, nil).Body.String(); !strings.Contains(body, "Invalid query: "+html.EscapeString(errLeadingWildcard.Error())) {
+		t.Errorf("expected the limit next to the query box, got %s", body)
+	}
+}
@@ -0,0 +1,178 @@
+package firlog
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultQueueSize    = 1000
+	DefaultQueueWorkers = 2
+	DefaultMaxBatchSize = 5000
+	// DefaultIndexChunkSize bounds the batches Engine.Index applies, see
+	// Engine.IndexChunkSize.
+	DefaultIndexChunkSize = 1000
+	// DefaultIndexWorkers is how many dates Engine.Index applies at once.
+	DefaultIndexWorkers = 4
+)
+
+// ErrQueueFull is returned by Enqueue when the engine's indexing queue
+// can't accept any more work.
+var ErrQueueFull = errors.New("indexing queue full")
+
+type indexQueue struct {
+	engine        *Engine
+	batches       chan queuedLogs
+	maxBatchSize  int
+	flushInterval time.Duration
+	pending       int64
+	workers       int
+	// flushMu keeps drains from interleaving their barriers.
+	flushMu sync.Mutex
+}
+
+// queuedLogs are the logs of a bulk request, along with the write-ahead log
+// segment to release once they're indexed, or a barrier of drain.
+type queuedLogs struct {
+	logs       []*Log
+	walSegment string
+	flush      *queueFlush
+}
+
+// StartQueue starts `workers` goroutines indexing logs submitted through
+// Enqueue. Up to `size` bulk requests can be waiting at once and consecutive
+// requests are coalesced into batches of at most `maxBatchSize` logs. With a
+// `flushInterval`, workers wait up to that long for batches to fill up,
+// trading latency for throughput, instead of indexing whatever is waiting.
+func (e *Engine) StartQueue(size, workers, maxBatchSize int, flushInterval time.Duration) {
+	q := &indexQueue{
+		engine:        e,
+		batches:       make(chan queuedLogs, size),
+		maxBatchSize:  maxBatchSize,
+		flushInterval: flushInterval,
+		workers:       workers,
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	e.queue = q
+}
+
+// Enqueue submits logs for asynchronous indexing. Engines without a started
+// queue index synchronously. With WAL, logs are on disk by the time Enqueue
+// returns.
+func (e *Engine) Enqueue(logs []*Log) error {
+	if e.readOnly {
+		return ErrReadOnly
+	}
+	if e.queue == nil {
+		return e.indexNow(logs)
+	}
+	walSegment, err := e.writeWAL(logs)
+	if err != nil {
+		return fmt.Errorf("writing wal: %v", err)
+	}
+	// Copied before being handed to the queue's workers, which change their
+	// fields as they index them, but only recorded once accepted.
+	recent := e.copyRecent(logs)
+	select {
+	case e.queue.batches <- queuedLogs{logs: logs, walSegment: walSegment}:
+		atomic.AddInt64(&e.queue.pending, int64(len(logs)))
+		e.recordRecent(recent)
+		return nil
+	default:
+		// Rejected logs are the client's to send again.
+		e.releaseWAL(walSegment)
+		return ErrQueueFull
+	}
+}
+
+// indexNow indexes logs given to Enqueue synchronously, through the WAL.
+func (e *Engine) indexNow(logs []*Log) error {
+	walSegment, err := e.writeWAL(logs)
+	if err != nil {
+		return fmt.Errorf("writing wal: %v", err)
+	}
+	recent := e.copyRecent(logs)
+	if err := e.Index(logs); err != nil {
+		return err
+	}
+	e.recordRecent(recent)
+	e.releaseWAL(walSegment)
+	return nil
+}
+
+// QueueDepth returns the number of bulk requests and logs waiting to be
+// indexed.
+func (e *Engine) QueueDepth() (int, int64) {
+	if e.queue == nil {
+		return 0, 0
+	}
+	return len(e.queue.batches), atomic.LoadInt64(&e.queue.pending)
+}
+
+func (q *indexQueue) work() {
+	for queued := range q.batches {
+		if queued.flush != nil {
+			queued.flush.wait()
+			continue
+		}
+		batch := append([]*Log{}, queued.logs...)
+		walSegments := []string{queued.walSegment}
+		// A nil channel never fires, so without an interval only what's
+		// already waiting gets coalesced.
+		var flush <-chan time.Time
+		var timer *time.Timer
+		// A barrier met while coalescing waits for the batch to be indexed.
+		var barrier *queueFlush
+		if q.flushInterval > 0 {
+			timer = time.NewTimer(q.flushInterval)
+			flush = timer.C
+		}
+	coalesce:
+		for len(batch) < q.maxBatchSize {
+			if flush == nil {
+				select {
+				case more := <-q.batches:
+					if more.flush != nil {
+						barrier = more.flush
+						break coalesce
+					}
+					batch = append(batch, more.logs...)
+					walSegments = append(walSegments, more.walSegment)
+				default:
+					break coalesce
+				}
+				continue
+			}
+			select {
+			case more := <-q.batches:
+				if more.flush != nil {
+					barrier = more.flush
+					break coalesce
+				}
+				batch = append(batch, more.logs...)
+				walSegments = append(walSegments, more.walSegment)
+			case <-flush:
+				break coalesce
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+
+		// Segments of failed batches stay around for ReplayWAL.
+		if err := q.engine.Index(batch); err != nil {
+			logger.Printf("error indexing: %v\n", err)
+		} else {
+			q.engine.releaseWAL(walSegments...)
+		}
+		atomic.AddInt64(&q.pending, -int64(len(batch)))
+		if barrier != nil {
+			barrier.wait()
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package firlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueCoalescing(t *testing.T) {
+	tests := []struct {
+		name         string
+		requests     []int
+		maxBatchSize int
+		batches      uint64
+	}{
+		{"all in one batch", []int{1, 1, 1}, 10, 1},
+		{"split at the max", []int{1, 1, 1, 1, 1, 1, 1}, 5, 2},
+		{"requests kept whole", []int{2, 2, 2, 2}, 3, 2},
+		{"requests over the max alone", []int{3, 3}, 2, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newTestEngine(t)
+			q := &indexQueue{
+				engine:       e,
+				batches:      make(chan queuedLogs, len(test.requests)),
+				maxBatchSize: test.maxBatchSize,
+				workers:      1,
+			}
+			total := 0
+			for i, size := range test.requests {
+				q.batches <- queuedLogs{logs: testLogs(e, string(rune('a'+i)), size)}
+				q.pending += int64(size)
+				total += size
+			}
+			// With everything waiting already, the worker coalesces as
+			// much as it can right away.
+			go q.work()
+			deadline := time.Now().Add(10 * time.Second)
+			for atomic.LoadInt64(&q.pending) > 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+
+			stats, err := e.IndexStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var batches uint64
+			for _, indexStats := range stats {
+				batches += indexStats.Batches
+			}
+			if batches != test.batches {
+				t.Errorf("got %d batches, want %d", batches, test.batches)
+			}
+			if count := docCount(t, e); count != uint64(total) {
+				t.Errorf("got %d logs indexed, want %d", count, total)
+			}
+			if pending := atomic.LoadInt64(&q.pending); pending != 0 {
+				t.Errorf("got %d logs pending, want 0", pending)
+			}
+		})
+	}
+}
+
+func TestQueueFlushDrains(t *testing.T) {
+	tests := []struct {
+		name          string
+		workers       int
+		flushInterval time.Duration
+	}{
+		{"one worker", 1, 0},
+		{"several workers", 4, 0},
+		{"waiting for batches to fill up", 3, 50 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newTestEngine(t)
+			e.StartQueue(100, test.workers, 5, test.flushInterval)
+			for i := 0; i < 20; i++ {
+				if err := e.Enqueue(testLogs(e, string(rune('a'+i)), 3)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := e.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if count := docCount(t, e); count != 60 {
+				t.Errorf("got %d logs indexed after Flush, want 60", count)
+			}
+			if _, pending := e.QueueDepth(); pending != 0 {
+				t.Errorf("got %d logs pending after Flush, want 0", pending)
+			}
+		})
+	}
+}
+
+func TestQueueFlushInterval(t *testing.T) {
+	tests := []struct {
+		name          string
+		flushInterval time.Duration
+		batches       uint64
+	}{
+		{"indexed right away", 0, 3},
+		{"waiting for the batch to fill up", time.Second, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newTestEngine(t)
+			e.StartQueue(10, 1, 6, test.flushInterval)
+			for i := 0; i < 3; i++ {
+				if err := e.Enqueue(testLogs(e, string(rune('a'+i)), 2)); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			// The third request fills the batch up, well before the interval.
+			deadline := time.Now().Add(10 * time.Second)
+			for _, pending := e.QueueDepth(); pending > 0 && time.Now().Before(deadline); _, pending = e.QueueDepth() {
+				time.Sleep(time.Millisecond)
+			}
+
+			stats, err := e.IndexStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var batches uint64
+			for _, indexStats := range stats {
+				batches += indexStats.Batches
+			}
+			if batches != test.batches {
+				t.Errorf("got %d batches, want %d", batches, test.batches)
+			}
+		})
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	e := newTestEngine(t)
+	e.WAL = true
+	// Without workers nothing leaves the queue.
+	e.StartQueue(2, 0, 5, 0)
+	for i := 0; i < 2; i++ {
+		if err := e.Enqueue(testLogs(e, "accepted", 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Enqueue(testLogs(e, "rejected", 1)); err != ErrQueueFull {
+		t.Fatalf("got %v enqueuing in a full queue, want ErrQueueFull", err)
+	}
+	segments, err := filepath.Glob(filepath.Join(e.dataDir, walDirName, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Errorf("got %d wal segments, want those of the 2 accepted requests", len(segments))
+	}
+	if requests, pending := e.QueueDepth(); requests != 2 || pending != 2 {
+		t.Errorf("got %d requests and %d logs queued, want 2 and 2", requests, pending)
+	}
+}
+
+func TestBulkQueueFull(t *testing.T) {
+	app := NewApp(t.TempDir(), []string{"app1"})
+	app.QueueSize = 1
+	app.QueueWorkers = 0
+
+	statuses := []int{}
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/bulk/app1", strings.NewReader("<14>1 2026-10-14T12:00:00Z host app - - - hello\n"))
+		w := httptest.NewRecorder()
+		app.handleBulk(w, r)
+		statuses = append(statuses, w.Code)
+	}
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusTooManyRequests {
+		t.Errorf("got statuses %v, want [200 429]", statuses)
+	}
+}
@@ -0,0 +1,73 @@
+package firlog
+
+import (
+	"sync"
+	"time"
+)
+
+const ipLimiterSweepInterval = time.Minute
+
+// ipLimiter is a token bucket per remote IP, refilled at `rate` requests per
+// second up to `burst`.
+type ipLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*ipBucket
+	lastSweep time.Time
+}
+
+type ipBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newIPLimiter(rate float64, burst int) *ipLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &ipLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   map[string]*ipBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *ipLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > ipLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		bucket = &ipBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// sweep forgets the buckets that refilled completely, they are no different
+// from new ones.
+func (l *ipLimiter) sweep(now time.Time) {
+	for ip, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,46 @@
+package firlog
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIPRateLimit(t *testing.T) {
+	app := newTestApp(t, "")
+	app.ipLimiter = newIPLimiter(1, 2)
+	handler := app.handler("user", "pass")
+	bulk := func(ip, token string) int {
+		r := httptest.NewRequest("POST", "/bulk/"+token, strings.NewReader(syslogLine("started")))
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Limited before the token is checked.
+	codes := []int{bulk("10.0.0.1", "app1"), bulk("10.0.0.1", "nope"), bulk("10.0.0.1", "app1"), bulk("10.0.0.2", "app1")}
+	if codes[0] != 200 || codes[1] != 401 || codes[2] != 429 || codes[3] != 200 {
+		t.Errorf("expected 200, 401, 429 then 200 for another IP, got %v", codes)
+	}
+
+	app.ipLimiter.mu.Lock()
+	app.ipLimiter.buckets["10.0.0.1"].last = time.Now().Add(-time.Second)
+	app.ipLimiter.mu.Unlock()
+	if code := bulk("10.0.0.1", "app1"); code != 200 {
+		t.Errorf("expected the bucket to refill, got %d", code)
+	}
+}
+
+func TestIPLimiterSweep(t *testing.T) {
+	limiter := newIPLimiter(10, 5)
+	limiter.allow("10.0.0.1")
+	limiter.allow("10.0.0.2")
+	limiter.buckets["10.0.0.1"].last = time.Now().Add(-time.Second)
+	limiter.lastSweep = time.Now().Add(-2 * ipLimiterSweepInterval)
+	limiter.allow("10.0.0.3")
+	if _, ok := limiter.buckets["10.0.0.1"]; ok || len(limiter.buckets) != 2 {
+		t.Errorf("expected only the refilled bucket to be forgotten, got %v", limiter.buckets)
+	}
+}
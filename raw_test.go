@@ -0,0 +1,55 @@
+package firlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRawField(t *testing.T) {
+	app := newTestApp(t, `{
+		"rawField": true,
+		"multiline": true,
+		"extractRules": [{"pattern": "password=(?P<password>\\S+)"}],
+		"redactRules": [{"field": "password"}]
+	}`)
+	engine := app.engineForToken("app1")
+	postBulk(t, app, "text/plain", strings.Join([]string{
+		"<14>1 2026-10-14T12:00:00Z web-1 api - - - request served in 12ms",
+		"<11>1 2026-10-14T12:00:01Z web-1 api - - - login failed password=hunter2",
+		"    at auth.check(password=hunter2)",
+		"",
+	}, "\n"))
+
+	logs := engine.Recent(10)
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 logs, got %d", len(logs))
+	}
+	raws := map[string]string{}
+	for _, log := range logs {
+		found, err := engine.Get(log.Id)
+		if err != nil || found == nil {
+			t.Fatalf("expected log %s to be stored, got %v", log.Id, err)
+		}
+		raw, _ := found.Data[rawField].(string)
+		raws[found.Data["host"].(string)+" "+found.Data["msg"].(string)[:5]] = raw
+	}
+	if raw := raws["web-1 reque"]; raw != "<14>1 2026-10-14T12:00:00Z web-1 api - - - request served in 12ms" {
+		t.Errorf("expected the line to be kept as is, got %q", raw)
+	}
+	if raw := raws["web-1 login"]; raw != "<11>1 2026-10-14T12:00:01Z web-1 api - - - login failed password=[REDACTED]\n    at auth.check(password=[REDACTED])" {
+		t.Errorf("expected both lines to be kept, redacted, got %q", raw)
+	}
+
+	for q, want := range map[string]uint64{
+		`_raw:"web-1 api"`: 2,
+		"_raw:12ms":        1,
+		"_raw:auth.check":  1,
+		"_raw:hunter2":     0,
+		"hunter2":          0,
+		"password:hunter2": 0,
+	} {
+		if total := searchTotal(t, engine, q); total != want {
+			t.Errorf("expected %s to match %d logs, got %d", q, want, total)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package firlog
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// ReadOnlyOpenTimeout is how long read-only engines wait for an index locked
+// by another process, like a running server, before giving up on it.
+const ReadOnlyOpenTimeout = time.Second
+
+var (
+	// ErrReadOnly is returned by the engine methods writing to indexes of
+	// read-only engines.
+	ErrReadOnly    = errors.New("engine is read-only")
+	errIndexLocked = errors.New("index is locked by another process")
+)
+
+// OpenEngineReadOnly opens the existing indexes of dataDir read-only, for
+// tools querying the data directory of a server. It never creates indexes
+// and rejects Index, Enqueue, Update and the other methods writing to them
+// with ErrReadOnly.
+//
+// Indexes are bolt files, which a single process can have opened for writing
+// at a time and none for reading meanwhile. The indexes a running server has
+// opened, usually all of them, are reported broken as locked after
+// ReadOnlyOpenTimeout, RepairIndex trying them again once it stopped. Indexes
+// opened read-only in turn keep a server from opening them until they're
+// closed. Searches of a read-only engine see what was indexed before it
+// opened each index.
+func OpenEngineReadOnly(dataDir string) (*Engine, error) {
+	if _, err := os.Stat(dataDir); err != nil {
+		return nil, err
+	}
+	return newEngine(dataDir, 0, true)
+}
+
+// NewReadOnlyApp is NewApp with read-only engines, see OpenEngineReadOnly.
+// Started, it only answers GET and HEAD requests.
+func NewReadOnlyApp(dataDir string, tokens []string) *App {
+	app := NewApp(dataDir, tokens)
+	app.readOnly = true
+	return app
+}
+
+// openIndex opens the index at path, read-only for read-only engines.
+func (e *Engine) openIndex(path string) (bleve.Index, error) {
+	if !e.readOnly {
+		return bleve.Open(path)
+	}
+
+	type openResult struct {
+		index bleve.Index
+		err   error
+	}
+	opened := make(chan openResult, 1)
+	go func() {
+		index, err := bleve.OpenUsing(path, map[string]interface{}{"read_only": true})
+		opened <- openResult{index: index, err: err}
+	}()
+	select {
+	case result := <-opened:
+		return result.index, result.err
+	case <-time.After(ReadOnlyOpenTimeout):
+		// Opening goes on until the lock is released, the index being
+		// closed right away then.
+		go func() {
+			if result := <-opened; result.err == nil {
+				result.index.Close()
+			}
+		}()
+		return nil, errIndexLocked
+	}
+}
+
+// readOnlyMiddleware rejects the requests of read-only apps that could
+// write.
+func (app *App) readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.readOnly && r.Method != "GET" && r.Method != "HEAD" {
+			writeError(w, 405, errorCodeMethodNotAllowed, "firlog is read-only, only GET supported")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,78 @@
+package firlog
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenEngineReadOnly(t *testing.T) {
+	if _, err := OpenEngineReadOnly(filepath.Join(t.TempDir(), "app1")); !os.IsNotExist(err) {
+		t.Errorf("expected missing data directories to be left alone, got %v", err)
+	}
+
+	writer := newTestEngine(t)
+	logs := testLogs(writer, "started", 2)
+	logs[0].Time = testTime.Add(-24 * time.Hour)
+	if err := writer.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	// The writer keeps today's index, closing yesterday's.
+	indexes := writer.snapshotIndexes()
+	if err := indexes["20261013"].Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := OpenEngineReadOnly(writer.dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if broken := engine.BrokenIndexes(); len(broken) != 1 || broken["20261014"] != errIndexLocked.Error() {
+		t.Errorf("expected the index the writer holds to be reported locked, got %v", broken)
+	}
+	if total := searchTotal(t, engine, "started"); total != 1 {
+		t.Errorf("expected the unlocked index to be searched, got %d logs", total)
+	}
+
+	if err := engine.Index(testLogs(engine, "more", 1)); err != ErrReadOnly {
+		t.Errorf("expected indexing to fail, got %v", err)
+	}
+	if _, err := engine.Update(logs[0].Id, map[string]interface{}{"user": "bob"}); err != ErrReadOnly {
+		t.Errorf("expected updates to fail, got %v", err)
+	}
+	if err := engine.QuarantineIndex("20261013"); err != ErrReadOnly {
+		t.Errorf("expected quarantining to fail, got %v", err)
+	}
+	if _, err := engine.indexFor("20261012"); err != ErrReadOnly {
+		t.Errorf("expected no index to be created, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(engine.dataDir, "20261012")); !os.IsNotExist(err) {
+		t.Errorf("expected no index directory to be created, got %v", err)
+	}
+}
+
+func TestReadOnlyApp(t *testing.T) {
+	dataDir := t.TempDir()
+	writer := NewApp(dataDir, []string{"app1"})
+	writer.QueueSize = 0
+	postBulk(t, writer, "text/plain", syslogLine("started"))
+	for _, index := range writer.engineForToken("app1").snapshotIndexes() {
+		index.Close()
+	}
+
+	app := NewReadOnlyApp(dataDir, []string{"app1"})
+	var response struct {
+		Total int `json:"total"`
+	}
+	if w := getJSON(t, app, "/search?token=app1"+dashboardRange, &response); w.Code != 200 || response.Total != 1 {
+		t.Errorf("expected searches to work, got %d: %d logs", w.Code, response.Total)
+	}
+	w := httptest.NewRecorder()
+	app.handler("user", "pass").ServeHTTP(w, httptest.NewRequest("POST", "/bulk/app1", strings.NewReader(syslogLine("rejected"))))
+	if w.Code != 405 || !strings.Contains(w.Body.String(), "read-only") {
+		t.Errorf("expected writes to be rejected, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,212 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// DefaultRecentSize is how many of the newest logs of each token are kept in
+// memory for `/recent`.
+const DefaultRecentSize = 1000
+
+// recentLogs is a ring buffer of the newest logs accepted for indexing.
+type recentLogs struct {
+	mu   sync.Mutex
+	logs []*Log
+	next int
+}
+
+// add adds logs, evicting the oldest ones past size.
+func (r *recentLogs) add(logs []*Log, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, log := range logs {
+		if len(r.logs) < size {
+			r.logs = append(r.logs, log)
+		} else {
+			r.logs[r.next] = log
+		}
+		r.next = (r.next + 1) % size
+	}
+}
+
+// newest returns copies of up to limit of the logs, the last received first,
+// so callers can display and change them.
+func (r *recentLogs) newest(limit int) []*Log {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.copies(limit)
+}
+
+// copies is newest, with mu held.
+func (r *recentLogs) copies(limit int) []*Log {
+	logs := []*Log{}
+	for i := 1; i <= len(r.logs) && len(logs) < limit; i++ {
+		logs = append(logs, copyLog(r.logs[(r.next-i+len(r.logs))%len(r.logs)]))
+	}
+	return logs
+}
+
+// after returns copies of up to limit of the logs received after the one
+// stored under id, the oldest first, and false with all of them when that
+// one isn't there anymore, or never was.
+func (r *recentLogs) after(id string, limit int) ([]*Log, bool) {
+	r.mu.Lock()
+	newest := r.copies(len(r.logs))
+	r.mu.Unlock()
+
+	found := false
+	for i, log := range newest {
+		if log.Id == id {
+			newest, found = newest[:i], true
+			break
+		}
+	}
+	if len(newest) > limit {
+		newest = newest[:limit]
+	}
+	logs := []*Log{}
+	for i := len(newest) - 1; i >= 0; i-- {
+		logs = append(logs, newest[i])
+	}
+	return logs, found
+}
+
+// Recent returns up to limit of the newest logs accepted by Enqueue since
+// startup, the last received first, whether they were indexed yet or not.
+func (e *Engine) Recent(limit int) []*Log {
+	return e.displayRecent(e.recent.newest(limit))
+}
+
+// RecentAfter returns up to limit of the logs accepted by Enqueue after the
+// one stored under id, the oldest first, for following a token's newest
+// logs. found is false when that one isn't among the recent logs anymore,
+// the logs returned then being all of them.
+func (e *Engine) RecentAfter(id string, limit int) (logs []*Log, found bool) {
+	logs, found = e.recent.after(id, limit)
+	return e.displayRecent(logs), found
+}
+
+func (e *Engine) displayRecent(logs []*Log) []*Log {
+	config := e.config()
+	for _, log := range logs {
+		config.display(log)
+	}
+	return logs
+}
+
+// copyRecent copies logs for recordRecent, indexing them changing their
+// fields.
+func (e *Engine) copyRecent(logs []*Log) []*Log {
+	if e.RecentSize <= 0 {
+		return nil
+	}
+	copies := []*Log{}
+	for _, log := range logs {
+		copies = append(copies, copyLog(log))
+	}
+	return copies
+}
+
+// copyLog copies log and its fields, leaving out how it's displayed.
+func copyLog(log *Log) *Log {
+	data := make(map[string]interface{}, len(log.Data))
+	for key, value := range log.Data {
+		data[key] = value
+	}
+	return &Log{Id: log.Id, Time: log.Time, Data: data}
+}
+
+// recordRecent adds the copies of copyRecent to the recent logs.
+func (e *Engine) recordRecent(copies []*Log) {
+	if e.RecentSize > 0 {
+		e.recent.add(copies, e.RecentSize)
+	}
+}
+
+// handleRecent answers the newest logs received for a token straight from
+// memory, without searching its indexes.
+func (app *App) handleRecent(w http.ResponseWriter, r *http.Request) {
+	engine, limit, ok := app.recentParams(w, r)
+	if !ok {
+		return
+	}
+	writeRecent(w, map[string]interface{}{
+		"logs": app.recentFields(engine.Recent(limit)),
+	})
+}
+
+// handleTail answers the logs received for a token after the one of the
+// `after` ID, the oldest first, for clients polling it with the last ID they
+// got to follow new logs as they arrive.
+func (app *App) handleTail(w http.ResponseWriter, r *http.Request) {
+	engine, limit, ok := app.recentParams(w, r)
+	if !ok {
+		return
+	}
+	after := r.URL.Query().Get("after")
+	logs, found := engine.RecentAfter(after, limit)
+	last := after
+	if len(logs) > 0 {
+		last = logs[len(logs)-1].Id
+	}
+	writeRecent(w, map[string]interface{}{
+		"logs": app.recentFields(logs),
+		"last": last,
+		// Logs may have been missed since after, evicted before this poll.
+		"gap": after != "" && !found,
+	})
+}
+
+// recentParams reads the token and limit of `/recent` and `/tail`, the
+// first token the user can see and RecentSize by default, answering an
+// error when they're invalid.
+func (app *App) recentParams(w http.ResponseWriter, r *http.Request) (*Engine, int, bool) {
+	if r.Method != "GET" {
+		writeError(w, 405, errorCodeMethodNotAllowed, "only GET supported")
+		return nil, 0, false
+	}
+	tokens := app.allowedTokens(r)
+	token := r.URL.Query().Get("token")
+	if token == "" && len(tokens) > 0 {
+		token = tokens[0]
+	}
+	if !contains(tokens, token) {
+		writeError(w, 400, errorCodeInvalidToken, errUnknownToken.Error())
+		return nil, 0, false
+	}
+	limit := app.RecentSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeError(w, 400, errorCodeBadRequest, errInvalidLimit.Error())
+			return nil, 0, false
+		}
+		limit = parsed
+	}
+	return app.engineForToken(token), limit, true
+}
+
+func (app *App) recentFields(logs []*Log) []interface{} {
+	fields := []interface{}{}
+	for _, log := range logs {
+		fields = append(fields, app.orderedFields(log.Data))
+	}
+	return fields
+}
+
+// writeRecent writes response along with the count of its logs.
+func writeRecent(w http.ResponseWriter, response map[string]interface{}) {
+	response["count"] = len(response["logs"].([]interface{}))
+	w.Header().Set("Content-Type", "application/json")
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		writeError(w, 500, errorCodeInternal, "Error serializing response")
+		return
+	}
+	w.Write(responseJSON)
+}
@@ -0,0 +1,110 @@
+package firlog
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecentHoldsEnqueuedLogsRightAway(t *testing.T) {
+	e := newTestEngine(t)
+	e.RecentSize = 3
+	// Without workers, logs are only in the queue.
+	e.StartQueue(2, 0, 5, 0)
+	if err := e.Enqueue(testLogs(e, "first", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Enqueue(testLogs(e, "second", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Enqueue(testLogs(e, "rejected", 1)); err != ErrQueueFull {
+		t.Fatalf("got %v, want ErrQueueFull", err)
+	}
+
+	messages := []interface{}{}
+	for _, log := range e.Recent(10) {
+		messages = append(messages, log.Data["msg"])
+	}
+	want := []interface{}{"second 1", "second 0", "first 1"}
+	if len(messages) != len(want) {
+		t.Fatalf("got %v, want %v", messages, want)
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Errorf("got %v, want %v", messages, want)
+			break
+		}
+	}
+}
+
+func TestRecentHoldsIndexedLogsRightAway(t *testing.T) {
+	e := newTestEngine(t)
+	e.RecentSize = 10
+	logs := testLogs(e, "synchronous", 2)
+	if err := e.Enqueue(logs); err != nil {
+		t.Fatal(err)
+	}
+	recent := e.Recent(10)
+	if len(recent) != 2 || recent[0].Id != logs[1].Id {
+		t.Fatalf("got %d logs, want the 2 indexed ones, newest first", len(recent))
+	}
+	// The ring's logs aren't the ones handed out.
+	recent[0].Data["msg"] = "changed"
+	if again := e.Recent(1); again[0].Data["msg"] == "changed" {
+		t.Error("got a change to a returned log into the recent logs")
+	}
+}
+
+func TestTail(t *testing.T) {
+	app := NewApp(t.TempDir(), []string{"app1"})
+	app.QueueSize = 0
+	app.RecentSize = 3
+	engine := app.engineForToken("app1")
+	logs := testLogs(engine, "tailed", 5)
+	for _, log := range logs {
+		// Like parsed logs, which carry their ID.
+		log.Data["id"] = log.Id
+	}
+	if err := engine.Enqueue(logs[:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	tail := func(after string) (ids []string, last string, gap bool) {
+		r := httptest.NewRequest("GET", "/tail?token=app1&after="+after, nil)
+		w := httptest.NewRecorder()
+		app.handleTail(w, r)
+		var response struct {
+			Logs []map[string]interface{} `json:"logs"`
+			Last string                   `json:"last"`
+			Gap  bool                     `json:"gap"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%v: %s", err, w.Body)
+		}
+		for _, log := range response.Logs {
+			ids = append(ids, log["id"].(string))
+		}
+		return ids, response.Last, response.Gap
+	}
+
+	ids, last, gap := tail("")
+	if len(ids) != 2 || ids[0] != logs[0].Id || last != logs[1].Id || gap {
+		t.Fatalf("got %v, last %s, gap %v, want the first 2 logs oldest first", ids, last, gap)
+	}
+	if ids, again, _ := tail(last); len(ids) != 0 || again != last {
+		t.Errorf("got %v, last %s, want nothing new", ids, again)
+	}
+	if err := engine.Enqueue(logs[2:3]); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _, _ := tail(last); len(ids) != 1 || ids[0] != logs[2].Id {
+		t.Errorf("got %v, want the new log only", ids)
+	}
+	// Past the ring's size, the log polled last is evicted.
+	if err := engine.Enqueue(logs[3:]); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _, gap := tail(logs[0].Id); len(ids) != 3 || !gap {
+		t.Errorf("got %v, gap %v, want the 3 logs kept and a gap", ids, gap)
+	}
+}
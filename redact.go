@@ -0,0 +1,187 @@
+package firlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultRedactPlaceholder = "[REDACTED]"
+
+	RedactActionMask   = "mask"
+	RedactActionRemove = "remove"
+)
+
+// RedactRule keeps secrets out of stored logs. A rule with only a Field
+// masks or removes that field entirely. A rule with a Pattern masks the
+// parts of values matching it, or removes fields whose value matches, in
+// Field or in every string field when it's empty.
+type RedactRule struct {
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"`
+	// Action is "mask" (the default), replacing with the placeholder, or
+	// "remove".
+	Action string `json:"action"`
+
+	regexp *regexp.Regexp
+}
+
+func compileRedactRules(rules []*RedactRule) error {
+	for i, rule := range rules {
+		if rule.Field == "" && rule.Pattern == "" {
+			return fmt.Errorf("redact rule %d: needs a field or a pattern", i)
+		}
+		if rule.Action == "" {
+			rule.Action = RedactActionMask
+		}
+		if rule.Action != RedactActionMask && rule.Action != RedactActionRemove {
+			return fmt.Errorf("redact rule %d: unknown action '%s'", i, rule.Action)
+		}
+		if rule.Pattern == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("redact rule %d: %v", i, err)
+		}
+		rule.regexp = compiled
+	}
+	return nil
+}
+
+// redact applies the redaction rules in order, returning the strings and
+// numbers they took out of data for redactRaw to take out of the line data
+// was parsed from too. What they took out is masked in the other strings of
+// data as well, like the message a field was extracted from. `id` and `time`
+// are needed to store logs so they're never redacted.
+func (c *TokenConfig) redact(data map[string]interface{}) []string {
+	secrets := []string{}
+	for _, rule := range c.RedactRules {
+		if rule.Field != "" {
+			secrets = append(secrets, c.redactField(rule, data, rule.Field)...)
+			continue
+		}
+		for field := range data {
+			secrets = append(secrets, c.redactField(rule, data, field)...)
+		}
+	}
+	if len(secrets) == 0 {
+		return secrets
+	}
+	// Longest first so secrets holding others get masked whole.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	for field, value := range data {
+		if field != "id" && field != "time" {
+			data[field] = c.maskSecrets(value, secrets)
+		}
+	}
+	return secrets
+}
+
+// maskSecrets masks secrets in the strings value holds.
+func (c *TokenConfig) maskSecrets(value interface{}, secrets []string) interface{} {
+	switch v := value.(type) {
+	case string:
+		for _, secret := range secrets {
+			if secret != "" {
+				v = strings.Replace(v, secret, c.redactPlaceholder(), -1)
+			}
+		}
+		return v
+	case map[string]interface{}:
+		for key, nested := range v {
+			v[key] = c.maskSecrets(nested, secrets)
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = c.maskSecrets(nested, secrets)
+		}
+	}
+	return value
+}
+
+// redactRaw masks secrets, as sorted by redact, in a raw line as they'd
+// appear in it, plain or JSON escaped, then what the pattern rules that aren't
+// limited to a field match, whatever their action. Short secrets mask more of
+// the line than they came from, never less.
+func (c *TokenConfig) redactRaw(line string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		line = strings.Replace(line, secret, c.redactPlaceholder(), -1)
+		escaped, _ := json.Marshal(secret)
+		line = strings.Replace(line, string(escaped[1:len(escaped)-1]), c.redactPlaceholder(), -1)
+	}
+	for _, rule := range c.RedactRules {
+		if rule.Field == "" && rule.regexp != nil {
+			line = rule.regexp.ReplaceAllLiteralString(line, c.redactPlaceholder())
+		}
+	}
+	return line
+}
+
+// redactField applies rule to field of data, returning what it took out.
+func (c *TokenConfig) redactField(rule *RedactRule, data map[string]interface{}, field string) []string {
+	if field == "id" || field == "time" {
+		return nil
+	}
+	value, ok := data[field]
+	if !ok {
+		return nil
+	}
+
+	if rule.regexp == nil {
+		if rule.Action == RedactActionRemove {
+			delete(data, field)
+		} else {
+			data[field] = c.redactPlaceholder()
+		}
+		return redactedValues(value)
+	}
+
+	text, ok := value.(string)
+	if !ok || !rule.regexp.MatchString(text) {
+		return nil
+	}
+	if rule.Action == RedactActionRemove {
+		delete(data, field)
+		return []string{text}
+	}
+	data[field] = rule.regexp.ReplaceAllLiteralString(text, c.redactPlaceholder())
+	return rule.regexp.FindAllString(text, -1)
+}
+
+// redactedValues returns the strings and numbers value holds, nested in
+// objects and arrays included, as they'd be written in a line.
+func redactedValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case float64, int, int64:
+		return []string{fmt.Sprint(v)}
+	case map[string]interface{}:
+		values := []string{}
+		for _, nested := range v {
+			values = append(values, redactedValues(nested)...)
+		}
+		return values
+	case []interface{}:
+		values := []string{}
+		for _, nested := range v {
+			values = append(values, redactedValues(nested)...)
+		}
+		return values
+	}
+	return nil
+}
+
+func (c *TokenConfig) redactPlaceholder() string {
+	if c.RedactPlaceholder == "" {
+		return DefaultRedactPlaceholder
+	}
+	return c.RedactPlaceholder
+}
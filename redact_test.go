@@ -0,0 +1,107 @@
+package firlog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const redactConfig = `{
+	"rawField": true,
+	"extractRules": [{"pattern": "user=(?P<user>\\w+) pin=(?P<pin>\\d+)"}],
+	"redactRules": [
+		{"field": "password"},
+		{"field": "token", "action": "remove"},
+		{"field": "card", "pattern": "\\d{4}-\\d{4}"},
+		{"field": "pin"},
+		{"field": "auth"},
+		{"pattern": "sk_live_\\w+"}
+	]
+}`
+
+// redactedSecrets are the values redactConfig must keep out of the logs
+// redactLines are parsed from.
+var redactedSecrets = []string{"hunter2", "tok-secret-9", "1234-5678", "4321", "s3cr\"et", "sk_live_abc"}
+
+const redactLines = `{"msg": "login", "password": "hunter2", "token": "tok-secret-9", "card": "number 1234-5678", "auth": {"header": "s3cr\"et"}, "note": "key sk_live_abc"}
+{"msg": "logged in user=alice pin=4321"}
+`
+
+// storedRaw returns the raw line stored for the log of id with StoreRaw.
+func storedRaw(t *testing.T, e *Engine, id string) string {
+	t.Helper()
+	_, index, err := e.find(id)
+	if err != nil || index == nil {
+		t.Fatalf("expected log %s to be stored, got %v", id, err)
+	}
+	raw, err := index.GetInternal(rawKey(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestRedactedValuesAreNeitherStoredNorSearchable(t *testing.T) {
+	app := newTestApp(t, redactConfig)
+	app.StoreRaw = true
+	engine := app.engineForToken("app1")
+	postBulk(t, app, "application/x-ndjson", redactLines)
+
+	stored := []string{}
+	for _, log := range engine.Recent(10) {
+		found, err := engine.Get(log.Id)
+		if err != nil || found == nil {
+			t.Fatalf("expected log %s to be stored, got %v", log.Id, err)
+		}
+		raw, ok := found.Data[rawField].(string)
+		if !ok || raw == "" {
+			t.Errorf("expected log %s to keep its line in %s, got %v", log.Id, rawField, found.Data)
+		}
+		data, _ := json.Marshal(found.Data)
+		stored = append(stored, string(data), storedRaw(t, engine, log.Id))
+	}
+	if len(stored) != 4 {
+		t.Fatalf("expected 2 logs, got %d", len(stored)/2)
+	}
+	for _, secret := range redactedSecrets {
+		for _, s := range stored {
+			if strings.Contains(s, secret) {
+				t.Errorf("expected %s to be redacted, got %s", secret, s)
+			}
+		}
+	}
+
+	for _, q := range []string{"hunter2", "password:hunter2", "_raw:hunter2", "tok", "_raw:tok", "1234", "_raw:5678", "4321", "_raw:4321", "s3cr", "_raw:s3cr", "sk_live_abc", "_raw:sk_live_abc"} {
+		if total := searchTotal(t, engine, q); total != 0 {
+			t.Errorf("expected %s to match nothing, got %d logs", q, total)
+		}
+	}
+	for q, want := range map[string]uint64{"login": 1, "_raw:number": 1, "_raw:alice": 1, "user:alice": 1, "_raw:REDACTED": 2} {
+		if total := searchTotal(t, engine, q); total != want {
+			t.Errorf("expected %s to match %d logs, got %d", q, want, total)
+		}
+	}
+}
+
+func TestRedactRaw(t *testing.T) {
+	config := &TokenConfig{RedactRules: []*RedactRule{{Field: "password"}, {Pattern: `sk_\w+`}}}
+	if err := compileRedactRules(config.RedactRules); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data map[string]interface{}
+		line string
+		want string
+	}{
+		{map[string]interface{}{"password": "a\"b"}, `{"password": "a\"b"}`, `{"password": "[REDACTED]"}`},
+		{map[string]interface{}{"password": 1234.0}, `{"password": 1234}`, `{"password": [REDACTED]}`},
+		{map[string]interface{}{"password": []interface{}{"ab", "abc"}}, `{"password": ["ab", "abc"]}`, `{"password": ["[REDACTED]", "[REDACTED]"]}`},
+		{map[string]interface{}{"password": ""}, `{"password": "", "key": "sk_123"}`, `{"password": "", "key": "[REDACTED]"}`},
+		{map[string]interface{}{"msg": "hello"}, `hello password`, `hello password`},
+	}
+	for _, test := range tests {
+		if got := config.redactRaw(test.line, config.redact(test.data)); got != test.want {
+			t.Errorf("expected %s to be redacted as %s, got %s", test.line, test.want, got)
+		}
+	}
+}
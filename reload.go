@@ -0,0 +1,118 @@
+package firlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+// newIndexesConfig are the TokenConfig settings that are part of the mapping
+// indexes get created with, so reloading them only changes new indexes.
+var newIndexesConfig = []string{"analyzer", "keywordFields", "booleanFields", "timeFormats"}
+
+// ConfigChanges are the settings a reload changed, by their `config.json`
+// key. NewIndexes are those that only apply to the indexes created from then
+// on, existing ones needing to be rebuilt to pick them up; the others apply
+// to every log indexed or displayed from then on.
+type ConfigChanges struct {
+	Changed    []string `json:"changed"`
+	NewIndexes []string `json:"newIndexes"`
+}
+
+// config is the token's current configuration.
+func (e *Engine) config() *TokenConfig {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	return e.Config
+}
+
+// ReloadConfig reads the token's `config.json` again and applies it to the
+// logs indexed and displayed from then on, keeping the current configuration
+// when the file is invalid.
+func (e *Engine) ReloadConfig() (*ConfigChanges, error) {
+	config, err := loadTokenConfig(e.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return e.setConfig(config), nil
+}
+
+func (e *Engine) setConfig(config *TokenConfig) *ConfigChanges {
+	e.configMu.Lock()
+	previous := e.Config
+	e.Config = config
+	e.configMu.Unlock()
+	return diffConfigs(previous, config)
+}
+
+// diffConfigs lists the settings of `config.json` that differ between from
+// and to.
+func diffConfigs(from, to *TokenConfig) *ConfigChanges {
+	changes := &ConfigChanges{Changed: []string{}, NewIndexes: []string{}}
+	fromFields, toFields := configFields(from), configFields(to)
+	for key, value := range toFields {
+		if reflect.DeepEqual(fromFields[key], value) {
+			continue
+		}
+		if contains(newIndexesConfig, key) {
+			changes.NewIndexes = append(changes.NewIndexes, key)
+		} else {
+			changes.Changed = append(changes.Changed, key)
+		}
+	}
+	sort.Strings(changes.Changed)
+	sort.Strings(changes.NewIndexes)
+	return changes
+}
+
+// configFields are the settings of config by their `config.json` key, as
+// they'd be written there.
+func configFields(config *TokenConfig) map[string]interface{} {
+	fields := map[string]interface{}{}
+	serialized, err := json.Marshal(config)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(serialized, &fields)
+	return fields
+}
+
+// handleReload reloads the `config.json` of every token, or of `token`, all
+// of them being checked before any is applied so an invalid one doesn't
+// leave tokens half reloaded.
+func (app *App) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, 405, errorCodeMethodNotAllowed, "only POST supported")
+		return
+	}
+	tokens := app.Tokens
+	if token := r.URL.Query().Get("token"); token != "" {
+		if !contains(app.Tokens, token) {
+			writeError(w, 404, errorCodeInvalidToken, "unknown token")
+			return
+		}
+		tokens = []string{token}
+	}
+
+	configs := map[string]*TokenConfig{}
+	for _, token := range tokens {
+		config, err := loadTokenConfig(app.engineForToken(token).dataDir)
+		if err != nil {
+			writeError(w, 400, errorCodeBadRequest, fmt.Sprintf("invalid config of %s, nothing was reloaded: %v", token, err))
+			return
+		}
+		configs[token] = config
+	}
+	changes := map[string]*ConfigChanges{}
+	for _, token := range tokens {
+		changes[token] = app.engineForToken(token).setConfig(configs[token])
+		if len(changes[token].Changed) > 0 || len(changes[token].NewIndexes) > 0 {
+			logger.Printf("reloaded config of %s, changed: %v, for new indexes: %v\n", token, changes[token].Changed, changes[token].NewIndexes)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tokens": changes})
+}
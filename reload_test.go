@@ -0,0 +1,83 @@
+package firlog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reload POSTs to `/reload` of app with query, decoding the changes of a 200.
+func reload(t *testing.T, app *App, query string) (*httptest.ResponseRecorder, map[string]*ConfigChanges) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/reload"+query, nil)
+	r.SetBasicAuth("user", "pass")
+	r.Header.Set(adminTokenHeader, app.AdminToken)
+	w := httptest.NewRecorder()
+	app.handler("user", "pass").ServeHTTP(w, r)
+	var response struct {
+		Tokens map[string]*ConfigChanges `json:"tokens"`
+	}
+	if w.Code == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, response.Tokens
+}
+
+// writeTokenConfig replaces the `config.json` of token with config.
+func writeTokenConfig(t *testing.T, app *App, token, config string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(app.DataDir, token, tokenConfigFileName), []byte(config), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	app := newTestApp(t, `{"messageField": "text"}`)
+	app.Tokens = append(app.Tokens, "app2")
+	app.AdminToken = "admin-secret"
+	engine := app.engineForToken("app1")
+	app.engineForToken("app2")
+
+	writeTokenConfig(t, app, "app1", `{"levelField": "severity", "analyzer": "en"}`)
+	w, changes := reload(t, app, "?token=app1")
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	want := map[string]*ConfigChanges{"app1": {Changed: []string{"levelField", "messageField"}, NewIndexes: []string{"analyzer"}}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("expected %+v, got %s", want["app1"], w.Body)
+	}
+	// Logs indexed from then on get the new config.
+	postSeverities(t, app)
+	var response struct {
+		Total int `json:"total"`
+	}
+	getJSON(t, app, "/search?token=app1&level=error"+dashboardRange, &response)
+	if response.Total != 2 {
+		t.Errorf("expected the reloaded level field to be used, got %d logs", response.Total)
+	}
+
+	// Nothing is reloaded when any config is invalid.
+	writeTokenConfig(t, app, "app1", `{"levelField": "level"}`)
+	writeTokenConfig(t, app, "app2", `{"analyzer": "klingon"}`)
+	if w, _ := reload(t, app, ""); w.Code != 400 || !strings.Contains(w.Body.String(), "invalid config of app2") {
+		t.Errorf("expected the invalid config to be reported, got %d: %s", w.Code, w.Body)
+	}
+	if engine.config().LevelField != "severity" {
+		t.Errorf("expected app1 to keep its config, got %+v", engine.config())
+	}
+
+	writeTokenConfig(t, app, "app2", `{}`)
+	if _, changes := reload(t, app, ""); len(changes) != 2 || !reflect.DeepEqual(changes["app2"], &ConfigChanges{Changed: []string{}, NewIndexes: []string{}}) {
+		t.Errorf("expected both tokens to be reloaded, app2 unchanged, got %+v", changes)
+	}
+	if w, _ := reload(t, app, "?token=app3"); w.Code != 404 {
+		t.Errorf("expected unknown tokens to be rejected, got %d", w.Code)
+	}
+}
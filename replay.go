@@ -0,0 +1,254 @@
+package firlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+const (
+	rawKeyPrefix     = "raw:"
+	replayChunkSize  = 1000
+	replayDateFormat = "20060102"
+)
+
+// rawKey is the internal key the raw line of the log stored under id is kept
+// at.
+func rawKey(id string) []byte {
+	return []byte(rawKeyPrefix + id)
+}
+
+// replayedID generates the ID of the log being replayed, keeping it.
+type replayedID string
+
+func (id replayedID) NewID(log *Log) string {
+	return string(id)
+}
+
+// Replay reparses the raw lines stored with StoreRaw for the logs of dates
+// between from and to (inclusive `20060102` dates, empty for unbounded)
+// through the token's current config, so fixed extract rules or time fields
+// apply to them, and reindexes them under the same IDs. enrich is applied to
+// reparsed logs like to freshly received ones. Logs without a raw line, or
+// whose line no longer parses, are left as they are and counted as skipped.
+// Alert rules aren't evaluated against replayed logs.
+func (e *Engine) Replay(from, to string, enrich func([]*Log)) (int, int, error) {
+	if e.readOnly {
+		return 0, 0, ErrReadOnly
+	}
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+
+	replayed, skipped := 0, 0
+	indexes := e.snapshotIndexes()
+	for _, key := range e.sortedIndexNames() {
+		index, ok := indexes[key]
+		if !ok || !indexInRange(key, from, to) {
+			continue
+		}
+		ids, err := docIDs(index)
+		if err != nil {
+			return replayed, skipped, err
+		}
+		for start := 0; start < len(ids); start += replayChunkSize {
+			end := start + replayChunkSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			chunkReplayed, chunkSkipped, err := e.replayChunk(key, index, ids[start:end], from, to, enrich)
+			replayed += chunkReplayed
+			skipped += chunkSkipped
+			if err != nil {
+				return replayed, skipped, fmt.Errorf("replaying %s: %v", key, err)
+			}
+		}
+	}
+	return replayed, skipped, nil
+}
+
+// docIDs lists the IDs of every log of index.
+func docIDs(index bleve.Index) ([]string, error) {
+	advanced, _, err := index.Advanced()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := advanced.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	internalIDs, err := reader.DocIDReaderAll()
+	if err != nil {
+		return nil, err
+	}
+	defer internalIDs.Close()
+
+	ids := []string{}
+	for {
+		internalID, err := internalIDs.Next()
+		if err != nil {
+			return nil, err
+		} else if internalID == nil {
+			return ids, nil
+		}
+		id, err := reader.ExternalID(internalID)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+}
+
+func (e *Engine) replayChunk(key string, index bleve.Index, ids []string, from, to string, enrich func([]*Log)) (int, int, error) {
+	logs := []*Log{}
+	skipped := 0
+	for _, id := range ids {
+		raw, err := index.GetInternal(rawKey(id))
+		if err != nil {
+			return 0, skipped, err
+		}
+		if raw == nil {
+			skipped++
+			continue
+		}
+		log, err := e.reparse(index, id, string(raw))
+		if err != nil {
+			return 0, skipped, err
+		} else if log == nil {
+			skipped++
+			continue
+		}
+		// Monthly indexes can hold dates outside of the range.
+		date := log.Time.Format(replayDateFormat)
+		if len(keyDate(key)) != len(replayDateFormat) && ((from != "" && date < from) || (to != "" && date > to)) {
+			continue
+		}
+		logs = append(logs, log)
+	}
+	if len(logs) == 0 {
+		return 0, skipped, nil
+	}
+	enrich(logs)
+
+	// Logs whose time changed can belong to another index now, they're
+	// removed from this one once they're in it.
+	batches := map[bleve.Index]*bleve.Batch{}
+	targets := []bleve.Index{}
+	moved := index.NewBatch()
+	dates := []string{}
+	for _, log := range logs {
+		dates = append(dates, log.Time.Format(replayDateFormat))
+	}
+	shards := e.shardCounts(dates)
+	for i, log := range logs {
+		target, err := e.indexFor(routeKey(dates[i], log.Id, shards[dates[i]]))
+		if err != nil {
+			return 0, skipped, err
+		}
+		if target != index {
+			moved.Delete(log.Id)
+			moved.DeleteInternal([]byte(log.Id))
+			moved.DeleteInternal(rawKey(log.Id))
+		}
+		batch, ok := batches[target]
+		if !ok {
+			batch = target.NewBatch()
+			batches[target] = batch
+			targets = append(targets, target)
+		}
+		// The JSON copy stored before SkipStoredJSON was enabled would
+		// shadow the reparsed log.
+		if e.config().SkipStoredJSON {
+			batch.DeleteInternal([]byte(log.Id))
+		}
+		if err := e.addToBatch(batch, log); err != nil {
+			return 0, skipped, err
+		}
+	}
+	for _, target := range targets {
+		if err := e.applyBatch(target, batches[target], noReopen); err != nil {
+			return 0, skipped, err
+		}
+	}
+	if moved.Size() > 0 {
+		if err := e.applyBatch(index, moved, noReopen); err != nil {
+			return 0, skipped, err
+		}
+	}
+	return len(logs), skipped, nil
+}
+
+// reparse parses the raw line of the log stored under id in index again, nil
+// if it doesn't parse anymore.
+func (e *Engine) reparse(index bleve.Index, id, raw string) (*Log, error) {
+	parse := parseLogLine
+	if strings.HasPrefix(raw, "{") {
+		// NDJSON lines without a time were given the time they were
+		// received at, which only the stored log still has.
+		data, err := loadLogData(index, id)
+		if err != nil {
+			return nil, err
+		}
+		receivedAt := time.Now().UTC()
+		if data != nil {
+			if value, ok := data["time"].(string); ok {
+				if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+					receivedAt = t
+				}
+			}
+		}
+		parse = func(line string) (*Log, error) {
+			return parseJSONLineAt(line, receivedAt)
+		}
+	}
+
+	parser := newLogParser(e.config(), replayedID(id))
+	parser.keepRaw = true
+	for _, line := range strings.Split(raw, "\n") {
+		parser.add(line, parse)
+	}
+	logs := parser.finish()
+	if len(logs) == 0 {
+		return nil, nil
+	}
+	// Continuation lines that aren't anymore are dropped, the log keeps all of
+	// its lines for later replays, redacted by the current rules.
+	logs[0].Raw = parser.config.redactRaw(raw, logs[0].redacted)
+	return logs[0], nil
+}
+
+// handleReplay reparses the stored raw lines of the logs of
+// `POST /replay?token=<token>&from=<date>&to=<date>` through the token's
+// current config.
+func (app *App) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, 405, errorCodeMethodNotAllowed, "only POST supported")
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if !contains(app.Tokens, token) {
+		writeError(w, 404, errorCodeInvalidToken, "unknown token")
+		return
+	}
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(replayDateFormat, date); date != "" && err != nil {
+			writeError(w, 400, errorCodeBadRequest, fmt.Sprintf("invalid date '%s'", date))
+			return
+		}
+	}
+
+	replayed, skipped, err := app.engineForToken(token).Replay(from, to, app.enrich)
+	if err != nil {
+		logger.Printf("error replaying %s after %d logs: %v\n", token, replayed, err)
+		writeError(w, 500, errorCodeInternal, fmt.Sprintf("Error replaying after %d logs", replayed))
+		return
+	}
+	logger.Printf("replayed %d logs of %s (%d skipped)\n", replayed, token, skipped)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"replayed": replayed, "skipped": skipped})
+}
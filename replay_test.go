@@ -0,0 +1,74 @@
+package firlog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayAppliesCurrentConfig(t *testing.T) {
+	app := newTestApp(t, `{"redactRules": [{"field": "password"}]}`)
+	app.StoreRaw = true
+	engine := app.engineForToken("app1")
+	postBulk(t, app, "text/plain", strings.Join([]string{
+		syslogLine("login failed user=alice status=401 pin=4321"),
+		syslogLine("request served status=200"),
+		"",
+	}, "\n"))
+	postBulk(t, app, "application/x-ndjson", `{"msg": "login", "password": "hunter2"}`+"\n")
+	// Logs indexed without their lines can't be replayed.
+	if err := engine.Index(testLogs(engine, "unparsed", 1)); err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]bool{}
+	for _, log := range engine.Recent(10) {
+		ids[log.Id] = true
+	}
+	if searchTotal(t, engine, "status:401") != 0 {
+		t.Fatal("expected status not to be extracted before replaying")
+	}
+
+	config := `{
+		"extractRules": [{"pattern": "user=(?P<user>\\w+) status=(?P<status>\\d+) pin=(?P<pin>\\d+)"}],
+		"redactRules": [{"field": "password"}, {"field": "pin"}]
+	}`
+	if err := ioutil.WriteFile(filepath.Join(engine.dataDir, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	replayed, skipped, err := engine.Replay("", "", func([]*Log) {})
+	if err != nil || replayed != 3 || skipped != 1 {
+		t.Fatalf("expected 3 logs replayed and 1 skipped, got %d and %d (%v)", replayed, skipped, err)
+	}
+
+	if count := docCount(t, engine); count != 4 {
+		t.Errorf("expected logs to be reindexed in place, got %d docs", count)
+	}
+	for q, want := range map[string]uint64{"status:401": 1, "user:alice": 1, "login": 2, "4321": 0, "pin:4321": 0, "hunter2": 0} {
+		if total := searchTotal(t, engine, q); total != want {
+			t.Errorf("expected %s to match %d logs, got %d", q, want, total)
+		}
+	}
+	for id := range ids {
+		found, err := engine.Get(id)
+		if err != nil || found == nil {
+			t.Fatalf("expected log %s to keep its ID, got %v", id, err)
+		}
+		if _, ok := found.Data["user"]; ok {
+			raw := storedRaw(t, engine, id)
+			if !strings.Contains(raw, "user=alice") || strings.Contains(raw, "4321") {
+				t.Errorf("expected the line to be kept with newly redacted values masked, got %s", raw)
+			}
+		}
+		data, _ := json.Marshal(found.Data)
+		for _, secret := range []string{"4321", "hunter2"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("expected %s to be redacted, got %s", secret, data)
+			}
+		}
+	}
+}
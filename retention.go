@@ -0,0 +1,246 @@
+package firlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultRetentionInterval     = 10 * time.Minute
+	DefaultRetentionLowWatermark = 0.9
+
+	RetentionScopeGlobal = "global"
+	RetentionScopeToken  = "token"
+)
+
+// RetentionReport is what a retention run deleted, indexes being named
+// `<token>/<date>`.
+type RetentionReport struct {
+	Deleted        []string `json:"deleted"`
+	ReclaimedBytes int64    `json:"reclaimedBytes"`
+}
+
+// retainedIndex is an index retention may delete.
+type retainedIndex struct {
+	engine *Engine
+	token  string
+	key    string
+	size   int64
+}
+
+// IndexSizes returns the bytes every index of the engine takes on disk,
+// opened or not.
+func (e *Engine) IndexSizes() (map[string]int64, error) {
+	names, err := listIndexes(e.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	sizes := map[string]int64{}
+	for _, name := range names {
+		size, err := dirSize(filepath.Join(e.dataDir, name))
+		if err != nil {
+			return nil, err
+		}
+		sizes[indexKey(name)] = size
+	}
+	return sizes, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	size := int64(0)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// DeleteIndex closes and removes the index stored for key, opened or not,
+// returning the bytes it took on disk.
+func (e *Engine) DeleteIndex(key string) (int64, error) {
+	if e.readOnly {
+		return 0, ErrReadOnly
+	}
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	path, err := e.indexDir(key)
+	if err != nil {
+		return 0, err
+	}
+	size, err := dirSize(path)
+	if err != nil {
+		return 0, err
+	}
+
+	e.mu.Lock()
+	if index, ok := e.indexes[key]; ok {
+		index.Close()
+		delete(e.indexes, key)
+		e.indexesChanged()
+	}
+	delete(e.broken, key)
+	e.mu.Unlock()
+	return size, os.RemoveAll(path)
+}
+
+// EnforceRetention deletes the indexes of every token holding nothing newer
+// than Retention, then, while the indexes of all tokens (or of each of them
+// with RetentionScopeToken) take more than RetentionMaxSize on disk, the
+// oldest ones until they're back under RetentionLowWatermark of it. Indexes
+// holding logs of now's date are never deleted.
+func (app *App) EnforceRetention(now time.Time) (*RetentionReport, error) {
+	report := &RetentionReport{Deleted: []string{}}
+	today := now.UTC().Format("20060102")
+
+	indexes := []*retainedIndex{}
+	engines := app.engines()
+	for _, token := range sortedTokens(engines) {
+		sizes, err := engines[token].IndexSizes()
+		if err != nil {
+			return report, fmt.Errorf("listing indexes of %s: %v", token, err)
+		}
+		for key, size := range sizes {
+			dates := indexDates(key)
+			if len(dates) == 0 || dates[len(dates)-1] >= today && dates[0] <= today {
+				continue
+			}
+			indexes = append(indexes, &retainedIndex{engine: engines[token], token: token, key: key, size: size})
+		}
+	}
+	// Oldest first, monthly indexes sorting before the dailies of the month.
+	sort.Slice(indexes, func(i, j int) bool {
+		if indexes[i].key != indexes[j].key {
+			return indexes[i].key < indexes[j].key
+		}
+		return indexes[i].token < indexes[j].token
+	})
+
+	kept := []*retainedIndex{}
+	cutoff := now.Add(-app.Retention).UTC().Format("20060102")
+	for _, index := range indexes {
+		dates := indexDates(index.key)
+		if app.Retention > 0 && dates[len(dates)-1] < cutoff {
+			if err := app.deleteRetained(index, report); err != nil {
+				return report, err
+			}
+			continue
+		}
+		kept = append(kept, index)
+	}
+
+	if app.RetentionMaxSize > 0 {
+		groups := map[string][]*retainedIndex{}
+		for _, index := range kept {
+			group := ""
+			if app.RetentionScope == RetentionScopeToken {
+				group = index.token
+			}
+			groups[group] = append(groups[group], index)
+		}
+		for group, groupIndexes := range groups {
+			// Indexes that can't be deleted still take space.
+			total, err := app.retainedSize(group)
+			if err != nil {
+				return report, err
+			}
+			if total <= app.RetentionMaxSize {
+				continue
+			}
+			lowWatermark := int64(float64(app.RetentionMaxSize) * app.retentionLowWatermark())
+			for _, index := range groupIndexes {
+				if total <= lowWatermark {
+					break
+				}
+				if err := app.deleteRetained(index, report); err != nil {
+					return report, err
+				}
+				total -= index.size
+			}
+		}
+	}
+
+	if len(report.Deleted) > 0 {
+		logger.Printf("retention deleted %d indexes (%s), reclaiming %d bytes\n",
+			len(report.Deleted), strings.Join(report.Deleted, ", "), report.ReclaimedBytes)
+	}
+	return report, nil
+}
+
+// retainedSize is the size of the indexes of token on disk, of all tokens
+// when empty.
+func (app *App) retainedSize(token string) (int64, error) {
+	total := int64(0)
+	for engineToken, engine := range app.engines() {
+		if token != "" && engineToken != token {
+			continue
+		}
+		sizes, err := engine.IndexSizes()
+		if err != nil {
+			return 0, err
+		}
+		for _, size := range sizes {
+			total += size
+		}
+	}
+	return total, nil
+}
+
+func (app *App) deleteRetained(index *retainedIndex, report *RetentionReport) error {
+	size, err := index.engine.DeleteIndex(index.key)
+	if err != nil {
+		return fmt.Errorf("deleting index %s of %s: %v", index.key, index.token, err)
+	}
+	index.size = size
+	report.Deleted = append(report.Deleted, index.token+"/"+index.key)
+	report.ReclaimedBytes += size
+	atomic.AddInt64(&app.retentionDeleted, 1)
+	atomic.AddInt64(&app.retentionReclaimed, size)
+	return nil
+}
+
+func (app *App) retentionLowWatermark() float64 {
+	if app.RetentionLowWatermark <= 0 || app.RetentionLowWatermark > 1 {
+		return DefaultRetentionLowWatermark
+	}
+	return app.RetentionLowWatermark
+}
+
+func (app *App) retentionLoop() {
+	for range time.Tick(DefaultRetentionInterval) {
+		if _, err := app.EnforceRetention(time.Now()); err != nil {
+			logger.Printf("error enforcing retention: %v\n", err)
+		}
+	}
+}
+
+// ParseSize parses a size in bytes, optionally suffixed with KB, MB, GB or
+// TB (powers of 1024).
+func ParseSize(size string) (int64, error) {
+	units := []string{"KB", "MB", "GB", "TB"}
+	multiplier := int64(1)
+	number := strings.ToUpper(strings.TrimSpace(size))
+	for i, unit := range units {
+		if strings.HasSuffix(number, unit) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit))
+			multiplier = int64(1) << (10 * uint(i+1))
+			break
+		}
+	}
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	return value * multiplier, nil
+}
@@ -0,0 +1,107 @@
+package firlog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// indexSize is how many bytes the indexes of e take on disk.
+func indexSize(t *testing.T, e *Engine) int64 {
+	t.Helper()
+	sizes, err := e.IndexSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := int64(0)
+	for _, size := range sizes {
+		total += size
+	}
+	return total
+}
+
+func indexKeys(t *testing.T, e *Engine) []string {
+	t.Helper()
+	indexes, err := e.Indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, index := range indexes {
+		keys = append(keys, index.Date)
+	}
+	return keys
+}
+
+func TestRetentionByAge(t *testing.T) {
+	app := newTestApp(t, "")
+	app.Retention = 48 * time.Hour
+	engine := app.engineForToken("app1")
+	indexDays(t, engine, 5, 1)
+	before := indexKeys(t, engine)
+
+	report, err := app.EnforceRetention(testTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Deleted, []string{"app1/20261010", "app1/20261011"}) || report.ReclaimedBytes <= 0 {
+		t.Errorf("expected the indexes older than 2 days to be deleted, got %+v", report)
+	}
+	if keys := indexKeys(t, engine); !reflect.DeepEqual(keys, before[2:]) {
+		t.Errorf("expected %v to be kept, got %v", before[2:], keys)
+	}
+	if total := searchTotal(t, engine, "log"); total != docCount(t, engine) {
+		t.Errorf("expected the kept indexes to stay searchable, got %d", total)
+	}
+}
+
+func TestRetentionBySize(t *testing.T) {
+	tests := []struct {
+		scope   string
+		deleted []string
+	}{
+		// Over the limit together, the oldest of both tokens go first.
+		{RetentionScopeGlobal, []string{"app1/20261012", "app1/20261013", "app2/20261013", "app1/20261015", "app2/20261015"}},
+		// Only app1 is over the limit on its own.
+		{RetentionScopeToken, []string{"app1/20261012", "app1/20261013", "app1/20261015"}},
+	}
+	for _, test := range tests {
+		t.Run(test.scope, func(t *testing.T) {
+			app := newTestApp(t, "")
+			app.Tokens = append(app.Tokens, "app2")
+			app.RetentionScope = test.scope
+			indexDays(t, app.engineForToken("app1"), 3, 1)
+			indexDays(t, app.engineForToken("app2"), 1, 1)
+			// Today's index is never deleted, no matter the size, the ones
+			// indexDays adds for the days around are.
+			app.RetentionMaxSize = indexSize(t, app.engineForToken("app1")) - 1
+
+			report, err := app.EnforceRetention(testTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Deleted) < 1 || len(report.Deleted) > len(test.deleted) || !reflect.DeepEqual(report.Deleted, test.deleted[:len(report.Deleted)]) {
+				t.Errorf("expected the oldest of %v to be deleted, got %v", test.deleted, report.Deleted)
+			}
+			for _, token := range []string{"app1", "app2"} {
+				if keys := indexKeys(t, app.engineForToken(token)); !contains(keys, "20261014") {
+					t.Errorf("expected the index of today to be kept for %s, got %v", token, keys)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"0": 0, "512": 512, "10KB": 10 << 10, "1 mb": 1 << 20, "2GB": 2 << 30, "1TB": 1 << 40}
+	for size, want := range tests {
+		if got, err := ParseSize(size); err != nil || got != want {
+			t.Errorf("expected %s to be %d bytes, got %d, %v", size, want, got, err)
+		}
+	}
+	for _, size := range []string{"", "-1", "1PB", "lots"} {
+		if _, err := ParseSize(size); err == nil {
+			t.Errorf("expected %q to be rejected", size)
+		}
+	}
+}
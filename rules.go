@@ -0,0 +1,64 @@
+package firlog
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const DefaultMaxExtractInput = 4096
+
+// ExtractRule promotes the named capture groups of Pattern matched against a
+// log's `msg` into fields of their own.
+type ExtractRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+
+	regexp *regexp.Regexp
+}
+
+func compileExtractRules(rules []*ExtractRule) error {
+	for _, rule := range rules {
+		compiled, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("extract rule '%s': %v", rule.Name, err)
+		}
+		rule.regexp = compiled
+	}
+	return nil
+}
+
+// extract applies the extraction rules in order. Go's regexps run in linear
+// time but only the first maxExtractInput bytes of the message are matched
+// to bound the cost of huge messages. Captures never overwrite a field that
+// is already set.
+func (c *TokenConfig) extract(data map[string]interface{}) {
+	message, ok := data["msg"].(string)
+	if !ok || len(c.ExtractRules) == 0 {
+		return
+	}
+	if len(message) > c.maxExtractInput() {
+		message = message[:c.maxExtractInput()]
+	}
+
+	for _, rule := range c.ExtractRules {
+		match := rule.regexp.FindStringSubmatch(message)
+		if match == nil {
+			continue
+		}
+		for i, name := range rule.regexp.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			if _, exists := data[name]; !exists {
+				data[name] = match[i]
+			}
+		}
+	}
+}
+
+func (c *TokenConfig) maxExtractInput() int {
+	if c.MaxExtractInput <= 0 {
+		return DefaultMaxExtractInput
+	}
+	return c.MaxExtractInput
+}
@@ -0,0 +1,52 @@
+package firlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractRules(t *testing.T) {
+	app := newTestApp(t, `{"extractRules": [
+		{"name": "request", "pattern": "(?P<method>GET|POST) (?P<path>/\\S*) (?P<status>\\d+)"},
+		{"name": "user", "pattern": "user=(?P<user>\\w+)"},
+		{"name": "host", "pattern": "from (?P<host>\\S+)"}
+	]}`)
+	engine := app.engineForToken("app1")
+	postBulk(t, app, "text/plain", syslogLine("GET /api/users 500 user=alice from proxy-1")+"\n")
+
+	logs := engine.Recent(1)
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	for field, want := range map[string]string{"method": "GET", "path": "/api/users", "status": "500", "user": "alice", "host": "host"} {
+		if value := logs[0].Data[field]; value != want {
+			t.Errorf("expected %s to be %q, got %v", field, want, value)
+		}
+	}
+	for _, q := range []string{"method:GET", "status:500", "user:alice"} {
+		if total := searchTotal(t, engine, q); total != 1 {
+			t.Errorf("expected %s to match, got %d logs", q, total)
+		}
+	}
+}
+
+func TestExtractRulesInput(t *testing.T) {
+	config := &TokenConfig{
+		ExtractRules:    []*ExtractRule{{Name: "user", Pattern: `user=(?P<user>\w+)`}},
+		MaxExtractInput: 20,
+	}
+	if err := compileExtractRules(config.ExtractRules); err != nil {
+		t.Fatal(err)
+	}
+	near := map[string]interface{}{"msg": "user=alice " + strings.Repeat("x", 100)}
+	far := map[string]interface{}{"msg": strings.Repeat("x", 100) + " user=bob"}
+	config.extract(near)
+	config.extract(far)
+	if near["user"] != "alice" || far["user"] != nil {
+		t.Errorf("expected only the first 20 bytes to be matched, got %v and %v", near["user"], far["user"])
+	}
+
+	if err := compileExtractRules([]*ExtractRule{{Name: "broken", Pattern: "(?P<user>"}}); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected invalid patterns to be rejected, got %v", err)
+	}
+}
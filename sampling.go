@@ -0,0 +1,54 @@
+package firlog
+
+import (
+	"strings"
+	"sync"
+)
+
+type sampler struct {
+	mu      sync.Mutex
+	seen    map[string]int
+	dropped int64
+}
+
+// Sample applies the token's sampling policy, keeping 1 in N logs of each
+// level listed in Config.Sampling. Kept logs are tagged with `sampled` and
+// the `sample_weight` they stand for. Errors and warnings are always kept.
+func (e *Engine) Sample(logs []*Log) []*Log {
+	sampling := e.config().Sampling
+	if len(sampling) == 0 {
+		return logs
+	}
+
+	e.sampler.mu.Lock()
+	defer e.sampler.mu.Unlock()
+
+	kept := []*Log{}
+	for _, log := range logs {
+		level := strings.ToLower(log.Level())
+		rate := sampling[level]
+		severity := levelSeverity(level)
+		if rate <= 1 || severity == "error" || severity == "warn" {
+			kept = append(kept, log)
+			continue
+		}
+
+		seen := e.sampler.seen[level]
+		e.sampler.seen[level] = (seen + 1) % rate
+		if seen != 0 {
+			e.sampler.dropped++
+			continue
+		}
+		log.Data["sampled"] = true
+		log.Data["sample_weight"] = rate
+		kept = append(kept, log)
+	}
+	return kept
+}
+
+// SampledOut returns how many logs sampling dropped since startup.
+func (e *Engine) SampledOut() int64 {
+	e.sampler.mu.Lock()
+	defer e.sampler.mu.Unlock()
+	return e.sampler.dropped
+}
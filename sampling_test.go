@@ -0,0 +1,43 @@
+package firlog
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSampling(t *testing.T) {
+	app := newTestApp(t, `{"sampling": {"info": 3, "error": 3, "warning": 3}}`)
+	lines := []string{}
+	for i := 0; i < 6; i++ {
+		for _, level := range []string{"INFO", "error", "warning", "debug"} {
+			lines = append(lines, fmt.Sprintf(`{"time": "2026-10-14T12:00:00Z", "msg": "%s %d", "level": "%s"}`, level, i, level))
+		}
+	}
+	postBulk(t, app, "application/x-ndjson", strings.Join(lines, "\n"))
+	engine := app.engineForToken("app1")
+
+	for q, want := range map[string]uint64{"level:info": 2, "level:error": 6, "level:warning": 6, "level:debug": 6} {
+		if total := searchTotal(t, engine, q); total != want {
+			t.Errorf("expected %d logs to match %s, got %d", want, q, total)
+		}
+	}
+	sampled := 0
+	for _, log := range engine.Recent(24) {
+		if log.Data["sampled"] == true {
+			sampled++
+			if log.Level() != "INFO" || log.Data["sample_weight"] != 3 {
+				t.Errorf("expected only info logs to be sampled, got %v", log.Data)
+			}
+		}
+	}
+	if sampled != 2 {
+		t.Errorf("expected kept info logs to be tagged, got %d", sampled)
+	}
+	if dropped := engine.SampledOut(); dropped != 4 {
+		t.Errorf("expected 4 logs to be dropped, got %d", dropped)
+	}
+	if body := getJSON(t, app, "/metrics", nil).Body.String(); !strings.Contains(body, `firlog_sampled_out_total{token="app1"} 4`) {
+		t.Errorf("expected dropped logs in the metrics, got %s", body)
+	}
+}
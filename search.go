@@ -0,0 +1,431 @@
+package firlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/oklog/ulid"
+)
+
+const (
+	DefaultSearchLimit  = 1000
+	MaxSearchLimit      = 10000
+	DefaultAroundWindow = 5 * time.Minute
+	MaxAroundWindow     = 24 * time.Hour
+
+	// InvertedRangeError rejects searches whose `from` is after `to`,
+	// InvertedRangeSwap searches between them anyway.
+	InvertedRangeError = "error"
+	InvertedRangeSwap  = "swap"
+
+	// SortTime sorts search results newest first, SortRelevance best
+	// matches first.
+	SortTime      = "time"
+	SortRelevance = "relevance"
+)
+
+var (
+	errUnknownToken  = errors.New("unknown token")
+	errInvalidLimit  = errors.New("invalid limit")
+	errInvalidAround = errors.New("invalid around, expected a log id")
+	errInvalidWindow = errors.New("invalid window")
+	errInvalidFrom   = errors.New("invalid from, expected an RFC3339 time")
+	errInvalidTo     = errors.New("invalid to, expected an RFC3339 time")
+	errInvertedRange = errors.New("invalid range, from is after to")
+	errFutureRange   = errors.New("invalid range, from is in the future")
+	errSearchTimeout = errors.New("search timed out, narrow it down or ask for partial results")
+	errInvalidSort   = errors.New("invalid sort, expected time or relevance")
+)
+
+// searchParams are the search options shared by the dashboard and the JSON
+// search API.
+type searchParams struct {
+	Token string
+	Query string
+	From  string
+	To    string
+	Level string
+	Limit int
+	// Sort is SortTime or SortRelevance.
+	Sort string
+	// Ascending sorts oldest first, used to read the context around a log.
+	Ascending bool
+	// Explain asks for how each log matched, which is expensive.
+	Explain bool
+	// Partial returns what was found when the search times out instead of
+	// failing it.
+	Partial bool
+	// Distinct returns the values of the field among the matching logs,
+	// sorted by DistinctSort, instead of the logs.
+	Distinct     string
+	DistinctSort string
+
+	// from and to are From and To parsed, set by checkRange.
+	from time.Time
+	to   time.Time
+	// tokens are those the requesting user can search, which AllTokens
+	// stands for.
+	tokens []string
+	// crossFields are the fields bare terms also match, see
+	// App.CrossFieldSearch.
+	crossFields []string
+	// requireTerms makes terms without `+` or `-` required, see
+	// App.DefaultOperator.
+	requireTerms bool
+	// levelField is the field Level filters and the dashboard facets, the
+	// token's LevelField or DefaultLevelField.
+	levelField string
+}
+
+// searchedTokens are the tokens params search.
+func (params *searchParams) searchedTokens() []string {
+	if params.Token == AllTokens {
+		return params.tokens
+	}
+	return []string{params.Token}
+}
+
+func (app *App) parseSearchParams(r *http.Request) (*searchParams, error) {
+	values := r.URL.Query()
+	params := &searchParams{
+		Token:        values.Get("token"),
+		Query:        values.Get("query"),
+		From:         values.Get("from"),
+		To:           values.Get("to"),
+		Level:        values.Get("level"),
+		Limit:        DefaultSearchLimit,
+		Sort:         values.Get("sort"),
+		Explain:      values.Get("explain") == "1",
+		Partial:      values.Get("partial") == "1",
+		Distinct:     values.Get("distinct"),
+		DistinctSort: values.Get("distinctSort"),
+	}
+
+	params.tokens = app.allowedTokens(r)
+	if len(params.tokens) == 0 {
+		return nil, errUnknownToken
+	}
+	if params.Token == "" {
+		params.Token = params.tokens[0]
+	}
+	if params.Token != AllTokens && !contains(params.tokens, params.Token) {
+		return nil, errUnknownToken
+	}
+	if around := values.Get("around"); around != "" {
+		if err := params.setAround(around, values.Get("window")); err != nil {
+			return nil, err
+		}
+	}
+	if params.From == "" {
+		params.From = time.Now().UTC().Add(-1 * 24 * time.Hour).Format(time.RFC3339)
+	}
+	if params.To == "" {
+		params.To = time.Now().UTC().Format(time.RFC3339)
+	}
+	if err := app.checkRange(params, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	if params.Sort == "" {
+		params.Sort = SortTime
+	} else if params.Sort != SortTime && params.Sort != SortRelevance {
+		return nil, errInvalidSort
+	}
+	if params.DistinctSort == "" {
+		params.DistinctSort = DistinctSortCount
+	} else if params.DistinctSort != DistinctSortCount && params.DistinctSort != DistinctSortAlpha {
+		return nil, errInvalidDistinctSort
+	}
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 || parsed > MaxSearchLimit {
+			return nil, errInvalidLimit
+		}
+		params.Limit = parsed
+	}
+	params.requireTerms = app.DefaultOperator == OperatorAnd
+	// Searches of several tokens use the default, like their display does.
+	params.levelField = DefaultLevelField
+	if params.Token != AllTokens {
+		if field := app.engineForToken(params.Token).config().LevelField; field != "" {
+			params.levelField = field
+		}
+	}
+	if app.CrossFieldSearch && params.Query != "" {
+		params.crossFields = app.indexGroup(params.searchedTokens()).within(params.from, params.to).textFields()
+	}
+	return params, nil
+}
+
+// setAround bounds the search to window on both sides of the time the log id
+// was generated at, sorting results oldest first.
+func (p *searchParams) setAround(id, window string) error {
+	parsed, err := ulid.Parse(id)
+	if err != nil {
+		return errInvalidAround
+	}
+	duration := DefaultAroundWindow
+	if window != "" {
+		duration, err = time.ParseDuration(window)
+		if err != nil || duration <= 0 || duration > MaxAroundWindow {
+			return errInvalidWindow
+		}
+	}
+
+	at := time.Unix(0, int64(parsed.Time())*int64(time.Millisecond)).UTC()
+	p.From = at.Add(-duration).Format(time.RFC3339Nano)
+	p.To = at.Add(duration).Format(time.RFC3339Nano)
+	p.Ascending = true
+	p.Sort = SortTime
+	return nil
+}
+
+// checkRange makes sure the searched range can match something: inverted
+// ranges are rejected or swapped, ranges starting in the future rejected and,
+// with MaxSearchAge, the part of ranges older than it dropped.
+func (app *App) checkRange(p *searchParams, now time.Time) error {
+	from, err := time.Parse(time.RFC3339Nano, p.From)
+	if err != nil {
+		return errInvalidFrom
+	}
+	to, err := time.Parse(time.RFC3339Nano, p.To)
+	if err != nil {
+		return errInvalidTo
+	}
+
+	if from.After(to) {
+		if app.InvertedRanges != InvertedRangeSwap {
+			return errInvertedRange
+		}
+		from, to = to, from
+		p.From, p.To = p.To, p.From
+	}
+	if from.After(now) {
+		return errFutureRange
+	}
+	if app.MaxSearchAge > 0 {
+		oldest := now.Add(-app.MaxSearchAge)
+		if to.Before(oldest) {
+			return fmt.Errorf("invalid range, it ends before the oldest searchable time (%s)", oldest.Format(time.RFC3339))
+		}
+		if from.Before(oldest) {
+			from = oldest
+			p.From = oldest.Format(time.RFC3339Nano)
+		}
+	}
+	p.from, p.to = from, to
+	return nil
+}
+
+// validateQuery reports syntax errors in the user's query, and queries past
+// the limits of checkQueryLimits, so they can be told apart from errors
+// executing the search.
+func (app *App) validateQuery(p *searchParams) error {
+	if p.Query == "" {
+		return nil
+	}
+	if err := app.checkQueryLimits(p.Query); err != nil {
+		return err
+	}
+	return bleve.NewQueryStringQuery(p.Query).Validate()
+}
+
+// timeQuery is the user's query bound to the searched time range. The bound
+// is a query of its own ANDed with the user's, so ORs and parentheses in the
+// user's query can't escape it.
+func (p *searchParams) timeQuery() query.Query {
+	inclusive := true
+	timeQuery := bleve.NewDateRangeInclusiveQuery(p.from, p.to, &inclusive, &inclusive)
+	timeQuery.SetField("time")
+	logger.Debugf("query %s time:>=\"%s\" time:<=\"%s\"", p.Query, p.From, p.To)
+	if p.Query == "" {
+		return timeQuery
+	}
+	// Parsed up front so explanations can show how the query was understood,
+	// invalid ones are left for the search to report.
+	var userQuery query.Query = bleve.NewQueryStringQuery(p.Query)
+	if parsed, err := bleve.NewQueryStringQuery(p.Query).Parse(); err == nil {
+		if p.requireTerms {
+			parsed = requireTerms(parsed)
+		}
+		userQuery = matchAcrossFields(matchBooleans(parsed), p.crossFields)
+	}
+	return bleve.NewConjunctionQuery(userQuery, timeQuery)
+}
+
+// searchQuery is timeQuery narrowed down by the other filters.
+func (p *searchParams) searchQuery() query.Query {
+	searchQuery := p.timeQuery()
+	if p.Level != "" {
+		levelQuery := bleve.NewMatchQuery(p.Level)
+		levelQuery.SetField(p.levelField)
+		searchQuery = bleve.NewConjunctionQuery(searchQuery, levelQuery)
+	}
+	return searchQuery
+}
+
+// tooManyIndexesError is returned for searches whose range spans more than
+// App.MaxSearchIndexes indexes.
+type tooManyIndexesError struct {
+	indexes int
+	max     int
+}
+
+func (e *tooManyIndexesError) Error() string {
+	return fmt.Sprintf("the time range spans %d indexes, more than the %d a search can go through, narrow it down", e.indexes, e.max)
+}
+
+type searchResults struct {
+	Logs []*Log
+	// Total is how many logs matched, Logs being capped by the limit.
+	Total uint64
+	// Indexes is how many indexes were searched.
+	Indexes int
+	// Explanations are how each of Logs matched, when asked for.
+	Explanations []*search.Explanation
+	// Warnings tell about indexes that had to be skipped.
+	Warnings []string
+	// Partial tells the search timed out before going through every index.
+	Partial bool
+	// Duration is how long the search took in milliseconds.
+	Duration float64
+}
+
+func (app *App) search(params *searchParams) (*searchResults, error) {
+	search := bleve.NewSearchRequest(params.searchQuery())
+	if params.Ascending {
+		search.SortBy([]string{"time", "_id"})
+	} else if params.Sort == SortRelevance {
+		// Ties broken like time sorted results, so equally scored logs come
+		// in a stable order.
+		search.SortBy([]string{"-_score", "-time", "-_id"})
+	} else {
+		search.SortBy([]string{"-time", "-_id"})
+	}
+	search.Fields = append(search.Fields, "time")
+	search.Explain = params.Explain
+	start := time.Now().UnixNano()
+	var result *SearchResult
+	// Indexes swapped by compaction or optimization while being searched
+	// are closed, searching again goes through their replacements.
+	for attempt := 0; attempt < 2 && (result == nil || result.closed); attempt++ {
+		group, err := app.searchGroup(params)
+		if err != nil {
+			return nil, err
+		}
+		if app.SearchTimeout > 0 {
+			result, err = group.searchIncrementally(search, params.Limit, app.SearchTimeout, !params.Ascending, params.Partial)
+		} else {
+			result, err = group.search(search, params.Limit)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Searching several tokens at once counts for each of them.
+	app.recordSearch(params.searchedTokens(), time.Duration(time.Now().UnixNano()-start))
+	return &searchResults{
+		Logs:         result.Logs,
+		Total:        result.Total,
+		Indexes:      result.Indexes,
+		Explanations: result.Explanations,
+		Warnings:     result.Warnings,
+		Partial:      result.Partial,
+		Duration:     float64(time.Now().UnixNano()-start) / 1000000,
+	}, nil
+}
+
+// searchGroup is the group of the indexes params' range spans, as long as
+// there are no more of them than MaxSearchIndexes.
+func (app *App) searchGroup(params *searchParams) (*indexGroup, error) {
+	group := app.indexGroup(params.searchedTokens()).within(params.from, params.to)
+	if app.MaxSearchIndexes > 0 && len(group.indexes) > app.MaxSearchIndexes {
+		return nil, &tooManyIndexesError{indexes: len(group.indexes), max: app.MaxSearchIndexes}
+	}
+	return group, nil
+}
+
+// handleSearch is the JSON counterpart of the dashboard, taking the same
+// query params.
+func (app *App) handleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params, err := app.parseSearchParams(r)
+	if err == errUnknownToken {
+		writeError(w, 400, errorCodeInvalidToken, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, 400, errorCodeBadRequest, err.Error())
+		return
+	}
+	if err := app.validateQuery(params); err != nil {
+		writeError(w, 400, errorCodeInvalidQuery, fmt.Sprintf("invalid query: %v", err))
+		return
+	}
+	if params.Distinct != "" {
+		app.handleDistinct(w, params)
+		return
+	}
+	results, err := app.search(params)
+	if _, ok := err.(*tooManyIndexesError); ok {
+		writeError(w, 400, errorCodeBadRequest, err.Error())
+		return
+	} else if err == errSearchTimeout {
+		writeError(w, 504, errorCodeTimeout, err.Error())
+		return
+	} else if err != nil {
+		logger.Println("error searching: ", err)
+		writeError(w, 500, errorCodeInternal, "Error executing search")
+		return
+	}
+	app.recordQuery(r, params)
+
+	logs := []interface{}{}
+	for _, log := range results.Logs {
+		logs = append(logs, app.orderedFields(log.Data))
+	}
+	response := map[string]interface{}{
+		"count":          len(logs),
+		"total":          results.Total,
+		"indexes":        results.Indexes,
+		"searchDuration": results.Duration,
+		"warnings":       results.Warnings,
+		"partial":        results.Partial,
+		"sort":           params.Sort,
+		"logs":           logs,
+		// The resolved range and query, defaults included, so surprising
+		// results can be traced back to what was actually searched.
+		"from":            params.from.UTC().Format(time.RFC3339Nano),
+		"to":              params.to.UTC().Format(time.RFC3339Nano),
+		"effective_query": params.searchQuery(),
+	}
+	// The fields the token's logs are displayed with, blank for the
+	// defaults, as they can't be configured across tokens.
+	response["messageField"], response["levelField"] = "", ""
+	if params.Token != AllTokens {
+		config := app.engineForToken(params.Token).config()
+		response["messageField"], response["levelField"] = config.MessageField, config.LevelField
+	}
+	if params.Explain {
+		explanations := []map[string]interface{}{}
+		for i, log := range results.Logs {
+			explanations = append(explanations, map[string]interface{}{
+				"id":          log.Id,
+				"explanation": results.Explanations[i],
+			})
+		}
+		response["explanations"] = explanations
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		writeError(w, 500, errorCodeInternal, "Error serializing response")
+		return
+	}
+	w.Write(responseJSON)
+}
@@ -0,0 +1,319 @@
+package firlog
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchEchoesEffectiveQuery(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+
+	type dateRange struct {
+		Start string `json:"start"`
+		End   string `json:"end"`
+		Field string `json:"field"`
+	}
+	var response struct {
+		Total          uint64 `json:"total"`
+		From           string `json:"from"`
+		To             string `json:"to"`
+		EffectiveQuery struct {
+			dateRange
+			Conjuncts []struct {
+				Match string `json:"match"`
+				Field string `json:"field"`
+			} `json:"conjuncts"`
+		} `json:"effective_query"`
+	}
+
+	// Without a range the last day is searched.
+	before := time.Now().UTC().Truncate(time.Second)
+	getJSON(t, app, "/search?token=app1", &response)
+	after := time.Now().UTC()
+	from, fromErr := time.Parse(time.RFC3339Nano, response.From)
+	to, toErr := time.Parse(time.RFC3339Nano, response.To)
+	if fromErr != nil || toErr != nil || to.Before(before) || to.After(after) || to.Sub(from)-24*time.Hour > time.Second {
+		t.Errorf("expected the last day to be echoed, got from %s to %s", response.From, response.To)
+	}
+	if query := response.EffectiveQuery; query.Field != "time" || query.Start != response.From || query.End != response.To {
+		t.Errorf("expected the query to be the echoed range, got %+v", query)
+	}
+
+	w := getJSON(t, app, "/search?token=app1&query=disk&level=3"+dashboardRange, &response)
+	if response.Total != 2 || response.From != "2026-10-14T00:00:00Z" || response.To != "2026-10-14T23:59:59Z" {
+		t.Errorf("expected 2 logs between the given bounds, got %d from %s to %s", response.Total, response.From, response.To)
+	}
+	conjuncts := response.EffectiveQuery.Conjuncts
+	if len(conjuncts) != 2 || conjuncts[1].Field != "level" || conjuncts[1].Match != "3" {
+		t.Fatalf("expected the query to be ANDed with the level, got %+v", response.EffectiveQuery)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"match":"disk"`) || !strings.Contains(body, `"start":"2026-10-14T00:00:00Z"`) {
+		t.Errorf("expected the query and range to be echoed, got %s", body)
+	}
+}
+
+func TestSearchAround(t *testing.T) {
+	app := newTestApp(t, "")
+	engine := app.engineForToken("app1")
+	// In order, as ULIDs never go back in time.
+	ids := &ulidSource{entropy: cryptorand.Reader}
+	logs := []*Log{}
+	for _, offset := range []time.Duration{-10 * time.Minute, -2 * time.Minute, 0, time.Minute, 3 * time.Minute} {
+		at := testTime.Add(-24 * time.Hour).Add(offset)
+		logs = append(logs, &Log{Id: ids.next(at).String(), Time: at, Data: map[string]interface{}{
+			"time": at,
+			"msg":  offset.String(),
+		}})
+	}
+	if err := engine.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	var response struct {
+		Logs []map[string]interface{} `json:"logs"`
+	}
+	for window, want := range map[string]string{"": "-2m0s 0s 1m0s 3m0s", "2m": "-2m0s 0s 1m0s"} {
+		if w := getJSON(t, app, "/search?token=app1&around="+logs[2].Id+"&window="+window, &response); w.Code != 200 {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		messages := []string{}
+		for _, log := range response.Logs {
+			messages = append(messages, log["msg"].(string))
+		}
+		if strings.Join(messages, " ") != want {
+			t.Errorf("window %q: expected %s oldest first, got %s", window, want, messages)
+		}
+	}
+
+	for _, path := range []string{"/search?token=app1&around=nope", "/search?token=app1&around=" + logs[2].Id + "&window=48h"} {
+		if w := getJSON(t, app, path, nil); w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestSearchStaysWithinRange(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+	postBulk(t, app, "application/x-ndjson", `{"time": "2026-10-12T12:00:00Z", "msg": "disk full long ago", "level": "3"}`+"\n")
+
+	for q, want := range map[string]uint64{"": 3, "disk": 2, "disk started": 3, "-started": 2, "+disk -ago": 2} {
+		var response struct {
+			Total uint64 `json:"total"`
+		}
+		getJSON(t, app, "/search?token=app1&query="+url.QueryEscape(q)+dashboardRange, &response)
+		if response.Total != want {
+			t.Errorf("%q: expected %d logs of the range, got %d", q, want, response.Total)
+		}
+	}
+}
+
+func TestMaxSearchIndexes(t *testing.T) {
+	app := newTestApp(t, "")
+	app.MaxSearchIndexes = 3
+	indexDays(t, app.engineForToken("app1"), 5, 2)
+
+	var response struct {
+		Indexes int `json:"indexes"`
+	}
+	// The days around are searched too for logs of other zones.
+	if w := getJSON(t, app, "/search?token=app1"+dashboardRange, &response); w.Code != 200 || response.Indexes != 3 {
+		t.Errorf("expected only the indexes of the range to be searched, got %d: %d indexes", w.Code, response.Indexes)
+	}
+	w := getJSON(t, app, "/search?token=app1&from=2026-10-11T00:00:00Z&to=2026-10-14T23:59:59Z", nil)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "more than the 3 a search can go through") {
+		t.Errorf("expected wider searches to be rejected, got %d: %s", w.Code, w.Body)
+	}
+	if body := getJSON(t, app, "/?token=app1&from=2026-10-11T00:00:00Z&to=2026-10-14T23:59:59Z", nil).Body.String(); !strings.Contains(body, "Search too wide: ") {
+		t.Error("expected the dashboard to ask to narrow the search down")
+	}
+}
+
+func TestSearchExplain(t *testing.T) {
+	app := newTestApp(t, "")
+	postSeverities(t, app)
+
+	var response struct {
+		Logs           []map[string]interface{} `json:"logs"`
+		EffectiveQuery json.RawMessage          `json:"effective_query"`
+		Explanations   []struct {
+			Id          string `json:"id"`
+			Explanation *struct {
+				Value   float64 `json:"value"`
+				Message string  `json:"message"`
+			} `json:"explanation"`
+		} `json:"explanations"`
+	}
+	getJSON(t, app, "/search?token=app1&query=disk&explain=1"+dashboardRange, &response)
+	if len(response.Logs) != 2 || len(response.Explanations) != 2 {
+		t.Fatalf("expected an explanation per log, got %d for %d logs", len(response.Explanations), len(response.Logs))
+	}
+	for i, explanation := range response.Explanations {
+		if explanation.Id != response.Logs[i]["id"] || explanation.Explanation == nil || explanation.Explanation.Value <= 0 {
+			t.Errorf("expected log %v to be explained, got %+v", response.Logs[i]["id"], explanation)
+		}
+	}
+	// The query string is shown parsed, as a match query on the terms.
+	if query := string(response.EffectiveQuery); !strings.Contains(query, `"match":"disk"`) || !strings.Contains(query, `"field":"time"`) {
+		t.Errorf("expected the parsed query ANDed with the range, got %s", query)
+	}
+
+	response.Explanations = nil
+	getJSON(t, app, "/search?token=app1&query=disk"+dashboardRange, &response)
+	if response.Explanations != nil {
+		t.Error("expected no explanations unless asked for")
+	}
+}
+
+func TestSearchTimeout(t *testing.T) {
+	app := newTestApp(t, "")
+	indexDays(t, app.engineForToken("app1"), 5, 3)
+	type response struct {
+		Total    int                      `json:"total"`
+		Indexes  int                      `json:"indexes"`
+		Partial  bool                     `json:"partial"`
+		Warnings []string                 `json:"warnings"`
+		Logs     []map[string]interface{} `json:"logs"`
+	}
+	search := "/search?token=app1&limit=4&from=2026-10-10T00:00:00Z&to=2026-10-14T23:59:59Z"
+
+	// In time, going through indexes one at a time finds the same logs.
+	var all, incremental response
+	getJSON(t, app, search, &all)
+	app.SearchTimeout = 10 * time.Second
+	getJSON(t, app, search, &incremental)
+	if all.Total != 17 || len(all.Logs) != 4 || !reflect.DeepEqual(incremental, all) {
+		t.Errorf("expected the same results searching incrementally, got %+v and %+v", incremental, all)
+	}
+
+	app.SearchTimeout = time.Nanosecond
+	if w := getJSON(t, app, search, nil); w.Code != 504 || !strings.Contains(w.Body.String(), `"timeout"`) {
+		t.Errorf("expected timed out searches to fail, got %d: %s", w.Code, w.Body)
+	}
+	var partial response
+	if w := getJSON(t, app, search+"&partial=1", &partial); w.Code != 200 || !partial.Partial || len(partial.Warnings) != 1 || !strings.Contains(partial.Warnings[0], "The search timed out") {
+		t.Errorf("expected partial results, got %d: %+v", w.Code, partial)
+	}
+	if body := getJSON(t, app, "/?token=app1&from=2026-10-10T00:00:00Z&to=2026-10-14T23:59:59Z", nil).Body.String(); !strings.Contains(body, "Search timed out") {
+		t.Error("expected the dashboard to tell the search timed out")
+	}
+}
+
+func TestSearchSort(t *testing.T) {
+	app := newTestApp(t, "")
+	postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+		`{"time": "2026-10-14T12:00:00Z", "msg": "disk disk disk"}`,
+		`{"time": "2026-10-14T12:00:01Z", "msg": "disk is almost out of space on the main volume"}`,
+		`{"time": "2026-10-14T12:00:02Z", "msg": "started"}`,
+	}, "\n"))
+	messages := func(params string) []string {
+		var response struct {
+			Sort string                   `json:"sort"`
+			Logs []map[string]interface{} `json:"logs"`
+		}
+		getJSON(t, app, "/search?token=app1"+dashboardRange+params, &response)
+		messages := []string{response.Sort}
+		for _, log := range response.Logs {
+			messages = append(messages, log["msg"].(string))
+		}
+		return messages
+	}
+
+	tests := map[string][]string{
+		"&query=disk":                {SortTime, "disk is almost out of space on the main volume", "disk disk disk"},
+		"&query=disk&sort=time":      {SortTime, "disk is almost out of space on the main volume", "disk disk disk"},
+		"&query=disk&sort=relevance": {SortRelevance, "disk disk disk", "disk is almost out of space on the main volume"},
+		// Without a query everything scores the same.
+		"&sort=relevance": {SortRelevance, "started", "disk is almost out of space on the main volume", "disk disk disk"},
+	}
+	for params, want := range tests {
+		if got := messages(params); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %q, got %q", params, want, got)
+		}
+	}
+	if w := getJSON(t, app, "/search?token=app1&sort=size"+dashboardRange, nil); w.Code != 400 {
+		t.Errorf("expected unknown sorts to be rejected, got %d", w.Code)
+	}
+	if body := getJSON(t, app, "/?token=app1&sort=relevance"+dashboardRange, nil).Body.String(); !strings.Contains(body, `<option value="relevance" selected>`) {
+		t.Error("expected the dashboard to keep the sort selected")
+	}
+}
+
+func TestCrossFieldSearch(t *testing.T) {
+	for _, crossField := range []bool{false, true} {
+		app := newTestApp(t, `{"analyzer": "en"}`)
+		app.CrossFieldSearch = crossField
+		postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+			`{"time": "2026-10-14T12:00:00Z", "msg": "servers crashing"}`,
+			`{"time": "2026-10-14T12:00:01Z", "msg": "servers started"}`,
+		}, "\n"))
+
+		// `_all` holds the stemmed words of msg, which only its own analyzer
+		// finds from the words as they were logged.
+		tests := []struct {
+			query             string
+			total, crossTotal int
+		}{
+			{"crashes", 0, 1},
+			{"servers", 0, 2},
+			{"msg:crashes", 1, 1},
+			{"+servers -crashes", 0, 1},
+			{"crash*", 1, 1},
+		}
+		for _, test := range tests {
+			var response struct {
+				Total int `json:"total"`
+			}
+			getJSON(t, app, "/search?token=app1&query="+url.QueryEscape(test.query)+dashboardRange, &response)
+			want := test.total
+			if crossField {
+				want = test.crossTotal
+			}
+			if response.Total != want {
+				t.Errorf("cross field %v: expected %s to match %d logs, got %d", crossField, test.query, want, response.Total)
+			}
+		}
+	}
+}
+
+func TestDefaultOperator(t *testing.T) {
+	tests := []struct {
+		query   string
+		or, and int
+	}{
+		{"disk", 2, 2},
+		{"disk full", 2, 1},
+		{"disk started", 3, 0},
+		{"disk -full", 1, 1},
+		{"+started disk", 1, 0},
+		{`"disk full" web`, 2, 1},
+	}
+	for _, operator := range []string{OperatorOr, OperatorAnd} {
+		app := newTestApp(t, "")
+		app.DefaultOperator = operator
+		postBulk(t, app, "application/x-ndjson", strings.Join([]string{
+			`{"time": "2026-10-14T12:00:00Z", "msg": "disk full", "host": "web"}`,
+			`{"time": "2026-10-14T12:00:01Z", "msg": "disk slow", "host": "db"}`,
+			`{"time": "2026-10-14T12:00:02Z", "msg": "started", "host": "web"}`,
+		}, "\n"))
+		for _, test := range tests {
+			want := test.or
+			if operator == OperatorAnd {
+				want = test.and
+			}
+			var response struct {
+				Total int `json:"total"`
+			}
+			getJSON(t, app, "/search?token=app1&query="+url.QueryEscape(test.query)+dashboardRange, &response)
+			if response.Total != want {
+				t.Errorf("operator %s: expected %s to match %d logs, got %d", operator, test.query, want, response.Total)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package firlog
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerTimeouts(t *testing.T) {
+	app := newTestApp(t, "")
+	if server := app.server(":0", "user", "pass"); server.ReadHeaderTimeout != DefaultReadHeaderTimeout || server.ReadTimeout != DefaultReadTimeout ||
+		server.WriteTimeout != DefaultWriteTimeout || server.IdleTimeout != DefaultIdleTimeout {
+		t.Errorf("expected the default timeouts, got %+v", server)
+	}
+
+	app.ReadHeaderTimeout = 50 * time.Millisecond
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := app.server(listener.Addr().String(), "user", "pass")
+	go server.Serve(listener)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Headers that never end.
+	if _, err := conn.Write([]byte("GET /info HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(conn); err != nil {
+		t.Errorf("expected the slow client to be disconnected, got %v", err)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	app := newTestApp(t, "")
+	if addr := app.listenAddr("3000"); addr != ":3000" {
+		t.Errorf("expected every interface to be listened on, got %s", addr)
+	}
+	app.Addr = "127.0.0.1:0"
+	addr := app.listenAddr("3000")
+	if addr != "127.0.0.1:0" {
+		t.Errorf("expected Addr to take precedence over the port, got %s", addr)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if host, _, _ := net.SplitHostPort(listener.Addr().String()); host != "127.0.0.1" {
+		t.Errorf("expected to only listen locally, got %s", listener.Addr())
+	}
+}
@@ -0,0 +1,99 @@
+package firlog
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// DefaultShards is how many indexes new dates are split into.
+const DefaultShards = 1
+
+// Dates are stored in `<date>_<shard>.bleve` directories, shards being
+// numbered from 1, or `<prefix>-<date>_<shard>.bleve` ones with an
+// IndexPrefix. The first shard of a date, the only one unless it was created
+// with Shards, is keyed by the date alone like monthly indexes are, the
+// others by `<date>_<shard>`.
+
+// indexKey is the key of the index stored in the directory name, whichever
+// prefix it has.
+func indexKey(name string) string {
+	name = strings.TrimSuffix(name, ".bleve")
+	// Keys have no dashes, prefixes can.
+	if i := strings.LastIndex(name, "-"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "_1")
+}
+
+// indexDirName is the name of the directory the index key is stored in
+// with prefix.
+func indexDirName(prefix, key string) string {
+	name := keyDate(key) + "_" + strconv.Itoa(keyShard(key)) + ".bleve"
+	if prefix != "" {
+		return prefix + "-" + name
+	}
+	return name
+}
+
+// shardKey is the key of shard of the indexes of date.
+func shardKey(date string, shard int) string {
+	if shard <= 1 {
+		return date
+	}
+	return date + "_" + strconv.Itoa(shard)
+}
+
+// keyDate is the `20060102` date, or `200601` month, of the index key.
+func keyDate(key string) string {
+	return strings.Split(key, "_")[0]
+}
+
+// keyShard is the shard of the indexes of its date the index key is.
+func keyShard(key string) int {
+	parts := strings.Split(key, "_")
+	if len(parts) < 2 {
+		return 1
+	}
+	shard, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 1
+	}
+	return shard
+}
+
+// shardCounts returns how many shards each of dates has, as many as the
+// highest numbered one found for dates that have indexes already, so
+// changing Shards only applies to new dates.
+func (e *Engine) shardCounts(dates []string) map[string]int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	counts := map[string]int{}
+	for key := range e.indexes {
+		if date := keyDate(key); keyShard(key) > counts[date] {
+			counts[date] = keyShard(key)
+		}
+	}
+	shards := e.Shards
+	if shards < 1 {
+		shards = 1
+	}
+	for _, date := range dates {
+		if counts[date] == 0 {
+			counts[date] = shards
+		}
+	}
+	return counts
+}
+
+// routeKey is the key of the shard among shards of date the log stored
+// under id goes to.
+func routeKey(date, id string, shards int) string {
+	if shards <= 1 {
+		return date
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return shardKey(date, int(hash.Sum32()%uint32(shards))+1)
+}
@@ -0,0 +1,126 @@
+package firlog
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestShards(t *testing.T) {
+	e := newTestEngine(t)
+	e.Shards = 3
+	logs := testLogs(e, "started", 30)
+	if err := e.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := filepath.Glob(filepath.Join(e.dataDir, "*.bleve"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range dirs {
+		dirs[i] = filepath.Base(dirs[i])
+	}
+	sort.Strings(dirs)
+	if !reflect.DeepEqual(dirs, []string{"20261014_1.bleve", "20261014_2.bleve", "20261014_3.bleve"}) {
+		t.Errorf("expected the date to be split into 3 shards, got %v", dirs)
+	}
+	if count := docCount(t, e); count != 30 {
+		t.Errorf("expected the shards to be searched together, got %d logs", count)
+	}
+	for _, log := range logs {
+		if found, err := e.Get(log.Id); err != nil || found == nil {
+			t.Fatalf("expected %s to be found in its shard, got %v", log.Id, err)
+		}
+	}
+
+	// Existing dates keep their shards, new ones get the new count.
+	e.Shards = 1
+	more := testLogs(e, "more", 10)
+	for _, log := range more[5:] {
+		log.Time = testTime.Add(-24 * time.Hour)
+	}
+	if err := e.Index(more); err != nil {
+		t.Fatal(err)
+	}
+	if counts := e.shardCounts([]string{"20261013", "20261014"}); counts["20261013"] != 1 || counts["20261014"] != 3 {
+		t.Errorf("unexpected shard counts %v", counts)
+	}
+	for _, index := range e.snapshotIndexes() {
+		index.Close()
+	}
+	reopened := NewEngine(e.dataDir)
+	if count := docCount(t, reopened); count != 40 {
+		t.Errorf("expected every shard to be opened again, got %d logs", count)
+	}
+}
+
+func TestIndexKeys(t *testing.T) {
+	tests := []struct {
+		name, prefix, key string
+	}{
+		{"20261014_1.bleve", "", "20261014"},
+		{"20261014_2.bleve", "", "20261014_2"},
+		{"202610_1.bleve", "", "202610"},
+		{"my-app-20261014_3.bleve", "my-app", "20261014_3"},
+	}
+	for _, test := range tests {
+		if key := indexKey(test.name); key != test.key {
+			t.Errorf("expected %s to be keyed %s, got %s", test.name, test.key, key)
+		}
+		if name := indexDirName(test.prefix, test.key); name != test.name {
+			t.Errorf("expected %s to be stored in %s, got %s", test.key, test.name, name)
+		}
+	}
+	if key := routeKey("20261014", "01ABC", 1); key != "20261014" {
+		t.Errorf("expected a single shard to be keyed by the date, got %s", key)
+	}
+	if routeKey("20261014", "01ABC", 4) != routeKey("20261014", "01ABC", 4) {
+		t.Error("expected logs to always go to the same shard")
+	}
+}
+
+func TestIndexPrefix(t *testing.T) {
+	app := newTestApp(t, "")
+	engine := app.engineForToken("app1")
+	// Created before the prefix was configured.
+	if err := engine.Index(testLogs(engine, "before", 5)); err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range engine.snapshotIndexes() {
+		index.Close()
+	}
+	app = NewApp(app.DataDir, app.Tokens)
+	app.QueueSize = 0
+	app.IndexPrefix = "firlog-{token}"
+	engine = app.engineForToken("app1")
+	if engine.IndexPrefix != "firlog-app1" {
+		t.Errorf("expected the token in the prefix, got %s", engine.IndexPrefix)
+	}
+
+	logs := testLogs(engine, "after", 10)
+	for _, log := range logs[5:] {
+		log.Time = testTime.Add(-24 * time.Hour)
+	}
+	if err := engine.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := filepath.Glob(filepath.Join(engine.dataDir, "*.bleve"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range dirs {
+		dirs[i] = filepath.Base(dirs[i])
+	}
+	sort.Strings(dirs)
+	if !reflect.DeepEqual(dirs, []string{"20261014_1.bleve", "firlog-app1-20261013_1.bleve"}) {
+		t.Errorf("expected only the new index to be prefixed, got %v", dirs)
+	}
+	if keys := indexKeys(t, engine); !reflect.DeepEqual(keys, []string{"20261013", "20261014"}) {
+		t.Errorf("expected both indexes to be keyed by their date, got %v", keys)
+	}
+	if count := docCount(t, engine); count != 15 {
+		t.Errorf("expected the logs of both indexes, got %d", count)
+	}
+}
@@ -0,0 +1,216 @@
+package firlog
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotDirName is where the indexes being snapshotted are copied to.
+const snapshotDirName = ".snapshots"
+
+// Snapshot writes a tar archive of the indexes holding dates between from and to
+// (inclusive `20060102` dates, empty for unbounded) to w. The indexes are
+// copied first with writes and compactions held off: every batch is
+// committed to disk when applied, so with none in flight the index
+// directories are consistent. The copy is archived once writes resume.
+func (e *Engine) Snapshot(w io.Writer, from, to string) error {
+	dir, paths, err := e.copyIndexDirs(from, to)
+	if dir != "" {
+		defer os.RemoveAll(dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, path := range paths {
+		if err := addDirToTar(tw, dir, path); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// copyIndexDirs copies the directories of the indexes holding dates between
+// from and to into a new directory, returning it along with their copies.
+func (e *Engine) copyIndexDirs(from, to string) (string, []string, error) {
+	e.compactMu.Lock()
+	defer e.compactMu.Unlock()
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	// A dot directory so it's never opened as an index if left behind.
+	if err := os.MkdirAll(filepath.Join(e.dataDir, snapshotDirName), os.ModePerm); err != nil {
+		return "", nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Join(e.dataDir, snapshotDirName), "")
+	if err != nil {
+		return "", nil, err
+	}
+	paths := []string{}
+	for _, date := range e.sortedIndexNames() {
+		if !indexInRange(date, from, to) {
+			continue
+		}
+		path := filepath.Join(dir, filepath.Base(e.indexPath(date)))
+		if err := copyDir(e.indexPath(date), path); err != nil {
+			return dir, nil, err
+		}
+		paths = append(paths, path)
+	}
+	return dir, paths, nil
+}
+
+// copyDir copies the directory src, and everything under it, to dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, name)
+		if fi.IsDir() {
+			return os.MkdirAll(target, fi.Mode().Perm())
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+func addDirToTar(tw *tar.Writer, base, dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// RestoreSnapshot extracts a (optionally gzipped) archive produced by
+// Snapshot into a token's data directory, which must not have any index yet.
+func RestoreSnapshot(dataDir string, r io.Reader) error {
+	if err := createDataDir(dataDir, DefaultDataDirMode); err != nil {
+		return err
+	}
+	existing, err := listIndexes(dataDir)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("%s already has indexes", dataDir)
+	}
+
+	r, err = maybeGunzip(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dataDir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, filepath.Clean(dataDir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in archive '%s'", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleSnapshot streams a backup of a token's indexes, gzipped unless
+// `gzip=0`, optionally restricted to `from`/`to` dates (`20060102`).
+func (app *App) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if !contains(app.Tokens, token) {
+		writeError(w, 404, errorCodeInvalidToken, "unknown token")
+		return
+	}
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	compress := r.URL.Query().Get("gzip") != "0"
+
+	filename := token + ".tar"
+	var out io.Writer = w
+	if compress {
+		filename += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	} else {
+		w.Header().Set("Content-Type", "application/x-tar")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if err := app.engineForToken(token).Snapshot(out, from, to); err != nil {
+		// Headers are sent by now, all that's left is to cut the archive short.
+		logger.Printf("error writing snapshot: %v\n", err)
+	}
+}
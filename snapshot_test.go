@@ -0,0 +1,107 @@
+package firlog
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestores(t *testing.T) {
+	e := newTestEngine(t)
+	indexDays(t, e, 3, 10)
+	day := testTime.Truncate(24 * time.Hour)
+
+	tests := []struct {
+		name, from, to string
+	}{
+		{"all", "", ""},
+		{"range", "20261013", "20261013"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var archive bytes.Buffer
+			if err := e.Snapshot(&archive, test.from, test.to); err != nil {
+				t.Fatal(err)
+			}
+			dir := filepath.Join(t.TempDir(), "app1")
+			if err := RestoreSnapshot(dir, &archive); err != nil {
+				t.Fatal(err)
+			}
+			restored := NewEngine(dir)
+
+			want := []string{}
+			for key, index := range e.snapshotIndexes() {
+				if indexInRange(key, test.from, test.to) {
+					ids, err := docIDs(index)
+					if err != nil {
+						t.Fatal(err)
+					}
+					want = append(want, ids...)
+				}
+			}
+			got := searchIDs(t, restored.group(), day.AddDate(0, 0, -30), day.AddDate(0, 0, 30))
+			if len(want) == 0 || len(got) != len(want) {
+				t.Fatalf("expected the %d logs of the range to be restored, got %d", len(want), len(got))
+			}
+			for _, id := range want {
+				original, _ := e.Get(id)
+				log, err := restored.Get(id)
+				if err != nil || log == nil || log.Data["msg"] != original.Data["msg"] {
+					t.Errorf("expected log %s to be restored as %v, got %v (%v)", id, original, log, err)
+				}
+			}
+		})
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(e.dataDir, snapshotDirName)); len(files) != 0 {
+		t.Errorf("expected snapshot copies to be removed, got %d", len(files))
+	}
+}
+
+func TestSnapshotDoesntHoldWritesWhileStreaming(t *testing.T) {
+	e := newTestEngine(t)
+	if err := e.Index(testLogs(e, "before", 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing reads the archive until logs were indexed.
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() {
+		err := e.Snapshot(w, "", "")
+		w.CloseWithError(err)
+		done <- err
+	}()
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(r, first); err != nil {
+		t.Fatal(err)
+	}
+	indexed := make(chan error)
+	go func() { indexed <- e.Index(testLogs(e, "during", 10)) }()
+	select {
+	case err := <-indexed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected logs to be indexed while the snapshot is streamed")
+	}
+
+	archive := bytes.NewBuffer(first)
+	if _, err := io.Copy(archive, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "app1")
+	if err := RestoreSnapshot(dir, archive); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewEngine(dir)
+	if count := docCount(t, restored); count != 10 {
+		t.Errorf("expected the logs indexed before the snapshot to be restored, got %d docs", count)
+	}
+}
@@ -0,0 +1,81 @@
+package firlog
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// IndexStats are the stats of an opened index, counters being since it was
+// opened. Unlike the raw bleve stats of Stats, they don't depend on the bleve
+// version or index type.
+type IndexStats struct {
+	DocCount uint64 `json:"docCount"`
+	// DiskBytes is the size of the index's directory.
+	DiskBytes int64 `json:"diskBytes"`
+	// Batches is how many batches were applied, Updates and Deletes how
+	// many documents they indexed and deleted and Errors how many failed.
+	Batches uint64 `json:"batches"`
+	Updates uint64 `json:"updates"`
+	Deletes uint64 `json:"deletes"`
+	Errors  uint64 `json:"errors"`
+	// IndexTime is the time spent indexing and SearchTime the time spent
+	// answering Searches, in nanoseconds in JSON.
+	IndexTime  time.Duration `json:"indexTime"`
+	Searches   uint64        `json:"searches"`
+	SearchTime time.Duration `json:"searchTime"`
+}
+
+// IndexStats returns the stats of every opened index of the engine by key.
+func (e *Engine) IndexStats() (map[string]IndexStats, error) {
+	indexes := e.snapshotIndexes()
+	stats := map[string]IndexStats{}
+	for _, key := range e.sortedIndexNames() {
+		index, ok := indexes[key]
+		if !ok {
+			continue
+		}
+		docCount, err := index.DocCount()
+		if err != nil {
+			return nil, err
+		}
+		diskBytes, err := dirSize(e.indexPath(key))
+		if os.IsNotExist(err) {
+			// Deleted since the snapshot, by retention for one.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		raw := index.StatsMap()
+		// The counters of the underlying index are nested under `index`.
+		indexRaw, _ := raw["index"].(map[string]interface{})
+		stats[key] = IndexStats{
+			DocCount:   docCount,
+			DiskBytes:  diskBytes,
+			Batches:    statCounter(indexRaw, "batches"),
+			Updates:    statCounter(indexRaw, "updates"),
+			Deletes:    statCounter(indexRaw, "deletes"),
+			Errors:     statCounter(indexRaw, "errors"),
+			IndexTime:  time.Duration(statCounter(indexRaw, "index_time")),
+			Searches:   statCounter(raw, "searches"),
+			SearchTime: time.Duration(statCounter(raw, "search_time")),
+		}
+	}
+	return stats, nil
+}
+
+// statCounter reads a counter of raw bleve stats, 0 when they don't have it.
+func statCounter(raw map[string]interface{}, name string) uint64 {
+	counter, _ := raw[name].(uint64)
+	return counter
+}
+
+func sortedIndexStatsKeys(stats map[string]IndexStats) []string {
+	keys := []string{}
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
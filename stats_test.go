@@ -0,0 +1,106 @@
+package firlog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsAreSorted(t *testing.T) {
+	app := newTestApp(t, "")
+	app.Tokens = []string{"app2", "app1"}
+	for _, token := range app.Tokens {
+		engine := app.engineForToken(token)
+		logs := testLogs(engine, "started", 2)
+		logs[1].Time = testTime.Add(-24 * time.Hour)
+		if err := engine.Index(logs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body := getJSON(t, app, "/stats", nil).Body.String()
+	var stats map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range app.Tokens {
+		if stats[token]["20261013"] == nil || stats[token]["20261014"] == nil {
+			t.Errorf("expected the stats of both indexes of %s, got %v", token, stats[token])
+		}
+	}
+	app1, app2 := strings.Index(body, `"app1"`), strings.Index(body, `"app2"`)
+	if app1 > app2 || strings.Index(body, `"20261013"`) > strings.Index(body, `"20261014"`) {
+		t.Errorf("expected tokens and dates to be sorted, got %s", body)
+	}
+	if again := getJSON(t, app, "/stats", nil).Body.String(); again != body {
+		t.Errorf("expected the same stats twice, got %s then %s", body, again)
+	}
+}
+
+func TestStatsFilters(t *testing.T) {
+	app := newTestApp(t, "")
+	app.Tokens = []string{"app1", "app2"}
+	postSeverities(t, app)
+	app.engineForToken("app2")
+
+	var stats map[string]interface{}
+	getJSON(t, app, "/stats?token=app1", &stats)
+	if _, ok := stats["app1"]; !ok || len(stats) != 1 {
+		t.Errorf("expected only app1's stats, got %v", stats)
+	}
+	if w := getJSON(t, app, "/stats?token=app3", nil); w.Code != 400 {
+		t.Errorf("expected unknown tokens to be rejected, got %d", w.Code)
+	}
+
+	var summary struct {
+		TokensCount  int     `json:"tokensCount"`
+		IndexesCount int     `json:"indexesCount"`
+		DocCount     uint64  `json:"docCount"`
+		LastIngest   *string `json:"lastIngest"`
+	}
+	getJSON(t, app, "/stats?summary=1", &summary)
+	if summary.TokensCount != 2 || summary.IndexesCount != 1 || summary.DocCount != 3 || summary.LastIngest == nil {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	getJSON(t, app, "/stats?summary=1&token=app2", &summary)
+	if summary.TokensCount != 1 || summary.IndexesCount != 0 || summary.DocCount != 0 || summary.LastIngest != nil {
+		t.Errorf("unexpected summary of app2 %+v", summary)
+	}
+}
+
+func TestIndexStats(t *testing.T) {
+	app := newTestApp(t, "")
+	engine := app.engineForToken("app1")
+	logs := testLogs(engine, "started", 3)
+	if err := engine.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+	if total := searchTotal(t, engine, "started"); total != 3 {
+		t.Fatalf("expected 3 logs, got %d", total)
+	}
+
+	stats, err := engine.IndexStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, ok := stats["20261014"]
+	if len(stats) != 1 || !ok {
+		t.Fatalf("expected the stats of today's index, got %v", stats)
+	}
+	if index.DocCount != 3 || index.DiskBytes <= 0 || index.Batches != 1 || index.Updates != 3 || index.Errors != 0 || index.IndexTime <= 0 || index.Searches != 1 {
+		t.Errorf("unexpected stats %+v", index)
+	}
+
+	var typed map[string]struct {
+		Indexes map[string]IndexStats `json:"indexes"`
+	}
+	getJSON(t, app, "/stats?typed=1", &typed)
+	if got := typed["app1"].Indexes["20261014"]; got.DocCount != 3 || got.Batches != 1 {
+		t.Errorf("expected the typed stats in /stats, got %+v", typed)
+	}
+	body := getJSON(t, app, "/metrics", nil).Body.String()
+	if !strings.Contains(body, `firlog_index_docs{token="app1",index="20261014"} 3`) || !strings.Contains(body, `firlog_index_disk_bytes{token="app1",index="20261014"} `) {
+		t.Errorf("expected the index gauges in /metrics, got %s", body)
+	}
+}
@@ -0,0 +1,92 @@
+package firlog
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+)
+
+// loadLogData returns the data of the log stored under id in index, nil if
+// there's none. Logs are read from their internal JSON copy or, for logs
+// indexed with SkipStoredJSON, rebuilt from the fields bleve stores.
+func loadLogData(index bleve.Index, id string) (map[string]interface{}, error) {
+	serialized, err := index.GetInternal([]byte(id))
+	if err != nil {
+		return nil, err
+	}
+	if serialized != nil {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(serialized, &data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	doc, err := index.Document(id)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	return documentData(doc), nil
+}
+
+// documentData rebuilds a log's data from its stored fields. Numbers come
+// back as float64 like JSON ones but dates, which include strings bleve
+// detected as dates, come back as RFC3339 strings that may be formatted
+// differently. Objects in arrays come back as separate arrays of their fields.
+func documentData(doc *document.Document) map[string]interface{} {
+	data := map[string]interface{}{}
+	for _, field := range doc.Fields {
+		var value interface{}
+		switch field := field.(type) {
+		case *document.TextField:
+			value = string(field.Value())
+		case *document.NumericField:
+			number, err := field.Number()
+			if err != nil {
+				continue
+			}
+			value = number
+		case *document.DateTimeField:
+			t, err := field.DateTime()
+			if err != nil {
+				continue
+			}
+			value = t.UTC().Format(time.RFC3339Nano)
+		case *document.BooleanField:
+			boolean, err := field.Boolean()
+			if err != nil {
+				continue
+			}
+			value = boolean
+		default:
+			continue
+		}
+		setDocumentField(data, strings.Split(field.Name(), "."), value, len(field.ArrayPositions()) > 0)
+	}
+	return data
+}
+
+func setDocumentField(data map[string]interface{}, path []string, value interface{}, inArray bool) {
+	for _, key := range path[:len(path)-1] {
+		nested, ok := data[key].(map[string]interface{})
+		if !ok {
+			nested = map[string]interface{}{}
+			data[key] = nested
+		}
+		data = nested
+	}
+
+	key := path[len(path)-1]
+	if !inArray {
+		data[key] = value
+		return
+	}
+	values, _ := data[key].([]interface{})
+	data[key] = append(values, value)
+}
@@ -0,0 +1,45 @@
+package firlog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSkipStoredJSON(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		config := ""
+		if skip {
+			config = `{"skipStoredJSON": true}`
+		}
+		engine := newTestApp(t, config).engineForToken("app1")
+		logs := testLogs(engine, "started", 1)
+		logs[0].Data["id"] = logs[0].Id
+		logs[0].Data["status"] = 200.0
+		logs[0].Data["ok"] = true
+		logs[0].Data["http"] = map[string]interface{}{"method": "GET"}
+		logs[0].Data["tags"] = []interface{}{"web", "api"}
+		if err := engine.Index(logs); err != nil {
+			t.Fatal(err)
+		}
+
+		index, err := engine.indexFor(logs[0].Time.Format("20060102"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serialized, err := index.GetInternal([]byte(logs[0].Id)); err != nil || (serialized == nil) != skip {
+			t.Errorf("skip %v: expected the JSON copy to be stored %v, got %v", skip, !skip, err)
+		}
+		log, err := engine.Get(logs[0].Id)
+		if err != nil || log == nil {
+			t.Fatalf("skip %v: expected the log back, got %v", skip, err)
+		}
+		for _, field := range []string{"id", "msg", "status", "ok", "http", "tags"} {
+			if !reflect.DeepEqual(log.Data[field], logs[0].Data[field]) {
+				t.Errorf("skip %v: expected %s to be %v, got %v", skip, field, logs[0].Data[field], log.Data[field])
+			}
+		}
+		if total := searchTotal(t, engine, "http.method:GET"); total != 1 {
+			t.Errorf("skip %v: expected the log to be searchable, got %d", skip, total)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package firlog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MinTokenLength is the length under which tokens are guessable enough to
+// warn about.
+const MinTokenLength = 16
+
+// ParseTokens parses a comma separated list of tokens, trimming spaces and
+// dropping duplicates. Empty tokens (such as from a trailing comma) and
+// tokens that can't be used as a `/bulk/` path and a directory name are
+// rejected. Warnings tell about dropped duplicates and short tokens.
+func ParseTokens(list string) ([]string, []string, error) {
+	tokens := []string{}
+	warnings := []string{}
+	seen := map[string]bool{}
+	for i, token := range strings.Split(list, ",") {
+		token = strings.TrimSpace(token)
+		if err := validateToken(token); err != nil {
+			return nil, nil, fmt.Errorf("token %d: %v", i+1, err)
+		}
+		if seen[token] {
+			warnings = append(warnings, fmt.Sprintf("token %d is a duplicate of an earlier one, ignoring it", i+1))
+			continue
+		}
+		seen[token] = true
+		if len(token) < MinTokenLength {
+			warnings = append(warnings, fmt.Sprintf("token %d is shorter than %d characters, it could be guessed", i+1, MinTokenLength))
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, warnings, nil
+}
+
+func validateToken(token string) error {
+	if token == "" {
+		return fmt.Errorf("empty")
+	}
+	if token == AllTokens || token == "." || token == ".." || strings.HasPrefix(token, ".") {
+		return fmt.Errorf("'%s' is reserved", token)
+	}
+	if strings.ContainsAny(token, "/\\?#% \t") {
+		return fmt.Errorf("'%s' has characters that can't be in a url path or directory name", token)
+	}
+	return nil
+}
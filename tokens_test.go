@@ -0,0 +1,67 @@
+package firlog
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokensListsIndexes(t *testing.T) {
+	app := newTestApp(t, "")
+	app.Tokens = []string{"app1", "app2"}
+	engine := app.engineForToken("app1")
+	logs := testLogs(engine, "started", 3)
+	logs[0].Time = testTime.Add(-24 * time.Hour)
+	if err := engine.Index(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	var response struct {
+		Tokens []struct {
+			Token   string      `json:"token"`
+			Indexes []IndexInfo `json:"indexes"`
+		} `json:"tokens"`
+	}
+	if w := getJSON(t, app, "/tokens", &response); w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if len(response.Tokens) != 2 || response.Tokens[0].Token != "app1" || response.Tokens[1].Token != "app2" {
+		t.Fatalf("expected both tokens to be listed, got %+v", response.Tokens)
+	}
+	indexes := response.Tokens[0].Indexes
+	if len(indexes) != 2 || indexes[0] != (IndexInfo{Date: "20261013", DocCount: 1}) || indexes[1] != (IndexInfo{Date: "20261014", DocCount: 2}) {
+		t.Errorf("expected the doc counts of both dates, got %+v", indexes)
+	}
+	if len(response.Tokens[1].Indexes) != 0 {
+		t.Errorf("expected no index for app2, got %+v", response.Tokens[1].Indexes)
+	}
+
+	w := httptest.NewRecorder()
+	app.handler("user", "pass").ServeHTTP(w, httptest.NewRequest("GET", "/tokens", nil))
+	if w.Code != 401 {
+		t.Errorf("expected the listing to need auth, got %d", w.Code)
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	tokens, warnings, err := ParseTokens(" 0123456789abcdef, short,0123456789abcdef ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tokens, []string{"0123456789abcdef", "short"}) {
+		t.Errorf("expected the tokens trimmed and de-duplicated, got %q", tokens)
+	}
+	if !reflect.DeepEqual(warnings, []string{
+		"token 2 is shorter than 16 characters, it could be guessed",
+		"token 3 is a duplicate of an earlier one, ignoring it",
+	}) {
+		t.Errorf("unexpected warnings %q", warnings)
+	}
+
+	for _, list := range []string{"", "app1,", "app1,,app2", "*", "..", ".hidden", "app/1", "app 1", "app%201"} {
+		if _, _, err := ParseTokens(list); err == nil {
+			t.Errorf("expected %q to be rejected", list)
+		}
+	}
+}
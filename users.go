@@ -0,0 +1,87 @@
+package firlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// User is a dashboard login that can only see some tokens, letting teams
+// share an instance without seeing each other's logs.
+type User struct {
+	Password string   `json:"password"`
+	Tokens   []string `json:"tokens"`
+}
+
+type userContextKey struct{}
+
+// LoadUsers reads a JSON file mapping user names to their password and
+// tokens, like `{"alice": {"password": "...", "tokens": ["web"]}}`.
+func LoadUsers(path string) (map[string]*User, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	users := map[string]*User{}
+	if err := json.Unmarshal(contents, &users); err != nil {
+		return nil, err
+	}
+	for name, user := range users {
+		if name == "" || user == nil || user.Password == "" {
+			return nil, fmt.Errorf("user '%s' needs a name and a password", name)
+		}
+	}
+	return users, nil
+}
+
+// authenticateUser returns the user of users r authenticates as, if any.
+// Every user is tried so timing doesn't tell which names exist.
+func authenticateUser(users map[string]*User, r *http.Request) *User {
+	var authenticated *User
+	for name, user := range users {
+		if authenticate(name, user.Password, r) {
+			authenticated = user
+		}
+	}
+	return authenticated
+}
+
+// requestUser is the restricted user r authenticated as, nil for the
+// `-basic-auth` credentials which can see every token.
+func requestUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey{}).(*User)
+	return user
+}
+
+// allowedTokens are the tokens the user r authenticated as can see.
+func (app *App) allowedTokens(r *http.Request) []string {
+	user := requestUser(r)
+	if user == nil {
+		return app.Tokens
+	}
+	tokens := []string{}
+	for _, token := range app.Tokens {
+		if contains(user.Tokens, token) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// unrestrictedMiddleware keeps restricted users out of the endpoints that
+// aren't scoped to a token, which would show or change all of them.
+func unrestrictedMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestUser(r) != nil {
+			writeError(w, http.StatusForbidden, errorCodeForbidden, "only available to users allowed every token")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func withUser(r *http.Request, user *User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+}
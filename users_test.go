@@ -0,0 +1,99 @@
+package firlog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestrictedUsers(t *testing.T) {
+	app := newTestApp(t, "")
+	app.Tokens = []string{"app1", "app2"}
+	app.Users = map[string]*User{"alice": {Password: "s3cret", Tokens: []string{"app2"}}}
+	for _, token := range app.Tokens {
+		r := httptest.NewRequest("POST", "/bulk/"+token, strings.NewReader(syslogLine("logged to "+token)))
+		app.handler("user", "pass").ServeHTTP(httptest.NewRecorder(), r)
+	}
+	id := app.engineForToken("app1").Recent(1)[0].Id
+	request := func(user, pass, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth(user, pass)
+		w := httptest.NewRecorder()
+		app.handler("user", "pass").ServeHTTP(w, r)
+		return w
+	}
+	messages := func(w *httptest.ResponseRecorder) string {
+		var response struct {
+			Logs []map[string]interface{} `json:"logs"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		messages := []string{}
+		for _, log := range response.Logs {
+			messages = append(messages, log["msg"].(string))
+		}
+		return strings.Join(messages, ",")
+	}
+
+	tests := []struct {
+		path        string
+		alice, user int
+	}{
+		{"/search?token=app1" + dashboardRange, 400, 200},
+		{"/search?token=app2" + dashboardRange, 200, 200},
+		{"/log/app1/" + id, 404, 200},
+		{"/export?token=app1", 404, 200},
+		{"/stats", 403, 200},
+		{"/metrics", 403, 200},
+	}
+	for _, test := range tests {
+		if w := request("alice", "s3cret", test.path); w.Code != test.alice {
+			t.Errorf("%s: expected alice to get %d, got %d: %.200s", test.path, test.alice, w.Code, w.Body)
+		}
+		if w := request("user", "pass", test.path); w.Code != test.user {
+			t.Errorf("%s: expected the basic auth user to get %d, got %d: %.200s", test.path, test.user, w.Code, w.Body)
+		}
+	}
+
+	// All tokens and the default token are those of the user.
+	if got := messages(request("alice", "s3cret", "/search?token=*"+dashboardRange)); got != "logged to app2" {
+		t.Errorf("expected alice to search app2 only, got %q", got)
+	}
+	if got := messages(request("alice", "s3cret", "/search?"+dashboardRange[1:])); got != "logged to app2" {
+		t.Errorf("expected alice to search app2 by default, got %q", got)
+	}
+	if got := messages(request("user", "pass", "/search?token=*"+dashboardRange)); got != "logged to app1,logged to app2" && got != "logged to app2,logged to app1" {
+		t.Errorf("expected the basic auth user to search every token, got %q", got)
+	}
+	if body := request("alice", "s3cret", "/tokens").Body.String(); strings.Contains(body, `"app1"`) || !strings.Contains(body, `"app2"`) {
+		t.Errorf("expected alice to only see app2, got %s", body)
+	}
+	if body := request("alice", "s3cret", "/?token=app2"+dashboardRange).Body.String(); strings.Contains(body, `value="app1"`) {
+		t.Error("expected app1 to be left out of the dashboard of alice")
+	}
+	if w := request("alice", "pass", "/search?token=app2"+dashboardRange); w.Code != 401 {
+		t.Errorf("expected a wrong password to be rejected, got %d", w.Code)
+	}
+}
+
+func TestLoadUsers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.json")
+	if err := ioutil.WriteFile(path, []byte(`{"alice": {"password": "s3cret", "tokens": ["web"]}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadUsers(path)
+	if err != nil || len(users) != 1 || users["alice"].Password != "s3cret" || users["alice"].Tokens[0] != "web" {
+		t.Errorf("unexpected users %v, %v", users, err)
+	}
+	for _, contents := range []string{`{"bob": {"tokens": ["web"]}}`, `{"bob": null}`, `["bob"]`} {
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadUsers(path); err == nil {
+			t.Errorf("expected %s to be rejected", contents)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package firlog
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const walDirName = ".wal"
+
+// writeWAL durably records logs about to be indexed into a segment of the
+// engine's write-ahead log, returning its path for releaseWAL once they are.
+// Engines without WAL return an empty path.
+func (e *Engine) writeWAL(logs []*Log) (string, error) {
+	if !e.WAL {
+		return "", nil
+	}
+	return writeLogsFile(filepath.Join(e.dataDir, walDirName), logs)
+}
+
+// releaseWAL removes the segments of logs that got indexed.
+func (e *Engine) releaseWAL(segments ...string) {
+	for _, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		if err := os.Remove(segment); err != nil {
+			logger.Printf("error removing wal segment: %v\n", err)
+		}
+	}
+}
+
+// ReplayWAL indexes the logs of the write-ahead log segments left behind by a
+// crash or a failed Index call, removing every segment successfully replayed.
+// Logs keep their IDs so ones that were indexed after all aren't duplicated.
+// It returns the number of logs indexed.
+func (e *Engine) ReplayWAL() (int, error) {
+	return e.replayLogsFiles(filepath.Join(e.dataDir, walDirName))
+}
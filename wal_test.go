@@ -0,0 +1,51 @@
+package firlog
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayWALAfterCrash(t *testing.T) {
+	e := newTestEngine(t)
+	e.WAL = true
+	// Without workers the logs are in the wal only, like when the process
+	// dies before indexing them.
+	e.StartQueue(10, 0, 5, 0)
+	logs := testLogs(e, "queued", 3)
+	if err := e.Enqueue(logs); err != nil {
+		t.Fatal(err)
+	}
+	segments := filepath.Join(e.dataDir, walDirName, "*.ndjson")
+	if matches, _ := filepath.Glob(segments); len(matches) != 1 {
+		t.Fatalf("got %d wal segments before the crash, want 1", len(matches))
+	}
+
+	reopened := NewEngine(e.dataDir)
+	replayed, err := reopened.ReplayWAL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 3 {
+		t.Errorf("got %d logs replayed, want 3", replayed)
+	}
+	if count := docCount(t, reopened); count != 3 {
+		t.Errorf("got %d logs indexed, want 3", count)
+	}
+	for _, log := range logs {
+		stored, err := reopened.Get(log.Id)
+		if err != nil {
+			t.Fatalf("getting %s: %v", log.Id, err)
+		}
+		if stored == nil || stored.Data["msg"] != log.Data["msg"] {
+			t.Errorf("got %+v stored under %s, want msg %q", stored, log.Id, log.Data["msg"])
+		}
+	}
+	if matches, _ := filepath.Glob(segments); len(matches) != 0 {
+		t.Errorf("got %d wal segments after replaying, want them released", len(matches))
+	}
+
+	// Replaying again finds nothing left.
+	if replayed, err := reopened.ReplayWAL(); err != nil || replayed != 0 {
+		t.Errorf("got %d, %v replaying again, want 0, nil", replayed, err)
+	}
+}
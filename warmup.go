@@ -0,0 +1,53 @@
+package firlog
+
+import (
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// warmUpSize is how many of the newest logs of each index WarmUp reads, as
+// many as the dashboard shows by default.
+const warmUpSize = 100
+
+// WarmUp searches the newest logs of the indexes of the dates since since,
+// reading their stored copies too, so the first searches of recent logs
+// after a restart don't pay for reading indexes from disk. It returns how
+// many indexes it went through.
+func (e *Engine) WarmUp(since time.Time) int {
+	first := since.UTC().Format("20060102")
+	warmed := 0
+	for key, index := range e.snapshotIndexes() {
+		// Monthly indexes are compared by their month.
+		date := keyDate(key)
+		if date < first[:len(date)] {
+			continue
+		}
+		search := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), warmUpSize, 0, false)
+		search.SortBy([]string{"-time"})
+		results, err := index.Search(search)
+		if err != nil {
+			logger.Printf("error warming up index %s of %s: %v\n", key, e.token(), err)
+			continue
+		}
+		for _, hit := range results.Hits {
+			index.GetInternal([]byte(hit.ID))
+		}
+		warmed++
+	}
+	return warmed
+}
+
+// warmUp warms up the indexes of the last WarmUpDays days of every token,
+// one token at a time so it doesn't compete with searches and ingestion
+// more than it has to.
+func (app *App) warmUp() {
+	start := time.Now()
+	since := start.AddDate(0, 0, 1-app.WarmUpDays)
+	warmed := 0
+	engines := app.engines()
+	for _, token := range sortedTokens(engines) {
+		warmed += engines[token].WarmUp(since)
+	}
+	logger.Printf("warmed up %d indexes in %s\n", warmed, time.Since(start))
+}
@@ -0,0 +1,35 @@
+package firlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmUp(t *testing.T) {
+	e := newTestEngine(t)
+	indexSeptember(t, e, 10)
+	if err := e.Compact(testTime); err != nil {
+		t.Fatal(err)
+	}
+	indexDays(t, e, 3, 2)
+
+	tests := []struct {
+		since  time.Time
+		warmed int
+	}{
+		// 20261012, 20261013, 20261014 and 20261015.
+		{time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), 4},
+		{time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), 2},
+		// The monthly index of September is warmed up with its month.
+		{time.Date(2026, 9, 20, 0, 0, 0, 0, time.UTC), 5},
+		{time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), 0},
+	}
+	for _, test := range tests {
+		if warmed := e.WarmUp(test.since); warmed != test.warmed {
+			t.Errorf("since %s: expected %d indexes to be warmed up, got %d", test.since.Format("20060102"), test.warmed, warmed)
+		}
+	}
+	if total := searchTotal(t, e, "log"); total != 8 {
+		t.Errorf("expected warm-up to leave the indexes searchable, got %d logs", total)
+	}
+}
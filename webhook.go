@@ -0,0 +1,120 @@
+package firlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+const (
+	DefaultWebhookTimeout    = 5 * time.Second
+	DefaultWebhookMaxRetries = 3
+)
+
+// WebhookNotifier POSTs alerts as JSON to URL. Deliveries happen in the
+// background, with a timeout and a bounded number of retries, so a slow
+// webhook never holds up indexing.
+type WebhookNotifier struct {
+	URL string
+	// Template, when set, renders the request body instead of the default
+	// payload. It is executed with the Alert and has a `json` function to
+	// encode values.
+	Template   *template.Template
+	Client     *http.Client
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+func NewWebhookNotifier(url, payloadTemplate string) (*WebhookNotifier, error) {
+	notifier := &WebhookNotifier{
+		URL:        url,
+		Client:     &http.Client{Timeout: DefaultWebhookTimeout},
+		MaxRetries: DefaultWebhookMaxRetries,
+		Backoff:    DefaultRetryBackoff,
+	}
+	if payloadTemplate != "" {
+		t, err := template.New("").Funcs(template.FuncMap{"json": toJSON}).Parse(payloadTemplate)
+		if err != nil {
+			return nil, err
+		}
+		notifier.Template = t
+	}
+	return notifier, nil
+}
+
+// Notify queues the delivery of alert, which outlives ctx.
+func (s *WebhookNotifier) Notify(ctx context.Context, alert *Alert) error {
+	payload, err := s.payload(alert)
+	if err != nil {
+		return err
+	}
+	go s.deliver(alert.Rule, payload)
+	return nil
+}
+
+func (s *WebhookNotifier) payload(alert *Alert) ([]byte, error) {
+	if s.Template != nil {
+		var out bytes.Buffer
+		if err := s.Template.Execute(&out, alert); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+
+	samples := []map[string]interface{}{}
+	for _, log := range alert.Samples {
+		samples = append(samples, log.Data)
+	}
+	payload := map[string]interface{}{
+		// Slack compatible webhooks display `text`.
+		"text":    fmt.Sprintf("firlog alert '%s': %d matches", alert.Rule, alert.Count),
+		"rule":    alert.Rule,
+		"token":   alert.Token,
+		"count":   alert.Count,
+		"from":    alert.From.Format(time.RFC3339),
+		"to":      alert.To.Format(time.RFC3339),
+		"samples": samples,
+	}
+	if alert.Received > 0 {
+		payload["text"] = fmt.Sprintf("firlog alert '%s' for %s: %d of %d lines", alert.Rule, alert.Token, alert.Count, alert.Received)
+		payload["received"] = alert.Received
+	}
+	return json.Marshal(payload)
+}
+
+func (s *WebhookNotifier) deliver(rule string, payload []byte) {
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		err := s.post(payload)
+		if err == nil {
+			return
+		}
+		if attempt == s.MaxRetries {
+			logger.Printf("error delivering alert '%s' webhook: %v\n", rule, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *WebhookNotifier) post(payload []byte) error {
+	res, err := s.Client.Post(s.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %d", res.StatusCode)
+	}
+	return nil
+}
+
+func toJSON(value interface{}) (string, error) {
+	serialized, err := json.Marshal(value)
+	return string(serialized), err
+}
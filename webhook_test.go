@@ -0,0 +1,90 @@
+package firlog
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookServer answers statuses in order, then 200, sending the bodies it
+// receives on the returned channel.
+func webhookServer(t *testing.T, statuses ...int) (*httptest.Server, chan string) {
+	bodies := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, bodies
+}
+
+func receive(t *testing.T, bodies chan string) string {
+	t.Helper()
+	select {
+	case body := <-bodies:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the webhook to be called")
+		return ""
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	server, bodies := webhookServer(t, 500)
+	notifier, err := NewWebhookNotifier(server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.Backoff = time.Millisecond
+	alert := &Alert{Rule: "errors", Token: "app1", Count: 3, From: testTime, To: testTime.Add(time.Minute)}
+	if err := notifier.Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	var first, second map[string]interface{}
+	json.Unmarshal([]byte(receive(t, bodies)), &first)
+	json.Unmarshal([]byte(receive(t, bodies)), &second)
+	if first["text"] != "firlog alert 'errors': 3 matches" || first["token"] != "app1" || first["from"] != "2026-10-14T12:00:00Z" {
+		t.Errorf("unexpected payload %v", first)
+	}
+	if second["rule"] != "errors" {
+		t.Errorf("expected the delivery to be retried, got %v", second)
+	}
+	select {
+	case body := <-bodies:
+		t.Errorf("expected a single retry, got %s", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAlertRuleWebhook(t *testing.T) {
+	server, bodies := webhookServer(t)
+	app := newTestApp(t, `{"alertRules": [{"name": "errors", "query": "level:error", "threshold": 1, "window": "1m",
+		"webhook": "`+server.URL+`", "template": "{\"alert\": {{json .Rule}}, \"count\": {{.Count}}}"}]}`)
+	notifier := &recordingNotifier{}
+	app.Notifier = notifier
+	engine := app.engineForToken("app1")
+
+	if err := engine.Index(errorLogs(engine, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if body := receive(t, bodies); strings.TrimSpace(body) != `{"alert": "errors", "count": 2}` {
+		t.Errorf("expected the templated payload, got %s", body)
+	}
+	if count := notifier.count(); count != 0 {
+		t.Errorf("expected the rule's webhook to replace the notifier, got %d alerts", count)
+	}
+
+	if err := compileAlertRules([]*AlertRule{{Name: "bad", Query: "level:error", Window: "1m", Webhook: server.URL, Template: "{{"}}); err == nil {
+		t.Error("expected an invalid template to be rejected")
+	}
+}